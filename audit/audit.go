@@ -1,7 +1,7 @@
 package lxAudit
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/sirupsen/logrus"
@@ -10,10 +10,11 @@ import (
 	//"errors"
 	"fmt"
 	//"github.com/sirupsen/logrus"
-	"net/http"
 
 	//"net/http"
 	//"sync"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,10 +41,29 @@ type queue struct {
 	auditHostAuthKey string
 	log              *logrus.Entry
 	throttle         time.Duration
-	runningWorkers   int
+	runningWorkers   int32
 	JobChan          chan interface{}
 	ErrChan          chan error
 	KillChan         chan bool
+	spool            *Spool
+	batch            *batcher
+	client           *Client
+
+	// lifecycle of Start and Shutdown
+	jobs        chan interface{}
+	overflow    string
+	lifeMux     sync.RWMutex
+	closed      bool
+	closing     chan struct{}
+	senders     sync.WaitGroup
+	workers     sync.WaitGroup
+	workCtx     context.Context
+	cancelWork  context.CancelFunc
+	bufMux      sync.Mutex
+	bufCond     *sync.Cond
+	buffered    int
+	pendingMux  sync.Mutex
+	undelivered []interface{}
 }
 
 const (
@@ -65,6 +85,16 @@ var (
 	ErrAuditEntryType = errors.New("must be AuditEntry or []AuditEntry type")
 	ErrRespContent    = errors.New("response shouldn't have any content")
 	ErrStatus         = errors.New("status must have 200")
+	ErrSpool          = errors.New("invalid spool")
+	ErrSpoolFull      = errors.New("spool size limit exceeded")
+	ErrSpoolCorrupt   = errors.New("spool segment is corrupt")
+	ErrTransient      = errors.New("audit service temporarily unavailable")
+	ErrPermanent      = errors.New("audit service rejected request")
+	ErrCircuitOpen    = errors.New("audit circuit breaker is open")
+	ErrBuffer         = errors.New("invalid audit buffer")
+	ErrQueueFull      = errors.New("audit queue buffer is full")
+	ErrQueueClosed    = errors.New("audit queue is closed")
+	ErrSink           = errors.New("invalid audit sink")
 )
 
 // NewQueue create instance of queue
@@ -84,17 +114,23 @@ func NewQueue(clientHost, auditHost, auditHostAuthKey string, logEntry *logrus.E
 		throttle = v
 	}
 
-	return &queue{
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	qu := &queue{
 		clientHost:       clientHost,
 		auditHost:        auditHost,
 		auditHostAuthKey: auditHostAuthKey,
 		log:              logEntry,
 		throttle:         throttle,
-		runningWorkers:   0,
 		JobChan:          make(chan interface{}),
 		ErrChan:          make(chan error),
 		KillChan:         make(chan bool),
+		client:           NewClient(auditHost, auditHostAuthKey, nil),
+		closing:          make(chan struct{}),
+		workCtx:          workCtx,
+		cancelWork:       cancelWork,
 	}
+	qu.bufCond = sync.NewCond(&qu.bufMux)
+	return qu
 }
 
 // StartWorker starts a worker
@@ -105,7 +141,7 @@ func NewQueue(clientHost, auditHost, auditHostAuthKey string, logEntry *logrus.E
 // queue.StartWorker(queue.JobChan, queue.KillChan)
 func (qu *queue) StartWorker(jobChan chan interface{}, killSig chan bool, errChan ...chan error) {
 	// increment worker
-	qu.runningWorkers++
+	workerNum := atomic.AddInt32(&qu.runningWorkers, 1)
 
 	// Set _errChan, when not given than is nil
 	var _errChan chan error
@@ -114,23 +150,19 @@ func (qu *queue) StartWorker(jobChan chan interface{}, killSig chan bool, errCha
 	}
 
 	// go func for worker
-	go func(jobChan chan interface{}, killSig chan bool, errChan chan error, workerNum int) {
+	go func(jobChan chan interface{}, killSig chan bool, errChan chan error, workerNum int32) {
 		for true {
 			select {
 			case j := <-jobChan:
-				// send the entries or entry to audit service
-				err := RequestAudit(j, qu.auditHost, qu.auditHostAuthKey)
-				if err != nil {
-					// log error for manual insert
-					jsonJob, jsonErr := json.Marshal(j)
-					ctxLog := qu.log.WithField("func", "lxAudit.StartWorker")
-					const Msg = "error by send entries to audit"
-					if jsonErr != nil {
-						// error by convert job to json print in raw
-						ctxLog.WithField("audit", j).Error(fmt.Errorf("%s, %w", Msg, err))
-					} else {
-						// log error as encoded base64 json string for manual insert
-						ctxLog.WithField("audit", string(jsonJob)).Error(fmt.Errorf("%s, %w", Msg, err))
+				var err error
+				if qu.batch != nil {
+					// coalesce into batch, delivered by batcher
+					err = qu.batch.add(j)
+				} else {
+					// send the entries or entry to audit service
+					err = qu.client.Request(j)
+					if err != nil {
+						qu.failed(j, err)
 					}
 				}
 				// stop before end job
@@ -142,11 +174,64 @@ func (qu *queue) StartWorker(jobChan chan interface{}, killSig chan bool, errCha
 				}
 			case <-killSig:
 				qu.log.Infof("shutdown worker %d with kill signal", workerNum)
-				qu.runningWorkers--
+				atomic.AddInt32(&qu.runningWorkers, -1)
 				return
 			}
 		}
-	}(jobChan, killSig, _errChan, qu.runningWorkers)
+	}(jobChan, killSig, _errChan, workerNum)
+}
+
+// failed, spool failed job for replay,
+// log for manual insert when spool is not set or failed
+func (qu *queue) failed(j interface{}, err error) {
+	ctxLog := qu.log.WithField("func", "lxAudit.StartWorker")
+	const Msg = "error by send entries to audit"
+
+	// rejected jobs are not replayed
+	if qu.spool != nil && !errors.Is(err, ErrPermanent) {
+		spoolErr := qu.spool.Append(j)
+		if spoolErr == nil {
+			ctxLog.Warn(fmt.Errorf("%s, spooled for replay, %w", Msg, err))
+			return
+		}
+		ctxLog = ctxLog.WithField("spool_error", spoolErr.Error())
+	}
+	logJob(ctxLog, j, fmt.Errorf("%s, %w", Msg, err))
+}
+
+// logJob, log error with job as json for manual insert
+func logJob(ctxLog *logrus.Entry, j interface{}, err error) {
+	jsonJob, jsonErr := json.Marshal(j)
+	if jsonErr != nil {
+		// error by convert job to json print in raw
+		ctxLog.WithField("audit", j).Error(err)
+	} else {
+		// log error as encoded base64 json string for manual insert
+		ctxLog.WithField("audit", string(jsonJob)).Error(err)
+	}
+}
+
+// SetSpool, spool failed jobs of workers and start replayer of spool
+// with delivery to the audit service of queue
+// Example:
+// spool, err := lxAudit.NewSpool(lxAudit.SpoolOptions{Dir: "/var/spool/audit"})
+// queue := NewQueue(....)
+// queue.SetSpool(spool)
+// defer spool.Close()
+func (qu *queue) SetSpool(spool *Spool) {
+	qu.spool = spool
+	spool.StartReplayer(func(job interface{}) error {
+		return qu.client.Request(job)
+	})
+}
+
+// SetClient, client for delivery to the audit service,
+// default is NewClient with default options
+// Example:
+// queue := NewQueue(....)
+// queue.SetClient(lxAudit.NewClient(auditHost, authKey, &lxAudit.ClientOptions{MaxRetries: 5}))
+func (qu *queue) SetClient(client *Client) {
+	qu.client = client
 }
 
 // GetCountOfRunningWorkers shows how many workers are running
@@ -154,7 +239,7 @@ func (qu *queue) StartWorker(jobChan chan interface{}, killSig chan bool, errCha
 // queue := NewQueue(....)
 // queue.GetCountOfRunningWorkers()
 func (qu *queue) GetCountOfRunningWorkers() int {
-	return qu.runningWorkers
+	return int(atomic.LoadInt32(&qu.runningWorkers))
 }
 
 // IsActive return true when worker running,
@@ -164,44 +249,48 @@ func (qu *queue) IsActive() bool {
 }
 
 // Send async convert and send elem to worker,
-// elm must be bson.M or []bson.M type
+// elm must be bson.M or []bson.M type.
+// Elem is added to the bounded buffer of SetBuffer or Start, without Start the
+// buffer is created with defaults and forwarded in order to the workers of StartWorker
+// or with batching (see SetBatching) to the batch
 // Example:
 // queue := NewQueue(....)
 // queue.Send(bson.M{...})
 // IBaseRepoAudit
 func (qu *queue) Send(elem interface{}) {
-	go func(elem interface{}) {
-		switch val := elem.(type) {
-		default:
-			qu.JobChan <- val
-		case bson.M:
-			// Send entry to worker
-			qu.JobChan <- AuditEntry{
-				Host:       qu.clientHost,
-				Collection: val["collection"].(string),
-				Action:     val["action"].(string),
-				User:       val["user"],
-				Data:       val["data"],
-			}
-		case []bson.M:
-			// Send entry to worker
-			entries := make(AuditEntries, len(val))
-			for i, e := range val {
-				entries[i] = AuditEntry{
-					Host:       qu.clientHost,
-					Collection: e["collection"].(string),
-					Action:     e["action"].(string),
-					User:       e["user"],
-					Data:       e["data"],
-				}
+	qu.enqueue(toJob(qu.clientHost, elem))
+}
+
+// toJob, convert bson.M to AuditEntry and []bson.M to AuditEntries of host,
+// other types are returned unchanged
+func toJob(host string, elem interface{}) interface{} {
+	switch val := elem.(type) {
+	case bson.M:
+		return AuditEntry{
+			Host:       host,
+			Collection: val["collection"].(string),
+			Action:     val["action"].(string),
+			User:       val["user"],
+			Data:       val["data"],
+		}
+	case []bson.M:
+		entries := make(AuditEntries, len(val))
+		for i, e := range val {
+			entries[i] = AuditEntry{
+				Host:       host,
+				Collection: e["collection"].(string),
+				Action:     e["action"].(string),
+				User:       e["user"],
+				Data:       e["data"],
 			}
-			// Send entries to worker
-			qu.JobChan <- entries
 		}
-	}(elem)
+		return entries
+	}
+	return elem
 }
 
-// RequestAudit send entry or entries to audit service.
+// RequestAudit send entry or entries to audit service with one attempt,
+// connections are reused. Errors are typed like errors of Client.
 // This function can also be used independently of the worker.
 // Example:
 // err := lxAudit.RequestAudit(...)
@@ -211,69 +300,7 @@ func RequestAudit(elem interface{}, auditHost, auditAuthKey string, timeout ...t
 		to = timeout[0]
 	}
 
-	// Request
-	var req *http.Request
-
-	switch val := elem.(type) {
-	default:
-		// Wrong type return with error
-		return ErrAuditEntryType
-
-	case AuditEntries:
-		// Convert entries to json
-		jsonBody, err := json.Marshal(val)
-		if err != nil {
-			return err
-		}
-
-		// Make request
-		req, err = http.NewRequest("POST", auditHost+PathLogEntries, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			return err
-		}
-
-	case AuditEntry:
-		// Convert entry to json
-		jsonBody, err := json.Marshal(val)
-		if err != nil {
-			return err
-		}
-
-		// Make request
-		req, err = http.NewRequest("POST", auditHost+PathLogEntry, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			return err
-		}
-	}
-
-	// Set client with timeout
-	client := &http.Client{
-		Timeout: to,
-	}
-
-	// Set header for request
-	req.Header.Set("Content-type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+auditAuthKey)
-
-	// Start request
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-
-	// Check error
-	var result interface{}
-	if resp.StatusCode != http.StatusOK && resp.ContentLength > 0 {
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return err
-		}
-
-		return fmt.Errorf("status: %v result: %v, %w", resp.Status, result, ErrRespContent)
-	}
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("%v, %w", resp.Status, ErrStatus)
-	}
-
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), to)
+	defer cancel()
+	return doRequest(ctx, sharedHTTPClient, elem, auditHost, auditAuthKey)
 }
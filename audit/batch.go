@@ -0,0 +1,331 @@
+package lxAudit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults of BatchOptions
+	DefaultBatchMaxEntries = 100
+	DefaultBatchMaxBytes   = 1 << 20
+	DefaultBatchMaxLatency = time.Second
+	DefaultBatchMaxPending = 10000
+)
+
+// BatchOptions, options for SetBatching,
+// a batch is delivered when one of the limits is reached
+type BatchOptions struct {
+	// MaxEntries, entries per request
+	MaxEntries int
+	// MaxBytes, json size of entries per request
+	MaxBytes int
+	// MaxLatency, max wait of first entry of batch
+	MaxLatency time.Duration
+	// MaxPending, max entries of batches waiting for delivery, when exceeded
+	// the overflow policy of the buffer is applied (see BufferOptions)
+	MaxPending int
+}
+
+// batcher, coalesce entries into AuditEntries for PathLogEntries,
+// batches are delivered in order by one goroutine
+type batcher struct {
+	qu   *queue
+	opts BatchOptions
+
+	mux     sync.Mutex
+	current AuditEntries
+	bytes   int
+	since   time.Time
+	sealed  []AuditEntries
+	pending int
+	space   *sync.Cond
+
+	wake     chan struct{}
+	flushReq chan chan struct{}
+	done     chan struct{}
+}
+
+// SetBatching, coalesce entries of Send and jobs of JobChan into batches
+// which are posted to PathLogEntries. Entries are delivered in order of Send,
+// so ordering per collection and document is preserved. A batch rejected by the
+// audit service is split to isolate bad entries, they are logged like failed jobs.
+// A batch which can't be delivered is spooled and later batches follow it into the spool,
+// without spool it is retried and later batches wait up to MaxPending entries.
+// Then the overflow policy of the buffer is applied, OverflowBlock waits for delivery,
+// OverflowDropOldest drops the oldest batch and OverflowSpill appends the batches to the spool.
+// Call before StartWorker and Send.
+// Example:
+// queue := NewQueue(....)
+// queue.SetBatching(lxAudit.BatchOptions{MaxEntries: 500, MaxLatency: time.Millisecond * 200})
+// queue.StartWorker(queue.JobChan, queue.KillChan)
+func (qu *queue) SetBatching(opts BatchOptions) {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultBatchMaxEntries
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultBatchMaxBytes
+	}
+	if opts.MaxLatency <= 0 {
+		opts.MaxLatency = DefaultBatchMaxLatency
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultBatchMaxPending
+	}
+
+	b := &batcher{
+		qu:       qu,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		flushReq: make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	b.space = sync.NewCond(&b.mux)
+	qu.batch = b
+	go b.run()
+}
+
+// Flush, deliver all buffered and batched entries and wait,
+// without batching or after Shutdown nothing is done
+// Example:
+// queue.Send(bson.M{...})
+// queue.Flush()
+func (qu *queue) Flush() {
+	if qu.batch == nil {
+		return
+	}
+	qu.waitBuffer()
+	reply := make(chan struct{})
+	select {
+	case qu.batch.flushReq <- reply:
+		<-reply
+	case <-qu.batch.done:
+	}
+}
+
+// stop, stop goroutine of batcher
+func (b *batcher) stop() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	// release adds waiting for delivery
+	b.space.Broadcast()
+}
+
+// add, add entries of job to current batch
+func (b *batcher) add(job interface{}) error {
+	var entries AuditEntries
+	switch val := job.(type) {
+	case AuditEntry:
+		entries = AuditEntries{val}
+	case AuditEntries:
+		entries = val
+	default:
+		return ErrAuditEntryType
+	}
+
+	sizes := make([]int, len(entries))
+	for i, e := range entries {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		// with separator
+		sizes[i] = len(raw) + 1
+	}
+
+	b.mux.Lock()
+	sealed := false
+	for i, e := range entries {
+		if len(b.current) > 0 && b.bytes+sizes[i] > b.opts.MaxBytes {
+			b.seal()
+			sealed = true
+		}
+		if len(b.current) == 0 {
+			b.since = time.Now()
+		}
+		b.current = append(b.current, e)
+		b.bytes += sizes[i]
+		if len(b.current) >= b.opts.MaxEntries {
+			b.seal()
+			sealed = true
+		}
+	}
+	if sealed {
+		b.limit()
+	}
+	b.mux.Unlock()
+
+	if sealed {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// seal, move current batch to sealed, caller holds mux
+func (b *batcher) seal() {
+	if len(b.current) == 0 {
+		return
+	}
+	b.sealed = append(b.sealed, b.current)
+	b.pending += len(b.current)
+	b.current, b.bytes = nil, 0
+}
+
+// limit, apply overflow policy of queue while pending entries exceed MaxPending,
+// caller holds mux
+func (b *batcher) limit() {
+	ctxLog := b.qu.log.WithField("func", "lxAudit.batcher.add")
+
+	b.qu.lifeMux.RLock()
+	overflow := b.qu.overflow
+	b.qu.lifeMux.RUnlock()
+
+	for b.pending > b.opts.MaxPending && len(b.sealed) > 0 {
+		switch overflow {
+		case OverflowDropOldest:
+			batch := b.sealed[0]
+			b.sealed = b.sealed[1:]
+			b.pending -= len(batch)
+			logJob(ctxLog, batch, fmt.Errorf("dropped oldest batch, %w", ErrQueueFull))
+		case OverflowSpill:
+			if b.qu.spool == nil {
+				batch := b.sealed[len(b.sealed)-1]
+				b.sealed = b.sealed[:len(b.sealed)-1]
+				b.pending -= len(batch)
+				logJob(ctxLog, batch, fmt.Errorf("no spool for overflow, %w", ErrQueueFull))
+				continue
+			}
+			// in order, later batches follow into the spool
+			for _, batch := range b.sealed {
+				b.pending -= len(batch)
+				if err := b.qu.spool.Append(batch); err != nil {
+					logJob(ctxLog, batch, fmt.Errorf("%v, %w", err, ErrQueueFull))
+				}
+			}
+			b.sealed = nil
+		default:
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			b.space.Wait()
+		}
+	}
+}
+
+// run, deliver sealed batches and current batch after max latency
+func (b *batcher) run() {
+	wait := b.opts.MaxLatency
+	for {
+		var reply chan struct{}
+		select {
+		case <-b.wake:
+		case <-time.After(wait):
+		case reply = <-b.flushReq:
+		case <-b.done:
+			return
+		}
+
+		b.mux.Lock()
+		if reply != nil || (len(b.current) > 0 && time.Since(b.since) >= b.opts.MaxLatency) {
+			b.seal()
+		}
+		b.mux.Unlock()
+
+		for {
+			b.mux.Lock()
+			if len(b.sealed) == 0 {
+				b.mux.Unlock()
+				break
+			}
+			batch := b.sealed[0]
+			b.sealed = b.sealed[1:]
+			b.mux.Unlock()
+			b.deliver(batch)
+
+			b.mux.Lock()
+			b.pending -= len(batch)
+			b.space.Broadcast()
+			b.mux.Unlock()
+		}
+
+		if reply != nil {
+			close(reply)
+		}
+
+		b.mux.Lock()
+		wait = b.opts.MaxLatency
+		if len(b.current) > 0 {
+			wait -= time.Since(b.since)
+		}
+		b.mux.Unlock()
+	}
+}
+
+// deliver, post batch, rejected batches are split in halves
+// and delivered in order until the bad entries are isolated.
+// After a transient failure the batch is spooled, later batches are appended to the spool
+// while it has pending jobs. Without spool the batch is retried with backoff
+// and later batches wait, so the order of entries is kept.
+func (b *batcher) deliver(batch AuditEntries) {
+	qu := b.qu
+
+	// Spooled jobs stay ahead of new batches
+	if qu.spool != nil && qu.spool.hasPending() {
+		if err := qu.spool.Append(batch); err != nil {
+			logJob(qu.log.WithField("func", "lxAudit.batcher.deliver"), batch, err)
+		}
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := qu.client.RequestContext(qu.workCtx, batch)
+		if err == nil {
+			return
+		}
+		if qu.workCtx.Err() != nil {
+			// Aborted by Shutdown
+			qu.addUndelivered(batch)
+			qu.failed(batch, err)
+			return
+		}
+
+		if errors.Is(err, ErrPermanent) {
+			if len(batch) > 1 {
+				mid := len(batch) / 2
+				b.deliver(batch[:mid])
+				b.deliver(batch[mid:])
+				return
+			}
+			qu.failed(batch[0], err)
+			return
+		}
+
+		if qu.spool != nil {
+			qu.failed(batch, err)
+			return
+		}
+
+		// Retry until delivered or Shutdown
+		wait := qu.client.backoff(attempt)
+		qu.log.WithField("func", "lxAudit.batcher.deliver").Warnf("deliver batch of %d entries, retry in %s: %v", len(batch), wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-qu.workCtx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
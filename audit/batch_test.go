@@ -0,0 +1,314 @@
+package lxAudit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// batchServer, record delivered batches, batches with collection "bad" are rejected,
+// the first unavailable requests fail with 503
+type batchServer struct {
+	mux         sync.Mutex
+	paths       []string
+	batches     []lxAudit.AuditEntries
+	rejected    int
+	unavailable int
+}
+
+func (s *batchServer) handler(w http.ResponseWriter, r *http.Request) {
+	var entries lxAudit.AuditEntries
+	body, _ := ioutil.ReadAll(r.Body)
+	_ = json.Unmarshal(body, &entries)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.paths = append(s.paths, r.URL.Path)
+	if s.unavailable > 0 {
+		s.unavailable--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	for _, e := range entries {
+		if e.Collection == "bad" {
+			s.rejected++
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	s.batches = append(s.batches, entries)
+	w.WriteHeader(http.StatusOK)
+}
+
+// names, return user names of delivered entries in order
+func (s *batchServer) names() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var names []string
+	for _, batch := range s.batches {
+		for _, e := range batch {
+			names = append(names, e.User.(map[string]interface{})["name"].(string))
+		}
+	}
+	return names
+}
+
+func batchEntry(i int, collection string) bson.M {
+	return bson.M{
+		"collection": collection,
+		"action":     lxAudit.Insert,
+		"user":       map[string]interface{}{"name": fmt.Sprintf("user_%02d", i)},
+		"data":       map[string]interface{}{"_id": i},
+	}
+}
+
+func TestQueue_SetBatching(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("size", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxEntries: 10, MaxLatency: time.Hour})
+
+		var expected []string
+		for i := 0; i < 25; i++ {
+			queue.Send(batchEntry(i, "orders"))
+			expected = append(expected, fmt.Sprintf("user_%02d", i))
+		}
+		queue.Flush()
+
+		its.Equal([]string{lxAudit.PathLogEntries, lxAudit.PathLogEntries, lxAudit.PathLogEntries}, srv.paths)
+		its.Len(srv.batches[0], 10)
+		its.Len(srv.batches[2], 5)
+		its.Equal(expected, srv.names())
+	})
+	t.Run("bytes", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxBytes: 250, MaxLatency: time.Hour})
+
+		queue.Send([]bson.M{batchEntry(0, "orders"), batchEntry(1, "orders"), batchEntry(2, "orders")})
+		queue.Flush()
+
+		its.Len(srv.batches, 2)
+		its.Equal([]string{"user_00", "user_01", "user_02"}, srv.names())
+	})
+	t.Run("latency", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxLatency: time.Millisecond * 50})
+		queue.StartWorker(queue.JobChan, queue.KillChan, queue.ErrChan)
+		defer close(queue.KillChan)
+
+		// Jobs of JobChan are batched by worker
+		queue.JobChan <- lxAudit.AuditEntry{Collection: "orders", User: map[string]interface{}{"name": "user_00"}}
+		its.NoError(<-queue.ErrChan)
+		queue.JobChan <- "foo"
+		its.Equal(lxAudit.ErrAuditEntryType, <-queue.ErrChan)
+
+		its.Eventually(func() bool {
+			return len(srv.names()) == 1
+		}, time.Second, time.Millisecond*10)
+	})
+	t.Run("split rejected", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, hook := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxLatency: time.Hour})
+
+		for i := 0; i < 8; i++ {
+			collection := "orders"
+			if i == 5 {
+				collection = "bad"
+			}
+			queue.Send(batchEntry(i, collection))
+		}
+		queue.Flush()
+
+		// Bad entry is isolated and logged, others are delivered in order
+		its.Equal([]string{"user_00", "user_01", "user_02", "user_03", "user_04", "user_06", "user_07"}, srv.names())
+		its.Equal(4, srv.rejected)
+
+		var entry lxAudit.AuditEntry
+		its.NoError(json.Unmarshal([]byte(hook.LastEntry().Data["audit"].(string)), &entry))
+		its.Equal("bad", entry.Collection)
+	})
+	t.Run("retry in order", func(t *testing.T) {
+		srv := &batchServer{unavailable: 3}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetClient(lxAudit.NewClient(server.URL, "", &lxAudit.ClientOptions{
+			MaxRetries: -1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 5, BreakerThreshold: 100,
+		}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxEntries: 10, MaxLatency: time.Hour})
+
+		var expected []string
+		for i := 0; i < 25; i++ {
+			queue.Send(batchEntry(i, "orders"))
+			expected = append(expected, fmt.Sprintf("user_%02d", i))
+		}
+		queue.Flush()
+
+		// Failed batch is retried before later batches
+		its.Equal(expected, srv.names())
+	})
+	t.Run("spool in order", func(t *testing.T) {
+		srv := &batchServer{unavailable: 1}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "lxaudit-batch")
+		its.NoError(err)
+		defer os.RemoveAll(dir)
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetClient(lxAudit.NewClient(server.URL, "", &lxAudit.ClientOptions{MaxRetries: -1}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxEntries: 10, MaxLatency: time.Hour})
+		spool, err := lxAudit.NewSpool(lxAudit.SpoolOptions{Dir: dir, ReplayInterval: time.Millisecond * 10})
+		its.NoError(err)
+		defer spool.Close()
+		queue.SetSpool(spool)
+
+		var expected []string
+		for i := 0; i < 25; i++ {
+			queue.Send(batchEntry(i, "orders"))
+			expected = append(expected, fmt.Sprintf("user_%02d", i))
+		}
+		queue.Flush()
+
+		// Later batches follow the failed batch into the spool
+		its.Eventually(func() bool {
+			return len(srv.names()) == 25
+		}, time.Second*5, time.Millisecond*10)
+		its.Equal(expected, srv.names())
+	})
+}
+
+func TestQueue_SetBatching_MaxPending(t *testing.T) {
+	its := assert.New(t)
+
+	// downServer, batchServer unavailable until up is called
+	downServer := func() (*batchServer, *httptest.Server, func()) {
+		srv := &batchServer{unavailable: 1 << 30}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		return srv, server, func() {
+			srv.mux.Lock()
+			srv.unavailable = 0
+			srv.mux.Unlock()
+		}
+	}
+	// setup, retry fast with batches of one entry
+	setup := func(queue interface {
+		SetClient(*lxAudit.Client)
+		SetBatching(lxAudit.BatchOptions)
+	}, url string) {
+		queue.SetClient(lxAudit.NewClient(url, "", &lxAudit.ClientOptions{
+			MaxRetries: -1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 5, BreakerThreshold: 1 << 20,
+		}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxEntries: 1, MaxLatency: time.Hour, MaxPending: 5})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		srv, server, up := downServer()
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		setup(queue, server.URL)
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{Size: 1}))
+		its.NoError(queue.Start(1))
+
+		// Send blocks while the service is down and batches are pending
+		var expected []string
+		sent := make(chan struct{})
+		go func() {
+			for i := 0; i < 20; i++ {
+				queue.Send(batchEntry(i, "orders"))
+			}
+			close(sent)
+		}()
+		for i := 0; i < 20; i++ {
+			expected = append(expected, fmt.Sprintf("user_%02d", i))
+		}
+		select {
+		case <-sent:
+			t.Error("send should block with max pending batches")
+		case <-time.After(time.Millisecond * 200):
+		}
+
+		up()
+		<-sent
+		_, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		its.Equal(expected, srv.names())
+	})
+	t.Run("drop oldest", func(t *testing.T) {
+		srv, server, up := downServer()
+		defer server.Close()
+
+		logger, hook := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		setup(queue, server.URL)
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{Overflow: lxAudit.OverflowDropOldest}))
+		its.NoError(queue.Start(1))
+
+		for i := 0; i < 100; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+		// Service is down for a long time, pending batches are limited
+		dropped := func() int {
+			n := 0
+			for _, entry := range hook.AllEntries() {
+				if strings.Contains(entry.Message, lxAudit.ErrQueueFull.Error()) {
+					n++
+				}
+			}
+			return n
+		}
+		its.Eventually(func() bool {
+			return dropped() == 95
+		}, time.Second*5, time.Millisecond*10)
+		time.Sleep(time.Millisecond * 50)
+		its.Equal(95, dropped())
+
+		up()
+		_, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		names := srv.names()
+		its.Len(names, 5)
+		its.Equal([]string{"user_96", "user_97", "user_98", "user_99"}, names[1:])
+	})
+}
@@ -0,0 +1,359 @@
+package lxAudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults of ClientOptions
+	DefaultClientMaxRetries       = 3
+	DefaultClientMinBackoff       = time.Millisecond * 100
+	DefaultClientMaxBackoff       = time.Second * 10
+	DefaultClientBreakerThreshold = 5
+	DefaultClientBreakerCooldown  = time.Second * 30
+
+	// States of circuit breaker
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	// maxResultSize, read limit of response body for StatusError
+	maxResultSize = 64 << 10
+)
+
+// sharedHTTPClient, connections are reused by RequestAudit
+var sharedHTTPClient = &http.Client{Transport: newTransport()}
+
+// newTransport, return transport with connection reuse for the audit service
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 16
+	return transport
+}
+
+// StatusError, error status of audit service.
+// Is ErrStatus, ErrRespContent with result, ErrTransient for 408, 429 and 5xx
+// or ErrPermanent for rejections (other 4xx).
+type StatusError struct {
+	Code   int
+	Status string
+	// Result, decoded json or text of response body
+	Result interface{}
+	// RetryAfter, of header Retry-After
+	RetryAfter time.Duration
+}
+
+// Error, message of status and result
+func (e *StatusError) Error() string {
+	if e.Result != nil {
+		return fmt.Sprintf("status: %v result: %v, %v", e.Status, e.Result, ErrRespContent)
+	}
+	return fmt.Sprintf("%v, %v", e.Status, ErrStatus)
+}
+
+// Temporary, return true when request can be retried
+func (e *StatusError) Temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusTooManyRequests || e.Code == http.StatusRequestTimeout
+}
+
+// Is, errors.Is support for ErrStatus, ErrRespContent, ErrTransient and ErrPermanent
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrStatus:
+		return true
+	case ErrRespContent:
+		return e.Result != nil
+	case ErrTransient:
+		return e.Temporary()
+	case ErrPermanent:
+		return !e.Temporary()
+	}
+	return false
+}
+
+// transientError, network error or open circuit breaker, is ErrTransient
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func (e *transientError) Is(target error) bool {
+	return target == ErrTransient
+}
+
+// ClientOptions, options for NewClient
+type ClientOptions struct {
+	// Timeout, per attempt, default DefaultTimeout
+	Timeout time.Duration
+	// MaxRetries, retries of transient errors, default DefaultClientMaxRetries, negative without retries
+	MaxRetries int
+	// MinBackoff and MaxBackoff, wait before retry, doubled by each retry with jitter
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold, failed attempts in a row which open the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown, requests fail with ErrCircuitOpen until a trial request after cooldown succeeds
+	BreakerCooldown time.Duration
+	// HTTPClient, default client with connection reuse
+	HTTPClient *http.Client
+}
+
+// Client, reusable client of audit service with retries and circuit breaker
+type Client struct {
+	auditHost    string
+	auditAuthKey string
+	opts         ClientOptions
+
+	mux       sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// NewClient, return client of audit service, opts can be nil
+// Example:
+// client := lxAudit.NewClient(auditHost, authKey, &lxAudit.ClientOptions{MaxRetries: 5})
+// err := client.Request(entries)
+// if errors.Is(err, lxAudit.ErrPermanent) { ... }
+func NewClient(auditHost, auditAuthKey string, opts *ClientOptions) *Client {
+	o := ClientOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultClientMaxRetries
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = DefaultClientMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = DefaultClientMaxBackoff
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+	if o.BreakerThreshold <= 0 {
+		o.BreakerThreshold = DefaultClientBreakerThreshold
+	}
+	if o.BreakerCooldown <= 0 {
+		o.BreakerCooldown = DefaultClientBreakerCooldown
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Transport: newTransport()}
+	}
+
+	return &Client{auditHost: auditHost, auditAuthKey: auditAuthKey, opts: o}
+}
+
+// Request, send entry or entries to audit service, see RequestContext
+func (c *Client) Request(elem interface{}) error {
+	return c.RequestContext(context.Background(), elem)
+}
+
+// RequestContext, send entry or entries to audit service.
+// Transient errors (network, 408, 429 and 5xx) are retried with exponential backoff
+// and jitter, Retry-After is honoured up to MaxBackoff. Returned errors are ErrTransient,
+// ErrPermanent for rejections by the service or ErrAuditEntryType.
+// With open circuit breaker ErrCircuitOpen is returned without request.
+func (c *Client) RequestContext(ctx context.Context, elem interface{}) error {
+	switch elem.(type) {
+	case AuditEntry, AuditEntries:
+	default:
+		return ErrAuditEntryType
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !c.allow() {
+			return &transientError{err: ErrCircuitOpen}
+		}
+
+		actx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		err := doRequest(actx, c.opts.HTTPClient, elem, c.auditHost, c.auditAuthKey)
+		cancel()
+		if ctx.Err() != nil {
+			// Canceled by caller, not a failure of the service
+			c.endTrial()
+			return err
+		}
+		c.record(err)
+
+		if err == nil || !errors.Is(err, ErrTransient) || attempt >= c.opts.MaxRetries {
+			return err
+		}
+
+		wait := c.backoff(attempt)
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > wait {
+			wait = se.RetryAfter
+			if wait > c.opts.MaxBackoff {
+				wait = c.opts.MaxBackoff
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff, return wait before retry with jitter between half and full backoff
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.MinBackoff << uint(attempt)
+	if d <= 0 || d > c.opts.MaxBackoff {
+		d = c.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// allow, return false with open circuit breaker,
+// after cooldown one trial request is allowed
+func (c *Client) allow() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.failures < c.opts.BreakerThreshold {
+		return true
+	}
+	if time.Now().Before(c.openUntil) || c.trial {
+		return false
+	}
+	c.trial = true
+	return true
+}
+
+// record, update circuit breaker with result of attempt,
+// only transient errors are failures of the service
+func (c *Client) record(err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.trial = false
+	if err != nil && errors.Is(err, ErrTransient) {
+		c.failures++
+		if c.failures >= c.opts.BreakerThreshold {
+			c.openUntil = time.Now().Add(c.opts.BreakerCooldown)
+		}
+		return
+	}
+	c.failures = 0
+}
+
+// endTrial, allow next trial request after an attempt without result
+func (c *Client) endTrial() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.trial = false
+}
+
+// State, return state of circuit breaker, BreakerClosed, BreakerOpen or BreakerHalfOpen
+func (c *Client) State() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch {
+	case c.failures < c.opts.BreakerThreshold:
+		return BreakerClosed
+	case time.Now().Before(c.openUntil):
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// doRequest, one attempt to send entry or entries to audit service
+func doRequest(ctx context.Context, client *http.Client, elem interface{}, auditHost, auditAuthKey string) error {
+	var path string
+	switch elem.(type) {
+	case AuditEntries:
+		path = PathLogEntries
+	case AuditEntry:
+		path = PathLogEntry
+	default:
+		// Wrong type return with error
+		return ErrAuditEntryType
+	}
+
+	// Convert entry or entries to json
+	jsonBody, err := json.Marshal(elem)
+	if err != nil {
+		return err
+	}
+
+	// Make request
+	req, err := http.NewRequestWithContext(ctx, "POST", auditHost+path, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+
+	// Set header for request
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+auditAuthKey)
+
+	// Start request
+	resp, err := client.Do(req)
+	if err != nil {
+		return &transientError{err: err}
+	}
+	defer func() {
+		// Drain for connection reuse
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResultSize))
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	// Error with result of body
+	statusErr := &StatusError{
+		Code:       resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResultSize))
+	if err == nil && len(bytes.TrimSpace(body)) > 0 {
+		var result interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			result = string(body)
+		}
+		statusErr.Result = result
+	}
+	return statusErr
+}
+
+// retryAfter, return duration of Retry-After header with seconds or http date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package lxAudit_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusServer, respond with statuses in order, the last one is repeated
+func statusServer(attempts *int32, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(attempts, 1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+		if statuses[n] == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		if statuses[n] == http.StatusBadRequest {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statuses[n])
+			_, _ = w.Write([]byte(`{"message":"invalid entry"}`))
+			return
+		}
+		w.WriteHeader(statuses[n])
+	}))
+}
+
+func testClientOptions() *lxAudit.ClientOptions {
+	return &lxAudit.ClientOptions{
+		Timeout:         time.Second,
+		MinBackoff:      time.Millisecond,
+		MaxBackoff:      time.Millisecond * 5,
+		BreakerCooldown: time.Millisecond * 50,
+	}
+}
+
+func TestClient_Request(t *testing.T) {
+	its := assert.New(t)
+	entry := getTestEntries()[0]
+
+	t.Run("retry 5xx", func(t *testing.T) {
+		var attempts int32
+		server := statusServer(&attempts, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+		defer server.Close()
+
+		client := lxAudit.NewClient(server.URL, "", testClientOptions())
+		its.NoError(client.Request(entry))
+		its.Equal(int32(3), atomic.LoadInt32(&attempts))
+	})
+	t.Run("retries exhausted", func(t *testing.T) {
+		var attempts int32
+		server := statusServer(&attempts, http.StatusInternalServerError)
+		defer server.Close()
+
+		opts := testClientOptions()
+		opts.MaxRetries = 2
+		err := lxAudit.NewClient(server.URL, "", opts).Request(entry)
+		its.True(errors.Is(err, lxAudit.ErrTransient))
+		its.True(errors.Is(err, lxAudit.ErrStatus))
+		its.False(errors.Is(err, lxAudit.ErrPermanent))
+		its.Equal(int32(3), atomic.LoadInt32(&attempts))
+
+		var statusErr *lxAudit.StatusError
+		its.True(errors.As(err, &statusErr))
+		its.Equal(http.StatusInternalServerError, statusErr.Code)
+	})
+	t.Run("retry after", func(t *testing.T) {
+		var attempts int32
+		server := statusServer(&attempts, http.StatusTooManyRequests, http.StatusOK)
+		defer server.Close()
+
+		opts := testClientOptions()
+		opts.MaxBackoff = time.Second * 2
+		start := time.Now()
+		its.NoError(lxAudit.NewClient(server.URL, "", opts).Request(entry))
+		its.True(time.Since(start) >= time.Second)
+		its.Equal(int32(2), atomic.LoadInt32(&attempts))
+
+		// Retry-After is limited by MaxBackoff
+		atomic.StoreInt32(&attempts, 0)
+		start = time.Now()
+		its.NoError(lxAudit.NewClient(server.URL, "", testClientOptions()).Request(entry))
+		its.True(time.Since(start) < time.Second)
+		its.Equal(int32(2), atomic.LoadInt32(&attempts))
+	})
+	t.Run("permanent rejection", func(t *testing.T) {
+		var attempts int32
+		server := statusServer(&attempts, http.StatusBadRequest)
+		defer server.Close()
+
+		err := lxAudit.NewClient(server.URL, "", testClientOptions()).Request(entry)
+		its.True(errors.Is(err, lxAudit.ErrPermanent))
+		its.True(errors.Is(err, lxAudit.ErrRespContent))
+		its.False(errors.Is(err, lxAudit.ErrTransient))
+		its.Equal(int32(1), atomic.LoadInt32(&attempts))
+
+		var statusErr *lxAudit.StatusError
+		its.True(errors.As(err, &statusErr))
+		its.Equal(map[string]interface{}{"message": "invalid entry"}, statusErr.Result)
+	})
+	t.Run("network error", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		url := server.URL
+		server.Close()
+
+		err := lxAudit.NewClient(url, "", testClientOptions()).Request(entry)
+		its.True(errors.Is(err, lxAudit.ErrTransient))
+		var netErr net.Error
+		its.True(errors.As(err, &netErr))
+	})
+	t.Run("wrong type", func(t *testing.T) {
+		err := lxAudit.NewClient("http://localhost", "", nil).Request("foo")
+		its.Equal(lxAudit.ErrAuditEntryType, err)
+	})
+	t.Run("context", func(t *testing.T) {
+		var attempts int32
+		server := statusServer(&attempts, http.StatusServiceUnavailable)
+		defer server.Close()
+
+		opts := testClientOptions()
+		opts.MinBackoff, opts.MaxBackoff = time.Second, time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		err := lxAudit.NewClient(server.URL, "", opts).RequestContext(ctx, entry)
+		its.True(errors.Is(err, lxAudit.ErrTransient))
+		its.Equal(int32(1), atomic.LoadInt32(&attempts))
+	})
+	t.Run("canceled not recorded", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 200)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		opts := testClientOptions()
+		opts.BreakerThreshold = 1
+		client := lxAudit.NewClient(server.URL, "", opts)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+		its.Error(client.RequestContext(ctx, entry))
+
+		// Canceled request is not a failure of the service
+		its.Equal(lxAudit.BreakerClosed, client.State())
+	})
+	t.Run("connection reuse", func(t *testing.T) {
+		var conns int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		client := lxAudit.NewClient(server.URL, "", nil)
+		for i := 0; i < 5; i++ {
+			its.NoError(client.Request(entry))
+		}
+		its.Equal(int32(1), atomic.LoadInt32(&conns))
+	})
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	its := assert.New(t)
+	entry := getTestEntries()[0]
+
+	var attempts, up int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	opts := testClientOptions()
+	opts.MaxRetries = -1
+	opts.BreakerThreshold = 2
+	client := lxAudit.NewClient(server.URL, "", opts)
+
+	its.Error(client.Request(entry))
+	its.Equal(lxAudit.BreakerClosed, client.State())
+	its.Error(client.Request(entry))
+	its.Equal(lxAudit.BreakerOpen, client.State())
+
+	// Open breaker short-circuits
+	err := client.Request(entry)
+	its.True(errors.Is(err, lxAudit.ErrCircuitOpen))
+	its.True(errors.Is(err, lxAudit.ErrTransient))
+	its.Equal(int32(2), atomic.LoadInt32(&attempts))
+
+	// Failed trial after cooldown opens again
+	time.Sleep(time.Millisecond * 60)
+	its.Equal(lxAudit.BreakerHalfOpen, client.State())
+	its.Error(client.Request(entry))
+	its.Equal(lxAudit.BreakerOpen, client.State())
+	its.Equal(int32(3), atomic.LoadInt32(&attempts))
+
+	// Successful trial closes
+	atomic.StoreInt32(&up, 1)
+	time.Sleep(time.Millisecond * 60)
+	its.NoError(client.Request(entry))
+	its.Equal(lxAudit.BreakerClosed, client.State())
+}
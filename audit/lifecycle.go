@@ -0,0 +1,336 @@
+package lxAudit
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBufferSize, jobs of Send buffered for workers of Start
+	DefaultBufferSize = 1000
+
+	// Overflow policies of full buffer
+	// OverflowBlock, Send waits for free space
+	OverflowBlock = "block"
+	// OverflowDropOldest, the oldest job is dropped and logged
+	OverflowDropOldest = "drop-oldest"
+	// OverflowSpill, the job is appended to the spool, see SetSpool
+	OverflowSpill = "spill"
+)
+
+// BufferOptions, options for SetBuffer
+type BufferOptions struct {
+	// Size, default DefaultBufferSize
+	Size int
+	// Overflow, OverflowBlock (default), OverflowDropOldest or OverflowSpill
+	Overflow string
+}
+
+// SetBuffer, set size and overflow policy of buffer for Send,
+// call before Start and Send
+// Example:
+// queue := NewQueue(....)
+// queue.SetBuffer(lxAudit.BufferOptions{Size: 5000, Overflow: lxAudit.OverflowSpill})
+// queue.Start(4)
+func (qu *queue) SetBuffer(opts BufferOptions) error {
+	opts, err := bufferOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	qu.lifeMux.Lock()
+	defer qu.lifeMux.Unlock()
+	if qu.jobs != nil {
+		return fmt.Errorf("buffer already set, %w", ErrBuffer)
+	}
+	qu.jobs = make(chan interface{}, opts.Size)
+	qu.overflow = opts.Overflow
+	return nil
+}
+
+// bufferOptions, return options with defaults, ErrBuffer for unknown overflow policy
+func bufferOptions(opts BufferOptions) (BufferOptions, error) {
+	if opts.Size <= 0 {
+		opts.Size = DefaultBufferSize
+	}
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OverflowBlock
+	case OverflowBlock, OverflowDropOldest, OverflowSpill:
+	default:
+		return opts, fmt.Errorf("overflow %q, %w", opts.Overflow, ErrBuffer)
+	}
+	return opts, nil
+}
+
+// Start, start n workers for the buffer of Send,
+// the buffer is created with defaults when SetBuffer was not called,
+// call before Send.
+// With batching one worker preserves the order of Send.
+// Example:
+// queue := NewQueue(....)
+// queue.Start(4)
+// defer queue.Shutdown(ctx)
+func (qu *queue) Start(n int) error {
+	qu.lifeMux.Lock()
+	defer qu.lifeMux.Unlock()
+	if qu.closed {
+		return ErrQueueClosed
+	}
+	if qu.jobs == nil {
+		qu.jobs = make(chan interface{}, DefaultBufferSize)
+		qu.overflow = OverflowBlock
+	}
+	for i := 0; i < n; i++ {
+		qu.workers.Add(1)
+		workerNum := atomic.AddInt32(&qu.runningWorkers, 1)
+		go qu.work(workerNum)
+	}
+	return nil
+}
+
+// work, deliver jobs of buffer until the buffer is closed by Shutdown,
+// after the deadline of Shutdown jobs are not delivered
+func (qu *queue) work(workerNum int32) {
+	defer qu.workers.Done()
+	defer atomic.AddInt32(&qu.runningWorkers, -1)
+
+	for job := range qu.jobs {
+		if err := qu.workCtx.Err(); err != nil {
+			qu.addUndelivered(job)
+			qu.failed(job, err)
+			qu.count(-1)
+			continue
+		}
+
+		if qu.batch != nil {
+			// coalesce into batch, delivered by batcher
+			if err := qu.batch.add(job); err != nil {
+				qu.failed(job, err)
+			}
+			qu.count(-1)
+			continue
+		}
+		qu.count(-1)
+
+		if err := qu.client.RequestContext(qu.workCtx, job); err != nil {
+			if qu.workCtx.Err() != nil {
+				qu.addUndelivered(job)
+			}
+			qu.failed(job, err)
+		}
+		// stop before next job
+		time.Sleep(qu.throttle)
+	}
+	qu.log.Debugf("shutdown worker %d", workerNum)
+}
+
+// startForward, create buffer with defaults when SetBuffer and Start were not called
+// and forward jobs of buffer to the workers of StartWorker or to the batch
+func (qu *queue) startForward() {
+	qu.lifeMux.Lock()
+	defer qu.lifeMux.Unlock()
+	if qu.closed || qu.jobs != nil {
+		return
+	}
+	qu.jobs = make(chan interface{}, DefaultBufferSize)
+	qu.overflow = OverflowBlock
+
+	qu.workers.Add(1)
+	go qu.forward()
+}
+
+// forward, move jobs of buffer in order to JobChan or batch until the buffer is closed by Shutdown
+func (qu *queue) forward() {
+	defer qu.workers.Done()
+
+	for job := range qu.jobs {
+		if qu.batch != nil {
+			if err := qu.batch.add(job); err != nil {
+				qu.failed(job, err)
+			}
+			qu.count(-1)
+			continue
+		}
+		select {
+		case qu.JobChan <- job:
+		case <-qu.workCtx.Done():
+			qu.addUndelivered(job)
+			qu.failed(job, qu.workCtx.Err())
+		}
+		qu.count(-1)
+	}
+}
+
+// push, add job to buffer, the job is counted until taken by a worker,
+// without block return false when buffer is full
+func (qu *queue) push(job interface{}, block bool) bool {
+	qu.count(1)
+	if block {
+		select {
+		case qu.jobs <- job:
+			return true
+		case <-qu.closing:
+		}
+	} else {
+		select {
+		case qu.jobs <- job:
+			return true
+		default:
+		}
+	}
+	qu.count(-1)
+	return false
+}
+
+// count, change number of jobs in buffer, wake waiters when empty
+func (qu *queue) count(delta int) {
+	qu.bufMux.Lock()
+	qu.buffered += delta
+	if qu.buffered == 0 {
+		qu.bufCond.Broadcast()
+	}
+	qu.bufMux.Unlock()
+}
+
+// waitBuffer, wait until all jobs of buffer are taken by workers
+func (qu *queue) waitBuffer() {
+	qu.bufMux.Lock()
+	for qu.buffered > 0 {
+		qu.bufCond.Wait()
+	}
+	qu.bufMux.Unlock()
+}
+
+// enqueue, add job to buffer by overflow policy, after Shutdown the job is failed
+func (qu *queue) enqueue(job interface{}) {
+	qu.lifeMux.RLock()
+	buffered := qu.jobs != nil
+	qu.lifeMux.RUnlock()
+	if !buffered {
+		qu.startForward()
+	}
+
+	qu.lifeMux.RLock()
+	if qu.closed {
+		qu.lifeMux.RUnlock()
+		qu.failed(job, ErrQueueClosed)
+		return
+	}
+	qu.senders.Add(1)
+	qu.lifeMux.RUnlock()
+	defer qu.senders.Done()
+
+	ctxLog := qu.log.WithField("func", "lxAudit.Send")
+	switch qu.overflow {
+	case OverflowDropOldest:
+		for !qu.push(job, false) {
+			select {
+			case old := <-qu.jobs:
+				qu.count(-1)
+				logJob(ctxLog, old, fmt.Errorf("dropped oldest job, %w", ErrQueueFull))
+			default:
+			}
+		}
+		return
+	case OverflowSpill:
+		if qu.push(job, false) {
+			return
+		}
+		if qu.spool == nil {
+			logJob(ctxLog, job, fmt.Errorf("no spool for overflow, %w", ErrQueueFull))
+			return
+		}
+		if err := qu.spool.Append(job); err != nil {
+			logJob(ctxLog, job, fmt.Errorf("%v, %w", err, ErrQueueFull))
+		}
+		return
+	default:
+		if !qu.push(job, true) {
+			qu.failed(job, ErrQueueClosed)
+		}
+	}
+}
+
+// addUndelivered, add job to report of Shutdown
+func (qu *queue) addUndelivered(job interface{}) {
+	qu.pendingMux.Lock()
+	defer qu.pendingMux.Unlock()
+	qu.undelivered = append(qu.undelivered, job)
+}
+
+// Shutdown, stop accepting new entries, deliver buffered and batched entries
+// and stop workers of Start. Entries which are not delivered before the deadline
+// of ctx are returned with error and spooled or logged like failed jobs.
+// Send after Shutdown spools or logs with ErrQueueClosed.
+// Example:
+// ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// defer cancel()
+// undelivered, err := queue.Shutdown(ctx)
+func (qu *queue) Shutdown(ctx context.Context) (AuditEntries, error) {
+	qu.lifeMux.Lock()
+	if qu.closed {
+		qu.lifeMux.Unlock()
+		return nil, ErrQueueClosed
+	}
+	qu.closed = true
+	close(qu.closing)
+	jobs := qu.jobs
+	qu.lifeMux.Unlock()
+
+	// Wait of blocked senders, they are released by closing
+	qu.senders.Wait()
+	if jobs != nil {
+		close(jobs)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		qu.workers.Wait()
+		qu.Flush()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		// Abort deliveries, remaining jobs are undelivered
+		qu.cancelWork()
+		<-done
+	}
+	qu.cancelWork()
+	if qu.batch != nil {
+		qu.batch.stop()
+	}
+
+	qu.pendingMux.Lock()
+	var undelivered AuditEntries
+	for _, job := range qu.undelivered {
+		switch val := job.(type) {
+		case AuditEntry:
+			undelivered = append(undelivered, val)
+		case AuditEntries:
+			undelivered = append(undelivered, val...)
+		}
+	}
+	qu.undelivered = nil
+	qu.pendingMux.Unlock()
+
+	if err != nil {
+		return undelivered, fmt.Errorf("%d entries undelivered, %w", len(undelivered), err)
+	}
+	return undelivered, nil
+}
+
+// Close, Shutdown for Sink, undelivered entries are spooled or logged
+// Example:
+// sink, err := lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkService, ...}, logEntry)
+// defer sink.Close(ctx)
+func (qu *queue) Close(ctx context.Context) error {
+	_, err := qu.Shutdown(ctx)
+	return err
+}
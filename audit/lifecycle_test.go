@@ -0,0 +1,216 @@
+package lxAudit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// countServer, count received entries, requests wait until release is closed
+func countServer(received *int32, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if release != nil {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		atomic.AddInt32(received, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestQueue_Shutdown(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("drain", func(t *testing.T) {
+		var received int32
+		server := countServer(&received, nil)
+		defer server.Close()
+
+		logger, hook := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		its.NoError(queue.Start(3))
+		its.Equal(3, queue.GetCountOfRunningWorkers())
+		its.True(queue.IsActive())
+
+		// Concurrent senders
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					queue.Send(batchEntry(i*10+j, "orders"))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		undelivered, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		its.Empty(undelivered)
+		its.Equal(int32(50), atomic.LoadInt32(&received))
+		its.Equal(0, queue.GetCountOfRunningWorkers())
+		its.False(queue.IsActive())
+
+		// Closed queue
+		queue.Send(batchEntry(50, "orders"))
+		its.Contains(hook.LastEntry().Message, lxAudit.ErrQueueClosed.Error())
+		_, err = queue.Shutdown(context.Background())
+		its.Equal(lxAudit.ErrQueueClosed, err)
+		its.Equal(lxAudit.ErrQueueClosed, queue.Start(1))
+	})
+	t.Run("deadline", func(t *testing.T) {
+		var received int32
+		release := make(chan struct{})
+		server := countServer(&received, release)
+		defer server.Close()
+		defer close(release)
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		its.NoError(queue.Start(1))
+		for i := 0; i < 5; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		undelivered, err := queue.Shutdown(ctx)
+		its.True(errors.Is(err, context.DeadlineExceeded))
+		its.Len(undelivered, 5)
+		its.Equal(int32(0), atomic.LoadInt32(&received))
+		its.Equal(0, queue.GetCountOfRunningWorkers())
+	})
+	t.Run("batching", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}))
+		queue.SetBatching(lxAudit.BatchOptions{MaxLatency: time.Hour})
+		its.NoError(queue.Start(1))
+		for i := 0; i < 5; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+
+		undelivered, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		its.Empty(undelivered)
+		its.Equal([]string{"user_00", "user_01", "user_02", "user_03", "user_04"}, srv.names())
+	})
+}
+
+func TestQueue_SetBuffer(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("invalid", func(t *testing.T) {
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", "", "", logger.WithFields(logrus.Fields{}))
+		its.True(errors.Is(queue.SetBuffer(lxAudit.BufferOptions{Overflow: "foo"}), lxAudit.ErrBuffer))
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{}))
+		its.True(errors.Is(queue.SetBuffer(lxAudit.BufferOptions{}), lxAudit.ErrBuffer))
+	})
+	t.Run("default", func(t *testing.T) {
+		var received int32
+		release := make(chan struct{})
+		server := countServer(&received, release)
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		queue.StartWorker(queue.JobChan, queue.KillChan)
+		defer close(queue.KillChan)
+
+		// Without SetBuffer and Start entries are buffered without goroutine per entry
+		goroutines := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+		its.Less(runtime.NumGoroutine()-goroutines, 10)
+
+		close(release)
+		its.Eventually(func() bool {
+			return atomic.LoadInt32(&received) == 100
+		}, time.Second*5, time.Millisecond*10)
+	})
+	t.Run("block", func(t *testing.T) {
+		var received int32
+		server := countServer(&received, nil)
+		defer server.Close()
+
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{Size: 1}))
+
+		// Second send blocks until workers are started
+		queue.Send(batchEntry(0, "orders"))
+		sent := make(chan struct{})
+		go func() {
+			queue.Send(batchEntry(1, "orders"))
+			close(sent)
+		}()
+		select {
+		case <-sent:
+			t.Error("send should block with full buffer")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		its.NoError(queue.Start(1))
+		<-sent
+		_, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		its.Equal(int32(2), atomic.LoadInt32(&received))
+	})
+	t.Run("drop oldest", func(t *testing.T) {
+		srv := &batchServer{}
+		server := httptest.NewServer(http.HandlerFunc(srv.handler))
+		defer server.Close()
+
+		logger, hook := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", server.URL, "", logger.WithFields(logrus.Fields{}), time.Millisecond)
+		queue.SetBatching(lxAudit.BatchOptions{MaxLatency: time.Hour})
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{Size: 2, Overflow: lxAudit.OverflowDropOldest}))
+		for i := 0; i < 5; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+		its.Len(hook.AllEntries(), 3)
+		for _, entry := range hook.AllEntries() {
+			its.Contains(entry.Message, lxAudit.ErrQueueFull.Error())
+		}
+
+		its.NoError(queue.Start(1))
+		_, err := queue.Shutdown(context.Background())
+		its.NoError(err)
+		its.Equal([]string{"user_03", "user_04"}, srv.names())
+	})
+	t.Run("spill", func(t *testing.T) {
+		logger, _ := test.NewNullLogger()
+		queue := lxAudit.NewQueue("test-host", "", "", logger.WithFields(logrus.Fields{}))
+		spool := newTestSpool(t, lxAudit.SpoolOptions{ReplayInterval: time.Hour})
+		defer spool.Close()
+		queue.SetSpool(spool)
+		its.NoError(queue.SetBuffer(lxAudit.BufferOptions{Size: 2, Overflow: lxAudit.OverflowSpill}))
+
+		queue.Send([]bson.M{batchEntry(0, "orders")})
+		for i := 1; i < 4; i++ {
+			queue.Send(batchEntry(i, "orders"))
+		}
+		its.Equal(uint64(2), spool.Metrics().Appended)
+	})
+}
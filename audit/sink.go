@@ -0,0 +1,335 @@
+package lxAudit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	lxLog "github.com/litixsoft/lxgo/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Sink, destination of audit entries with Send/IsActive contract of IAudit
+// (IBaseRepoAudit), Send does not wait for the write. Implemented by queue (audit service),
+// MongoSink, FileSink, LogSink and FanOutSink, see NewSink for selection by configuration.
+type Sink interface {
+	IAudit
+	// Close, write buffered entries until deadline of ctx and release resources,
+	// Send after Close is ignored
+	Close(ctx context.Context) error
+}
+
+// sinkBuffer, bounded buffer of Send, jobs are written in order by one goroutine
+type sinkBuffer struct {
+	jobs     chan interface{}
+	overflow string
+	write    func(job interface{})
+	log      *logrus.Entry
+
+	mux     sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	senders sync.WaitGroup
+	abort   chan struct{}
+	done    chan struct{}
+}
+
+// sinkBufferOptions, return options with defaults, ErrBuffer for OverflowSpill without spool
+func sinkBufferOptions(opts BufferOptions) (BufferOptions, error) {
+	opts, err := bufferOptions(opts)
+	if err != nil {
+		return opts, err
+	}
+	if opts.Overflow == OverflowSpill {
+		return opts, fmt.Errorf("overflow %q needs spool, %w", opts.Overflow, ErrBuffer)
+	}
+	return opts, nil
+}
+
+// newSinkBuffer, return buffer with valid opts and start goroutine which writes jobs
+func newSinkBuffer(opts BufferOptions, log *logrus.Entry, write func(job interface{})) *sinkBuffer {
+	b := &sinkBuffer{
+		jobs:     make(chan interface{}, opts.Size),
+		overflow: opts.Overflow,
+		write:    write,
+		log:      log,
+		closing:  make(chan struct{}),
+		abort:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// run, write jobs until buffer is closed, after abort jobs are logged
+func (b *sinkBuffer) run() {
+	defer close(b.done)
+	for job := range b.jobs {
+		select {
+		case <-b.abort:
+			logJob(b.log, job, fmt.Errorf("undelivered by close, %w", ErrQueueClosed))
+			continue
+		default:
+		}
+		b.write(job)
+	}
+}
+
+// active, return true until close
+func (b *sinkBuffer) active() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return !b.closed
+}
+
+// send, add job to buffer by overflow policy, jobs after close are ignored
+func (b *sinkBuffer) send(job interface{}) {
+	b.mux.RLock()
+	if b.closed {
+		b.mux.RUnlock()
+		return
+	}
+	b.senders.Add(1)
+	b.mux.RUnlock()
+	defer b.senders.Done()
+
+	if b.overflow == OverflowDropOldest {
+		for {
+			select {
+			case b.jobs <- job:
+				return
+			default:
+			}
+			select {
+			case old := <-b.jobs:
+				logJob(b.log, old, fmt.Errorf("dropped oldest job, %w", ErrQueueFull))
+			default:
+			}
+		}
+	}
+
+	select {
+	case b.jobs <- job:
+	case <-b.closing:
+		logJob(b.log, job, ErrQueueClosed)
+	}
+}
+
+// close, stop accepting jobs and wait until buffered jobs are written,
+// after the deadline of ctx remaining jobs are logged
+func (b *sinkBuffer) close(ctx context.Context) error {
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		<-b.done
+		return nil
+	}
+	b.closed = true
+	close(b.closing)
+	b.mux.Unlock()
+
+	// Wait of blocked senders, they are released by closing
+	b.senders.Wait()
+	close(b.jobs)
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		close(b.abort)
+		<-b.done
+		return ctx.Err()
+	}
+}
+
+// SinkRecord, stored entry of MongoSink and FileSink with time of Send
+type SinkRecord struct {
+	Host       string      `json:"host" bson:"host"`
+	Collection string      `json:"collection" bson:"collection"`
+	Action     string      `json:"action" bson:"action"`
+	User       interface{} `json:"user" bson:"user"`
+	Data       interface{} `json:"data" bson:"data"`
+	Timestamp  time.Time   `json:"timestamp" bson:"timestamp"`
+}
+
+// toRecords, convert elem of Send to records of host,
+// elem must be bson.M, []bson.M, AuditEntry or AuditEntries
+func toRecords(host string, elem interface{}) ([]SinkRecord, error) {
+	var entries AuditEntries
+	switch val := toJob(host, elem).(type) {
+	case AuditEntry:
+		entries = AuditEntries{val}
+	case AuditEntries:
+		entries = val
+	default:
+		return nil, ErrAuditEntryType
+	}
+
+	now := time.Now().UTC()
+	records := make([]SinkRecord, len(entries))
+	for i, e := range entries {
+		records[i] = SinkRecord{
+			Host:       e.Host,
+			Collection: e.Collection,
+			Action:     e.Action,
+			User:       e.User,
+			Data:       e.Data,
+			Timestamp:  now,
+		}
+	}
+	return records, nil
+}
+
+// defaultLog, return logEntry or entry of lxLog logger
+func defaultLog(logEntry *logrus.Entry) *logrus.Entry {
+	if logEntry != nil {
+		return logEntry
+	}
+	return lxLog.GetLogger().WithFields(logrus.Fields{})
+}
+
+// LogSink, write entries to logrus, e.g. for tests or deployments without audit service
+type LogSink struct {
+	host string
+	log  *logrus.Entry
+	mux  sync.RWMutex
+	done bool
+}
+
+// NewLogSink, return sink which logs entries with level info,
+// without logEntry the logger of lxLog is used
+// Example:
+// sink := lxAudit.NewLogSink("my-service", nil)
+// repo := lxDb.NewMongoBaseRepo(collection, sink)
+func NewLogSink(host string, logEntry *logrus.Entry) *LogSink {
+	return &LogSink{host: host, log: defaultLog(logEntry)}
+}
+
+// Send, log each entry with host, collection, action, user and data fields,
+// IBaseRepoAudit
+func (s *LogSink) Send(elem interface{}) {
+	if !s.IsActive() {
+		return
+	}
+	records, err := toRecords(s.host, elem)
+	if err != nil {
+		s.log.WithField("func", "lxAudit.LogSink.Send").WithField("audit", elem).Error(err)
+		return
+	}
+	for _, r := range records {
+		s.log.WithFields(logrus.Fields{
+			"host":       r.Host,
+			"collection": r.Collection,
+			"action":     r.Action,
+			"user":       r.User,
+			"data":       r.Data,
+		}).Info("audit entry")
+	}
+}
+
+// IsActive, return true until Close,
+// IBaseRepoAudit
+func (s *LogSink) IsActive() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return !s.done
+}
+
+// Close, stop logging of entries
+func (s *LogSink) Close(ctx context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.done = true
+	return nil
+}
+
+// FanOutSink, send entries to several sinks, each sink has a buffer and goroutine,
+// a failing or slow sink does not prevent delivery to the others.
+// When the buffer of a sink is full, its oldest entry is dropped and logged.
+type FanOutSink struct {
+	sinks   []IAudit
+	buffers []*sinkBuffer
+	log     *logrus.Entry
+}
+
+// NewFanOutSink, return sink which sends to all active sinks,
+// without logEntry the logger of lxLog is used
+// Example:
+// fileSink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: "/var/log/audit/audit.ndjson"})
+// sink := lxAudit.NewFanOutSink(nil, queue, fileSink)
+func NewFanOutSink(logEntry *logrus.Entry, sinks ...IAudit) *FanOutSink {
+	s := &FanOutSink{sinks: sinks, log: defaultLog(logEntry)}
+	opts := BufferOptions{Size: DefaultBufferSize, Overflow: OverflowDropOldest}
+	for i, sink := range sinks {
+		ctxLog := s.log.WithField("func", "lxAudit.FanOutSink.Send").WithField("failed_sink", fmt.Sprintf("%d %T", i, sink))
+		s.buffers = append(s.buffers, newSinkBuffer(opts, ctxLog, fanOutWrite(ctxLog, sink)))
+	}
+	return s
+}
+
+// fanOutWrite, return write of sink for buffer, panics of the sink are recovered and logged
+func fanOutWrite(ctxLog *logrus.Entry, sink IAudit) func(job interface{}) {
+	return func(job interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				logJob(ctxLog, job, fmt.Errorf("sink panic: %v", r))
+			}
+		}()
+		if sink.IsActive() {
+			sink.Send(job)
+		}
+	}
+}
+
+// Send, add elem to the buffers of all active sinks,
+// IBaseRepoAudit
+func (s *FanOutSink) Send(elem interface{}) {
+	for i, sink := range s.sinks {
+		if sink.IsActive() {
+			s.buffers[i].send(elem)
+		}
+	}
+}
+
+// IsActive, return true until Close when one of the sinks is active,
+// IBaseRepoAudit
+func (s *FanOutSink) IsActive() bool {
+	for i, sink := range s.sinks {
+		if s.buffers[i].active() && sink.IsActive() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close, send buffered entries and close all sinks which implement Sink,
+// all sinks are closed, the first error is returned
+func (s *FanOutSink) Close(ctx context.Context) error {
+	errs := make([]error, len(s.sinks))
+	var wg sync.WaitGroup
+	for i, sink := range s.sinks {
+		wg.Add(1)
+		go func(i int, sink IAudit) {
+			defer wg.Done()
+			err := s.buffers[i].close(ctx)
+			if closer, ok := sink.(Sink); ok {
+				if closeErr := closer.Close(ctx); closeErr != nil {
+					err = closeErr
+				}
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("close sink %d %T, %w", i, sink, err)
+				s.log.WithField("func", "lxAudit.FanOutSink.Close").Error(errs[i])
+			}
+		}(i, sink)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package lxAudit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// Types of SinkConfig
+	SinkService = "service"
+	SinkMongo   = "mongo"
+	SinkFile    = "file"
+	SinkLog     = "log"
+	SinkFanOut  = "fanout"
+
+	// Default env prefix for NewSinkConfigFromEnv
+	DefaultSinkEnvPrefix = "AUDIT_SINK"
+
+	// DefaultSinkServiceWorkers, workers of SinkService
+	DefaultSinkServiceWorkers = 1
+)
+
+// SinkConfig, configuration for NewSink, Type selects the sink
+type SinkConfig struct {
+	// Type, SinkService, SinkMongo, SinkFile, SinkLog or SinkFanOut
+	Type string
+	// Host, client host of entries
+	Host string
+
+	// Audit service of SinkService
+	ServiceHost    string
+	ServiceAuthKey string
+	ServiceWorkers int
+
+	// Mongo, client config of SinkMongo, the client is disconnected by Close
+	Mongo           *lxDb.MongoClientConfig
+	MongoDatabase   string
+	MongoCollection string
+	MongoTTL        time.Duration
+
+	// File of SinkFile
+	FilePath     string
+	FileMaxBytes int64
+	FileMaxFiles int
+	FileSync     bool
+
+	// Sinks of SinkFanOut
+	Sinks []SinkConfig
+}
+
+// NewSinkConfigFromEnv, return config from environment variables.
+// Without prefix DefaultSinkEnvPrefix is used. With type fanout the sinks of
+// <prefix>_SINKS are configured by the same variables.
+// The mongo client is configured by lxDb.NewMongoClientConfigFromEnv with <prefix>_MONGO.
+// Example with prefix "AUDIT_SINK":
+// AUDIT_SINK_TYPE=fanout
+// AUDIT_SINK_SINKS=service,file
+// AUDIT_SINK_HOST=my-service
+// AUDIT_SINK_SERVICE_HOST=http://audit:3000
+// AUDIT_SINK_SERVICE_AUTH_KEY=secret
+// AUDIT_SINK_SERVICE_WORKERS=2
+// AUDIT_SINK_MONGO_URI=mongodb://127.0.0.1:27017
+// AUDIT_SINK_MONGO_DATABASE=app
+// AUDIT_SINK_MONGO_COLLECTION=audit
+// AUDIT_SINK_MONGO_TTL=2160h
+// AUDIT_SINK_FILE_PATH=/var/log/audit/audit.ndjson
+// AUDIT_SINK_FILE_MAX_BYTES=104857600
+// AUDIT_SINK_FILE_MAX_FILES=10
+// AUDIT_SINK_FILE_SYNC=false
+func NewSinkConfigFromEnv(prefix ...string) (*SinkConfig, error) {
+	p := DefaultSinkEnvPrefix
+	if len(prefix) > 0 && prefix[0] != "" {
+		p = prefix[0]
+	}
+
+	env := func(key string) string {
+		return strings.TrimSpace(os.Getenv(p + "_" + key))
+	}
+
+	cfg := &SinkConfig{
+		Type:            strings.ToLower(env("TYPE")),
+		Host:            env("HOST"),
+		ServiceHost:     env("SERVICE_HOST"),
+		ServiceAuthKey:  env("SERVICE_AUTH_KEY"),
+		MongoDatabase:   env("MONGO_DATABASE"),
+		MongoCollection: env("MONGO_COLLECTION"),
+		FilePath:        env("FILE_PATH"),
+	}
+
+	var types []string
+	if cfg.Type == SinkFanOut {
+		for _, t := range strings.Split(env("SINKS"), ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				types = append(types, t)
+			}
+		}
+	} else {
+		types = []string{cfg.Type}
+	}
+
+	if v := env("SERVICE_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s_SERVICE_WORKERS: %w", p, err)
+		}
+		cfg.ServiceWorkers = n
+	}
+	if v := env("MONGO_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s_MONGO_TTL: %w", p, err)
+		}
+		cfg.MongoTTL = d
+	}
+	if v := env("FILE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE_MAX_BYTES: %w", p, err)
+		}
+		cfg.FileMaxBytes = n
+	}
+	if v := env("FILE_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE_MAX_FILES: %w", p, err)
+		}
+		cfg.FileMaxFiles = n
+	}
+	if v := env("FILE_SYNC"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE_SYNC: %w", p, err)
+		}
+		cfg.FileSync = b
+	}
+
+	for _, t := range types {
+		if t == SinkMongo {
+			mongoCfg, err := lxDb.NewMongoClientConfigFromEnv(p + "_MONGO")
+			if err != nil {
+				return nil, err
+			}
+			cfg.Mongo = mongoCfg
+		}
+	}
+
+	if cfg.Type == SinkFanOut {
+		sinks := make([]SinkConfig, 0, len(types))
+		for _, t := range types {
+			if t == SinkFanOut {
+				return nil, fmt.Errorf("%s_SINKS: nested %s, %w", p, SinkFanOut, ErrSink)
+			}
+			sub := *cfg
+			sub.Type = t
+			sinks = append(sinks, sub)
+		}
+		cfg.Sinks = sinks
+	}
+
+	return cfg, nil
+}
+
+// NewSink, return sink selected by Type of config,
+// without logEntry the logger of lxLog is used
+// Example:
+// cfg, err := lxAudit.NewSinkConfigFromEnv()
+// sink, err := lxAudit.NewSink(cfg, nil)
+// defer sink.Close(ctx)
+// repo := lxDb.NewMongoBaseRepo(collection, sink)
+func NewSink(cfg *SinkConfig, logEntry *logrus.Entry) (Sink, error) {
+	logEntry = defaultLog(logEntry)
+
+	switch cfg.Type {
+	case SinkService:
+		if cfg.ServiceHost == "" {
+			return nil, fmt.Errorf("missing service host, %w", ErrSink)
+		}
+		workers := cfg.ServiceWorkers
+		if workers <= 0 {
+			workers = DefaultSinkServiceWorkers
+		}
+		qu := NewQueue(cfg.Host, cfg.ServiceHost, cfg.ServiceAuthKey, logEntry)
+		if err := qu.Start(workers); err != nil {
+			return nil, err
+		}
+		return qu, nil
+
+	case SinkMongo:
+		if cfg.Mongo == nil || cfg.MongoDatabase == "" {
+			return nil, fmt.Errorf("missing mongo config or database, %w", ErrSink)
+		}
+		name := cfg.MongoCollection
+		if name == "" {
+			name = DefaultSinkMongoCollection
+		}
+		client, err := lxDb.NewMongoClient(cfg.Mongo)
+		if err != nil {
+			// client is connected when ping failed
+			if client != nil {
+				disconnect(client)
+			}
+			return nil, err
+		}
+		sink, err := NewMongoSink(client.Database(cfg.MongoDatabase).Collection(name), &MongoSinkOptions{
+			TTL:  cfg.MongoTTL,
+			Host: cfg.Host,
+			Log:  logEntry,
+		})
+		if err != nil {
+			disconnect(client)
+			return nil, err
+		}
+		sink.client = client
+		return sink, nil
+
+	case SinkFile:
+		return NewFileSink(FileSinkOptions{
+			Path:     cfg.FilePath,
+			MaxBytes: cfg.FileMaxBytes,
+			MaxFiles: cfg.FileMaxFiles,
+			Sync:     cfg.FileSync,
+			Host:     cfg.Host,
+			Log:      logEntry,
+		})
+
+	case SinkLog:
+		return NewLogSink(cfg.Host, logEntry), nil
+
+	case SinkFanOut:
+		if len(cfg.Sinks) == 0 {
+			return nil, fmt.Errorf("missing sinks of %s, %w", SinkFanOut, ErrSink)
+		}
+		sinks := make([]IAudit, 0, len(cfg.Sinks))
+		for i := range cfg.Sinks {
+			sink, err := NewSink(&cfg.Sinks[i], logEntry)
+			if err != nil {
+				// Close already created sinks
+				ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+				defer cancel()
+				_ = NewFanOutSink(logEntry, sinks...).Close(ctx)
+				return nil, fmt.Errorf("sink %d of %s: %w", i, SinkFanOut, err)
+			}
+			sinks = append(sinks, sink)
+		}
+		return NewFanOutSink(logEntry, sinks...), nil
+	}
+
+	return nil, fmt.Errorf("type %q, %w", cfg.Type, ErrSink)
+}
+
+// disconnect, disconnect client of failed NewSink
+func disconnect(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	_ = client.Disconnect(ctx)
+}
@@ -0,0 +1,245 @@
+package lxAudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Defaults of FileSinkOptions
+	DefaultFileSinkMaxBytes = 100 << 20
+	DefaultFileSinkMaxFiles = 10
+
+	// fileSinkTimeFormat, time of rotated file name
+	fileSinkTimeFormat = "20060102T150405.000000000"
+)
+
+// FileSinkOptions, options for NewFileSink
+type FileSinkOptions struct {
+	// Path, of NDJSON file, e.g. /var/log/audit/audit.ndjson
+	Path string
+	// MaxBytes, size of file before rotation, default DefaultFileSinkMaxBytes
+	MaxBytes int64
+	// MaxFiles, rotated files to keep, default DefaultFileSinkMaxFiles
+	MaxFiles int
+	// Sync, fsync after each write
+	Sync bool
+	// Host, client host of entries
+	Host string
+	// Buffer, entries of Send buffered for writing, OverflowSpill is not supported
+	Buffer BufferOptions
+	// Log, default logger of lxLog
+	Log *logrus.Entry
+}
+
+// FileSink, write entries as SinkRecord lines (NDJSON) to file,
+// the file is rotated to <name>-<time><ext> when MaxBytes is reached,
+// entries of Send are written by a goroutine
+type FileSink struct {
+	opts   FileSinkOptions
+	buffer *sinkBuffer
+
+	mux  sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink, open or create file of sink
+// Example:
+// sink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: "/var/log/audit/audit.ndjson", Host: "my-service"})
+// defer sink.Close(ctx)
+func NewFileSink(opts FileSinkOptions) (*FileSink, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("missing path of file sink, %w", ErrSink)
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultFileSinkMaxBytes
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultFileSinkMaxFiles
+	}
+	opts.Log = defaultLog(opts.Log)
+	buffer, err := sinkBufferOptions(opts.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	opts.Buffer = buffer
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0750); err != nil {
+		return nil, err
+	}
+	s := &FileSink{opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	ctxLog := opts.Log.WithField("func", "lxAudit.FileSink.Send")
+	s.buffer = newSinkBuffer(opts.Buffer, ctxLog, func(job interface{}) {
+		if err := s.Write(job); err != nil {
+			logJob(ctxLog, job, err)
+		}
+	})
+	return s, nil
+}
+
+// open, open file for append, caller holds mux
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// Send, add entries to buffer of sink, errors of write are logged with entries for manual insert,
+// IBaseRepoAudit
+func (s *FileSink) Send(elem interface{}) {
+	s.buffer.send(elem)
+}
+
+// Write, append entries of elem to file and return error
+func (s *FileSink) Write(elem interface{}) error {
+	records, err := toRecords(s.opts.Host, elem)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("file sink closed, %w", ErrSink)
+	}
+	if s.size > 0 && s.size+int64(buf.Len()) > s.opts.MaxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.opts.Sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// rotate, rename file with time, reopen and remove oldest rotated files, caller holds mux
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	ext := filepath.Ext(s.opts.Path)
+	base := strings.TrimSuffix(s.opts.Path, ext)
+	rotated := base + "-" + time.Now().UTC().Format(fileSinkTimeFormat) + ext
+	if err := os.Rename(s.opts.Path, rotated); err != nil {
+		// continue with current file
+		if openErr := s.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	files, err := s.Rotated()
+	if err != nil {
+		return err
+	}
+	for len(files) > s.opts.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Rotated, return paths of rotated files, oldest first,
+// files with other names than <name>-<time><ext> are ignored
+func (s *FileSink) Rotated() ([]string, error) {
+	ext := filepath.Ext(s.opts.Path)
+	base := strings.TrimSuffix(s.opts.Path, ext)
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, f := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(f, base+"-"), ext)
+		if len(stamp) != len(fileSinkTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(fileSinkTimeFormat, stamp); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	// time format sorts chronological
+	sort.Strings(files)
+	return files, nil
+}
+
+// IsActive, return true until Close,
+// IBaseRepoAudit
+func (s *FileSink) IsActive() bool {
+	if !s.buffer.active() {
+		return false
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.file != nil
+}
+
+// Close, write buffered entries until deadline of ctx, sync and close file
+func (s *FileSink) Close(ctx context.Context) error {
+	bufErr := s.buffer.close(ctx)
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+	return bufErr
+}
+
+// closeFile, sync and close file
+func (s *FileSink) closeFile() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	file := s.file
+	s.file = nil
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
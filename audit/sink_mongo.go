@@ -0,0 +1,153 @@
+package lxAudit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultSinkMongoCollection, collection of SinkMongo in NewSink
+const DefaultSinkMongoCollection = "audit"
+
+// MongoSinkOptions, options for NewMongoSink
+type MongoSinkOptions struct {
+	// Timeout, of insert and index creation, default DefaultTimeout
+	Timeout time.Duration
+	// TTL, entries expire after TTL, without TTL entries are kept
+	TTL time.Duration
+	// Host, client host of entries
+	Host string
+	// Buffer, entries of Send buffered for insert, OverflowSpill is not supported
+	Buffer BufferOptions
+	// Log, default logger of lxLog
+	Log *logrus.Entry
+}
+
+// MongoSink, insert entries as SinkRecord into collection
+// with indexes on collection, action, user and timestamp,
+// entries of Send are inserted by a goroutine
+type MongoSink struct {
+	collection *mongo.Collection
+	opts       MongoSinkOptions
+	buffer     *sinkBuffer
+	// client, disconnected by Close when the sink owns the client, see NewSink
+	client *mongo.Client
+
+	mux  sync.RWMutex
+	done bool
+}
+
+// NewMongoSink, return sink for collection and create indexes
+// Example:
+// sink, err := lxAudit.NewMongoSink(client.Database("app").Collection("audit"), &lxAudit.MongoSinkOptions{Host: "my-service"})
+// repo := lxDb.NewMongoBaseRepo(collection, sink)
+func NewMongoSink(collection *mongo.Collection, opts *MongoSinkOptions) (*MongoSink, error) {
+	o := MongoSinkOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	o.Log = defaultLog(o.Log)
+	buffer, err := sinkBufferOptions(o.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	o.Buffer = buffer
+
+	s := &MongoSink{collection: collection, opts: o}
+	if err := s.ensureIndexes(); err != nil {
+		return nil, err
+	}
+	ctxLog := o.Log.WithField("func", "lxAudit.MongoSink.Send")
+	s.buffer = newSinkBuffer(o.Buffer, ctxLog, func(job interface{}) {
+		if err := s.Write(job); err != nil {
+			logJob(ctxLog, job, err)
+		}
+	})
+	return s, nil
+}
+
+// MongoSinkIndexes, return indexes of MongoSink, with ttl timestamp index expires entries
+func MongoSinkIndexes(ttl time.Duration) []mongo.IndexModel {
+	timestamp := options.Index()
+	if ttl > 0 {
+		timestamp.SetExpireAfterSeconds(int32(ttl / time.Second))
+	}
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}, Options: timestamp},
+		{Keys: bson.D{{Key: "collection", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "timestamp", Value: -1}}},
+	}
+}
+
+// ensureIndexes, create indexes of sink
+func (s *MongoSink) ensureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	if _, err := s.collection.Indexes().CreateMany(ctx, MongoSinkIndexes(s.opts.TTL)); err != nil {
+		return fmt.Errorf("create indexes of mongo sink, %w", err)
+	}
+	return nil
+}
+
+// Send, add entries to buffer of sink, errors of insert are logged with entries for manual insert,
+// IBaseRepoAudit
+func (s *MongoSink) Send(elem interface{}) {
+	s.buffer.send(elem)
+}
+
+// Write, insert entries of elem and return error
+func (s *MongoSink) Write(elem interface{}) error {
+	records, err := toRecords(s.opts.Host, elem)
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, len(records))
+	for i, r := range records {
+		docs[i] = r
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	_, err = s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+// IsActive, return true until Close,
+// IBaseRepoAudit
+func (s *MongoSink) IsActive() bool {
+	if !s.buffer.active() {
+		return false
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return !s.done
+}
+
+// Close, insert buffered entries until deadline of ctx and stop,
+// the client is disconnected when created by NewSink
+func (s *MongoSink) Close(ctx context.Context) error {
+	bufErr := s.buffer.close(ctx)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.done {
+		return bufErr
+	}
+	s.done = true
+	if s.client != nil {
+		if err := s.client.Disconnect(ctx); err != nil {
+			return err
+		}
+	}
+	return bufErr
+}
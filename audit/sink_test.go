@@ -0,0 +1,456 @@
+package lxAudit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// panicSink, sink which panics on Send
+type panicSink struct{}
+
+func (s *panicSink) Send(elem interface{}) { panic("sink failure") }
+func (s *panicSink) IsActive() bool        { return true }
+
+// inactiveSink, sink which records Send while inactive
+type inactiveSink struct {
+	sent int32
+}
+
+func (s *inactiveSink) Send(elem interface{}) { atomic.AddInt32(&s.sent, 1) }
+func (s *inactiveSink) IsActive() bool        { return false }
+
+// slowSink, sink which waits for release on Send
+type slowSink struct {
+	release chan struct{}
+	sent    int32
+}
+
+func (s *slowSink) Send(elem interface{}) {
+	<-s.release
+	atomic.AddInt32(&s.sent, 1)
+}
+func (s *slowSink) IsActive() bool { return true }
+
+// tempDir, return temp dir which is removed after test
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lxaudit-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// readRecords, return records of NDJSON file
+func readRecords(t *testing.T, path string) []lxAudit.SinkRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []lxAudit.SinkRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r lxAudit.SinkRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLogSink(t *testing.T) {
+	its := assert.New(t)
+
+	logger, hook := test.NewNullLogger()
+	sink := lxAudit.NewLogSink("test-host", logger.WithFields(logrus.Fields{}))
+	its.True(sink.IsActive())
+
+	sink.Send([]bson.M{batchEntry(0, "orders"), batchEntry(1, "users")})
+	its.Len(hook.AllEntries(), 2)
+	entry := hook.LastEntry()
+	its.Equal(logrus.InfoLevel, entry.Level)
+	its.Equal("test-host", entry.Data["host"])
+	its.Equal("users", entry.Data["collection"])
+	its.Equal(lxAudit.Insert, entry.Data["action"])
+	its.Equal(map[string]interface{}{"name": "user_01"}, entry.Data["user"])
+
+	// Wrong type
+	sink.Send("foo")
+	its.Equal(logrus.ErrorLevel, hook.LastEntry().Level)
+	its.Contains(hook.LastEntry().Message, lxAudit.ErrAuditEntryType.Error())
+
+	its.NoError(sink.Close(context.Background()))
+	its.False(sink.IsActive())
+	hook.Reset()
+	sink.Send(batchEntry(2, "orders"))
+	its.Empty(hook.AllEntries())
+}
+
+func TestFileSink(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("write", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit", "audit.ndjson")
+		sink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path, Host: "test-host"})
+		its.NoError(err)
+		its.True(sink.IsActive())
+
+		sink.Send(batchEntry(0, "orders"))
+		sink.Send([]bson.M{batchEntry(1, "orders"), batchEntry(2, "users")})
+		its.NoError(sink.Close(context.Background()))
+		its.False(sink.IsActive())
+		its.True(errors.Is(sink.Write(batchEntry(3, "orders")), lxAudit.ErrSink))
+
+		records := readRecords(t, path)
+		its.Len(records, 3)
+		its.Equal("test-host", records[0].Host)
+		its.Equal("users", records[2].Collection)
+		its.Equal(lxAudit.Insert, records[2].Action)
+		its.Equal(map[string]interface{}{"name": "user_02"}, records[2].User)
+		its.WithinDuration(time.Now(), records[2].Timestamp, time.Minute)
+
+		// Append to existing file
+		sink, err = lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path})
+		its.NoError(err)
+		its.NoError(sink.Write(batchEntry(3, "orders")))
+		its.NoError(sink.Close(context.Background()))
+		its.Len(readRecords(t, path), 4)
+	})
+	t.Run("rotate", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.ndjson")
+		sink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path, MaxBytes: 200, MaxFiles: 2, Sync: true})
+		its.NoError(err)
+		defer sink.Close(context.Background())
+
+		// One entry per file, oldest rotated files are removed
+		for i := 0; i < 6; i++ {
+			its.NoError(sink.Write(batchEntry(i, "orders")))
+		}
+		rotated, err := sink.Rotated()
+		its.NoError(err)
+		its.Len(rotated, 2)
+
+		its.Equal("user_03", readRecords(t, rotated[0])[0].User.(map[string]interface{})["name"])
+		its.Equal("user_04", readRecords(t, rotated[1])[0].User.(map[string]interface{})["name"])
+		its.Equal("user_05", readRecords(t, path)[0].User.(map[string]interface{})["name"])
+
+		// Files with other names are not rotated files
+		dir := filepath.Dir(path)
+		for _, name := range []string{"audit-backup.ndjson", "audit-2020.ndjson", "audit-20200102T150405.000000000x.ndjson"} {
+			its.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0640))
+		}
+		its.NoError(sink.Write(batchEntry(6, "orders")))
+		rotated, err = sink.Rotated()
+		its.NoError(err)
+		its.Equal([]string{"user_04", "user_05"}, []string{
+			readRecords(t, rotated[0])[0].User.(map[string]interface{})["name"].(string),
+			readRecords(t, rotated[1])[0].User.(map[string]interface{})["name"].(string),
+		})
+		_, err = os.Stat(filepath.Join(dir, "audit-backup.ndjson"))
+		its.NoError(err)
+	})
+	t.Run("buffer", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.ndjson")
+		_, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path, Buffer: lxAudit.BufferOptions{Overflow: lxAudit.OverflowSpill}})
+		its.True(errors.Is(err, lxAudit.ErrBuffer))
+
+		sink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path, Buffer: lxAudit.BufferOptions{Size: 1, Overflow: lxAudit.OverflowBlock}})
+		its.NoError(err)
+		for i := 0; i < 10; i++ {
+			sink.Send(batchEntry(i, "orders"))
+		}
+		its.NoError(sink.Close(context.Background()))
+		its.Len(readRecords(t, path), 10)
+
+		// Send after Close is ignored
+		sink.Send(batchEntry(10, "orders"))
+		its.Len(readRecords(t, path), 10)
+	})
+	t.Run("missing path", func(t *testing.T) {
+		_, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{})
+		its.True(errors.Is(err, lxAudit.ErrSink))
+	})
+}
+
+func TestFanOutSink(t *testing.T) {
+	its := assert.New(t)
+
+	logger, hook := test.NewNullLogger()
+	logSink := lxAudit.NewLogSink("test-host", logger.WithField("sink", "log"))
+	path := filepath.Join(tempDir(t), "audit.ndjson")
+	fileSink, err := lxAudit.NewFileSink(lxAudit.FileSinkOptions{Path: path})
+	its.NoError(err)
+	inactive := &inactiveSink{}
+
+	sink := lxAudit.NewFanOutSink(logger.WithField("sink", "fanout"), &panicSink{}, inactive, logSink, fileSink)
+	its.True(sink.IsActive())
+
+	// Failure of a sink is isolated, Close writes buffered entries
+	sink.Send(batchEntry(0, "orders"))
+	its.NoError(sink.Close(context.Background()))
+	its.Len(readRecords(t, path), 1)
+	its.Equal(int32(0), atomic.LoadInt32(&inactive.sent))
+
+	var audited, panicked int
+	for _, entry := range hook.AllEntries() {
+		switch entry.Data["sink"] {
+		case "log":
+			audited++
+		case "fanout":
+			panicked++
+			its.Contains(entry.Message, "sink failure")
+			its.NotEmpty(entry.Data["audit"])
+			its.Equal("0 *lxAudit_test.panicSink", entry.Data["failed_sink"])
+		}
+	}
+	its.Equal(1, audited)
+	its.Equal(1, panicked)
+
+	its.False(logSink.IsActive())
+	its.False(fileSink.IsActive())
+	its.False(sink.IsActive())
+	its.False(lxAudit.NewFanOutSink(nil, inactive).IsActive())
+}
+
+func TestFanOutSink_Async(t *testing.T) {
+	its := assert.New(t)
+
+	slow := &slowSink{release: make(chan struct{})}
+	logger, _ := test.NewNullLogger()
+	logSink := lxAudit.NewLogSink("test-host", logger.WithField("sink", "log"))
+	sink := lxAudit.NewFanOutSink(nil, slow, logSink)
+
+	// Send does not wait for the slow sink
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		sink.Send(batchEntry(i, "orders"))
+	}
+	its.True(time.Since(start) < time.Millisecond*100)
+
+	close(slow.release)
+	its.NoError(sink.Close(context.Background()))
+	its.Equal(int32(3), atomic.LoadInt32(&slow.sent))
+}
+
+func TestNewSink(t *testing.T) {
+	its := assert.New(t)
+	logger, _ := test.NewNullLogger()
+	logEntry := logger.WithFields(logrus.Fields{})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := lxAudit.NewSink(&lxAudit.SinkConfig{Type: "foo"}, logEntry)
+		its.True(errors.Is(err, lxAudit.ErrSink))
+		_, err = lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkService}, logEntry)
+		its.True(errors.Is(err, lxAudit.ErrSink))
+		_, err = lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkMongo}, logEntry)
+		its.True(errors.Is(err, lxAudit.ErrSink))
+		_, err = lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkFanOut}, logEntry)
+		its.True(errors.Is(err, lxAudit.ErrSink))
+		_, err = lxAudit.NewSink(&lxAudit.SinkConfig{
+			Type:  lxAudit.SinkFanOut,
+			Sinks: []lxAudit.SinkConfig{{Type: lxAudit.SinkLog}, {Type: lxAudit.SinkFile}},
+		}, logEntry)
+		its.True(errors.Is(err, lxAudit.ErrSink))
+	})
+	t.Run("mongo unreachable", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+		_, err := lxAudit.NewSink(&lxAudit.SinkConfig{
+			Type:          lxAudit.SinkMongo,
+			Mongo:         &lxDb.MongoClientConfig{URI: "mongodb://127.0.0.1:1", ConnectTimeout: time.Millisecond * 100},
+			MongoDatabase: "test",
+		}, logEntry)
+		its.Error(err)
+
+		// Client of failed ping is disconnected, monitors are stopped
+		deadline := time.Now().Add(time.Second * 5)
+		for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+		its.LessOrEqual(runtime.NumGoroutine(), goroutines)
+	})
+	t.Run("types", func(t *testing.T) {
+		sink, err := lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkLog}, logEntry)
+		its.NoError(err)
+		its.IsType(&lxAudit.LogSink{}, sink)
+
+		sink, err = lxAudit.NewSink(&lxAudit.SinkConfig{Type: lxAudit.SinkFile, FilePath: filepath.Join(tempDir(t), "audit.ndjson")}, logEntry)
+		its.NoError(err)
+		its.IsType(&lxAudit.FileSink{}, sink)
+		its.NoError(sink.Close(context.Background()))
+
+		// IBaseRepoAudit of lxDb
+		var _ lxDb.IBaseRepoAudit = sink
+	})
+	t.Run("service in fanout", func(t *testing.T) {
+		var received int32
+		server := countServer(&received, nil)
+		defer server.Close()
+
+		path := filepath.Join(tempDir(t), "audit.ndjson")
+		sink, err := lxAudit.NewSink(&lxAudit.SinkConfig{
+			Type: lxAudit.SinkFanOut,
+			Host: "test-host",
+			Sinks: []lxAudit.SinkConfig{
+				{Type: lxAudit.SinkService, Host: "test-host", ServiceHost: server.URL},
+				{Type: lxAudit.SinkFile, Host: "test-host", FilePath: path},
+			},
+		}, logEntry)
+		its.NoError(err)
+		its.True(sink.IsActive())
+
+		for i := 0; i < 3; i++ {
+			sink.Send(batchEntry(i, "orders"))
+		}
+		// Close of service sink delivers buffered entries
+		its.NoError(sink.Close(context.Background()))
+		its.Equal(int32(3), atomic.LoadInt32(&received))
+		its.Len(readRecords(t, path), 3)
+		its.False(sink.IsActive())
+	})
+}
+
+func TestNewSinkConfigFromEnv(t *testing.T) {
+	its := assert.New(t)
+
+	setEnv := func(t *testing.T, env map[string]string) {
+		for key, val := range env {
+			its.NoError(os.Setenv(key, val))
+		}
+		t.Cleanup(func() {
+			for key := range env {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+
+	t.Run("fanout", func(t *testing.T) {
+		setEnv(t, map[string]string{
+			"TEST_SINK_TYPE":             "FanOut",
+			"TEST_SINK_SINKS":            "service, file,mongo",
+			"TEST_SINK_HOST":             "test-host",
+			"TEST_SINK_SERVICE_HOST":     "http://audit:3000",
+			"TEST_SINK_SERVICE_WORKERS":  "2",
+			"TEST_SINK_MONGO_URI":        "mongodb://127.0.0.1:27017",
+			"TEST_SINK_MONGO_DATABASE":   "app",
+			"TEST_SINK_MONGO_TTL":        "24h",
+			"TEST_SINK_FILE_PATH":        "/var/log/audit/audit.ndjson",
+			"TEST_SINK_FILE_MAX_BYTES":   "1024",
+			"TEST_SINK_FILE_MAX_FILES":   "3",
+			"TEST_SINK_FILE_SYNC":        "true",
+			"TEST_SINK_SERVICE_AUTH_KEY": "secret",
+		})
+
+		cfg, err := lxAudit.NewSinkConfigFromEnv("TEST_SINK")
+		its.NoError(err)
+		its.Equal(lxAudit.SinkFanOut, cfg.Type)
+		its.Len(cfg.Sinks, 3)
+		its.Equal(lxAudit.SinkService, cfg.Sinks[0].Type)
+		its.Equal(lxAudit.SinkFile, cfg.Sinks[1].Type)
+		its.Equal(lxAudit.SinkMongo, cfg.Sinks[2].Type)
+		for _, sub := range cfg.Sinks {
+			its.Equal("test-host", sub.Host)
+			its.Empty(sub.Sinks)
+		}
+		its.Equal("http://audit:3000", cfg.Sinks[0].ServiceHost)
+		its.Equal("secret", cfg.Sinks[0].ServiceAuthKey)
+		its.Equal(2, cfg.Sinks[0].ServiceWorkers)
+		its.Equal("/var/log/audit/audit.ndjson", cfg.Sinks[1].FilePath)
+		its.Equal(int64(1024), cfg.Sinks[1].FileMaxBytes)
+		its.Equal(3, cfg.Sinks[1].FileMaxFiles)
+		its.True(cfg.Sinks[1].FileSync)
+		its.Equal("mongodb://127.0.0.1:27017", cfg.Sinks[2].Mongo.URI)
+		its.Equal("app", cfg.Sinks[2].MongoDatabase)
+		its.Equal(time.Hour*24, cfg.Sinks[2].MongoTTL)
+	})
+	t.Run("single", func(t *testing.T) {
+		setEnv(t, map[string]string{"TEST_SINK_TYPE": "log"})
+		cfg, err := lxAudit.NewSinkConfigFromEnv("TEST_SINK")
+		its.NoError(err)
+		its.Equal(lxAudit.SinkLog, cfg.Type)
+		its.Empty(cfg.Sinks)
+		its.Nil(cfg.Mongo)
+	})
+	t.Run("errors", func(t *testing.T) {
+		setEnv(t, map[string]string{"TEST_SINK_TYPE": "fanout", "TEST_SINK_SINKS": "log,fanout"})
+		_, err := lxAudit.NewSinkConfigFromEnv("TEST_SINK")
+		its.True(errors.Is(err, lxAudit.ErrSink))
+
+		setEnv(t, map[string]string{"TEST_SINK_TYPE": "file", "TEST_SINK_FILE_MAX_FILES": "many"})
+		_, err = lxAudit.NewSinkConfigFromEnv("TEST_SINK")
+		its.Error(err)
+		its.Contains(err.Error(), "TEST_SINK_FILE_MAX_FILES")
+	})
+}
+
+func TestMongoSink(t *testing.T) {
+	its := assert.New(t)
+
+	dbHost := os.Getenv("DB_HOST")
+	if dbHost == "" {
+		t.Skip("DB_HOST not set")
+	}
+
+	sink, err := lxAudit.NewSink(&lxAudit.SinkConfig{
+		Type:            lxAudit.SinkMongo,
+		Host:            "test-host",
+		Mongo:           &lxDb.MongoClientConfig{URI: dbHost},
+		MongoDatabase:   "lxgo_test",
+		MongoCollection: "audit_sink",
+		MongoTTL:        time.Hour,
+	}, nil)
+	if !its.NoError(err) {
+		return
+	}
+	defer sink.Close(context.Background())
+
+	client, err := lxDb.NewMongoClient(&lxDb.MongoClientConfig{URI: dbHost})
+	its.NoError(err)
+	defer client.Disconnect(context.Background())
+	collection := client.Database("lxgo_test").Collection("audit_sink")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	_, err = collection.DeleteMany(ctx, bson.M{})
+	its.NoError(err)
+
+	sink.Send([]bson.M{batchEntry(0, "orders"), batchEntry(1, "users")})
+	its.Eventually(func() bool {
+		n, err := collection.CountDocuments(ctx, bson.M{"host": "test-host"})
+		return err == nil && n == 2
+	}, time.Second*5, time.Millisecond*20)
+	n, err := collection.CountDocuments(ctx, bson.M{"host": "test-host", "collection": "users", "action": lxAudit.Insert})
+	its.NoError(err)
+	its.Equal(int64(1), n)
+
+	// Indexes on collection, action, user and timestamp
+	cursor, err := collection.Indexes().List(ctx)
+	its.NoError(err)
+	var indexes []bson.M
+	its.NoError(cursor.All(ctx, &indexes))
+	var names []string
+	for _, idx := range indexes {
+		names = append(names, idx["name"].(string))
+	}
+	its.Subset(names, []string{"timestamp_-1", "collection_1_timestamp_-1", "action_1_timestamp_-1", "user_1_timestamp_-1"})
+
+	its.NoError(sink.Close(context.Background()))
+	its.False(sink.IsActive())
+}
@@ -0,0 +1,566 @@
+package lxAudit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Defaults of SpoolOptions
+	DefaultSpoolMaxBytes       = int64(512 << 20)
+	DefaultSpoolSegmentSize    = int64(8 << 20)
+	DefaultSpoolReplayInterval = time.Second * 5
+	DefaultSpoolMinBackoff     = time.Second
+	DefaultSpoolMaxBackoff     = time.Minute * 5
+
+	// Segment files, <seq>.seg, corrupted segments are renamed to <seq>.seg.corrupt
+	SpoolSegmentExt = ".seg"
+	SpoolCorruptExt = ".corrupt"
+
+	// Record header, length and crc32 (Castagnoli) of payload
+	spoolHeaderSize = 8
+)
+
+var spoolTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SpoolOptions, options for NewSpool
+type SpoolOptions struct {
+	// Dir, spool directory, is created when not exists
+	Dir string
+	// MaxBytes, size limit of all segments, Append returns ErrSpoolFull when exceeded
+	MaxBytes int64
+	// SegmentSize, a new segment is started when exceeded
+	SegmentSize int64
+	// ReplayInterval, check interval of replayer
+	ReplayInterval time.Duration
+	// MinBackoff and MaxBackoff, wait of replayer after failed delivery, doubled by each failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Log        *logrus.Entry
+}
+
+// SpoolMetrics, snapshot of spool
+type SpoolMetrics struct {
+	Segments int
+	Bytes    int64
+	// Pending, records not delivered
+	Pending int64
+	// Appended, Replayed, Rejected (full spool), Dropped (rejected by service)
+	// and Corrupt (segments) since start
+	Appended   uint64
+	Replayed   uint64
+	Rejected   uint64
+	Dropped    uint64
+	Corrupt    uint64
+	LastError  string
+	LastReplay time.Time
+}
+
+// SegmentInfo, segment file of spool
+type SegmentInfo struct {
+	Path    string
+	Records int
+	Bytes   int64
+	// Err, ErrSpoolCorrupt when checksum or length of a record is invalid
+	Err error
+}
+
+// Spool, durable disk spool for failed audit deliveries.
+// Jobs (AuditEntry or AuditEntries) are appended to fsync'ed segment files
+// with checksums, the replayer redelivers them in order and removes delivered segments.
+// Only one process should use a spool directory at a time.
+type Spool struct {
+	opts SpoolOptions
+
+	mux        sync.Mutex
+	active     *os.File
+	activeSeq  uint64
+	activeSize int64
+	nextSeq    uint64
+	bytes      int64
+	pending    int64
+	metrics    SpoolMetrics
+
+	// replayMux, serialize replays
+	replayMux sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// spoolRecord, payload of record
+type spoolRecord struct {
+	Entry   *AuditEntry  `json:"entry,omitempty"`
+	Entries AuditEntries `json:"entries,omitempty"`
+}
+
+// job, return AuditEntry or AuditEntries of record
+func (r *spoolRecord) job() interface{} {
+	if r.Entry != nil {
+		return *r.Entry
+	}
+	return r.Entries
+}
+
+// framedRecord, record of segment with raw bytes
+type framedRecord struct {
+	raw []byte
+	job interface{}
+}
+
+// NewSpool, return spool of directory, existing segments are counted for replay
+// Example:
+// spool, err := lxAudit.NewSpool(lxAudit.SpoolOptions{Dir: "/var/spool/audit", Log: log})
+// queue.SetSpool(spool)
+// defer spool.Close()
+func NewSpool(opts SpoolOptions) (*Spool, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("spool dir is empty, %w", ErrSpool)
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultSpoolMaxBytes
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSpoolSegmentSize
+	}
+	if opts.ReplayInterval <= 0 {
+		opts.ReplayInterval = DefaultSpoolReplayInterval
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultSpoolMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultSpoolMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.Log == nil {
+		opts.Log = logrus.NewEntry(logrus.StandardLogger())
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{opts: opts, nextSeq: 1}
+	segments, err := s.Segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		s.bytes += seg.Bytes
+		s.pending += int64(seg.Records)
+		if seq, ok := segmentSeq(seg.Path); ok && seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	return s, nil
+}
+
+// Append, write job to active segment and fsync,
+// job must be AuditEntry or AuditEntries
+func (s *Spool) Append(job interface{}) error {
+	var rec spoolRecord
+	switch val := job.(type) {
+	case AuditEntry:
+		rec.Entry = &val
+	case AuditEntries:
+		rec.Entries = val
+	default:
+		return ErrAuditEntryType
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	raw := frameRecord(payload)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.bytes+int64(len(raw)) > s.opts.MaxBytes {
+		s.metrics.Rejected++
+		return fmt.Errorf("%d of %d bytes, %w", s.bytes, s.opts.MaxBytes, ErrSpoolFull)
+	}
+	if s.active != nil && s.activeSize+int64(len(raw)) > s.opts.SegmentSize {
+		if err := s.closeActive(); err != nil {
+			return err
+		}
+	}
+	if s.active == nil {
+		if err := s.openActive(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(raw); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	s.activeSize += int64(len(raw))
+	s.bytes += int64(len(raw))
+	s.pending++
+	s.metrics.Appended++
+	return nil
+}
+
+// openActive, create next segment, caller holds mux
+func (s *Spool) openActive() error {
+	seq := s.nextSeq
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(s.opts.Dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.active, s.activeSeq, s.activeSize = f, seq, 0
+	s.nextSeq++
+	return nil
+}
+
+// closeActive, close active segment, caller holds mux
+func (s *Spool) closeActive() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active, s.activeSeq, s.activeSize = nil, 0, 0
+	return err
+}
+
+// segmentPath, return path of segment
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.opts.Dir, fmt.Sprintf("%020d%s", seq, SpoolSegmentExt))
+}
+
+// segmentSeq, return sequence of segment path
+func segmentSeq(path string) (uint64, bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, SpoolSegmentExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, SpoolSegmentExt), 10, 64)
+	return seq, err == nil
+}
+
+// Segments, return segments in order of delivery, corrupted segments are not included
+func (s *Spool) Segments() ([]SegmentInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(s.opts.Dir, "*"+SpoolSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var segments []SegmentInfo
+	for _, path := range paths {
+		if _, ok := segmentSeq(path); !ok {
+			continue
+		}
+		info := SegmentInfo{Path: path}
+		if fi, err := os.Stat(path); err == nil {
+			info.Bytes = fi.Size()
+		}
+		records, err := ReadSegment(path)
+		info.Records, info.Err = len(records), err
+		segments = append(segments, info)
+	}
+	return segments, nil
+}
+
+// CorruptSegments, return paths of segments which were renamed by replay because of invalid records
+func (s *Spool) CorruptSegments() ([]string, error) {
+	return filepath.Glob(filepath.Join(s.opts.Dir, "*"+SpoolSegmentExt+SpoolCorruptExt))
+}
+
+// ReadSegment, return jobs of segment file in order,
+// by invalid record the jobs before and ErrSpoolCorrupt are returned
+// Example:
+// jobs, err := lxAudit.ReadSegment("/var/spool/audit/00000000000000000001.seg")
+func ReadSegment(path string) ([]interface{}, error) {
+	records, err := readSegment(path)
+	jobs := make([]interface{}, len(records))
+	for i, rec := range records {
+		jobs[i] = rec.job
+	}
+	return jobs, err
+}
+
+// readSegment, return records of segment
+func readSegment(path string) ([]framedRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []framedRecord
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < spoolHeaderSize {
+			return records, fmt.Errorf("%s: truncated header at %d, %w", path, offset, ErrSpoolCorrupt)
+		}
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		sum := binary.BigEndian.Uint32(data[offset+4:])
+		end := offset + spoolHeaderSize + size
+		if size <= 0 || end > len(data) {
+			return records, fmt.Errorf("%s: invalid length at %d, %w", path, offset, ErrSpoolCorrupt)
+		}
+		payload := data[offset+spoolHeaderSize : end]
+		if crc32.Checksum(payload, spoolTable) != sum {
+			return records, fmt.Errorf("%s: checksum mismatch at %d, %w", path, offset, ErrSpoolCorrupt)
+		}
+
+		var rec spoolRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return records, fmt.Errorf("%s: %v at %d, %w", path, err, offset, ErrSpoolCorrupt)
+		}
+		records = append(records, framedRecord{raw: data[offset:end], job: rec.job()})
+		offset = end
+	}
+	return records, nil
+}
+
+// frameRecord, return payload with header
+func frameRecord(payload []byte) []byte {
+	raw := make([]byte, spoolHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(raw, uint32(len(payload)))
+	binary.BigEndian.PutUint32(raw[4:], crc32.Checksum(payload, spoolTable))
+	copy(raw[spoolHeaderSize:], payload)
+	return raw
+}
+
+// Replay, deliver spooled jobs in order with send until the first error.
+// Delivered segments are removed, a partly delivered segment is compacted to the
+// remaining records. Jobs rejected by the service (ErrPermanent) are logged and dropped.
+// Segments with invalid records are renamed to *.corrupt after
+// the valid records are delivered.
+// Example:
+// n, err := spool.Replay(func(job interface{}) error { return lxAudit.RequestAudit(job, auditHost, authKey) })
+func (s *Spool) Replay(send func(job interface{}) error) (int, error) {
+	s.replayMux.Lock()
+	defer s.replayMux.Unlock()
+
+	// Close active segment, new jobs are appended to new segments from limit
+	s.mux.Lock()
+	err := s.closeActive()
+	limit := s.nextSeq
+	s.mux.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	segments, err := s.Segments()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, seg := range segments {
+		if seq, _ := segmentSeq(seg.Path); seq >= limit {
+			break
+		}
+		records, readErr := readSegment(seg.Path)
+		if readErr != nil && !errors.Is(readErr, ErrSpoolCorrupt) {
+			return delivered, s.replayed(readErr)
+		}
+
+		for i, rec := range records {
+			err := send(rec.job)
+			if errors.Is(err, ErrPermanent) {
+				// Rejected by audit service, replay can't deliver it
+				raw, _ := json.Marshal(rec.job)
+				s.opts.Log.WithField("func", "lxAudit.Spool.Replay").WithField("audit", string(raw)).
+					Error(fmt.Errorf("dropped rejected job, %w", err))
+				s.mux.Lock()
+				s.pending--
+				s.metrics.Dropped++
+				s.mux.Unlock()
+				continue
+			}
+			if err != nil {
+				if i > 0 {
+					if cerr := s.compact(seg, records[i:]); cerr != nil {
+						err = fmt.Errorf("%v, compact: %w", err, cerr)
+					}
+				}
+				return delivered, s.replayed(err)
+			}
+			delivered++
+			s.mux.Lock()
+			s.pending--
+			s.metrics.Replayed++
+			s.mux.Unlock()
+		}
+
+		if readErr != nil {
+			// Keep invalid records for manual inspection
+			s.opts.Log.WithField("func", "lxAudit.Spool.Replay").Error(readErr)
+			if err := os.Rename(seg.Path, seg.Path+SpoolCorruptExt); err != nil {
+				return delivered, s.replayed(err)
+			}
+			s.mux.Lock()
+			s.metrics.Corrupt++
+			s.mux.Unlock()
+		} else if err := os.Remove(seg.Path); err != nil {
+			return delivered, s.replayed(err)
+		}
+		s.mux.Lock()
+		s.bytes -= seg.Bytes
+		s.mux.Unlock()
+	}
+	return delivered, s.replayed(nil)
+}
+
+// compact, replace segment with remaining records
+func (s *Spool) compact(seg SegmentInfo, remaining []framedRecord) error {
+	tmp := seg.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, rec := range remaining {
+		if _, err := f.Write(rec.raw); err != nil {
+			_ = f.Close()
+			return err
+		}
+		size += int64(len(rec.raw))
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, seg.Path); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	s.bytes -= seg.Bytes - size
+	s.mux.Unlock()
+	return syncDir(s.opts.Dir)
+}
+
+// replayed, update metrics of replay and return err
+func (s *Spool) replayed(err error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.metrics.LastReplay = time.Now()
+	if err != nil {
+		s.metrics.LastError = err.Error()
+	} else {
+		s.metrics.LastError = ""
+	}
+	return err
+}
+
+// StartReplayer, replay pending jobs with send in background,
+// after a failed delivery the replayer waits with exponential backoff
+// Example:
+// spool.StartReplayer(func(job interface{}) error { return lxAudit.RequestAudit(job, auditHost, authKey) })
+func (s *Spool) StartReplayer(send func(job interface{}) error) {
+	s.mux.Lock()
+	if s.stop != nil {
+		s.mux.Unlock()
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	stop, done := s.stop, s.done
+	s.mux.Unlock()
+
+	go func() {
+		defer close(done)
+		wait := s.opts.ReplayInterval
+		backoff := s.opts.MinBackoff
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+
+			if s.Metrics().Pending == 0 {
+				wait = s.opts.ReplayInterval
+				continue
+			}
+			n, err := s.Replay(send)
+			if err != nil {
+				s.opts.Log.WithField("func", "lxAudit.Spool.StartReplayer").
+					Warnf("replay stopped after %d jobs, retry in %s: %v", n, backoff, err)
+				wait = backoff
+				if backoff *= 2; backoff > s.opts.MaxBackoff {
+					backoff = s.opts.MaxBackoff
+				}
+				continue
+			}
+			if n > 0 {
+				s.opts.Log.WithField("func", "lxAudit.Spool.StartReplayer").Infof("replayed %d jobs", n)
+			}
+			wait, backoff = s.opts.ReplayInterval, s.opts.MinBackoff
+		}
+	}()
+}
+
+// hasPending, return true while records are not delivered
+func (s *Spool) hasPending() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.pending > 0
+}
+
+// Metrics, return snapshot of spool
+func (s *Spool) Metrics() SpoolMetrics {
+	s.mux.Lock()
+	m := s.metrics
+	m.Bytes, m.Pending = s.bytes, s.pending
+	s.mux.Unlock()
+
+	if paths, err := filepath.Glob(filepath.Join(s.opts.Dir, "*"+SpoolSegmentExt)); err == nil {
+		m.Segments = len(paths)
+	}
+	return m
+}
+
+// Close, stop replayer and close active segment
+func (s *Spool) Close() error {
+	s.mux.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mux.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closeActive()
+}
+
+// syncDir, fsync directory after create or rename of files
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not supported by all platforms
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,250 @@
+package lxAudit_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+	lxLog "github.com/litixsoft/lxgo/log"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpool(t *testing.T, opts lxAudit.SpoolOptions) *lxAudit.Spool {
+	dir, err := ioutil.TempDir("", "lxaudit-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	opts.Dir = dir
+	opts.Log = logrus.NewEntry(logrus.New())
+	opts.Log.Logger.SetOutput(ioutil.Discard)
+	spool, err := lxAudit.NewSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+func TestSpool_Append(t *testing.T) {
+	its := assert.New(t)
+	testEntries := getTestEntries()
+
+	t.Run("append and read", func(t *testing.T) {
+		spool := newTestSpool(t, lxAudit.SpoolOptions{SegmentSize: 1024})
+		its.NoError(spool.Append(testEntries[0]))
+		its.NoError(spool.Append(testEntries))
+		its.True(errors.Is(spool.Append("foo"), lxAudit.ErrAuditEntryType))
+
+		// Second job exceeds segment size
+		segments, err := spool.Segments()
+		its.NoError(err)
+		its.Len(segments, 2)
+
+		jobs, err := lxAudit.ReadSegment(segments[0].Path)
+		its.NoError(err)
+		its.Len(jobs, 1)
+		entry, ok := jobs[0].(lxAudit.AuditEntry)
+		its.True(ok)
+		its.Equal(testEntries[0].Collection, entry.Collection)
+
+		jobs, err = lxAudit.ReadSegment(segments[1].Path)
+		its.NoError(err)
+		its.Len(jobs[0], len(testEntries))
+
+		m := spool.Metrics()
+		its.Equal(int64(2), m.Pending)
+		its.Equal(uint64(2), m.Appended)
+		its.Equal(2, m.Segments)
+		its.NoError(spool.Close())
+
+		// Reopen counts existing segments
+		reopened, err := lxAudit.NewSpool(lxAudit.SpoolOptions{Dir: filepath.Dir(segments[0].Path)})
+		its.NoError(err)
+		its.Equal(int64(2), reopened.Metrics().Pending)
+		its.NoError(reopened.Append(testEntries[1]))
+		its.NoError(reopened.Close())
+		segments, _ = reopened.Segments()
+		its.Len(segments, 3)
+	})
+	t.Run("size limit", func(t *testing.T) {
+		spool := newTestSpool(t, lxAudit.SpoolOptions{MaxBytes: 300})
+		defer spool.Close()
+		its.NoError(spool.Append(testEntries[0]))
+		its.True(errors.Is(spool.Append(testEntries), lxAudit.ErrSpoolFull))
+		its.Equal(uint64(1), spool.Metrics().Rejected)
+	})
+	t.Run("checksum", func(t *testing.T) {
+		spool := newTestSpool(t, lxAudit.SpoolOptions{})
+		its.NoError(spool.Append(testEntries[0]))
+		its.NoError(spool.Append(testEntries[1]))
+		its.NoError(spool.Close())
+
+		segments, _ := spool.Segments()
+		data, err := ioutil.ReadFile(segments[0].Path)
+		its.NoError(err)
+		data[len(data)-2] ^= 0xff
+		its.NoError(ioutil.WriteFile(segments[0].Path, data, 0600))
+
+		jobs, err := lxAudit.ReadSegment(segments[0].Path)
+		its.True(errors.Is(err, lxAudit.ErrSpoolCorrupt))
+		its.Len(jobs, 1)
+
+		// Valid records are delivered, segment is kept for inspection
+		var delivered []interface{}
+		n, err := spool.Replay(func(job interface{}) error {
+			delivered = append(delivered, job)
+			return nil
+		})
+		its.NoError(err)
+		its.Equal(1, n)
+		corrupt, _ := spool.CorruptSegments()
+		its.Len(corrupt, 1)
+		its.Equal(uint64(1), spool.Metrics().Corrupt)
+	})
+}
+
+func TestSpool_Replay(t *testing.T) {
+	its := assert.New(t)
+	testEntries := getTestEntries()
+
+	spool := newTestSpool(t, lxAudit.SpoolOptions{})
+	defer spool.Close()
+	for _, entry := range testEntries[:3] {
+		its.NoError(spool.Append(entry))
+	}
+
+	// Failure after first job, remaining jobs are compacted
+	errDown := errors.New("down")
+	var sent []string
+	send := func(job interface{}) error {
+		if len(sent) == 1 {
+			return errDown
+		}
+		sent = append(sent, job.(lxAudit.AuditEntry).Host)
+		return nil
+	}
+	n, err := spool.Replay(send)
+	its.True(errors.Is(err, errDown))
+	its.Equal(1, n)
+	m := spool.Metrics()
+	its.Equal(int64(2), m.Pending)
+	its.Equal(errDown.Error(), m.LastError)
+
+	segments, _ := spool.Segments()
+	its.Len(segments, 1)
+	its.Equal(2, segments[0].Records)
+	its.Equal(m.Bytes, segments[0].Bytes)
+
+	// Jobs appended during outage are delivered after older jobs
+	its.NoError(spool.Append(testEntries[3]))
+	sent = []string{}
+	n, err = spool.Replay(func(job interface{}) error {
+		sent = append(sent, job.(lxAudit.AuditEntry).Host)
+		return nil
+	})
+	its.NoError(err)
+	its.Equal(3, n)
+	its.Equal([]string{testEntries[1].Host, testEntries[2].Host, testEntries[3].Host}, sent)
+
+	m = spool.Metrics()
+	its.Equal(int64(0), m.Pending)
+	its.Equal(int64(0), m.Bytes)
+	its.Equal(0, m.Segments)
+	its.Equal(uint64(4), m.Replayed)
+
+	// Rejected jobs are dropped, replay continues
+	its.NoError(spool.Append(testEntries[4]))
+	its.NoError(spool.Append(testEntries[5]))
+	sent = []string{}
+	n, err = spool.Replay(func(job interface{}) error {
+		if job.(lxAudit.AuditEntry).Host == testEntries[4].Host {
+			return &lxAudit.StatusError{Code: http.StatusBadRequest, Status: "400 Bad Request"}
+		}
+		sent = append(sent, job.(lxAudit.AuditEntry).Host)
+		return nil
+	})
+	its.NoError(err)
+	its.Equal(1, n)
+	its.Equal([]string{testEntries[5].Host}, sent)
+	m = spool.Metrics()
+	its.Equal(uint64(1), m.Dropped)
+	its.Equal(int64(0), m.Pending)
+}
+
+func TestQueue_SetSpool(t *testing.T) {
+	its := assert.New(t)
+
+	// /dev/null for test
+	lxLog.InitLogger(
+		ioutil.Discard,
+		"debug",
+		"text")
+
+	// Service is down until up is set, rejects while reject is set
+	var up, reject, received int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&reject) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	spool := newTestSpool(t, lxAudit.SpoolOptions{
+		ReplayInterval: time.Millisecond * 10,
+		MinBackoff:     time.Millisecond * 10,
+		MaxBackoff:     time.Millisecond * 20,
+	})
+	defer spool.Close()
+
+	queue := lxAudit.NewQueue(
+		"test-host",
+		server.URL,
+		"",
+		lxLog.GetLogger().WithFields(logrus.Fields{}),
+		time.Millisecond)
+	queue.SetClient(lxAudit.NewClient(server.URL, "", &lxAudit.ClientOptions{
+		MinBackoff:      time.Millisecond,
+		MaxBackoff:      time.Millisecond * 5,
+		BreakerCooldown: time.Millisecond * 10,
+	}))
+	queue.SetSpool(spool)
+	queue.StartWorker(queue.JobChan, queue.KillChan, queue.ErrChan)
+	defer close(queue.KillChan)
+
+	// Rejected jobs are not spooled
+	atomic.StoreInt32(&reject, 1)
+	queue.JobChan <- getTestEntries()
+	err := <-queue.ErrChan
+	its.True(errors.Is(err, lxAudit.ErrPermanent))
+	its.True(errors.Is(err, lxAudit.ErrStatus))
+	its.Equal(uint64(0), spool.Metrics().Appended)
+	atomic.StoreInt32(&reject, 0)
+
+	queue.JobChan <- getTestEntries()
+	its.True(errors.Is(<-queue.ErrChan, lxAudit.ErrStatus))
+	its.Equal(uint64(1), spool.Metrics().Appended)
+
+	// Replayer delivers after service is up
+	atomic.StoreInt32(&up, 1)
+	its.Eventually(func() bool {
+		return spool.Metrics().Pending == 0
+	}, time.Second*2, time.Millisecond*10)
+	its.Equal(int32(1), atomic.LoadInt32(&received))
+}
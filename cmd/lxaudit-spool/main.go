@@ -0,0 +1,102 @@
+// Command lxaudit-spool inspects and replays the disk spool of lxAudit.
+//
+// Stop the service of the spool before a manual replay, a spool directory
+// must only be used by one process at a time.
+//
+// Examples:
+// lxaudit-spool -dir /var/spool/audit
+// lxaudit-spool -dir /var/spool/audit -print
+// lxaudit-spool -dir /var/spool/audit -replay -host https://audit.example.com -key <auth key>
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	lxAudit "github.com/litixsoft/lxgo/audit"
+)
+
+func main() {
+	var (
+		dir       = flag.String("dir", "", "spool directory")
+		printJobs = flag.Bool("print", false, "print spooled jobs as json lines")
+		replay    = flag.Bool("replay", false, "replay spooled jobs to audit service")
+		host      = flag.String("host", "", "audit service host, required for replay")
+		key       = flag.String("key", os.Getenv("AUDIT_AUTH_KEY"), "audit service auth key, default AUDIT_AUTH_KEY")
+		timeout   = flag.Duration("timeout", lxAudit.DefaultTimeout, "timeout of requests")
+	)
+	flag.Parse()
+
+	if *dir == "" || (*replay && *host == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		log.Fatal(err)
+	}
+
+	spool, err := lxAudit.NewSpool(lxAudit.SpoolOptions{Dir: *dir})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer spool.Close()
+
+	segments, err := spool.Segments()
+	if err != nil {
+		log.Fatal(err)
+	}
+	corrupt, err := spool.CorruptSegments()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, seg := range segments {
+		status := "ok"
+		if seg.Err != nil {
+			status = seg.Err.Error()
+		}
+		fmt.Printf("%s\t%d records\t%d bytes\t%s\n", seg.Path, seg.Records, seg.Bytes, status)
+	}
+	for _, path := range corrupt {
+		fmt.Printf("%s\tcorrupt\n", path)
+	}
+	m := spool.Metrics()
+	fmt.Printf("segments: %d, pending: %d, bytes: %d, corrupt: %d\n", m.Segments, m.Pending, m.Bytes, len(corrupt))
+
+	if *printJobs {
+		enc := json.NewEncoder(os.Stdout)
+		for _, path := range append(paths(segments), corrupt...) {
+			jobs, err := lxAudit.ReadSegment(path)
+			for _, job := range jobs {
+				if err := enc.Encode(job); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if *replay {
+		n, err := spool.Replay(func(job interface{}) error {
+			return lxAudit.RequestAudit(job, *host, *key, *timeout)
+		})
+		fmt.Printf("replayed: %d\n", n)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// paths, return paths of segments
+func paths(segments []lxAudit.SegmentInfo) []string {
+	p := make([]string, len(segments))
+	for i, seg := range segments {
+		p[i] = seg.Path
+	}
+	return p
+}
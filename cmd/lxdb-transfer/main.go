@@ -0,0 +1,190 @@
+// Command lxdb-transfer exports and imports mongo collections as NDJSON or CSV.
+//
+// The connection is configured with MONGO_* environment variables,
+// see lxDb.NewMongoClientConfigFromEnv, -uri overwrites MONGO_URI.
+//
+// Examples:
+// lxdb-transfer -db app -collection users -filter '{"is_active":true}' -file users.ndjson
+// lxdb-transfer -db app -collection users -format csv -columns 'Name:name,City:address.city' -file users.csv
+// lxdb-transfer -mode import -db app -collection users -upsert-keys email -file users.ndjson
+// lxdb-transfer -mode import -db app -collection users -dry-run -schema-root ./schemas -schema user.json -file users.ndjson
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxDbTransfer "github.com/litixsoft/lxgo/db/transfer"
+	lxHelper "github.com/litixsoft/lxgo/helper"
+	lxSchema "github.com/litixsoft/lxgo/schema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func main() {
+	var (
+		uri        = flag.String("uri", "", "mongo uri, default MONGO_URI")
+		dbName     = flag.String("db", "", "database name")
+		collection = flag.String("collection", "", "collection name")
+		mode       = flag.String("mode", "export", "export or import")
+		format     = flag.String("format", lxDbTransfer.FormatNDJSON, "ndjson or csv")
+		canonical  = flag.Bool("canonical", false, "export canonical extended json")
+		file       = flag.String("file", "", "file, default stdout or stdin")
+		filter     = flag.String("filter", "", "export filter as extended json")
+		query      = flag.String("query", "", `export RequestByQuery as json, {"query":{...},"opts":{...}}`)
+		columns    = flag.String("columns", "", "csv columns header:path:type,...")
+		upsertKeys = flag.String("upsert-keys", "", "import upsert keys, comma separated")
+		dryRun     = flag.Bool("dry-run", false, "import without writes")
+		schemaRoot = flag.String("schema-root", "", "schema root directory")
+		schema     = flag.String("schema", "", "import validation schema")
+		batch      = flag.Int("batch", lxDbTransfer.DefaultBatchSize, "batch size")
+		verbose    = flag.Bool("v", false, "log progress")
+	)
+	flag.Parse()
+
+	if *dbName == "" || *collection == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := lxDb.NewMongoClientConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *uri != "" {
+		cfg.URI = *uri
+	}
+
+	client, err := lxDb.NewMongoClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = client.Disconnect(ctx)
+	}()
+
+	repo := lxDb.NewMongoBaseRepo(client.Database(*dbName).Collection(*collection))
+
+	var cols []lxDbTransfer.Column
+	if *columns != "" {
+		if cols, err = lxDbTransfer.ParseColumns(*columns); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	progress := func(p lxDbTransfer.Progress) {
+		if *verbose {
+			log.Printf("read: %d, written: %d, upserted: %d, invalid: %d, failed: %d",
+				p.Read, p.Written, p.Upserted, p.Invalid, p.Failed)
+		}
+	}
+
+	switch *mode {
+	case "export":
+		err = export(repo, *file, *filter, *query, lxDbTransfer.ExportOptions{
+			Format:    *format,
+			Canonical: *canonical,
+			Columns:   cols,
+			BatchSize: int32(*batch),
+			Progress:  progress,
+		})
+	case "import":
+		opts := lxDbTransfer.ImportOptions{
+			Format:    *format,
+			Columns:   cols,
+			BatchSize: *batch,
+			DryRun:    *dryRun,
+			Schema:    *schema,
+			Progress:  progress,
+		}
+		if *upsertKeys != "" {
+			opts.UpsertKeys = strings.Split(*upsertKeys, ",")
+		}
+		if *schema != "" {
+			lxSchema.InitJsonSchemaLoader()
+			if err := lxSchema.Loader.SetSchemaRootDirectory(*schemaRoot); err != nil {
+				log.Fatal(err)
+			}
+			opts.SchemaLoader = lxSchema.Loader
+		}
+		err = importFile(repo, *file, opts)
+	default:
+		err = fmt.Errorf("unknown mode %q", *mode)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// export, export to file or stdout
+func export(repo lxDb.IBaseRepo, file, filter, query string, opts lxDbTransfer.ExportOptions) error {
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	var progress *lxDbTransfer.Progress
+	var err error
+	if query != "" {
+		rbq, rbqErr := lxHelper.NewRequestByQuery(query)
+		if rbqErr != nil {
+			return rbqErr
+		}
+		progress, err = lxDbTransfer.ExportByQuery(repo, w, rbq, opts)
+	} else {
+		var f interface{} = bson.D{}
+		if filter != "" {
+			var d bson.D
+			if err := bson.UnmarshalExtJSON([]byte(filter), false, &d); err != nil {
+				return err
+			}
+			f = d
+		}
+		progress, err = lxDbTransfer.Export(repo, w, f, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("exported %d documents", progress.Written)
+	return nil
+}
+
+// importFile, import from file or stdin
+func importFile(repo lxDb.IBaseRepo, file string, opts lxDbTransfer.ImportOptions) error {
+	var r io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	res, err := lxDbTransfer.Import(repo, r, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, rowErr := range res.Errors {
+		log.Println(rowErr)
+	}
+	log.Printf("read: %d, inserted: %d, upserted: %d, invalid: %d, failed: %d",
+		res.Read, res.Written, res.Upserted, res.Invalid, res.Failed)
+	return nil
+}
@@ -0,0 +1,89 @@
+// Command lxdb-validator syncs a lxSchema JSON schema to the $jsonSchema validator of a mongo collection.
+//
+// The connection is configured with MONGO_* environment variables,
+// see lxDb.NewMongoClientConfigFromEnv, -uri overwrites MONGO_URI.
+//
+// Examples:
+// lxdb-validator -plan -db app -collection users -schema-root ./schemas -schema user.json
+// lxdb-validator -db app -collection users -schema-root ./schemas -schema user.json -action warn
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxDbValidator "github.com/litixsoft/lxgo/db/validator"
+	lxSchema "github.com/litixsoft/lxgo/schema"
+)
+
+func main() {
+	var (
+		uri        = flag.String("uri", "", "mongo uri, default MONGO_URI")
+		dbName     = flag.String("db", "", "database name")
+		collection = flag.String("collection", "", "collection name")
+		schemaRoot = flag.String("schema-root", "", "schema root directory")
+		schema     = flag.String("schema", "", "schema file")
+		level      = flag.String("level", lxDbValidator.LevelStrict, "validation level, strict, moderate or off")
+		action     = flag.String("action", lxDbValidator.ActionError, "validation action, error or warn")
+		planOnly   = flag.Bool("plan", false, "show changes without apply")
+		timeout    = flag.Duration("timeout", lxDb.DefaultTimeout, "timeout of commands")
+	)
+	flag.Parse()
+
+	if *dbName == "" || *collection == "" || *schema == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	lxSchema.InitJsonSchemaLoader()
+	if err := lxSchema.Loader.SetSchemaRootDirectory(*schemaRoot); err != nil {
+		log.Fatal(err)
+	}
+	loader, err := lxSchema.Loader.LoadSchema(*schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := lxDb.NewMongoClientConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *uri != "" {
+		cfg.URI = *uri
+	}
+
+	client, err := lxDb.NewMongoClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = client.Disconnect(ctx)
+	}()
+
+	coll := client.Database(*dbName).Collection(*collection)
+	opts := lxDbValidator.Options{
+		ValidationLevel:  *level,
+		ValidationAction: *action,
+		Timeout:          *timeout,
+	}
+
+	var plan *lxDbValidator.SyncPlan
+	if *planOnly {
+		plan, err = lxDbValidator.Plan(coll, loader, opts)
+	} else {
+		plan, err = lxDbValidator.Sync(coll, loader, opts)
+	}
+	if plan != nil {
+		fmt.Print(plan)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
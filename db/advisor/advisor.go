@@ -0,0 +1,357 @@
+// Package lxDbAdvisor detects missing indexes in tests. The command monitor of
+// the advisor records the query shapes of a test run, Analyze explains every shape
+// and reports collection scans, in-memory sorts and low selectivity with
+// suggested compound indexes.
+package lxDbAdvisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultMinSelectivity, returned per examined documents or keys
+	DefaultMinSelectivity = 0.1
+	// DefaultMinExamined, selectivity is checked from this number of examined documents or keys
+	DefaultMinExamined = 100
+
+	// Issues
+	IssueCollScan       = "COLLSCAN"
+	IssueInMemorySort   = "IN_MEMORY_SORT"
+	IssueLowSelectivity = "LOW_SELECTIVITY"
+)
+
+// Options, options for NewAdvisor
+type Options struct {
+	// MustBeIndexed, collections as "collection" or "db.collection",
+	// AssertIndexed fails when they are scanned
+	MustBeIndexed []string
+	// MinSelectivity, default DefaultMinSelectivity
+	MinSelectivity float64
+	// MinExamined, default DefaultMinExamined
+	MinExamined int64
+}
+
+// QueryShape, query of a collection with values replaced by placeholders
+type QueryShape struct {
+	Database   string
+	Collection string
+	// Command, e.g. find, aggregate, count, update
+	Command string
+	// Shape, filter with placeholders, see Shape
+	Shape string
+	// Filter and Sort of first executed query
+	Filter bson.D
+	Sort   bson.D
+	// Count, number of executions
+	Count int
+}
+
+// Namespace, return "db.collection"
+func (q *QueryShape) Namespace() string {
+	return q.Database + "." + q.Collection
+}
+
+// Finding, analyzed query shape
+type Finding struct {
+	*QueryShape
+	Plan Plan
+	// Issues, IssueCollScan, IssueInMemorySort or IssueLowSelectivity
+	Issues []string
+	// Suggestion, compound index keys by ESR rule, nil without issues
+	Suggestion bson.D
+	// MustBeIndexed, collection is marked as must be indexed
+	MustBeIndexed bool
+}
+
+// Report, findings of Analyze
+type Report struct {
+	Findings []*Finding
+}
+
+// Issues, return findings with issues
+func (r *Report) Issues() []*Finding {
+	var findings []*Finding
+	for _, f := range r.Findings {
+		if len(f.Issues) > 0 {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// String, return findings with issues, one per line
+// Example:
+// COLLSCAN shop.orders find {"status": "?"} x3, plan COLLSCAN, suggest {"status": 1}
+func (r *Report) String() string {
+	var lines []string
+	for _, f := range r.Issues() {
+		line := fmt.Sprintf("%s %s %s %s x%d, plan %s",
+			strings.Join(f.Issues, ","), f.Namespace(), f.Command, f.Shape, f.Count, f.Plan)
+		if f.Suggestion != nil {
+			if raw, err := bson.MarshalExtJSON(f.Suggestion, false, false); err == nil {
+				line += ", suggest " + string(raw)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Advisor, records query shapes of command monitor
+type Advisor struct {
+	opts   Options
+	mux    sync.Mutex
+	shapes map[string]*QueryShape
+	order  []string
+}
+
+// NewAdvisor, return advisor with options
+// Example:
+// advisor := lxDbAdvisor.NewAdvisor(lxDbAdvisor.Options{MustBeIndexed: []string{"orders"}})
+// client, err := lxDb.NewMongoClient(&lxDb.MongoClientConfig{URI: uri, CommandMonitor: advisor.Monitor()})
+// ... run tests
+// advisor.AssertIndexed(t, context.Background(), client)
+func NewAdvisor(opts Options) *Advisor {
+	if opts.MinSelectivity <= 0 {
+		opts.MinSelectivity = DefaultMinSelectivity
+	}
+	if opts.MinExamined <= 0 {
+		opts.MinExamined = DefaultMinExamined
+	}
+	return &Advisor{opts: opts, shapes: make(map[string]*QueryShape)}
+}
+
+// Monitor, return command monitor which records query shapes,
+// chain with other monitors by lxDb.ChainCommandMonitors
+func (a *Advisor) Monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			a.Record(evt)
+		},
+	}
+}
+
+// Record, record query shapes of command, explain and non query commands are ignored
+func (a *Advisor) Record(evt *event.CommandStartedEvent) {
+	if evt == nil || isInternalDatabase(evt.DatabaseName) {
+		return
+	}
+
+	var cmd bson.D
+	if err := bson.Unmarshal(evt.Command, &cmd); err != nil {
+		return
+	}
+	m := cmd.Map()
+	collection, _ := m[evt.CommandName].(string)
+	if collection == "" || strings.HasPrefix(collection, "system.") {
+		return
+	}
+
+	add := func(filter, sort interface{}) {
+		f, _ := toD(filter)
+		s, _ := toD(sort)
+		a.add(evt.DatabaseName, collection, evt.CommandName, f, s)
+	}
+
+	switch evt.CommandName {
+	case "find":
+		add(m["filter"], m["sort"])
+	case "count", "distinct":
+		add(m["query"], nil)
+	case "findAndModify":
+		add(m["query"], m["sort"])
+	case "update":
+		for _, stmt := range statements(m["updates"]) {
+			add(stmt["q"], nil)
+		}
+	case "delete":
+		for _, stmt := range statements(m["deletes"]) {
+			add(stmt["q"], nil)
+		}
+	case "aggregate":
+		// Leading $match and $sort stages can use indexes,
+		// pipelines with other leading stages like $geoNear are ignored
+		var filter, sort interface{}
+		pipeline, _ := m["pipeline"].(bson.A)
+		for i, stage := range pipeline {
+			s, _ := toM(stage)
+			if match, ok := s["$match"]; ok && i == 0 {
+				filter = match
+				continue
+			}
+			if srt, ok := s["$sort"]; ok && i <= 1 {
+				sort = srt
+			} else if i == 0 {
+				return
+			}
+			break
+		}
+		add(filter, sort)
+	}
+}
+
+// statements, return update or delete statements
+func statements(value interface{}) []bson.M {
+	var stmts []bson.M
+	items, _ := value.(bson.A)
+	for _, item := range items {
+		if m, ok := toM(item); ok {
+			stmts = append(stmts, m)
+		}
+	}
+	return stmts
+}
+
+// add, count query shape
+func (a *Advisor) add(database, collection, command string, filter, sort bson.D) {
+	shape := Shape(filter)
+	sortShape := ""
+	if len(sort) > 0 {
+		sortShape = Shape(sort)
+	}
+	key := strings.Join([]string{database, collection, command, shape, sortShape}, "|")
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if q, ok := a.shapes[key]; ok {
+		q.Count++
+		return
+	}
+	a.shapes[key] = &QueryShape{
+		Database:   database,
+		Collection: collection,
+		Command:    command,
+		Shape:      shape,
+		Filter:     filter,
+		Sort:       sort,
+		Count:      1,
+	}
+	a.order = append(a.order, key)
+}
+
+// Shapes, return recorded query shapes in order of first execution
+func (a *Advisor) Shapes() []*QueryShape {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	shapes := make([]*QueryShape, 0, len(a.order))
+	for _, key := range a.order {
+		q := *a.shapes[key]
+		shapes = append(shapes, &q)
+	}
+	return shapes
+}
+
+// Reset, remove recorded query shapes
+func (a *Advisor) Reset() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.shapes = make(map[string]*QueryShape)
+	a.order = nil
+}
+
+// Analyze, explain recorded query shapes with verbosity executionStats,
+// findings are sorted by namespace
+func (a *Advisor) Analyze(ctx context.Context, client *mongo.Client) (*Report, error) {
+	report := new(Report)
+	for _, q := range a.Shapes() {
+		find := bson.D{{Key: "find", Value: q.Collection}}
+		if q.Filter != nil {
+			find = append(find, bson.E{Key: "filter", Value: q.Filter})
+		}
+		if len(q.Sort) > 0 {
+			find = append(find, bson.E{Key: "sort", Value: q.Sort})
+		}
+		cmd := bson.D{{Key: "explain", Value: find}, {Key: "verbosity", Value: "executionStats"}}
+
+		var explain bson.M
+		if err := client.Database(q.Database).RunCommand(ctx, cmd).Decode(&explain); err != nil {
+			return nil, fmt.Errorf("explain %s %s: %w", q.Namespace(), q.Shape, err)
+		}
+		report.Findings = append(report.Findings, a.Evaluate(q, ParsePlan(explain)))
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Namespace() < report.Findings[j].Namespace()
+	})
+	return report, nil
+}
+
+// Evaluate, return finding with issues of plan
+func (a *Advisor) Evaluate(q *QueryShape, plan Plan) *Finding {
+	f := &Finding{QueryShape: q, Plan: plan, MustBeIndexed: a.mustBeIndexed(q)}
+	if plan.CollScan {
+		f.Issues = append(f.Issues, IssueCollScan)
+	}
+	if plan.InMemorySort {
+		f.Issues = append(f.Issues, IssueInMemorySort)
+	}
+
+	// Selectivity of used index, collection scans are flagged already
+	examined := plan.DocsExamined
+	if plan.KeysExamined > examined {
+		examined = plan.KeysExamined
+	}
+	if !plan.CollScan && examined >= a.opts.MinExamined &&
+		float64(plan.Returned)/float64(examined) < a.opts.MinSelectivity {
+		f.Issues = append(f.Issues, IssueLowSelectivity)
+	}
+
+	if len(f.Issues) > 0 {
+		f.Suggestion = SuggestIndex(q.Filter, q.Sort)
+	}
+	return f
+}
+
+// mustBeIndexed, return true when collection of query is marked
+func (a *Advisor) mustBeIndexed(q *QueryShape) bool {
+	for _, name := range a.opts.MustBeIndexed {
+		if name == q.Collection || name == q.Namespace() {
+			return true
+		}
+	}
+	return false
+}
+
+// TestingT, subset of *testing.T
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertIndexed, analyze recorded query shapes and fail test for
+// collection scans of collections marked as must be indexed, returns report
+// Example:
+// report := advisor.AssertIndexed(t, context.Background(), client)
+// t.Log(report)
+func (a *Advisor) AssertIndexed(t TestingT, ctx context.Context, client *mongo.Client) *Report {
+	t.Helper()
+	report, err := a.Analyze(ctx, client)
+	if err != nil {
+		t.Errorf("index advisor: %v", err)
+		return nil
+	}
+	for _, f := range report.Findings {
+		if f.MustBeIndexed && f.Plan.CollScan {
+			suggestion := ""
+			if raw, err := bson.MarshalExtJSON(f.Suggestion, false, false); err == nil && f.Suggestion != nil {
+				suggestion = ", suggested index " + string(raw)
+			}
+			t.Errorf("collection %s must be indexed, %s %s is a collection scan%s",
+				f.Namespace(), f.Command, f.Shape, suggestion)
+		}
+	}
+	return report
+}
+
+// isInternalDatabase, return true for admin, config and local
+func isInternalDatabase(name string) bool {
+	return name == "admin" || name == "config" || name == "local"
+}
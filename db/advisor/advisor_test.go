@@ -0,0 +1,138 @@
+package lxDbAdvisor_test
+
+import (
+	"context"
+	"testing"
+
+	lxDbAdvisor "github.com/litixsoft/lxgo/db/advisor"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func started(t *testing.T, name string, cmd bson.D) *event.CommandStartedEvent {
+	raw, err := bson.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &event.CommandStartedEvent{Command: raw, DatabaseName: "shop", CommandName: name}
+}
+
+func TestAdvisor_Record(t *testing.T) {
+	its := assert.New(t)
+	advisor := lxDbAdvisor.NewAdvisor(lxDbAdvisor.Options{})
+	monitor := advisor.Monitor()
+
+	// Same shape with different values
+	for _, status := range []string{"open", "closed"} {
+		monitor.Started(context.Background(), started(t, "find", bson.D{
+			{Key: "find", Value: "orders"},
+			{Key: "filter", Value: bson.D{{Key: "status", Value: status}}},
+			{Key: "sort", Value: bson.D{{Key: "created", Value: -1}}},
+		}))
+	}
+	advisor.Record(started(t, "aggregate", bson.D{
+		{Key: "aggregate", Value: "orders"},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{{Key: "total", Value: bson.D{{Key: "$gt", Value: 100}}}}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "total", Value: 1}}}},
+		}},
+	}))
+	advisor.Record(started(t, "update", bson.D{
+		{Key: "update", Value: "users"},
+		{Key: "updates", Value: bson.A{bson.D{{Key: "q", Value: bson.D{{Key: "email", Value: "a@b.c"}}}}}},
+	}))
+
+	// Ignored commands
+	advisor.Record(started(t, "insert", bson.D{{Key: "insert", Value: "orders"}}))
+	advisor.Record(started(t, "explain", bson.D{{Key: "explain", Value: bson.D{{Key: "find", Value: "orders"}}}}))
+	advisor.Record(started(t, "aggregate", bson.D{
+		{Key: "aggregate", Value: "orders"},
+		{Key: "pipeline", Value: bson.A{bson.D{{Key: "$geoNear", Value: bson.D{}}}}},
+	}))
+
+	shapes := advisor.Shapes()
+	its.Len(shapes, 3)
+	its.Equal("orders", shapes[0].Collection)
+	its.Equal(`{"status":"?"}`, shapes[0].Shape)
+	its.Equal(2, shapes[0].Count)
+	its.Equal("aggregate", shapes[1].Command)
+	its.Equal(`{"total":{"$gt":"?"}}`, shapes[1].Shape)
+	its.Equal(bson.D{{Key: "total", Value: int32(1)}}, shapes[1].Sort)
+	its.Equal("shop.users", shapes[2].Namespace())
+
+	advisor.Reset()
+	its.Empty(advisor.Shapes())
+}
+
+func TestParsePlan(t *testing.T) {
+	its := assert.New(t)
+
+	plan := lxDbAdvisor.ParsePlan(bson.M{
+		"queryPlanner": bson.M{"winningPlan": bson.M{
+			"stage": "SORT",
+			"inputStage": bson.M{
+				"stage":      "FETCH",
+				"inputStage": bson.M{"stage": "IXSCAN", "indexName": "status_1"},
+			},
+		}},
+		"executionStats": bson.M{"totalDocsExamined": int32(500), "totalKeysExamined": int32(500), "nReturned": int32(10)},
+	})
+	its.Equal("SORT > FETCH > IXSCAN status_1", plan.String())
+	its.Equal([]string{"status_1"}, plan.Indexes)
+	its.True(plan.InMemorySort)
+	its.False(plan.CollScan)
+	its.Equal(int64(500), plan.DocsExamined)
+	its.Equal(int64(10), plan.Returned)
+
+	// Sharded
+	plan = lxDbAdvisor.ParsePlan(bson.M{"queryPlanner": bson.M{"winningPlan": bson.M{
+		"stage":  "SHARD_MERGE",
+		"shards": bson.A{bson.M{"winningPlan": bson.M{"stage": "COLLSCAN"}}},
+	}}})
+	its.True(plan.CollScan)
+}
+
+func TestSuggestIndex(t *testing.T) {
+	its := assert.New(t)
+
+	its.Equal(bson.D{{Key: "status", Value: 1}, {Key: "tenant", Value: 1}, {Key: "created", Value: -1}, {Key: "total", Value: 1}},
+		lxDbAdvisor.SuggestIndex(bson.D{
+			{Key: "total", Value: bson.D{{Key: "$gte", Value: 100}}},
+			{Key: "$and", Value: bson.A{
+				bson.D{{Key: "status", Value: "open"}},
+				bson.D{{Key: "tenant", Value: bson.D{{Key: "$in", Value: bson.A{1, 2}}}}},
+			}},
+		}, bson.D{{Key: "created", Value: int32(-1)}}))
+
+	// Equality field is not repeated for sort
+	its.Equal(bson.D{{Key: "status", Value: 1}},
+		lxDbAdvisor.SuggestIndex(bson.D{{Key: "status", Value: "open"}}, bson.D{{Key: "status", Value: -1}}))
+	its.Nil(lxDbAdvisor.SuggestIndex(nil, nil))
+}
+
+func TestAdvisor_Evaluate(t *testing.T) {
+	its := assert.New(t)
+	advisor := lxDbAdvisor.NewAdvisor(lxDbAdvisor.Options{MustBeIndexed: []string{"shop.orders"}})
+
+	q := &lxDbAdvisor.QueryShape{Database: "shop", Collection: "orders", Command: "find",
+		Shape: `{"status":"?"}`, Filter: bson.D{{Key: "status", Value: "open"}}, Count: 1}
+	f := advisor.Evaluate(q, lxDbAdvisor.Plan{Stages: []string{"COLLSCAN"}, CollScan: true, DocsExamined: 1000})
+	its.Equal([]string{lxDbAdvisor.IssueCollScan}, f.Issues)
+	its.True(f.MustBeIndexed)
+	its.Equal(bson.D{{Key: "status", Value: 1}}, f.Suggestion)
+
+	f = advisor.Evaluate(q, lxDbAdvisor.Plan{Stages: []string{"FETCH", "IXSCAN status_1"}, DocsExamined: 1000, KeysExamined: 1000, Returned: 5})
+	its.Equal([]string{lxDbAdvisor.IssueLowSelectivity}, f.Issues)
+
+	// Below MinExamined
+	f = advisor.Evaluate(q, lxDbAdvisor.Plan{DocsExamined: 50, Returned: 1})
+	its.Empty(f.Issues)
+	its.Nil(f.Suggestion)
+
+	report := &lxDbAdvisor.Report{Findings: []*lxDbAdvisor.Finding{
+		advisor.Evaluate(q, lxDbAdvisor.Plan{Stages: []string{"COLLSCAN"}, CollScan: true}), f,
+	}}
+	its.Len(report.Issues(), 1)
+	its.Equal(`COLLSCAN shop.orders find {"status":"?"} x1, plan COLLSCAN, suggest {"status":1}`, report.String())
+}
@@ -0,0 +1,247 @@
+package lxDbAdvisor
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Plan stages
+const (
+	StageCollScan = "COLLSCAN"
+	StageIxScan   = "IXSCAN"
+	StageSort     = "SORT"
+)
+
+// Plan, summary of the winning plan and execution stats of explain
+type Plan struct {
+	// Stages, stages of winning plan from root to leaves
+	Stages []string
+	// Indexes, names of used indexes
+	Indexes      []string
+	CollScan     bool
+	InMemorySort bool
+	DocsExamined int64
+	KeysExamined int64
+	Returned     int64
+}
+
+// String, return stages like "FETCH > IXSCAN status_1"
+func (p Plan) String() string {
+	return strings.Join(p.Stages, " > ")
+}
+
+// ParsePlan, return plan of explain result with verbosity executionStats,
+// plans of shards are merged
+func ParsePlan(explain bson.M) Plan {
+	var plan Plan
+	if planner, ok := toM(explain["queryPlanner"]); ok {
+		plan.walk(planner["winningPlan"])
+	}
+	if stats, ok := toM(explain["executionStats"]); ok {
+		plan.DocsExamined = toInt64(stats["totalDocsExamined"])
+		plan.KeysExamined = toInt64(stats["totalKeysExamined"])
+		plan.Returned = toInt64(stats["nReturned"])
+	}
+	return plan
+}
+
+// walk, add stages of plan tree
+func (p *Plan) walk(value interface{}) {
+	stage, ok := toM(value)
+	if !ok {
+		return
+	}
+
+	// Sharded, plans of shards
+	if shards, ok := stage["shards"].(bson.A); ok {
+		for _, shard := range shards {
+			if s, ok := toM(shard); ok {
+				p.walk(s["winningPlan"])
+			}
+		}
+		return
+	}
+
+	name, _ := stage["stage"].(string)
+	switch name {
+	case StageCollScan:
+		p.CollScan = true
+	case StageSort:
+		p.InMemorySort = true
+	case StageIxScan:
+		if index, ok := stage["indexName"].(string); ok {
+			p.Indexes = append(p.Indexes, index)
+			name += " " + index
+		}
+	}
+	if name != "" {
+		p.Stages = append(p.Stages, name)
+	}
+
+	p.walk(stage["inputStage"])
+	if inputs, ok := stage["inputStages"].(bson.A); ok {
+		for _, input := range inputs {
+			p.walk(input)
+		}
+	}
+}
+
+// SuggestIndex, return compound index keys for filter and sort by the ESR rule:
+// equality fields, then sort fields, then range fields.
+// $or and $nor are not considered, nil without fields
+// Example:
+// keys := lxDbAdvisor.SuggestIndex(bson.D{{"status", "open"}, {"total", bson.D{{"$gt", 100}}}}, bson.D{{"created", -1}})
+// // {status: 1, created: -1, total: 1}
+func SuggestIndex(filter, sort bson.D) bson.D {
+	var equality, ranges []string
+	var collect func(doc bson.D)
+	collect = func(doc bson.D) {
+		for _, e := range doc {
+			if e.Key == "$and" {
+				if items, ok := e.Value.(bson.A); ok {
+					for _, item := range items {
+						if d, ok := toD(item); ok {
+							collect(d)
+						}
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(e.Key, "$") {
+				continue
+			}
+			if isEquality(e.Value) {
+				equality = append(equality, e.Key)
+			} else {
+				ranges = append(ranges, e.Key)
+			}
+		}
+	}
+	collect(filter)
+
+	keys := bson.D{}
+	seen := make(map[string]bool)
+	add := func(field string, value interface{}) {
+		if !seen[field] {
+			seen[field] = true
+			keys = append(keys, bson.E{Key: field, Value: value})
+		}
+	}
+	for _, field := range equality {
+		add(field, 1)
+	}
+	for _, e := range sort {
+		add(e.Key, direction(e.Value))
+	}
+	for _, field := range ranges {
+		add(field, 1)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys
+}
+
+// isEquality, return true for values and $eq or $in conditions
+func isEquality(value interface{}) bool {
+	if _, ok := value.(primitive.Regex); ok {
+		return false
+	}
+	doc, ok := toD(value)
+	if !ok || len(doc) == 0 || !strings.HasPrefix(doc[0].Key, "$") {
+		return true
+	}
+	for _, e := range doc {
+		if e.Key != "$eq" && e.Key != "$in" {
+			return false
+		}
+	}
+	return true
+}
+
+// direction, return 1 or -1 of sort value
+func direction(value interface{}) int {
+	if toInt64(value) < 0 {
+		return -1
+	}
+	return 1
+}
+
+// Shape, return filter with values replaced by "?", operators and fields are kept
+// Example:
+// lxDbAdvisor.Shape(bson.D{{"status", "open"}, {"total", bson.D{{"$gt", 100}}}}) // {"status": "?", "total": {"$gt": "?"}}
+func Shape(filter bson.D) string {
+	raw, err := bson.MarshalExtJSON(shapeValue(filter), false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", filter)
+	}
+	return string(raw)
+}
+
+// shapeValue, return value with placeholders
+func shapeValue(value interface{}) interface{} {
+	doc, ok := toD(value)
+	if !ok {
+		return "?"
+	}
+	out := bson.D{}
+	for _, e := range doc {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			items, _ := e.Value.(bson.A)
+			shaped := bson.A{}
+			for _, item := range items {
+				shaped = append(shaped, shapeValue(item))
+			}
+			out = append(out, bson.E{Key: e.Key, Value: shaped})
+		default:
+			out = append(out, bson.E{Key: e.Key, Value: shapeValue(e.Value)})
+		}
+	}
+	return out
+}
+
+// toD, return document as bson.D
+func toD(value interface{}) (bson.D, bool) {
+	switch v := value.(type) {
+	case bson.D:
+		return v, true
+	case bson.M:
+		doc := bson.D{}
+		for key, val := range v {
+			doc = append(doc, bson.E{Key: key, Value: val})
+		}
+		return doc, true
+	}
+	return nil, false
+}
+
+// toM, return document as bson.M
+func toM(value interface{}) (bson.M, bool) {
+	switch v := value.(type) {
+	case bson.M:
+		return v, true
+	case bson.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// toInt64, return number as int64
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,586 @@
+package lxDb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lxLog "github.com/litixsoft/lxgo/log"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	// Default connect timeout for NewMongoClient
+	DefaultConnectTimeout = time.Second * 10
+
+	// Default env prefix for NewMongoClientConfigFromEnv
+	DefaultEnvPrefix = "MONGO"
+
+	// Compressors
+	CompressorZstd   = "zstd"
+	CompressorSnappy = "snappy"
+	CompressorZlib   = "zlib"
+)
+
+// MongoClientConfig, configuration for NewMongoClient
+type MongoClientConfig struct {
+	URI                    string
+	AppName                string
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	MinPoolSize            uint64
+	MaxPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+
+	// TLS, CAFile and CertificateKeyFile are PEM files,
+	// the certificate key file contains certificate and private key
+	TLSCAFile             string
+	TLSCertificateKeyFile string
+	TLSInsecure           bool
+
+	// Compressors in order of preference, zstd, snappy or zlib
+	Compressors []string
+	ZstdLevel   int
+
+	// ReadPreference mode: primary, primaryPreferred, secondary, secondaryPreferred, nearest
+	ReadPreference string
+	// ReadConcern level: local, majority, available, linearizable, snapshot
+	ReadConcern string
+	// WriteConcern "majority" or number of nodes
+	WriteConcern        string
+	WriteConcernJournal bool
+	WriteConcernTimeout time.Duration
+
+	// Monitoring, events are logged with Logger
+	MonitorCommands bool
+	MonitorPool     bool
+	Logger          *logrus.Entry
+	// CommandMonitor, additional command monitor, e.g. of lxDbAdvisor
+	CommandMonitor *event.CommandMonitor
+}
+
+// NewMongoClientConfigFromEnv, return config from environment variables.
+// Without prefix DefaultEnvPrefix is used.
+// Example with prefix "MONGO":
+// MONGO_URI=mongodb://127.0.0.1:27017
+// MONGO_APP_NAME=my-service
+// MONGO_CONNECT_TIMEOUT=10s
+// MONGO_SERVER_SELECTION_TIMEOUT=30s
+// MONGO_MIN_POOL_SIZE=5
+// MONGO_MAX_POOL_SIZE=100
+// MONGO_MAX_CONN_IDLE_TIME=5m
+// MONGO_TLS_CA_FILE=/etc/ssl/ca.pem
+// MONGO_TLS_CERTIFICATE_KEY_FILE=/etc/ssl/client.pem
+// MONGO_TLS_INSECURE=false
+// MONGO_COMPRESSORS=zstd,snappy
+// MONGO_ZSTD_LEVEL=6
+// MONGO_READ_PREFERENCE=secondaryPreferred
+// MONGO_READ_CONCERN=majority
+// MONGO_WRITE_CONCERN=majority
+// MONGO_WRITE_CONCERN_JOURNAL=true
+// MONGO_WRITE_CONCERN_TIMEOUT=5s
+// MONGO_MONITOR_COMMANDS=false
+// MONGO_MONITOR_POOL=false
+func NewMongoClientConfigFromEnv(prefix ...string) (*MongoClientConfig, error) {
+	p := DefaultEnvPrefix
+	if len(prefix) > 0 && prefix[0] != "" {
+		p = prefix[0]
+	}
+
+	env := func(key string) string {
+		return strings.TrimSpace(os.Getenv(p + "_" + key))
+	}
+
+	cfg := &MongoClientConfig{
+		URI:                   env("URI"),
+		AppName:               env("APP_NAME"),
+		TLSCAFile:             env("TLS_CA_FILE"),
+		TLSCertificateKeyFile: env("TLS_CERTIFICATE_KEY_FILE"),
+		ReadPreference:        env("READ_PREFERENCE"),
+		ReadConcern:           env("READ_CONCERN"),
+		WriteConcern:          env("WRITE_CONCERN"),
+	}
+
+	if v := env("COMPRESSORS"); v != "" {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				cfg.Compressors = append(cfg.Compressors, c)
+			}
+		}
+	}
+
+	// Durations
+	durations := map[string]*time.Duration{
+		"CONNECT_TIMEOUT":          &cfg.ConnectTimeout,
+		"SERVER_SELECTION_TIMEOUT": &cfg.ServerSelectionTimeout,
+		"MAX_CONN_IDLE_TIME":       &cfg.MaxConnIdleTime,
+		"WRITE_CONCERN_TIMEOUT":    &cfg.WriteConcernTimeout,
+	}
+	for key, dst := range durations {
+		if v := env(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s_%s: %w", p, key, err)
+			}
+			*dst = d
+		}
+	}
+
+	// Unsigned numbers
+	numbers := map[string]*uint64{
+		"MIN_POOL_SIZE": &cfg.MinPoolSize,
+		"MAX_POOL_SIZE": &cfg.MaxPoolSize,
+	}
+	for key, dst := range numbers {
+		if v := env(key); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s_%s: %w", p, key, err)
+			}
+			*dst = n
+		}
+	}
+
+	if v := env("ZSTD_LEVEL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s_ZSTD_LEVEL: %w", p, err)
+		}
+		cfg.ZstdLevel = n
+	}
+
+	// Booleans
+	booleans := map[string]*bool{
+		"TLS_INSECURE":          &cfg.TLSInsecure,
+		"WRITE_CONCERN_JOURNAL": &cfg.WriteConcernJournal,
+		"MONITOR_COMMANDS":      &cfg.MonitorCommands,
+		"MONITOR_POOL":          &cfg.MonitorPool,
+	}
+	for key, dst := range booleans {
+		if v := env(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s_%s: %w", p, key, err)
+			}
+			*dst = b
+		}
+	}
+
+	return cfg, nil
+}
+
+// ToClientOptions, convert config to mongo driver client options
+func (cfg *MongoClientConfig) ToClientOptions() (*options.ClientOptions, error) {
+	if cfg.URI == "" {
+		return nil, ErrMissingURI
+	}
+
+	opts := options.Client().ApplyURI(cfg.URI)
+
+	if cfg.AppName != "" {
+		opts.SetAppName(cfg.AppName)
+	}
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 && cfg.MaxPoolSize > 0 && cfg.MinPoolSize > cfg.MaxPoolSize {
+		return nil, fmt.Errorf("min pool size %d greater than max pool size %d, %w",
+			cfg.MinPoolSize, cfg.MaxPoolSize, ErrClientConfig)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+
+	// TLS
+	if cfg.TLSCAFile != "" || cfg.TLSCertificateKeyFile != "" || cfg.TLSInsecure {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	// Compressors
+	if len(cfg.Compressors) > 0 {
+		for _, c := range cfg.Compressors {
+			switch c {
+			case CompressorZstd, CompressorSnappy, CompressorZlib:
+			default:
+				return nil, fmt.Errorf("compressor %q, %w", c, ErrClientConfig)
+			}
+		}
+		opts.SetCompressors(cfg.Compressors)
+	}
+	if cfg.ZstdLevel != 0 {
+		opts.SetZstdLevel(cfg.ZstdLevel)
+	}
+
+	// Read preference
+	if cfg.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("read preference %q, %w", cfg.ReadPreference, ErrClientConfig)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	// Read concern
+	if cfg.ReadConcern != "" {
+		switch cfg.ReadConcern {
+		case "local", "majority", "available", "linearizable", "snapshot":
+			opts.SetReadConcern(readconcern.New(readconcern.Level(cfg.ReadConcern)))
+		default:
+			return nil, fmt.Errorf("read concern %q, %w", cfg.ReadConcern, ErrClientConfig)
+		}
+	}
+
+	// Write concern
+	if cfg.WriteConcern != "" || cfg.WriteConcernJournal || cfg.WriteConcernTimeout > 0 {
+		var wcOpts []writeconcern.Option
+		switch cfg.WriteConcern {
+		case "":
+		case "majority":
+			wcOpts = append(wcOpts, writeconcern.WMajority())
+		default:
+			w, err := strconv.Atoi(cfg.WriteConcern)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("write concern %q, %w", cfg.WriteConcern, ErrClientConfig)
+			}
+			wcOpts = append(wcOpts, writeconcern.W(w))
+		}
+		if cfg.WriteConcernJournal {
+			wcOpts = append(wcOpts, writeconcern.J(true))
+		}
+		if cfg.WriteConcernTimeout > 0 {
+			wcOpts = append(wcOpts, writeconcern.WTimeout(cfg.WriteConcernTimeout))
+		}
+		opts.SetWriteConcern(writeconcern.New(wcOpts...))
+	}
+
+	// Monitoring
+	switch {
+	case cfg.MonitorCommands && cfg.CommandMonitor != nil:
+		opts.SetMonitor(ChainCommandMonitors(NewCommandMonitor(cfg.logger()), cfg.CommandMonitor))
+	case cfg.MonitorCommands:
+		opts.SetMonitor(NewCommandMonitor(cfg.logger()))
+	case cfg.CommandMonitor != nil:
+		opts.SetMonitor(cfg.CommandMonitor)
+	}
+	if cfg.MonitorPool {
+		opts.SetPoolMonitor(NewPoolMonitor(cfg.logger()))
+	}
+
+	return opts, opts.Validate()
+}
+
+// tlsConfig, build tls config from files
+func (cfg *MongoClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TLSInsecure,
+	}
+
+	if cfg.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s, %w", cfg.TLSCAFile, ErrClientConfig)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLSCertificateKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertificateKeyFile, cfg.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// logger, return config logger or default lxLog logger
+func (cfg *MongoClientConfig) logger() *logrus.Entry {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+	return lxLog.GetLogger().WithField("module", "lxDb")
+}
+
+// NewMongoClient, return new connected mongo driver client from config
+func NewMongoClient(cfg *MongoClientConfig) (*mongo.Client, error) {
+	opts, err := cfg.ToClientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := DefaultConnectTimeout
+	if cfg.ConnectTimeout > 0 {
+		timeout = cfg.ConnectTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check connection
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return client, err
+	}
+
+	return client, nil
+}
+
+// NewCommandMonitor, return command monitor with logging to log entry.
+// Started and succeeded commands are logged with debug level, failed with warn level.
+func NewCommandMonitor(log *logrus.Entry) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			log.WithFields(logrus.Fields{
+				"command":    evt.CommandName,
+				"database":   evt.DatabaseName,
+				"request_id": evt.RequestID,
+				"connection": evt.ConnectionID,
+			}).Debug("mongo command started")
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			log.WithFields(logrus.Fields{
+				"command":    evt.CommandName,
+				"request_id": evt.RequestID,
+				"connection": evt.ConnectionID,
+				"duration":   time.Duration(evt.DurationNanos).String(),
+			}).Debug("mongo command succeeded")
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			log.WithFields(logrus.Fields{
+				"command":    evt.CommandName,
+				"request_id": evt.RequestID,
+				"connection": evt.ConnectionID,
+				"duration":   time.Duration(evt.DurationNanos).String(),
+			}).Warn("mongo command failed: " + evt.Failure)
+		},
+	}
+}
+
+// ChainCommandMonitors, return command monitor which calls all monitors in order
+func ChainCommandMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Started != nil {
+					m.Started(ctx, evt)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Succeeded != nil {
+					m.Succeeded(ctx, evt)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m != nil && m.Failed != nil {
+					m.Failed(ctx, evt)
+				}
+			}
+		},
+	}
+}
+
+// NewPoolMonitor, return pool monitor with logging to log entry.
+// Cleared pools and failed checkouts are logged with warn level, all others with debug level.
+func NewPoolMonitor(log *logrus.Entry) *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			ctxLog := log.WithFields(logrus.Fields{
+				"event":   evt.Type,
+				"address": evt.Address,
+			})
+			if evt.ConnectionID != 0 {
+				ctxLog = ctxLog.WithField("connection", evt.ConnectionID)
+			}
+			if evt.Reason != "" {
+				ctxLog = ctxLog.WithField("reason", evt.Reason)
+			}
+
+			switch evt.Type {
+			case event.PoolCleared, event.GetFailed:
+				ctxLog.Warn("mongo pool event")
+			default:
+				ctxLog.Debug("mongo pool event")
+			}
+		},
+	}
+}
+
+// MongoRegistry, holds named clients and databases
+// Example:
+// reg := lxDb.NewMongoRegistry()
+// _, err := reg.Connect("main", cfg)
+// db, err := reg.AddDatabase("users", "main", "users_db")
+// defer reg.DisconnectAll(context.Background())
+type MongoRegistry struct {
+	mux       sync.RWMutex
+	clients   map[string]*mongo.Client
+	names     []string
+	databases map[string]*mongo.Database
+}
+
+// NewMongoRegistry, return empty registry
+func NewMongoRegistry() *MongoRegistry {
+	return &MongoRegistry{
+		clients:   make(map[string]*mongo.Client),
+		databases: make(map[string]*mongo.Database),
+	}
+}
+
+// Connect, create client from config and add it with name
+func (reg *MongoRegistry) Connect(name string, cfg *MongoClientConfig) (*mongo.Client, error) {
+	reg.mux.RLock()
+	_, exists := reg.clients[name]
+	reg.mux.RUnlock()
+	if exists {
+		return nil, fmt.Errorf("client %q, %w", name, ErrClientExists)
+	}
+
+	client, err := NewMongoClient(cfg)
+	if err != nil {
+		if client != nil {
+			_ = client.Disconnect(context.Background())
+		}
+		return nil, err
+	}
+
+	if err := reg.AddClient(name, client); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// AddClient, add an existing client with name
+func (reg *MongoRegistry) AddClient(name string, client *mongo.Client) error {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	if _, ok := reg.clients[name]; ok {
+		return fmt.Errorf("client %q, %w", name, ErrClientExists)
+	}
+	reg.clients[name] = client
+	reg.names = append(reg.names, name)
+
+	return nil
+}
+
+// Client, return client by name
+func (reg *MongoRegistry) Client(name string) (*mongo.Client, error) {
+	reg.mux.RLock()
+	defer reg.mux.RUnlock()
+
+	client, ok := reg.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("client %q, %w", name, ErrClientNotFound)
+	}
+
+	return client, nil
+}
+
+// AddDatabase, add database dbName of client clientName with name
+func (reg *MongoRegistry) AddDatabase(name, clientName, dbName string, opts ...*options.DatabaseOptions) (*mongo.Database, error) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	client, ok := reg.clients[clientName]
+	if !ok {
+		return nil, fmt.Errorf("client %q, %w", clientName, ErrClientNotFound)
+	}
+	if _, ok := reg.databases[name]; ok {
+		return nil, fmt.Errorf("database %q, %w", name, ErrDatabaseExists)
+	}
+
+	db := client.Database(dbName, opts...)
+	reg.databases[name] = db
+
+	return db, nil
+}
+
+// Database, return database by name
+func (reg *MongoRegistry) Database(name string) (*mongo.Database, error) {
+	reg.mux.RLock()
+	defer reg.mux.RUnlock()
+
+	db, ok := reg.databases[name]
+	if !ok {
+		return nil, fmt.Errorf("database %q, %w", name, ErrDatabaseNotFound)
+	}
+
+	return db, nil
+}
+
+// DisconnectAll, disconnect all clients and clear the registry.
+// All clients are disconnected, also when one of them fails,
+// the error of the first failed client in order of adding is returned.
+func (reg *MongoRegistry) DisconnectAll(ctx context.Context) error {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(reg.names))
+	for i, name := range reg.names {
+		wg.Add(1)
+		go func(i int, name string, client *mongo.Client) {
+			defer wg.Done()
+			if err := client.Disconnect(ctx); err != nil && err != mongo.ErrClientDisconnected {
+				errs[i] = fmt.Errorf("disconnect client %q: %w", name, err)
+			}
+		}(i, name, reg.clients[name])
+	}
+	wg.Wait()
+
+	reg.clients = make(map[string]*mongo.Client)
+	reg.names = nil
+	reg.databases = make(map[string]*mongo.Database)
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package lxDb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestNewMongoClientConfigFromEnv(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("with prefix", func(t *testing.T) {
+		env := map[string]string{
+			"LXTEST_URI":                   "mongodb://127.0.0.1:27017",
+			"LXTEST_APP_NAME":              "lxgo-test",
+			"LXTEST_CONNECT_TIMEOUT":       "5s",
+			"LXTEST_MIN_POOL_SIZE":         "2",
+			"LXTEST_MAX_POOL_SIZE":         "50",
+			"LXTEST_COMPRESSORS":           "zstd, snappy",
+			"LXTEST_READ_PREFERENCE":       "secondaryPreferred",
+			"LXTEST_READ_CONCERN":          "majority",
+			"LXTEST_WRITE_CONCERN":         "majority",
+			"LXTEST_WRITE_CONCERN_JOURNAL": "true",
+			"LXTEST_MONITOR_COMMANDS":      "true",
+		}
+		for k, v := range env {
+			its.NoError(os.Setenv(k, v))
+		}
+		defer func() {
+			for k := range env {
+				_ = os.Unsetenv(k)
+			}
+		}()
+
+		cfg, err := lxDb.NewMongoClientConfigFromEnv("LXTEST")
+		its.NoError(err)
+		its.Equal("mongodb://127.0.0.1:27017", cfg.URI)
+		its.Equal("lxgo-test", cfg.AppName)
+		its.Equal(time.Second*5, cfg.ConnectTimeout)
+		its.Equal(uint64(2), cfg.MinPoolSize)
+		its.Equal(uint64(50), cfg.MaxPoolSize)
+		its.Equal([]string{"zstd", "snappy"}, cfg.Compressors)
+		its.Equal("secondaryPreferred", cfg.ReadPreference)
+		its.Equal("majority", cfg.ReadConcern)
+		its.Equal("majority", cfg.WriteConcern)
+		its.True(cfg.WriteConcernJournal)
+		its.True(cfg.MonitorCommands)
+		its.False(cfg.MonitorPool)
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		its.NoError(os.Setenv("LXTEST_MAX_POOL_SIZE", "many"))
+		defer func() { _ = os.Unsetenv("LXTEST_MAX_POOL_SIZE") }()
+
+		cfg, err := lxDb.NewMongoClientConfigFromEnv("LXTEST")
+		its.Error(err)
+		its.Nil(cfg)
+	})
+}
+
+func TestMongoClientConfig_ToClientOptions(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("valid config", func(t *testing.T) {
+		cfg := &lxDb.MongoClientConfig{
+			URI:                 dbHost,
+			AppName:             "lxgo-test",
+			MinPoolSize:         2,
+			MaxPoolSize:         10,
+			Compressors:         []string{lxDb.CompressorZstd, lxDb.CompressorSnappy},
+			ReadPreference:      "nearest",
+			ReadConcern:         "local",
+			WriteConcern:        "2",
+			WriteConcernTimeout: time.Second,
+			MonitorCommands:     true,
+			MonitorPool:         true,
+		}
+
+		opts, err := cfg.ToClientOptions()
+		its.NoError(err)
+		its.Equal("lxgo-test", *opts.AppName)
+		its.Equal(uint64(2), *opts.MinPoolSize)
+		its.Equal(uint64(10), *opts.MaxPoolSize)
+		its.Equal([]string{"zstd", "snappy"}, opts.Compressors)
+		its.Equal(readpref.NearestMode, opts.ReadPreference.Mode())
+		its.Equal("local", opts.ReadConcern.GetLevel())
+		its.Equal(2, opts.WriteConcern.GetW())
+		its.Equal(time.Second, opts.WriteConcern.GetWTimeout())
+		its.NotNil(opts.Monitor)
+		its.NotNil(opts.PoolMonitor)
+	})
+	t.Run("errors", func(t *testing.T) {
+		tests := map[string]*lxDb.MongoClientConfig{
+			"missing uri":     {},
+			"pool size":       {URI: dbHost, MinPoolSize: 10, MaxPoolSize: 5},
+			"compressor":      {URI: dbHost, Compressors: []string{"lz4"}},
+			"read preference": {URI: dbHost, ReadPreference: "fastest"},
+			"read concern":    {URI: dbHost, ReadConcern: "some"},
+			"write concern":   {URI: dbHost, WriteConcern: "all"},
+			"tls ca file":     {URI: dbHost, TLSCAFile: "not_exists.pem"},
+		}
+		for name, cfg := range tests {
+			_, err := cfg.ToClientOptions()
+			its.Error(err, name)
+		}
+
+		_, err := (&lxDb.MongoClientConfig{}).ToClientOptions()
+		its.True(errors.Is(err, lxDb.ErrMissingURI))
+		_, err = (&lxDb.MongoClientConfig{URI: dbHost, ReadConcern: "some"}).ToClientOptions()
+		its.True(errors.Is(err, lxDb.ErrClientConfig))
+	})
+}
+
+func TestMongoRegistry(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("not found", func(t *testing.T) {
+		reg := lxDb.NewMongoRegistry()
+
+		_, err := reg.Client("main")
+		its.True(errors.Is(err, lxDb.ErrClientNotFound))
+
+		_, err = reg.Database("users")
+		its.True(errors.Is(err, lxDb.ErrDatabaseNotFound))
+
+		_, err = reg.AddDatabase("users", "main", TestDbName)
+		its.True(errors.Is(err, lxDb.ErrClientNotFound))
+
+		its.NoError(reg.DisconnectAll(context.Background()))
+	})
+	t.Run("add and disconnect", func(t *testing.T) {
+		reg := lxDb.NewMongoRegistry()
+
+		// Not connected client
+		client, err := mongo.NewClient()
+		its.NoError(err)
+
+		its.NoError(reg.AddClient("main", client))
+		its.True(errors.Is(reg.AddClient("main", client), lxDb.ErrClientExists))
+
+		db, err := reg.AddDatabase("users", "main", TestDbName)
+		its.NoError(err)
+		its.Equal(TestDbName, db.Name())
+
+		_, err = reg.AddDatabase("users", "main", TestDbName)
+		its.True(errors.Is(err, lxDb.ErrDatabaseExists))
+
+		chk, err := reg.Database("users")
+		its.NoError(err)
+		its.Equal(db, chk)
+
+		its.NoError(reg.DisconnectAll(context.Background()))
+
+		_, err = reg.Client("main")
+		its.True(errors.Is(err, lxDb.ErrClientNotFound))
+	})
+	t.Run("disconnect several", func(t *testing.T) {
+		reg := lxDb.NewMongoRegistry()
+		for _, name := range []string{"main", "audit", "archive"} {
+			client, err := mongo.NewClient()
+			its.NoError(err)
+			its.NoError(reg.AddClient(name, client))
+		}
+
+		its.NoError(reg.DisconnectAll(context.Background()))
+		for _, name := range []string{"main", "audit", "archive"} {
+			_, err := reg.Client(name)
+			its.True(errors.Is(err, lxDb.ErrClientNotFound))
+		}
+
+		// Registry is reusable after DisconnectAll
+		client, err := mongo.NewClient()
+		its.NoError(err)
+		its.NoError(reg.AddClient("main", client))
+		its.NoError(reg.DisconnectAll(context.Background()))
+	})
+}
+
+func TestNewMongoClient(t *testing.T) {
+	its := assert.New(t)
+
+	reg := lxDb.NewMongoRegistry()
+	client, err := reg.Connect("main", &lxDb.MongoClientConfig{
+		URI:         dbHost,
+		AppName:     "lxgo-test",
+		MaxPoolSize: 10,
+	})
+	its.NoError(err)
+	its.IsType(&mongo.Client{}, client)
+	its.NoError(reg.DisconnectAll(context.Background()))
+}
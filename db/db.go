@@ -1,6 +1,7 @@
 package lxDb
 
 import (
+	lxHelper "github.com/litixsoft/lxgo/helper"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -17,6 +18,7 @@ type IBaseRepo interface {
 	FindOneAndReplace(filter, replacement, result interface{}, args ...interface{}) error
 	FindOneAndUpdate(filter, update, result interface{}, args ...interface{}) error
 	UpdateOne(filter interface{}, update interface{}, args ...interface{}) error
+	ReplaceOne(filter interface{}, replacement interface{}, args ...interface{}) error
 	UpdateMany(filter interface{}, update interface{}, args ...interface{}) (*UpdateManyResult, error)
 	DeleteOne(filter interface{}, args ...interface{}) error
 	DeleteMany(filter interface{}, args ...interface{}) (*DeleteManyResult, error)
@@ -25,6 +27,38 @@ type IBaseRepo interface {
 	GetRepoName() string
 	SetLocale(code string)
 	Aggregate(pipeline interface{}, result interface{}, args ...interface{}) error
+	Distinct(fieldName string, filter interface{}, args ...interface{}) ([]interface{}, error)
+	FindWithCount(filter interface{}, result interface{}, findOptions *lxHelper.FindOptions, args ...interface{}) (*FindWithCountResult, error)
+}
+
+// IRelationRepo, optional interface of repos with Relations
+// Example:
+// plan, err := repo.(lxDb.IRelationRepo).PlanDelete(bson.D{{"_id", customerID}})
+type IRelationRepo interface {
+	PlanDelete(filter interface{}, args ...interface{}) (*CascadePlan, error)
+}
+
+// IGeoRepo, optional interface of repos with geospatial queries
+// Example:
+// err := repo.(lxDb.IGeoRepo).FindNear(&lxDb.GeoNearOptions{Near: lxDb.NewPoint(13.405, 52.52)}, &stores)
+type IGeoRepo interface {
+	FindNear(near *GeoNearOptions, result interface{}, args ...interface{}) error
+}
+
+// IHistoryRepo, optional interface of repos with HistoryOptions
+// Example:
+// revisions, err := repo.(lxDb.IHistoryRepo).Revisions(id)
+type IHistoryRepo interface {
+	Revisions(id interface{}, args ...interface{}) ([]Revision, error)
+	GetRevision(id interface{}, revision int64, args ...interface{}) (*Revision, error)
+	DiffRevisions(id interface{}, from, to int64, args ...interface{}) ([]RevisionChange, error)
+	RevertRevision(id interface{}, revision int64, args ...interface{}) error
+}
+
+// IReadAuditRepo, optional interface of repos with ReadAuditOptions
+type IReadAuditRepo interface {
+	// FlushReadAudit, send read entries pending in AggregateWindow, e.g. before shutdown
+	FlushReadAudit()
 }
 
 type IBaseRepoAudit interface {
@@ -62,6 +96,32 @@ type DeleteManyResult struct {
 	DeletedCount int64
 }
 
+// FindWithCountResult, total and page metadata of FindWithCount,
+// Page starts with 1, without limit all documents are on one page
+type FindWithCountResult struct {
+	Total   int64
+	Count   int64
+	Skip    int64
+	Limit   int64
+	Page    int64
+	Pages   int64
+	HasNext bool
+	Facets  map[string][]FacetCount
+}
+
+// FacetCount, number of documents with value
+type FacetCount struct {
+	Value interface{} `bson:"_id"`
+	Count int64       `bson:"count"`
+}
+
+// FacetOptions, per field facet counts for FindWithCount,
+// array fields are counted per element, Limit 0 returns all values
+type FacetOptions struct {
+	Fields []string
+	Limit  int64
+}
+
 // SetAuditAuthUser, returns AuditAuth with user
 func SetAuditAuth(user interface{}) *AuditAuth {
 	return &AuditAuth{User: user}
@@ -7,3 +7,49 @@ var ErrIndexConvert = errors.New("can't convert index models")
 
 // Not Found error
 var ErrNotFound = errors.New("not found")
+
+// Client config errors
+var (
+	ErrMissingURI       = errors.New("missing mongo uri")
+	ErrClientConfig     = errors.New("invalid client config")
+	ErrClientExists     = errors.New("client already exists")
+	ErrClientNotFound   = errors.New("client not found")
+	ErrDatabaseExists   = errors.New("database already exists")
+	ErrDatabaseNotFound = errors.New("database not found")
+)
+
+// Invalid aggregation pipeline
+var ErrPipeline = errors.New("invalid pipeline")
+
+// Id errors
+var (
+	ErrIdType        = errors.New("generated id does not match type of _id")
+	ErrInvalidUUID   = errors.New("invalid uuid")
+	ErrInvalidULID   = errors.New("invalid ulid")
+	ErrSnowflakeNode = errors.New("snowflake node out of range")
+)
+
+// Schema validation errors
+var (
+	ErrValidation       = errors.New("document validation failed")
+	ErrUpdateValidation = errors.New("update can't be validated")
+)
+
+// History errors
+var ErrHistoryDisabled = errors.New("history is not enabled for repo")
+
+// Builder errors
+var (
+	ErrFilter = errors.New("invalid filter")
+	ErrUpdate = errors.New("invalid update")
+	ErrFields = errors.New("invalid fields model")
+)
+
+// Invalid GeoJSON
+var ErrGeoJSON = errors.New("invalid geojson")
+
+// Relation errors
+var (
+	ErrRelation = errors.New("invalid relation")
+	ErrRestrict = errors.New("delete restricted by references")
+)
@@ -0,0 +1,220 @@
+package lxDb
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields, registry of field paths of a struct by bson tags,
+// filters and updates of the registry reject unknown paths.
+// Nested structs, arrays of structs with indexes and positional operators,
+// maps and interface{} fields with any sub path are supported.
+// Example:
+// var userFields = lxDb.MustFields(User{})
+// filter := userFields.Where("address.city").Eq("Berlin")
+// update := userFields.Update().Set("address.zip", "10115")
+type Fields struct {
+	root *fieldNode
+}
+
+// fieldNode, field of registry
+type fieldNode struct {
+	children map[string]*fieldNode
+	// array, elements are addressed by index or positional operator
+	array bool
+	// open, any sub path is valid
+	open bool
+}
+
+// fieldsCache, registries by type
+var fieldsCache sync.Map
+
+// NewFields, return registry of struct or pointer to struct
+func NewFields(model interface{}) (*Fields, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a struct, %w", model, ErrFields)
+	}
+
+	if fields, ok := fieldsCache.Load(t); ok {
+		return fields.(*Fields), nil
+	}
+	fields := &Fields{root: structNode(t, map[reflect.Type]bool{})}
+	fieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+// MustFields, return registry of struct, panics when model is no struct
+func MustFields(model interface{}) *Fields {
+	fields, err := NewFields(model)
+	if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	docType      = reflect.TypeOf(bson.D{})
+	marshalType  = reflect.TypeOf((*bson.Marshaler)(nil)).Elem()
+	valueType    = reflect.TypeOf((*bson.ValueMarshaler)(nil)).Elem()
+	textType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	primitivePkg = reflect.TypeOf(bson.E{}).PkgPath()
+)
+
+// structNode, return node with bson fields of struct type
+func structNode(t reflect.Type, visiting map[reflect.Type]bool) *fieldNode {
+	if visiting[t] {
+		// Recursive type
+		return &fieldNode{open: true}
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	node := &fieldNode{children: map[string]*fieldNode{}}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+
+		tag := sf.Tag.Get("bson")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		inline := false
+		for _, opt := range parts[1:] {
+			if opt == "inline" {
+				inline = true
+			}
+		}
+
+		child := typeNode(sf.Type, visiting)
+		if inline {
+			if child.open {
+				node.open = true
+			}
+			for key, value := range child.children {
+				node.children[key] = value
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		node.children[name] = child
+	}
+	return node
+}
+
+// typeNode, return node of field type
+func typeNode(t reflect.Type, visiting map[reflect.Type]bool) *fieldNode {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == docType:
+		return &fieldNode{open: true}
+	case t == timeType, t.PkgPath() == primitivePkg,
+		t.Implements(marshalType), t.Implements(valueType), t.Implements(textType),
+		reflect.PtrTo(t).Implements(valueType):
+		return &fieldNode{}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structNode(t, visiting)
+	case reflect.Map, reflect.Interface:
+		return &fieldNode{open: true}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Binary
+			return &fieldNode{}
+		}
+		elem := typeNode(t.Elem(), visiting)
+		return &fieldNode{children: elem.children, open: elem.open, array: true}
+	}
+	return &fieldNode{}
+}
+
+// Has, return true when path is a field of the registry,
+// after arrays indexes and positional operators like $, $[] and $[elem] are valid
+func (f *Fields) Has(path string) bool {
+	if path == "" {
+		return false
+	}
+	node := f.root
+	parts := strings.Split(path, ".")
+	for i := 0; i < len(parts); i++ {
+		if node.open {
+			return true
+		}
+		part := parts[i]
+		if node.array && isArrayElem(part) {
+			node = &fieldNode{children: node.children, open: node.open}
+			continue
+		}
+		child, ok := node.children[part]
+		if !ok {
+			return false
+		}
+		node = child
+	}
+	return true
+}
+
+// isArrayElem, return true for index or positional operator
+func isArrayElem(part string) bool {
+	if strings.HasPrefix(part, "$") {
+		return true
+	}
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+// Paths, return all paths of registry without array elements, sorted
+func (f *Fields) Paths() []string {
+	var paths []string
+	var walk func(prefix string, node *fieldNode)
+	walk = func(prefix string, node *fieldNode) {
+		for key, child := range node.children {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			paths = append(paths, path)
+			walk(path, child)
+		}
+	}
+	walk("", f.root)
+	sort.Strings(paths)
+	return paths
+}
+
+// Where, return condition of path, unknown paths return ErrFilter by Build
+func (f *Fields) Where(path string) *Condition {
+	c := Where(path)
+	if !f.Has(path) {
+		c.errs = append(c.errs, fmt.Sprintf("unknown field %q", path))
+	}
+	return c
+}
+
+// Update, return empty update, unknown paths return ErrUpdate by Build
+func (f *Fields) Update() *UpdateBuilder {
+	return &UpdateBuilder{fields: f}
+}
@@ -0,0 +1,334 @@
+package lxDb
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter, fluent builder for query filters, can be passed as filter to all IBaseRepo methods,
+// invalid filters return ErrFilter by Build and on marshal
+// Example:
+// filter := lxDb.Where("status").Eq("open").And(lxDb.Where("total").Gt(100))
+// err := repo.Find(filter, &result)
+type Filter struct {
+	doc  bson.D
+	errs []string
+}
+
+// Condition, operators of one field, see Where
+type Condition struct {
+	field string
+	not   bool
+	errs  []string
+}
+
+// Where, return condition of field
+func Where(field string) *Condition {
+	c := &Condition{field: field}
+	if field == "" || strings.HasPrefix(field, "$") {
+		c.errs = append(c.errs, fmt.Sprintf("invalid field name %q", field))
+	}
+	return c
+}
+
+// Not, negate the following operator with $not
+// Example:
+// filter := lxDb.Where("name").Not().Regex("^a", "i")
+func (c *Condition) Not() *Condition {
+	c.not = !c.not
+	return c
+}
+
+// op, return filter with operator of field
+func (c *Condition) op(op string, value interface{}) *Filter {
+	expr := bson.D{{Key: op, Value: value}}
+	if c.not {
+		return c.filter(bson.D{{Key: "$not", Value: expr}})
+	}
+	return c.filter(expr)
+}
+
+// filter, return filter with value of field
+func (c *Condition) filter(value interface{}) *Filter {
+	return &Filter{doc: bson.D{{Key: c.field, Value: value}}, errs: append([]string{}, c.errs...)}
+}
+
+// fail, return filter with error
+func (c *Condition) fail(format string, a ...interface{}) *Filter {
+	f := c.filter(nil)
+	f.errs = append(f.errs, fmt.Sprintf("%s: ", c.field)+fmt.Sprintf(format, a...))
+	return f
+}
+
+// Comparison
+
+// Eq, equality, without Not as {field: value}
+func (c *Condition) Eq(value interface{}) *Filter {
+	if c.not {
+		return c.op("$eq", value)
+	}
+	return c.filter(value)
+}
+
+// Ne, $ne operator
+func (c *Condition) Ne(value interface{}) *Filter { return c.op("$ne", value) }
+
+// Gt, $gt operator
+func (c *Condition) Gt(value interface{}) *Filter { return c.op("$gt", value) }
+
+// Gte, $gte operator
+func (c *Condition) Gte(value interface{}) *Filter { return c.op("$gte", value) }
+
+// Lt, $lt operator
+func (c *Condition) Lt(value interface{}) *Filter { return c.op("$lt", value) }
+
+// Lte, $lte operator
+func (c *Condition) Lte(value interface{}) *Filter { return c.op("$lte", value) }
+
+// In, $in operator
+func (c *Condition) In(values ...interface{}) *Filter { return c.op("$in", bson.A(values)) }
+
+// Nin, $nin operator
+func (c *Condition) Nin(values ...interface{}) *Filter { return c.op("$nin", bson.A(values)) }
+
+// Between, $gte min and $lte max
+func (c *Condition) Between(min, max interface{}) *Filter {
+	expr := bson.D{{Key: "$gte", Value: min}, {Key: "$lte", Value: max}}
+	if c.not {
+		return c.filter(bson.D{{Key: "$not", Value: expr}})
+	}
+	return c.filter(expr)
+}
+
+// Element
+
+// Exists, $exists operator
+func (c *Condition) Exists(exists bool) *Filter { return c.op("$exists", exists) }
+
+// Type, $type operator with bson type aliases like "string" or numbers
+func (c *Condition) Type(types ...interface{}) *Filter {
+	if len(types) == 0 {
+		return c.fail("$type requires at least one type")
+	}
+	if len(types) == 1 {
+		return c.op("$type", types[0])
+	}
+	return c.op("$type", bson.A(types))
+}
+
+// Evaluation
+
+// Regex, $regex operator with options like "i"
+func (c *Condition) Regex(pattern, options string) *Filter {
+	if c.not {
+		// $not requires a regex object instead of $regex
+		return c.filter(bson.D{{Key: "$not", Value: primitive.Regex{Pattern: pattern, Options: options}}})
+	}
+	expr := bson.D{{Key: "$regex", Value: pattern}}
+	if options != "" {
+		expr = append(expr, bson.E{Key: "$options", Value: options})
+	}
+	return c.filter(expr)
+}
+
+// Mod, $mod operator
+func (c *Condition) Mod(divisor, remainder int64) *Filter {
+	if divisor == 0 {
+		return c.fail("$mod divisor can't be 0")
+	}
+	return c.op("$mod", bson.A{divisor, remainder})
+}
+
+// Array
+
+// All, $all operator
+func (c *Condition) All(values ...interface{}) *Filter { return c.op("$all", bson.A(values)) }
+
+// Size, $size operator
+func (c *Condition) Size(size int) *Filter {
+	if size < 0 {
+		return c.fail("$size can't be negative")
+	}
+	return c.op("$size", size)
+}
+
+// ElemMatch, $elemMatch operator, fields of filter are fields of the elements
+// Example:
+// filter := lxDb.Where("items").ElemMatch(lxDb.Where("qty").Gt(5).And(lxDb.Where("sku").Eq("A1")))
+func (c *Condition) ElemMatch(filter *Filter) *Filter {
+	f := c.op("$elemMatch", filter.doc)
+	f.errs = append(f.errs, filter.errs...)
+	return f
+}
+
+// Geospatial, geometry is GeoJSON like bson.D{{"type", "Point"}, {"coordinates", bson.A{lng, lat}}}
+
+// GeoWithin, $geoWithin with $geometry
+func (c *Condition) GeoWithin(geometry interface{}) *Filter {
+	return c.op("$geoWithin", bson.D{{Key: "$geometry", Value: geometry}})
+}
+
+// GeoWithinBox, $geoWithin with legacy $box of bottom left and top right
+func (c *Condition) GeoWithinBox(bottomLeft, topRight [2]float64) *Filter {
+	return c.op("$geoWithin", bson.D{{Key: "$box", Value: bson.A{bottomLeft[:], topRight[:]}}})
+}
+
+// GeoWithinCenterSphere, $geoWithin with $centerSphere, radius in radians
+func (c *Condition) GeoWithinCenterSphere(center [2]float64, radius float64) *Filter {
+	return c.op("$geoWithin", bson.D{{Key: "$centerSphere", Value: bson.A{center[:], radius}}})
+}
+
+// GeoWithinPolygon, $geoWithin with legacy $polygon
+func (c *Condition) GeoWithinPolygon(points ...[2]float64) *Filter {
+	if len(points) < 3 {
+		return c.fail("$polygon requires at least three points")
+	}
+	polygon := make(bson.A, len(points))
+	for i := range points {
+		polygon[i] = points[i][:]
+	}
+	return c.op("$geoWithin", bson.D{{Key: "$polygon", Value: polygon}})
+}
+
+// GeoIntersects, $geoIntersects with $geometry
+func (c *Condition) GeoIntersects(geometry interface{}) *Filter {
+	return c.op("$geoIntersects", bson.D{{Key: "$geometry", Value: geometry}})
+}
+
+// Near, $near with $geometry, distances in meters, 0 is not set
+func (c *Condition) Near(geometry interface{}, maxDistance, minDistance float64) *Filter {
+	return c.near("$near", geometry, maxDistance, minDistance)
+}
+
+// NearSphere, $nearSphere with $geometry, distances in meters, 0 is not set
+func (c *Condition) NearSphere(geometry interface{}, maxDistance, minDistance float64) *Filter {
+	return c.near("$nearSphere", geometry, maxDistance, minDistance)
+}
+
+// near, $near or $nearSphere operator, can't be negated
+func (c *Condition) near(op string, geometry interface{}, maxDistance, minDistance float64) *Filter {
+	if c.not {
+		return c.fail("%s can't be used with $not", op)
+	}
+	if maxDistance < 0 || minDistance < 0 || (maxDistance > 0 && minDistance > maxDistance) {
+		return c.fail("%s invalid distance", op)
+	}
+	expr := bson.D{{Key: "$geometry", Value: geometry}}
+	if maxDistance > 0 {
+		expr = append(expr, bson.E{Key: "$maxDistance", Value: maxDistance})
+	}
+	if minDistance > 0 {
+		expr = append(expr, bson.E{Key: "$minDistance", Value: minDistance})
+	}
+	return c.op(op, expr)
+}
+
+// Logical
+
+// And, return $and of filters, empty filters are left out
+func And(filters ...*Filter) *Filter {
+	return logical("$and", filters)
+}
+
+// Or, return $or of filters, empty filters are left out
+func Or(filters ...*Filter) *Filter {
+	return logical("$or", filters)
+}
+
+// Nor, return $nor of filters, empty filters are left out
+func Nor(filters ...*Filter) *Filter {
+	return logical("$nor", filters)
+}
+
+// And, return $and of filter and others, nested $and are flattened
+func (f *Filter) And(others ...*Filter) *Filter {
+	return And(append([]*Filter{f}, others...)...)
+}
+
+// Or, return $or of filter and others, nested $or are flattened
+func (f *Filter) Or(others ...*Filter) *Filter {
+	return Or(append([]*Filter{f}, others...)...)
+}
+
+// logical, return filter with logical operator,
+// a single filter is returned unchanged
+func logical(op string, filters []*Filter) *Filter {
+	res := &Filter{}
+	var items bson.A
+	for _, f := range filters {
+		if f == nil {
+			continue
+		}
+		res.errs = append(res.errs, f.errs...)
+		if len(f.doc) == 0 {
+			continue
+		}
+		// Flatten same operator
+		if op != "$nor" && len(f.doc) == 1 && f.doc[0].Key == op {
+			if nested, ok := f.doc[0].Value.(bson.A); ok {
+				items = append(items, nested...)
+				continue
+			}
+		}
+		items = append(items, f.doc)
+	}
+
+	switch len(items) {
+	case 0:
+		res.doc = bson.D{}
+	case 1:
+		if op == "$nor" {
+			res.doc = bson.D{{Key: op, Value: items}}
+		} else {
+			res.doc = items[0].(bson.D)
+		}
+	default:
+		res.doc = bson.D{{Key: op, Value: items}}
+	}
+	return res
+}
+
+// Build, return filter or ErrFilter with all errors
+func (f *Filter) Build() (bson.D, error) {
+	if len(f.errs) > 0 {
+		return nil, fmt.Errorf("%s, %w", strings.Join(f.errs, "; "), ErrFilter)
+	}
+	if f.doc == nil {
+		return bson.D{}, nil
+	}
+	return f.doc, nil
+}
+
+// MustBuild, return filter, panics by errors
+func (f *Filter) MustBuild() bson.D {
+	doc, err := f.Build()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// MarshalBSON, implements bson.Marshaler, the filter can be used as filter of IBaseRepo methods
+func (f *Filter) MarshalBSON() ([]byte, error) {
+	doc, err := f.Build()
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+// String, return filter as relaxed extended json for debugging
+func (f *Filter) String() string {
+	doc, err := f.Build()
+	if err != nil {
+		return err.Error()
+	}
+	raw, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err.Error()
+	}
+	return string(raw)
+}
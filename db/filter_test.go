@@ -0,0 +1,164 @@
+package lxDb_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type builderAddress struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip,omitempty"`
+}
+
+type builderItem struct {
+	Sku string `bson:"sku"`
+	Qty int    `bson:"qty"`
+}
+
+type builderBase struct {
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type builderOrder struct {
+	builderBase `bson:",inline"`
+	ID          primitive.ObjectID     `bson:"_id,omitempty"`
+	Status      string                 `bson:"status"`
+	Total       float64                `bson:"total"`
+	Address     *builderAddress        `bson:"address"`
+	Items       []builderItem          `bson:"items"`
+	Tags        []string               `bson:"tags"`
+	Meta        map[string]interface{} `bson:"meta"`
+	Note        string
+	Internal    string `bson:"-"`
+}
+
+func TestFilter_Build(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("comparison", func(t *testing.T) {
+		filter, err := lxDb.Where("status").Eq("open").And(lxDb.Where("total").Gt(100)).Build()
+		its.NoError(err)
+		its.Equal(bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "status", Value: "open"}},
+			bson.D{{Key: "total", Value: bson.D{{Key: "$gt", Value: 100}}}},
+		}}}, filter)
+
+		its.Equal(bson.D{{Key: "total", Value: bson.D{{Key: "$gte", Value: 1}, {Key: "$lte", Value: 5}}}},
+			lxDb.Where("total").Between(1, 5).MustBuild())
+		its.Equal(bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{"open", "new"}}}}},
+			lxDb.Where("status").In("open", "new").MustBuild())
+	})
+	t.Run("logical", func(t *testing.T) {
+		// Nested $or is flattened, single filters are unchanged
+		filter := lxDb.Or(lxDb.Where("a").Eq(1).Or(lxDb.Where("b").Eq(2)), lxDb.Where("c").Exists(false))
+		its.Equal(bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "a", Value: 1}},
+			bson.D{{Key: "b", Value: 2}},
+			bson.D{{Key: "c", Value: bson.D{{Key: "$exists", Value: false}}}},
+		}}}, filter.MustBuild())
+		its.Equal(bson.D{{Key: "a", Value: 1}}, lxDb.And(lxDb.Where("a").Eq(1)).MustBuild())
+		its.Equal(bson.D{}, lxDb.And().MustBuild())
+
+		its.Equal(bson.D{{Key: "total", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 5}}}}}},
+			lxDb.Where("total").Not().Gt(5).MustBuild())
+		its.Equal(bson.D{{Key: "name", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: "^a", Options: "i"}}}}},
+			lxDb.Where("name").Not().Regex("^a", "i").MustBuild())
+	})
+	t.Run("array and geo", func(t *testing.T) {
+		its.Equal(bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "qty", Value: bson.D{{Key: "$gt", Value: 5}}}}}}}},
+			lxDb.Where("items").ElemMatch(lxDb.Where("qty").Gt(5)).MustBuild())
+
+		point := bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: bson.A{13.4, 52.5}}}
+		its.Equal(bson.D{{Key: "location", Value: bson.D{{Key: "$near", Value: bson.D{
+			{Key: "$geometry", Value: point},
+			{Key: "$maxDistance", Value: 1000.0},
+		}}}}}, lxDb.Where("location").Near(point, 1000, 0).MustBuild())
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := lxDb.Where("$where").Eq(1).Build()
+		its.True(errors.Is(err, lxDb.ErrFilter))
+
+		_, err = lxDb.Where("a").Eq(1).And(lxDb.Where("tags").Size(-1)).Build()
+		its.True(errors.Is(err, lxDb.ErrFilter))
+
+		// Invalid filter can't be marshaled for repo methods
+		_, err = bson.Marshal(lxDb.Where("location").Not().Near(nil, 0, 0))
+		its.True(errors.Is(err, lxDb.ErrFilter))
+	})
+	t.Run("marshal", func(t *testing.T) {
+		doc, err := lxDb.ToBsonDoc(lxDb.Where("status").Eq("open"))
+		its.NoError(err)
+		its.Equal(bson.D{{Key: "status", Value: "open"}}, *doc)
+	})
+}
+
+func TestUpdateBuilder_Build(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("operators", func(t *testing.T) {
+		update, err := lxDb.Set("a", 1).Inc("b", 2).Push("c", "x").Set("d", true).Build()
+		its.NoError(err)
+		its.Equal(bson.D{
+			{Key: "$set", Value: bson.D{{Key: "a", Value: 1}, {Key: "d", Value: true}}},
+			{Key: "$inc", Value: bson.D{{Key: "b", Value: 2}}},
+			{Key: "$push", Value: bson.D{{Key: "c", Value: "x"}}},
+		}, update)
+
+		its.Equal(bson.D{
+			{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.D{{Key: "$each", Value: bson.A{1, 2}}, {Key: "$slice", Value: -5}}}}},
+			{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "qty", Value: bson.D{{Key: "$lte", Value: 0}}}}}}},
+			{Key: "$unset", Value: bson.D{{Key: "x", Value: ""}, {Key: "y", Value: ""}}},
+		}, lxDb.NewUpdate().
+			PushEach("scores", bson.A{1, 2}, bson.E{Key: "$slice", Value: -5}).
+			Pull("items", lxDb.Where("qty").Lte(0)).
+			Unset("x", "y").
+			MustBuild())
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := lxDb.NewUpdate().Build()
+		its.True(errors.Is(err, lxDb.ErrUpdate))
+
+		// Conflicting paths of operators
+		_, err = lxDb.Set("a.b", 1).Inc("a", 1).Build()
+		its.True(errors.Is(err, lxDb.ErrUpdate))
+		_, err = lxDb.Set("a", 1).Set("a.b", 1).Build()
+		its.True(errors.Is(err, lxDb.ErrUpdate))
+
+		_, err = lxDb.NewUpdate().PushEach("a", bson.A{1}, bson.E{Key: "$limit", Value: 1}).Build()
+		its.True(errors.Is(err, lxDb.ErrUpdate))
+	})
+}
+
+func TestFields(t *testing.T) {
+	its := assert.New(t)
+
+	fields, err := lxDb.NewFields(&builderOrder{})
+	its.NoError(err)
+
+	for _, path := range []string{"_id", "status", "created_at", "address.city", "items", "items.sku",
+		"items.0.qty", "items.$.qty", "items.$[].qty", "items.$[elem].sku", "tags.1", "meta.any.path", "note"} {
+		its.True(fields.Has(path), path)
+	}
+	for _, path := range []string{"", "Status", "address.street", "items.price", "tags.x", "internal", "created_at.x"} {
+		its.False(fields.Has(path), path)
+	}
+	its.Contains(fields.Paths(), "address.zip")
+
+	_, err = fields.Where("address.city").Eq("Berlin").Or(fields.Where("stauts").Eq("open")).Build()
+	its.True(errors.Is(err, lxDb.ErrFilter))
+	its.Contains(err.Error(), `unknown field "stauts"`)
+
+	_, err = fields.Update().Set("status", "closed").Inc("items.$.qty", 1).Build()
+	its.NoError(err)
+	_, err = fields.Update().Set("totla", 1).Build()
+	its.True(errors.Is(err, lxDb.ErrUpdate))
+
+	_, err = lxDb.NewFields("order")
+	its.True(errors.Is(err, lxDb.ErrFields))
+}
@@ -0,0 +1,725 @@
+// Package lxDbFixtures loads test data from JSON, Extended JSON or YAML files.
+//
+// A fixture file is either a plain array of documents, the collection name
+// is then the file name up to the first dot (users.test.json -> users),
+// or a fixture object:
+//
+//	collection: orders
+//	mode: recreate            # truncate (default) or recreate
+//	indexes:
+//	  - keys: {customerId: 1, createdAt: -1}
+//	    options: {name: customer_created}
+//	documents:
+//	  - "@name": order1       # symbolic name, removed before insert
+//	    _id: "@id"            # generated ObjectID
+//	    customerId: "@ref:alice"
+//	    createdAt: "@now-3d"
+//
+// Directives in string values:
+//
+//	@id              new ObjectID
+//	@now, @now-3d    relative date, units ms, s, m, h, d, w, M (month), y
+//	@ref:<name>      _id of the document with symbolic name
+//	@@...            escaped literal string beginning with @
+//
+// Documents with a symbolic name and without _id get a generated ObjectID,
+// so they can be referenced. Extended JSON like {"$oid": "..."} or
+// {"$date": "..."} can be used in JSON and YAML files.
+package lxDbFixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Modes for prepare the collection
+	ModeTruncate = "truncate"
+	ModeRecreate = "recreate"
+
+	// Directives
+	KeyName        = "@name"
+	DirectiveID    = "@id"
+	DirectiveNow   = "@now"
+	DirectiveRef   = "@ref:"
+	DirectiveEsc   = "@@"
+	directiveStart = "@"
+)
+
+// Errors
+var (
+	ErrFormat       = errors.New("unsupported fixture format")
+	ErrMode         = errors.New("unsupported fixture mode")
+	ErrCollection   = errors.New("missing fixture collection")
+	ErrDirective    = errors.New("invalid fixture directive")
+	ErrDuplicateRef = errors.New("duplicate fixture name")
+	ErrUnknownRef   = errors.New("unknown fixture reference")
+	ErrIndexOption  = errors.New("unsupported fixture index option")
+)
+
+// Index in fixture file
+type Index struct {
+	Keys    bson.D `json:"keys" bson:"keys"`
+	Options bson.M `json:"options,omitempty" bson:"options,omitempty"`
+}
+
+// Fixture, parsed fixture file
+type Fixture struct {
+	File       string   `bson:"-"`
+	Collection string   `bson:"collection"`
+	Mode       string   `bson:"mode,omitempty"`
+	Indexes    []Index  `bson:"indexes,omitempty"`
+	Documents  []bson.D `bson:"documents"`
+}
+
+// Result, loaded ids for assertions in tests
+type Result struct {
+	// IDs of documents with symbolic name
+	IDs map[string]interface{}
+	// InsertedIDs by collection in order of fixture documents
+	InsertedIDs map[string][]interface{}
+}
+
+// ID, return _id of document with symbolic name, nil when not exists
+func (r *Result) ID(name string) interface{} {
+	return r.IDs[name]
+}
+
+// ObjectID, return _id of document with symbolic name as ObjectID,
+// primitive.NilObjectID when not exists or other type
+func (r *Result) ObjectID(name string) primitive.ObjectID {
+	id, _ := r.IDs[name].(primitive.ObjectID)
+	return id
+}
+
+// Loader, loads fixtures into target
+type Loader struct {
+	target ITarget
+	now    func() time.Time
+}
+
+// NewLoader, return loader for target
+// Example:
+// loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMongoTarget(db))
+// res, err := loader.LoadDir("testdata/fixtures")
+// its.NoError(err)
+// userId := res.ObjectID("alice")
+func NewLoader(target ITarget) *Loader {
+	return &Loader{
+		target: target,
+		now:    time.Now,
+	}
+}
+
+// SetNow, set func for current time, for deterministic relative dates
+func (l *Loader) SetNow(now func() time.Time) {
+	l.now = now
+}
+
+// LoadDir, load all *.json, *.yaml and *.yml files of dir in name order
+func (l *Loader) LoadDir(dir string) (*Result, error) {
+	var files []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	return l.LoadFiles(files...)
+}
+
+// LoadFiles, parse files, resolve directives and insert documents.
+// References can point to documents of all given files.
+func (l *Loader) LoadFiles(files ...string) (*Result, error) {
+	fixtures := make([]*Fixture, 0, len(files))
+	for _, file := range files {
+		f, err := ParseFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	return l.Load(fixtures...)
+}
+
+// Load, resolve directives and insert documents of fixtures
+func (l *Loader) Load(fixtures ...*Fixture) (*Result, error) {
+	res := &Result{
+		IDs:         make(map[string]interface{}),
+		InsertedIDs: make(map[string][]interface{}),
+	}
+	now := l.now()
+
+	// Copy documents, fixtures of caller are not changed
+	docs := make([][]bson.D, len(fixtures))
+	for fi, f := range fixtures {
+		docs[fi] = make([]bson.D, len(f.Documents))
+		for i, doc := range f.Documents {
+			docs[fi][i] = append(bson.D(nil), doc...)
+		}
+	}
+
+	// First pass, assign ids to named documents
+	for fi, f := range fixtures {
+		for i, doc := range docs[fi] {
+			name, ok := lookup(doc, KeyName)
+			if !ok {
+				continue
+			}
+			n, ok := name.(string)
+			if !ok || n == "" {
+				return nil, fmt.Errorf("%s: %s must be a string, %w", f.File, KeyName, ErrDirective)
+			}
+			if _, exists := res.IDs[n]; exists {
+				return nil, fmt.Errorf("%s: %q, %w", f.File, n, ErrDuplicateRef)
+			}
+
+			id, hasID := lookup(doc, "_id")
+			if s, isStr := id.(string); !hasID || isStr && s == DirectiveID {
+				id = primitive.NewObjectID()
+				docs[fi][i] = set(doc, "_id", id)
+			}
+			res.IDs[n] = id
+		}
+	}
+
+	// Second pass, resolve directives
+	for fi, f := range fixtures {
+		for i, doc := range docs[fi] {
+			resolved, err := resolve(remove(doc, KeyName), res.IDs, now)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.File, err)
+			}
+			docs[fi][i] = resolved.(bson.D)
+		}
+	}
+
+	// Prepare collections once and insert
+	prepared := make(map[string]bool)
+	for fi, f := range fixtures {
+		if !prepared[f.Collection] {
+			if err := l.prepare(f); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.File, err)
+			}
+			prepared[f.Collection] = true
+		}
+
+		if len(docs[fi]) == 0 {
+			continue
+		}
+		insert := make([]interface{}, len(docs[fi]))
+		for i, doc := range docs[fi] {
+			insert[i] = doc
+		}
+
+		ids, err := l.target.InsertMany(f.Collection, insert)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.File, err)
+		}
+		res.InsertedIDs[f.Collection] = append(res.InsertedIDs[f.Collection], ids...)
+	}
+
+	return res, nil
+}
+
+// prepare, truncate or recreate collection and ensure indexes
+func (l *Loader) prepare(f *Fixture) error {
+	switch f.Mode {
+	case "", ModeTruncate:
+		if err := l.target.Truncate(f.Collection); err != nil {
+			return err
+		}
+	case ModeRecreate:
+		if err := l.target.Recreate(f.Collection); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%q, %w", f.Mode, ErrMode)
+	}
+
+	if len(f.Indexes) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.IndexModel, len(f.Indexes))
+	for i, idx := range f.Indexes {
+		models[i] = mongo.IndexModel{Keys: idx.Keys}
+		if idx.Options != nil {
+			opts, err := toIndexOptions(idx.Options)
+			if err != nil {
+				return err
+			}
+			models[i].Options = opts
+		}
+	}
+
+	return l.target.EnsureIndexes(f.Collection, models)
+}
+
+// ParseFile, parse a json or yaml fixture file
+func ParseFile(file string) (*Fixture, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+	case ".yaml", ".yml":
+		if raw, err = yamlToJSON(raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w", file, ErrFormat)
+	}
+
+	f, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	f.File = file
+
+	// Collection from file name
+	if f.Collection == "" {
+		f.Collection = strings.SplitN(filepath.Base(file), ".", 2)[0]
+	}
+
+	return f, nil
+}
+
+// Parse, parse fixture from (extended) json,
+// plain arrays of documents are returned without collection
+func Parse(raw []byte) (*Fixture, error) {
+	// Wrap for support of top level arrays
+	wrapped := append(append([]byte(`{"v":`), raw...), '}')
+	var v struct {
+		V bson.RawValue `bson:"v"`
+	}
+	if err := bson.UnmarshalExtJSON(wrapped, false, &v); err != nil {
+		return nil, err
+	}
+
+	f := new(Fixture)
+	switch v.V.Type {
+	case bson.TypeArray:
+		if err := bson.Unmarshal(wrapDoc(v.V), &f); err != nil {
+			return nil, err
+		}
+	case bson.TypeEmbeddedDocument:
+		if err := v.V.Unmarshal(f); err != nil {
+			return nil, err
+		}
+		if f.Collection == "" {
+			return nil, ErrCollection
+		}
+	default:
+		return nil, ErrFormat
+	}
+
+	return f, nil
+}
+
+// wrapDoc, wrap array value as documents field
+func wrapDoc(v bson.RawValue) []byte {
+	doc, _ := bson.Marshal(bson.D{{Key: "documents", Value: v}})
+	return doc
+}
+
+// yamlToJSON, convert yaml to json with string keys,
+// the order of mapping keys is kept for compound indexes and documents
+func yamlToJSON(raw []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+
+	// Decode again with ordered mappings
+	switch v.(type) {
+	case map[interface{}]interface{}:
+		var m yaml.MapSlice
+		if err := yaml.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		v = m
+	case []interface{}:
+		var a []yaml.MapSlice
+		if err := yaml.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, len(a))
+		for i, m := range a {
+			list[i] = m
+		}
+		v = list
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, yamlConvert(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlConvert, convert yaml mappings to bson.D with string keys and sequences to bson.A
+func yamlConvert(v interface{}) interface{} {
+	switch val := v.(type) {
+	case yaml.MapSlice:
+		d := make(bson.D, len(val))
+		for i, e := range val {
+			d[i] = bson.E{Key: fmt.Sprint(e.Key), Value: yamlConvert(e.Value)}
+		}
+		return d
+	case []interface{}:
+		a := make(bson.A, len(val))
+		for i, e := range val {
+			a[i] = yamlConvert(e)
+		}
+		return a
+	case time.Time:
+		return bson.D{{Key: "$date", Value: val.Format(time.RFC3339Nano)}}
+	}
+	return v
+}
+
+// writeJSON, write json of converted yaml, bson.D in order of keys
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case bson.D:
+		buf.WriteByte('{')
+		for i, e := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(e.Key)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, e.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case bson.A:
+		buf.WriteByte('[')
+		for i, e := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		buf.Write(raw)
+	}
+	return nil
+}
+
+// resolve, replace directives recursive
+func resolve(v interface{}, ids map[string]interface{}, now time.Time) (interface{}, error) {
+	switch val := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(val))
+		for i, e := range val {
+			r, err := resolve(e.Value, ids, now)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = bson.E{Key: e.Key, Value: r}
+		}
+		return out, nil
+	case bson.A:
+		out := make(bson.A, len(val))
+		for i, e := range val {
+			r, err := resolve(e, ids, now)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		return resolveString(val, ids, now)
+	}
+	return v, nil
+}
+
+// resolveString, replace string directive
+func resolveString(s string, ids map[string]interface{}, now time.Time) (interface{}, error) {
+	switch {
+	case !strings.HasPrefix(s, directiveStart):
+		return s, nil
+	case strings.HasPrefix(s, DirectiveEsc):
+		return s[1:], nil
+	case s == DirectiveID:
+		return primitive.NewObjectID(), nil
+	case strings.HasPrefix(s, DirectiveRef):
+		id, ok := ids[strings.TrimPrefix(s, DirectiveRef)]
+		if !ok {
+			return nil, fmt.Errorf("%q, %w", s, ErrUnknownRef)
+		}
+		return id, nil
+	case strings.HasPrefix(s, DirectiveNow):
+		t, err := RelativeDate(strings.TrimPrefix(s, DirectiveNow), now)
+		if err != nil {
+			return nil, fmt.Errorf("%q, %w", s, err)
+		}
+		return primitive.NewDateTimeFromTime(t), nil
+	}
+	return nil, fmt.Errorf("%q, %w", s, ErrDirective)
+}
+
+var relativeExp = regexp.MustCompile(`^([+-]?)(\d+)(ms|s|m|h|d|w|M|y)`)
+
+// RelativeDate, return now with offsets like "-3d", "+1h30m", "-1M+2d",
+// parts without sign use the sign of the previous part
+func RelativeDate(offset string, now time.Time) (time.Time, error) {
+	t := now
+	sign := ""
+	for offset != "" {
+		m := relativeExp.FindStringSubmatch(offset)
+		if m == nil || m[1] == "" && sign == "" {
+			return now, ErrDirective
+		}
+		offset = offset[len(m[0]):]
+		if m[1] != "" {
+			sign = m[1]
+		}
+
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return now, err
+		}
+		if sign == "-" {
+			n = -n
+		}
+
+		switch m[3] {
+		case "ms":
+			t = t.Add(time.Duration(n) * time.Millisecond)
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "w":
+			t = t.AddDate(0, 0, n*7)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		}
+	}
+	return t, nil
+}
+
+// toIndexOptions, convert options of fixture file to index options,
+// keys are named like the options of createIndexes
+func toIndexOptions(m bson.M) (*options.IndexOptions, error) {
+	opts := options.Index()
+	for key, value := range m {
+		ok := true
+		switch key {
+		case "name":
+			var v string
+			if v, ok = value.(string); ok {
+				opts.SetName(v)
+			}
+		case "unique", "sparse", "background", "hidden":
+			var v bool
+			if v, ok = value.(bool); ok {
+				switch key {
+				case "unique":
+					opts.SetUnique(v)
+				case "sparse":
+					opts.SetSparse(v)
+				case "background":
+					opts.SetBackground(v)
+				case "hidden":
+					opts.SetHidden(v)
+				}
+			}
+		case "expireAfterSeconds", "v", "textIndexVersion", "2dsphereIndexVersion", "bits", "bucketSize":
+			var v int32
+			if v, ok = toInt32(value); ok {
+				switch key {
+				case "expireAfterSeconds":
+					opts.SetExpireAfterSeconds(v)
+				case "v":
+					opts.SetVersion(v)
+				case "textIndexVersion":
+					opts.SetTextVersion(v)
+				case "2dsphereIndexVersion":
+					opts.SetSphereVersion(v)
+				case "bits":
+					opts.SetBits(v)
+				case "bucketSize":
+					opts.SetBucketSize(v)
+				}
+			}
+		case "min", "max":
+			var v float64
+			if v, ok = toFloat64(value); ok {
+				if key == "min" {
+					opts.SetMin(v)
+				} else {
+					opts.SetMax(v)
+				}
+			}
+		case "default_language", "language_override":
+			var v string
+			if v, ok = value.(string); ok {
+				if key == "default_language" {
+					opts.SetDefaultLanguage(v)
+				} else {
+					opts.SetLanguageOverride(v)
+				}
+			}
+		case "partialFilterExpression":
+			opts.SetPartialFilterExpression(value)
+		case "weights":
+			opts.SetWeights(value)
+		case "wildcardProjection":
+			opts.SetWildcardProjection(value)
+		case "storageEngine":
+			opts.SetStorageEngine(value)
+		case "collation":
+			var c *options.Collation
+			if c, ok = toCollation(value); ok {
+				opts.SetCollation(c)
+			}
+		default:
+			return nil, fmt.Errorf("%q, %w", key, ErrIndexOption)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: %v, %w", key, value, ErrIndexOption)
+		}
+	}
+	return opts, nil
+}
+
+// toCollation, convert collation document of index options
+func toCollation(value interface{}) (*options.Collation, bool) {
+	m, ok := value.(bson.M)
+	if !ok {
+		return nil, false
+	}
+	c := &options.Collation{}
+	for key, v := range m {
+		switch key {
+		case "locale":
+			c.Locale, ok = v.(string)
+		case "caseLevel":
+			c.CaseLevel, ok = v.(bool)
+		case "caseFirst":
+			c.CaseFirst, ok = v.(string)
+		case "strength":
+			var n int32
+			n, ok = toInt32(v)
+			c.Strength = int(n)
+		case "numericOrdering":
+			c.NumericOrdering, ok = v.(bool)
+		case "alternate":
+			c.Alternate, ok = v.(string)
+		case "maxVariable":
+			c.MaxVariable, ok = v.(string)
+		case "normalization":
+			c.Normalization, ok = v.(bool)
+		case "backwards":
+			c.Backwards, ok = v.(bool)
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return c, true
+}
+
+// toInt32, return integral number as int32
+func toInt32(v interface{}) (int32, bool) {
+	switch n := v.(type) {
+	case int32:
+		return n, true
+	case int64:
+		return int32(n), int64(int32(n)) == n
+	case float64:
+		return int32(n), float64(int32(n)) == n
+	}
+	return 0, false
+}
+
+// toFloat64, return number as float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// lookup, return value of key in doc
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set, set value of key in doc, new keys are prepended
+func set(doc bson.D, key string, value interface{}) bson.D {
+	for i, e := range doc {
+		if e.Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(bson.D{{Key: key, Value: value}}, doc...)
+}
+
+// remove, return doc without key
+func remove(doc bson.D, key string) bson.D {
+	out := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+package lxDbFixtures_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lxDbFixtures "github.com/litixsoft/lxgo/db/fixtures"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testNow = time.Date(2020, 9, 21, 12, 0, 0, 0, time.UTC)
+
+func TestLoader_LoadDir(t *testing.T) {
+	its := assert.New(t)
+
+	target := lxDbFixtures.NewMemoryTarget()
+	loader := lxDbFixtures.NewLoader(target)
+	loader.SetNow(func() time.Time { return testNow })
+
+	res, err := loader.LoadDir("testdata")
+	its.NoError(err)
+
+	// Named ids
+	alice := res.ObjectID("alice")
+	bob := res.ObjectID("bob")
+	its.False(alice.IsZero())
+	its.False(bob.IsZero())
+	its.NotNil(res.ID("order1"))
+	its.Nil(res.ID("unknown"))
+
+	// Customers with resolved directives
+	customers := target.Documents("customers")
+	its.Len(customers, 2)
+	its.Equal(res.InsertedIDs["customers"], []interface{}{alice, bob})
+	its.Equal(alice, customers[0].Map()["_id"])
+	its.Equal(primitive.NewDateTimeFromTime(testNow.AddDate(0, 0, -3)), customers[0].Map()["createdAt"])
+	its.Equal(primitive.NewDateTimeFromTime(testNow.AddDate(0, -1, 2)), customers[1].Map()["createdAt"])
+	its.Equal("@home", customers[1].Map()["note"])
+	_, hasName := customers[0].Map()["@name"]
+	its.False(hasName)
+
+	// Indexes
+	indexes := target.Indexes("customers")
+	its.Len(indexes, 1)
+	its.Equal("email_unique", *indexes[0].Options.Name)
+	its.True(*indexes[0].Options.Unique)
+
+	// Orders with references and extended json
+	orders := target.Documents("orders")
+	its.Len(orders, 2)
+	its.Equal(alice, orders[0].Map()["customerId"])
+	its.Equal(bob, orders[1].Map()["customerId"])
+	its.Equal(primitive.NewDateTimeFromTime(testNow), orders[0].Map()["createdAt"])
+	its.Equal(primitive.NewDateTimeFromTime(time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)), orders[1].Map()["createdAt"])
+	oid, _ := primitive.ObjectIDFromHex("5f6a1b2c3d4e5f6a7b8c9d0e")
+	its.Equal(oid, orders[1].Map()["ticket"])
+	its.Len(target.Indexes("orders"), 1)
+
+	// Plain array with collection from file name
+	tags := target.Documents("tags")
+	its.Len(tags, 2)
+	its.Equal("sale", tags[1].Map()["name"])
+}
+
+func TestLoader_Load(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("unknown reference", func(t *testing.T) {
+		loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMemoryTarget())
+		_, err := loader.Load(&lxDbFixtures.Fixture{
+			Collection: "orders",
+			Documents:  []bson.D{{{Key: "customerId", Value: "@ref:nobody"}}},
+		})
+		its.True(errors.Is(err, lxDbFixtures.ErrUnknownRef))
+	})
+	t.Run("duplicate name", func(t *testing.T) {
+		loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMemoryTarget())
+		_, err := loader.Load(&lxDbFixtures.Fixture{
+			Collection: "users",
+			Documents: []bson.D{
+				{{Key: "@name", Value: "alice"}},
+				{{Key: "@name", Value: "alice"}},
+			},
+		})
+		its.True(errors.Is(err, lxDbFixtures.ErrDuplicateRef))
+	})
+	t.Run("invalid directive", func(t *testing.T) {
+		loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMemoryTarget())
+		_, err := loader.Load(&lxDbFixtures.Fixture{
+			Collection: "users",
+			Documents:  []bson.D{{{Key: "createdAt", Value: "@yesterday"}}},
+		})
+		its.True(errors.Is(err, lxDbFixtures.ErrDirective))
+	})
+	t.Run("invalid mode", func(t *testing.T) {
+		loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMemoryTarget())
+		_, err := loader.Load(&lxDbFixtures.Fixture{Collection: "users", Mode: "append"})
+		its.True(errors.Is(err, lxDbFixtures.ErrMode))
+	})
+	t.Run("fixture not changed", func(t *testing.T) {
+		loader := lxDbFixtures.NewLoader(lxDbFixtures.NewMemoryTarget())
+		f := &lxDbFixtures.Fixture{
+			Collection: "users",
+			Documents:  []bson.D{{{Key: "@name", Value: "alice"}, {Key: "createdAt", Value: "@now"}}},
+		}
+		_, err := loader.Load(f)
+		its.NoError(err)
+		its.Equal([]bson.D{{{Key: "@name", Value: "alice"}, {Key: "createdAt", Value: "@now"}}}, f.Documents)
+	})
+	t.Run("index options", func(t *testing.T) {
+		target := lxDbFixtures.NewMemoryTarget()
+		loader := lxDbFixtures.NewLoader(target)
+		_, err := loader.Load(&lxDbFixtures.Fixture{
+			Collection: "sessions",
+			Indexes: []lxDbFixtures.Index{{
+				Keys: bson.D{{Key: "createdAt", Value: 1}},
+				Options: bson.M{
+					"expireAfterSeconds":      int32(3600),
+					"partialFilterExpression": bson.M{"active": true},
+					"collation":               bson.M{"locale": "de", "strength": int32(2)},
+				},
+			}},
+		})
+		its.NoError(err)
+		opts := target.Indexes("sessions")[0].Options
+		its.Equal(int32(3600), *opts.ExpireAfterSeconds)
+		its.Equal(bson.M{"active": true}, opts.PartialFilterExpression)
+		its.Equal("de", opts.Collation.Locale)
+		its.Equal(2, opts.Collation.Strength)
+
+		_, err = loader.Load(&lxDbFixtures.Fixture{
+			Collection: "sessions",
+			Indexes:    []lxDbFixtures.Index{{Keys: bson.D{{Key: "a", Value: 1}}, Options: bson.M{"expireAfter": int32(1)}}},
+		})
+		its.True(errors.Is(err, lxDbFixtures.ErrIndexOption))
+		_, err = loader.Load(&lxDbFixtures.Fixture{
+			Collection: "sessions",
+			Indexes:    []lxDbFixtures.Index{{Keys: bson.D{{Key: "a", Value: 1}}, Options: bson.M{"unique": "yes"}}},
+		})
+		its.True(errors.Is(err, lxDbFixtures.ErrIndexOption))
+	})
+	t.Run("truncate before insert", func(t *testing.T) {
+		target := lxDbFixtures.NewMemoryTarget()
+		loader := lxDbFixtures.NewLoader(target)
+		f := func() *lxDbFixtures.Fixture {
+			return &lxDbFixtures.Fixture{
+				Collection: "users",
+				Documents:  []bson.D{{{Key: "name", Value: "Alice"}}},
+			}
+		}
+
+		_, err := loader.Load(f())
+		its.NoError(err)
+		res, err := loader.Load(f())
+		its.NoError(err)
+		its.Len(target.Documents("users"), 1)
+		its.Len(res.InsertedIDs["users"], 1)
+	})
+}
+
+func TestParse(t *testing.T) {
+	its := assert.New(t)
+
+	f, err := lxDbFixtures.Parse([]byte(`{"documents": [{"a": 1}]}`))
+	its.True(errors.Is(err, lxDbFixtures.ErrCollection))
+	its.Nil(f)
+
+	_, err = lxDbFixtures.Parse([]byte(`"users"`))
+	its.True(errors.Is(err, lxDbFixtures.ErrFormat))
+
+	f, err = lxDbFixtures.Parse([]byte(`[{"a": 1}, {"a": {"$numberLong": "2"}}]`))
+	its.NoError(err)
+	its.Len(f.Documents, 2)
+	its.Equal(int64(2), f.Documents[1].Map()["a"])
+
+	_, err = lxDbFixtures.ParseFile("testdata/not_exists.json")
+	its.Error(err)
+}
+
+func TestParseFile_YAML(t *testing.T) {
+	its := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "lxdb-fixtures")
+	its.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "orders.yaml")
+	its.NoError(ioutil.WriteFile(file, []byte(`collection: orders
+indexes:
+  - keys: {customerId: 1, createdAt: -1}
+    options: {name: customer_created, expireAfterSeconds: 60}
+documents:
+  - zip: "12345"
+    name: Order
+    address: {street: Main Street, city: Berlin}
+    ticket: {$oid: 5f6a1b2c3d4e5f6a7b8c9d0e}
+`), 0644))
+
+	f, err := lxDbFixtures.ParseFile(file)
+	its.NoError(err)
+
+	// Order of keys is kept
+	its.Equal(bson.D{{Key: "customerId", Value: int32(1)}, {Key: "createdAt", Value: int32(-1)}}, f.Indexes[0].Keys)
+	its.Equal([]string{"zip", "name", "address", "ticket"}, keys(f.Documents[0]))
+	its.Equal(bson.D{{Key: "street", Value: "Main Street"}, {Key: "city", Value: "Berlin"}}, f.Documents[0].Map()["address"])
+	oid, _ := primitive.ObjectIDFromHex("5f6a1b2c3d4e5f6a7b8c9d0e")
+	its.Equal(oid, f.Documents[0].Map()["ticket"])
+
+	// Plain array
+	file = filepath.Join(dir, "tags.yml")
+	its.NoError(ioutil.WriteFile(file, []byte("- {name: new, color: red}\n- {name: sale}\n"), 0644))
+	f, err = lxDbFixtures.ParseFile(file)
+	its.NoError(err)
+	its.Equal("tags", f.Collection)
+	its.Equal([]string{"name", "color"}, keys(f.Documents[0]))
+}
+
+// keys, return keys of doc in order
+func keys(doc bson.D) []string {
+	out := make([]string, len(doc))
+	for i, e := range doc {
+		out[i] = e.Key
+	}
+	return out
+}
+
+func TestRelativeDate(t *testing.T) {
+	its := assert.New(t)
+
+	tests := map[string]time.Time{
+		"":         testNow,
+		"-3d":      testNow.AddDate(0, 0, -3),
+		"+1h30m":   testNow.Add(time.Hour + time.Minute*30),
+		"-1M+2d":   testNow.AddDate(0, -1, 2),
+		"+1y-1w":   testNow.AddDate(1, 0, -7),
+		"-500ms":   testNow.Add(-time.Millisecond * 500),
+		"+10s-10s": testNow,
+	}
+	for offset, expected := range tests {
+		chk, err := lxDbFixtures.RelativeDate(offset, testNow)
+		its.NoError(err, offset)
+		its.Equal(expected, chk, offset)
+	}
+
+	_, err := lxDbFixtures.RelativeDate("-3x", testNow)
+	its.Error(err)
+	_, err = lxDbFixtures.RelativeDate("3d", testNow)
+	its.Error(err)
+}
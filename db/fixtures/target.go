@@ -0,0 +1,227 @@
+package lxDbFixtures
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ITarget, destination of fixtures
+type ITarget interface {
+	Truncate(collection string) error
+	Recreate(collection string) error
+	EnsureIndexes(collection string, indexes []mongo.IndexModel) error
+	InsertMany(collection string, docs []interface{}) ([]interface{}, error)
+}
+
+// mongoTarget, loads fixtures into a mongo database
+type mongoTarget struct {
+	db      *mongo.Database
+	timeout time.Duration
+}
+
+// NewMongoTarget, return target for mongo database
+func NewMongoTarget(db *mongo.Database, timeout ...time.Duration) ITarget {
+	to := lxDb.DefaultTimeout
+	if len(timeout) > 0 {
+		to = timeout[0]
+	}
+
+	return &mongoTarget{db: db, timeout: to}
+}
+
+// Truncate, delete all documents of collection
+func (t *mongoTarget) Truncate(collection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	_, err := t.db.Collection(collection).DeleteMany(ctx, bson.D{})
+	return err
+}
+
+// Recreate, drop collection with indexes and create it
+func (t *mongoTarget) Recreate(collection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	if err := t.db.Collection(collection).Drop(ctx); err != nil {
+		return err
+	}
+
+	return t.db.RunCommand(ctx, bson.D{{Key: "create", Value: collection}}).Err()
+}
+
+// EnsureIndexes, create indexes
+func (t *mongoTarget) EnsureIndexes(collection string, indexes []mongo.IndexModel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	_, err := t.db.Collection(collection).Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// InsertMany, insert documents
+func (t *mongoTarget) InsertMany(collection string, docs []interface{}) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	res, err := t.db.Collection(collection).InsertMany(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.InsertedIDs, nil
+}
+
+// repoTarget, loads fixtures with base repositories,
+// works with each IBaseRepo implementation like mocks or in-memory repos
+type repoTarget struct {
+	repos map[string]lxDb.IBaseRepo
+}
+
+// NewRepoTarget, return target for repositories by collection name
+func NewRepoTarget(repos map[string]lxDb.IBaseRepo) ITarget {
+	return &repoTarget{repos: repos}
+}
+
+// repo, return repo for collection
+func (t *repoTarget) repo(collection string) (lxDb.IBaseRepo, error) {
+	repo, ok := t.repos[collection]
+	if !ok {
+		return nil, fmt.Errorf("no repository for collection %q", collection)
+	}
+	return repo, nil
+}
+
+// Truncate, delete all documents of collection
+func (t *repoTarget) Truncate(collection string) error {
+	repo, err := t.repo(collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DeleteMany(bson.D{})
+	return err
+}
+
+// Recreate, repositories can't drop collections, delete all documents
+func (t *repoTarget) Recreate(collection string) error {
+	return t.Truncate(collection)
+}
+
+// EnsureIndexes, create indexes
+func (t *repoTarget) EnsureIndexes(collection string, indexes []mongo.IndexModel) error {
+	repo, err := t.repo(collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.CreateIndexes(indexes)
+	return err
+}
+
+// InsertMany, insert documents
+func (t *repoTarget) InsertMany(collection string, docs []interface{}) ([]interface{}, error) {
+	repo, err := t.repo(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := repo.InsertMany(docs)
+	if err != nil {
+		return nil, err
+	}
+	if res.FailedCount > 0 {
+		return res.InsertedIDs, fmt.Errorf("%d documents not inserted in %q", res.FailedCount, collection)
+	}
+
+	return res.InsertedIDs, nil
+}
+
+// MemoryTarget, holds fixtures in memory
+type MemoryTarget struct {
+	mux         sync.RWMutex
+	collections map[string][]bson.D
+	indexes     map[string][]mongo.IndexModel
+}
+
+// NewMemoryTarget, return empty in-memory target
+func NewMemoryTarget() *MemoryTarget {
+	return &MemoryTarget{
+		collections: make(map[string][]bson.D),
+		indexes:     make(map[string][]mongo.IndexModel),
+	}
+}
+
+// Truncate, delete all documents of collection
+func (t *MemoryTarget) Truncate(collection string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.collections[collection] = nil
+	return nil
+}
+
+// Recreate, delete all documents and indexes of collection
+func (t *MemoryTarget) Recreate(collection string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.collections[collection] = nil
+	t.indexes[collection] = nil
+	return nil
+}
+
+// EnsureIndexes, save indexes
+func (t *MemoryTarget) EnsureIndexes(collection string, indexes []mongo.IndexModel) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.indexes[collection] = append(t.indexes[collection], indexes...)
+	return nil
+}
+
+// InsertMany, save documents, missing _id values are generated
+func (t *MemoryTarget) InsertMany(collection string, docs []interface{}) ([]interface{}, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	ids := make([]interface{}, len(docs))
+	for i, d := range docs {
+		doc, err := lxDb.ToBsonDoc(d)
+		if err != nil {
+			return nil, err
+		}
+		id, ok := lookup(*doc, "_id")
+		if !ok {
+			id = primitive.NewObjectID()
+			*doc = set(*doc, "_id", id)
+		}
+		ids[i] = id
+		t.collections[collection] = append(t.collections[collection], *doc)
+	}
+
+	return ids, nil
+}
+
+// Documents, return documents of collection
+func (t *MemoryTarget) Documents(collection string) []bson.D {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return t.collections[collection]
+}
+
+// Indexes, return indexes of collection
+func (t *MemoryTarget) Indexes(collection string) []mongo.IndexModel {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return t.indexes[collection]
+}
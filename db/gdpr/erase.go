@@ -0,0 +1,211 @@
+package lxDbGdpr
+
+import (
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RuleReport, erase result of one rule
+type RuleReport struct {
+	Rule       string
+	Collection string
+	Action     string
+	Matched    int64
+	Affected   int64
+	// Remaining, documents with personal data after erase
+	Remaining int64
+	Err       error
+}
+
+// Report, verification report of Erase
+type Report struct {
+	SubjectID   interface{}
+	Transaction bool
+	Verified    bool
+	Rules       []*RuleReport
+	Duration    time.Duration
+}
+
+// Erase, apply erase rules of all repos for subject.
+// When Options.Client is set and all repos are collections of the client,
+// the rules are applied in one transaction, otherwise rule by rule.
+// After erase every rule is verified, Report.Verified is true when no personal data remains.
+// Example:
+// report, err := registry.Erase(customerID)
+func (r *Registry) Erase(subjectID interface{}) (*Report, error) {
+	start := r.opts.Now()
+	rules := r.Rules()
+	report := &Report{SubjectID: subjectID}
+
+	var entries []bson.M
+	var ids [][]interface{}
+
+	// Transaction
+	var err error
+	if collections, ok := transactionCollections(r.opts.Client, rules); ok {
+		err = withTransaction(r.opts.Client, r.opts.Timeout, func(sc mongo.SessionContext) error {
+			// Reset on retry of transaction
+			report.Rules, entries, ids = nil, nil, nil
+			for i, rule := range rules {
+				ruleReport, ruleIDs, ruleEntries := r.eraseRule(&txExecutor{ctx: sc, collection: collections[i]}, rule, subjectID)
+				report.Rules = append(report.Rules, ruleReport)
+				if ruleReport.Err != nil {
+					return ruleReport.Err
+				}
+				ids = append(ids, ruleIDs)
+				entries = append(entries, ruleEntries...)
+			}
+			return nil
+		})
+		if err == nil {
+			report.Transaction = true
+		} else if !transactionsUnsupported(err) {
+			return report, err
+		}
+	}
+
+	// Without transaction, errors of rules are reported
+	if !report.Transaction {
+		err = nil
+		report.Rules, entries, ids = nil, nil, nil
+		for _, rule := range rules {
+			ruleReport, ruleIDs, ruleEntries := r.eraseRule(&repoExecutor{repo: rule.Repo, timeout: r.opts.Timeout}, rule, subjectID)
+			report.Rules = append(report.Rules, ruleReport)
+			ids = append(ids, ruleIDs)
+			entries = append(entries, ruleEntries...)
+			if ruleReport.Err != nil && err == nil {
+				err = ruleReport.Err
+			}
+		}
+	}
+
+	r.sendAudit(entries)
+
+	// Verify
+	report.Verified = err == nil
+	for i, rule := range rules {
+		ruleReport := report.Rules[i]
+		if ruleReport.Err != nil || (len(ids[i]) == 0 && rule.Action != ActionDelete) {
+			continue
+		}
+		exec := &repoExecutor{repo: rule.Repo, timeout: r.opts.Timeout}
+		if ruleReport.Remaining, ruleReport.Err = exec.count(rule.remainingFilter(subjectID, ids[i])); ruleReport.Err != nil && err == nil {
+			err = ruleReport.Err
+		}
+		if ruleReport.Remaining > 0 || ruleReport.Err != nil {
+			report.Verified = false
+		}
+	}
+
+	report.Duration = r.opts.Now().Sub(start)
+	return report, err
+}
+
+// eraseRule, apply rule, return report, erased ids and audit entries
+func (r *Registry) eraseRule(exec executor, rule *Rule, subjectID interface{}) (*RuleReport, []interface{}, []bson.M) {
+	report := &RuleReport{
+		Rule:       rule.Name,
+		Collection: collectionName(rule.Repo),
+		Action:     rule.Action,
+	}
+
+	// Find documents of subject
+	projection := bson.D{{Key: "_id", Value: 1}}
+	for _, field := range rule.Pseudonymize {
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+	docs, err := exec.find(rule.subjectFilter(subjectID), projection)
+	if err != nil {
+		report.Err = err
+		return report, nil, nil
+	}
+	report.Matched = int64(len(docs))
+	if len(docs) == 0 {
+		return report, nil, nil
+	}
+
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc["_id"]
+	}
+	byIDs := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}
+
+	var fields []string
+	switch rule.Action {
+	case ActionDelete:
+		report.Affected, report.Err = exec.deleteMany(byIDs)
+	case ActionAnonymize:
+		fields = sortedKeys(rule.Anonymize)
+		report.Affected, report.Err = exec.updateMany(byIDs, rule.anonymizeUpdate())
+	case ActionPseudonymize:
+		fields = rule.Pseudonymize
+		for _, doc := range docs {
+			set := bson.D{}
+			for _, field := range rule.Pseudonymize {
+				value, ok := lookupField(doc, field)
+				if !ok || value == nil {
+					continue
+				}
+				if s, ok := value.(string); ok && strings.HasPrefix(s, PseudonymPrefix) {
+					continue
+				}
+				set = append(set, bson.E{Key: field, Value: r.Pseudonym(value)})
+			}
+			if len(set) == 0 {
+				continue
+			}
+
+			n, err := exec.updateOne(bson.D{{Key: "_id", Value: doc["_id"]}}, bson.D{{Key: "$set", Value: set}})
+			if err != nil {
+				report.Err = err
+				break
+			}
+			report.Affected += n
+		}
+	}
+	if report.Err != nil {
+		return report, ids, nil
+	}
+
+	// Audit entries with ids only
+	entries := make([]bson.M, 0, len(ids))
+	for _, id := range ids {
+		data := bson.M{
+			"_id":     id,
+			"rule":    rule.Name,
+			"erasure": rule.Action,
+			"subject": r.auditSubject(subjectID),
+		}
+		if len(fields) > 0 {
+			data["fields"] = fields
+		}
+
+		entries = append(entries, bson.M{
+			"collection": report.Collection,
+			"action":     lxDb.Purge,
+			"user":       r.opts.SystemUser,
+			"data":       data})
+	}
+
+	return report, ids, entries
+}
+
+// auditSubject, return pseudonym of subject when key exists
+func (r *Registry) auditSubject(subjectID interface{}) interface{} {
+	if len(r.opts.PseudonymKey) > 0 {
+		return r.Pseudonym(subjectID)
+	}
+	return subjectID
+}
+
+// sendAudit, send entries to audit
+func (r *Registry) sendAudit(entries []bson.M) {
+	if len(entries) == 0 || r.opts.Audit == nil || !r.opts.Audit.IsActive() {
+		return
+	}
+	r.opts.Audit.Send(entries)
+}
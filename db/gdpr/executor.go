@@ -0,0 +1,162 @@
+package lxDbGdpr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// executor, db operations of erase, with or without transaction
+type executor interface {
+	find(filter, projection interface{}) ([]bson.M, error)
+	count(filter interface{}) (int64, error)
+	deleteMany(filter interface{}) (int64, error)
+	updateMany(filter, update interface{}) (int64, error)
+	updateOne(filter, update interface{}) (int64, error)
+}
+
+// repoExecutor, executes with IBaseRepo, without transaction
+type repoExecutor struct {
+	repo    lxDb.IBaseRepo
+	timeout time.Duration
+}
+
+func (e *repoExecutor) find(filter, projection interface{}) ([]bson.M, error) {
+	opts := options.Find()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	var docs []bson.M
+	err := e.repo.Find(filter, &docs, opts, e.timeout)
+	return docs, err
+}
+
+func (e *repoExecutor) count(filter interface{}) (int64, error) {
+	return e.repo.CountDocuments(filter, e.timeout)
+}
+
+func (e *repoExecutor) deleteMany(filter interface{}) (int64, error) {
+	res, err := e.repo.DeleteMany(filter, e.timeout)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (e *repoExecutor) updateMany(filter, update interface{}) (int64, error) {
+	res, err := e.repo.UpdateMany(filter, update, e.timeout)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (e *repoExecutor) updateOne(filter, update interface{}) (int64, error) {
+	if err := e.repo.UpdateOne(filter, update, e.timeout); err != nil {
+		if errors.Is(err, lxDb.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return 1, nil
+}
+
+// txExecutor, executes in session of transaction
+type txExecutor struct {
+	ctx        mongo.SessionContext
+	collection *mongo.Collection
+}
+
+func (e *txExecutor) find(filter, projection interface{}) ([]bson.M, error) {
+	opts := options.Find()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cur, err := e.collection.Find(e.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.M
+	err = cur.All(e.ctx, &docs)
+	return docs, err
+}
+
+func (e *txExecutor) count(filter interface{}) (int64, error) {
+	return e.collection.CountDocuments(e.ctx, filter)
+}
+
+func (e *txExecutor) deleteMany(filter interface{}) (int64, error) {
+	res, err := e.collection.DeleteMany(e.ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (e *txExecutor) updateMany(filter, update interface{}) (int64, error) {
+	res, err := e.collection.UpdateMany(e.ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (e *txExecutor) updateOne(filter, update interface{}) (int64, error) {
+	res, err := e.collection.UpdateOne(e.ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// transactionCollections, return collections of rules when all are mongo collections of client
+func transactionCollections(client *mongo.Client, rules []*Rule) ([]*mongo.Collection, bool) {
+	if client == nil {
+		return nil, false
+	}
+
+	collections := make([]*mongo.Collection, len(rules))
+	for i, rule := range rules {
+		collection, ok := rule.Repo.GetCollection().(*mongo.Collection)
+		if !ok || collection == nil || collection.Database().Client() != client {
+			return nil, false
+		}
+		collections[i] = collection
+	}
+	return collections, true
+}
+
+// transactionsUnsupported, return true when server has no transactions (standalone)
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 20 {
+		return true
+	}
+	return strings.Contains(err.Error(), "Transaction numbers are only allowed")
+}
+
+// withTransaction, run fn in transaction
+func withTransaction(client *mongo.Client, timeout time.Duration, fn func(ctx mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
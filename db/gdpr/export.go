@@ -0,0 +1,81 @@
+package lxDbGdpr
+
+import (
+	"sort"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bundle, personal data of a subject by rule name
+type Bundle struct {
+	SubjectID interface{}
+	CreatedAt time.Time
+	Data      map[string][]bson.M
+}
+
+// JSON, return bundle as relaxed extended json, rules are sorted by name
+func (b *Bundle) JSON() ([]byte, error) {
+	names := make([]string, 0, len(b.Data))
+	for name := range b.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := bson.D{}
+	for _, name := range names {
+		docs := b.Data[name]
+		if docs == nil {
+			docs = []bson.M{}
+		}
+		data = append(data, bson.E{Key: name, Value: docs})
+	}
+
+	return bson.MarshalExtJSON(bson.D{
+		{Key: "subjectId", Value: b.SubjectID},
+		{Key: "createdAt", Value: b.CreatedAt},
+		{Key: "data", Value: data},
+	}, false, false)
+}
+
+// Export, return personal data of subject from all repos
+// Example:
+// bundle, err := registry.Export(customerID)
+// data, err := bundle.JSON()
+func (r *Registry) Export(subjectID interface{}) (*Bundle, error) {
+	bundle := &Bundle{
+		SubjectID: subjectID,
+		CreatedAt: r.opts.Now().UTC(),
+		Data:      make(map[string][]bson.M),
+	}
+
+	var entries []bson.M
+	for _, rule := range r.Rules() {
+		opts := options.Find()
+		if rule.Projection != nil {
+			opts.SetProjection(rule.Projection)
+		}
+
+		var docs []bson.M
+		if err := rule.Repo.Find(rule.subjectFilter(subjectID), &docs, opts, r.opts.Timeout); err != nil {
+			return nil, err
+		}
+		bundle.Data[rule.Name] = docs
+
+		entries = append(entries, bson.M{
+			"collection": collectionName(rule.Repo),
+			"action":     lxDb.Read,
+			"user":       r.opts.SystemUser,
+			"data": bson.M{
+				"rule":    rule.Name,
+				"export":  true,
+				"subject": r.auditSubject(subjectID),
+				"count":   int64(len(docs)),
+			}})
+	}
+
+	r.sendAudit(entries)
+	return bundle, nil
+}
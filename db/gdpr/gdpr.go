@@ -0,0 +1,287 @@
+// Package lxDbGdpr locates, exports and erases personal data of a subject
+// across repositories. Each repo registers a rule with the fields or filter
+// of the subject and the erasure action.
+package lxDbGdpr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// Erasure actions
+	ActionDelete       = "delete"
+	ActionAnonymize    = "anonymize"
+	ActionPseudonymize = "pseudonymize"
+
+	// PseudonymPrefix, prefix of pseudonymized values
+	PseudonymPrefix = "pseudo:"
+)
+
+// Errors
+var (
+	ErrRule         = errors.New("invalid gdpr rule")
+	ErrRuleExists   = errors.New("gdpr rule already exists")
+	ErrPseudonymKey = errors.New("pseudonymize requires a key")
+)
+
+// DefaultSystemUser, user of audit entries when Options.SystemUser is nil
+var DefaultSystemUser = bson.M{"name": "system", "id": "gdpr"}
+
+// Rule, describes personal data of a subject in one repo
+type Rule struct {
+	// Name, unique name of rule, key of export bundle
+	Name string
+	// Repo with personal data
+	Repo lxDb.IBaseRepo
+	// Fields, paths which contain the subject id, matched with $or
+	Fields []string
+	// Filter, return filter of subject, overrides Fields
+	Filter func(subjectID interface{}) interface{}
+	// Action ActionDelete, ActionAnonymize or ActionPseudonymize
+	Action string
+	// Anonymize, fields with replacement values, nil removes the field
+	Anonymize map[string]interface{}
+	// Pseudonymize, fields replaced with keyed hash of value
+	Pseudonymize []string
+	// Projection of export, optional
+	Projection interface{}
+}
+
+// Options, options for Registry
+type Options struct {
+	// Audit for erase and export entries, optional
+	Audit lxDb.IBaseRepoAudit
+	// SystemUser of audit entries, default DefaultSystemUser
+	SystemUser interface{}
+	// PseudonymKey, hmac key of pseudonyms, required for ActionPseudonymize
+	PseudonymKey []byte
+	// Client, erase in one transaction when all repos are mongo collections
+	// of this client and the server supports transactions
+	Client *mongo.Client
+	// Timeout of each db operation, default lxDb.DefaultTimeout
+	Timeout time.Duration
+	// Now, default time.Now
+	Now func() time.Time
+}
+
+// Registry, rules of all repos with personal data
+type Registry struct {
+	opts  Options
+	mux   sync.RWMutex
+	rules []*Rule
+}
+
+// NewRegistry, return registry
+// Example:
+// registry := lxDbGdpr.NewRegistry(lxDbGdpr.Options{Audit: audit, PseudonymKey: key, Client: client})
+// err := registry.Register(&lxDbGdpr.Rule{Name: "users", Repo: userRepo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete})
+// err = registry.Register(&lxDbGdpr.Rule{Name: "orders", Repo: orderRepo, Fields: []string{"customerId"},
+// Action: lxDbGdpr.ActionPseudonymize, Pseudonymize: []string{"customerId", "address.street"}})
+func NewRegistry(opts Options) *Registry {
+	if opts.Timeout <= 0 {
+		opts.Timeout = lxDb.DefaultTimeout
+	}
+	if opts.SystemUser == nil {
+		opts.SystemUser = DefaultSystemUser
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Registry{opts: opts}
+}
+
+// Register, validate and add rule
+func (r *Registry) Register(rule *Rule) error {
+	if err := r.validate(rule); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, existing := range r.rules {
+		if existing.Name == rule.Name {
+			return fmt.Errorf("%s: %w", rule.Name, ErrRuleExists)
+		}
+	}
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+// Rules, return registered rules
+func (r *Registry) Rules() []*Rule {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return append([]*Rule{}, r.rules...)
+}
+
+// Pseudonym, return keyed hash of value
+func (r *Registry) Pseudonym(value interface{}) string {
+	mac := hmac.New(sha256.New, r.opts.PseudonymKey)
+	if s, ok := value.(string); ok {
+		mac.Write([]byte(s))
+	} else if raw, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, true, false); err == nil {
+		mac.Write(raw)
+	} else {
+		mac.Write([]byte(fmt.Sprint(value)))
+	}
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validate, check rule
+func (r *Registry) validate(rule *Rule) error {
+	switch {
+	case rule.Name == "":
+		return fmt.Errorf("name is required, %w", ErrRule)
+	case rule.Repo == nil:
+		return fmt.Errorf("%s: repo is required, %w", rule.Name, ErrRule)
+	case rule.Filter == nil && len(rule.Fields) == 0:
+		return fmt.Errorf("%s: fields or filter is required, %w", rule.Name, ErrRule)
+	}
+
+	switch rule.Action {
+	case ActionDelete:
+	case ActionAnonymize:
+		if len(rule.Anonymize) == 0 {
+			return fmt.Errorf("%s: anonymize requires fields, %w", rule.Name, ErrRule)
+		}
+		if _, ok := rule.Anonymize["_id"]; ok {
+			return fmt.Errorf("%s: _id can't be anonymized, %w", rule.Name, ErrRule)
+		}
+	case ActionPseudonymize:
+		if len(rule.Pseudonymize) == 0 {
+			return fmt.Errorf("%s: pseudonymize requires fields, %w", rule.Name, ErrRule)
+		}
+		for _, field := range rule.Pseudonymize {
+			if field == "_id" {
+				return fmt.Errorf("%s: _id can't be pseudonymized, %w", rule.Name, ErrRule)
+			}
+		}
+		if len(r.opts.PseudonymKey) == 0 {
+			return fmt.Errorf("%s: %w", rule.Name, ErrPseudonymKey)
+		}
+	default:
+		return fmt.Errorf("%s: unknown action %q, %w", rule.Name, rule.Action, ErrRule)
+	}
+	return nil
+}
+
+// subjectFilter, return filter of subject in rule
+func (rule *Rule) subjectFilter(subjectID interface{}) interface{} {
+	if rule.Filter != nil {
+		return rule.Filter(subjectID)
+	}
+	if len(rule.Fields) == 1 {
+		return bson.D{{Key: rule.Fields[0], Value: subjectID}}
+	}
+
+	or := bson.A{}
+	for _, field := range rule.Fields {
+		or = append(or, bson.D{{Key: field, Value: subjectID}})
+	}
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+// anonymizeUpdate, return update of ActionAnonymize
+func (rule *Rule) anonymizeUpdate() bson.D {
+	set, unset := bson.D{}, bson.D{}
+	for _, field := range sortedKeys(rule.Anonymize) {
+		if value := rule.Anonymize[field]; value == nil {
+			unset = append(unset, bson.E{Key: field, Value: ""})
+		} else {
+			set = append(set, bson.E{Key: field, Value: value})
+		}
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// remainingFilter, return filter of documents of ids which are not erased
+func (rule *Rule) remainingFilter(subjectID interface{}, ids []interface{}) interface{} {
+	if rule.Action == ActionDelete {
+		return rule.subjectFilter(subjectID)
+	}
+
+	or := bson.A{}
+	if rule.Action == ActionAnonymize {
+		for _, field := range sortedKeys(rule.Anonymize) {
+			if value := rule.Anonymize[field]; value == nil {
+				or = append(or, bson.D{{Key: field, Value: bson.D{{Key: "$exists", Value: true}}}})
+			} else {
+				or = append(or, bson.D{{Key: field, Value: bson.D{{Key: "$ne", Value: value}}}})
+			}
+		}
+	} else {
+		for _, field := range rule.Pseudonymize {
+			or = append(or, bson.D{{Key: field, Value: bson.D{
+				{Key: "$exists", Value: true},
+				{Key: "$ne", Value: nil},
+				{Key: "$not", Value: primitive.Regex{Pattern: "^" + PseudonymPrefix}},
+			}}})
+		}
+	}
+
+	return bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}},
+		{Key: "$or", Value: or},
+	}
+}
+
+// lookupField, return value of dotted path in document
+func lookupField(doc bson.M, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	var current interface{} = doc
+	for _, part := range parts {
+		var m bson.M
+		switch val := current.(type) {
+		case bson.M:
+			m = val
+		case bson.D:
+			m = val.Map()
+		default:
+			return nil, false
+		}
+
+		var ok bool
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// sortedKeys, return sorted keys of map
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// collectionName, return collection of repo name "db/collection"
+func collectionName(repo lxDb.IBaseRepo) string {
+	name := repo.GetRepoName()
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,193 @@
+package lxDbGdpr_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxDbGdpr "github.com/litixsoft/lxgo/db/gdpr"
+	lxDbMocks "github.com/litixsoft/lxgo/db/mocks"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	testKey = []byte("secret")
+	testNow = time.Date(2020, 9, 21, 12, 0, 0, 0, time.UTC)
+)
+
+// findDocs, mock Find result
+func findDocs(docs ...bson.M) func(filter interface{}, result interface{}, args ...interface{}) error {
+	return func(filter interface{}, result interface{}, args ...interface{}) error {
+		*result.(*[]bson.M) = docs
+		return nil
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+	registry := lxDbGdpr.NewRegistry(lxDbGdpr.Options{})
+	its.NoError(registry.Register(&lxDbGdpr.Rule{Name: "users", Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete}))
+	its.True(errors.Is(registry.Register(&lxDbGdpr.Rule{Name: "users", Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete}), lxDbGdpr.ErrRuleExists))
+
+	invalid := []*lxDbGdpr.Rule{
+		{Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete},
+		{Name: "a", Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete},
+		{Name: "a", Repo: repo, Action: lxDbGdpr.ActionDelete},
+		{Name: "a", Repo: repo, Fields: []string{"_id"}, Action: "shred"},
+		{Name: "a", Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionAnonymize},
+		{Name: "a", Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionPseudonymize, Pseudonymize: []string{"_id"}},
+	}
+	for i, rule := range invalid {
+		its.True(errors.Is(registry.Register(rule), lxDbGdpr.ErrRule), i)
+	}
+
+	err := registry.Register(&lxDbGdpr.Rule{Name: "a", Repo: repo, Fields: []string{"_id"}, Action: lxDbGdpr.ActionPseudonymize, Pseudonymize: []string{"name"}})
+	its.True(errors.Is(err, lxDbGdpr.ErrPseudonymKey))
+	its.Len(registry.Rules(), 1)
+}
+
+func TestRegistry_Pseudonym(t *testing.T) {
+	its := assert.New(t)
+
+	registry := lxDbGdpr.NewRegistry(lxDbGdpr.Options{PseudonymKey: testKey})
+	other := lxDbGdpr.NewRegistry(lxDbGdpr.Options{PseudonymKey: []byte("other")})
+
+	p := registry.Pseudonym("alice@example.com")
+	its.True(strings.HasPrefix(p, lxDbGdpr.PseudonymPrefix))
+	its.Equal(p, registry.Pseudonym("alice@example.com"))
+	its.NotEqual(p, other.Pseudonym("alice@example.com"))
+	its.NotEqual(registry.Pseudonym(int32(1)), registry.Pseudonym("1"))
+}
+
+func TestRegistry_Export(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	users := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+	orders := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+	audit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+	registry := lxDbGdpr.NewRegistry(lxDbGdpr.Options{Audit: audit, Now: func() time.Time { return testNow }})
+	its.NoError(registry.Register(&lxDbGdpr.Rule{Name: "users", Repo: users, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete}))
+	its.NoError(registry.Register(&lxDbGdpr.Rule{
+		Name:      "orders",
+		Repo:      orders,
+		Fields:    []string{"customerId", "recipientId"},
+		Action:    lxDbGdpr.ActionAnonymize,
+		Anonymize: map[string]interface{}{"address": nil},
+	}))
+
+	users.EXPECT().Find(bson.D{{Key: "_id", Value: "c1"}}, gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(findDocs(bson.M{"_id": "c1", "name": "Alice"}))
+	orders.EXPECT().Find(bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "customerId", Value: "c1"}},
+		bson.D{{Key: "recipientId", Value: "c1"}},
+	}}}, gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(findDocs())
+	users.EXPECT().GetRepoName().Return("test/users")
+	orders.EXPECT().GetRepoName().Return("test/orders")
+	audit.EXPECT().IsActive().Return(true)
+	audit.EXPECT().Send(gomock.Len(2))
+
+	bundle, err := registry.Export("c1")
+	its.NoError(err)
+
+	data, err := bundle.JSON()
+	its.NoError(err)
+	its.JSONEq(`{"subjectId":"c1","createdAt":{"$date":"2020-09-21T12:00:00Z"},"data":{"orders":[],"users":[{"_id":"c1","name":"Alice"}]}}`, string(data))
+}
+
+func TestRegistry_Erase(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	users := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+	orders := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+	notes := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+	audit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+	registry := lxDbGdpr.NewRegistry(lxDbGdpr.Options{Audit: audit, PseudonymKey: testKey})
+	its.NoError(registry.Register(&lxDbGdpr.Rule{Name: "users", Repo: users, Fields: []string{"_id"}, Action: lxDbGdpr.ActionDelete}))
+	its.NoError(registry.Register(&lxDbGdpr.Rule{
+		Name:         "orders",
+		Repo:         orders,
+		Fields:       []string{"customerId"},
+		Action:       lxDbGdpr.ActionPseudonymize,
+		Pseudonymize: []string{"customerId", "address.street"},
+	}))
+	its.NoError(registry.Register(&lxDbGdpr.Rule{
+		Name:      "notes",
+		Repo:      notes,
+		Filter:    func(subjectID interface{}) interface{} { return bson.M{"author": subjectID} },
+		Action:    lxDbGdpr.ActionAnonymize,
+		Anonymize: map[string]interface{}{"author": "anonymous"},
+	}))
+
+	for _, repo := range []*lxDbMocks.MockIBaseRepo{users, orders, notes} {
+		repo.EXPECT().GetCollection().Return(nil).AnyTimes()
+	}
+	users.EXPECT().GetRepoName().Return("test/users")
+	orders.EXPECT().GetRepoName().Return("test/orders")
+	notes.EXPECT().GetRepoName().Return("test/notes")
+
+	// Delete
+	users.EXPECT().Find(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(findDocs(bson.M{"_id": "c1"}))
+	users.EXPECT().DeleteMany(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: []interface{}{"c1"}}}}}, gomock.Any()).
+		Return(&lxDb.DeleteManyResult{DeletedCount: 1}, nil)
+	users.EXPECT().CountDocuments(bson.D{{Key: "_id", Value: "c1"}}, gomock.Any()).Return(int64(0), nil)
+
+	// Pseudonymize
+	orders.EXPECT().Find(bson.D{{Key: "customerId", Value: "c1"}}, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(findDocs(
+		bson.M{"_id": 1, "customerId": "c1", "address": bson.M{"street": "Main Street 1"}},
+		bson.M{"_id": 2, "customerId": "c1"},
+	))
+	orders.EXPECT().UpdateOne(bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "customerId", Value: registry.Pseudonym("c1")},
+		{Key: "address.street", Value: registry.Pseudonym("Main Street 1")},
+	}}}, gomock.Any()).Return(nil)
+	orders.EXPECT().UpdateOne(bson.D{{Key: "_id", Value: 2}}, gomock.Any(), gomock.Any()).Return(nil)
+	orders.EXPECT().CountDocuments(gomock.Any(), gomock.Any()).Return(int64(0), nil)
+
+	// Anonymize, one document remains
+	notes.EXPECT().Find(bson.M{"author": "c1"}, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(findDocs(bson.M{"_id": 7}))
+	notes.EXPECT().UpdateMany(gomock.Any(), bson.D{{Key: "$set", Value: bson.D{{Key: "author", Value: "anonymous"}}}}, gomock.Any()).
+		Return(&lxDb.UpdateManyResult{ModifiedCount: 1}, nil)
+	notes.EXPECT().CountDocuments(gomock.Any(), gomock.Any()).Return(int64(1), nil)
+
+	audit.EXPECT().IsActive().Return(true)
+	audit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) {
+		entries := elem.([]bson.M)
+		its.Len(entries, 4)
+		its.Equal(lxDb.Purge, entries[0]["action"])
+		its.Equal(lxDbGdpr.DefaultSystemUser, entries[0]["user"])
+		its.Equal(bson.M{
+			"_id":     1,
+			"rule":    "orders",
+			"erasure": lxDbGdpr.ActionPseudonymize,
+			"subject": registry.Pseudonym("c1"),
+			"fields":  []string{"customerId", "address.street"},
+		}, entries[1]["data"])
+	})
+
+	report, err := registry.Erase("c1")
+	its.NoError(err)
+	its.False(report.Transaction)
+	its.False(report.Verified)
+	its.Len(report.Rules, 3)
+	its.Equal(int64(1), report.Rules[0].Affected)
+	its.Equal(int64(2), report.Rules[1].Affected)
+	its.Equal(int64(1), report.Rules[2].Remaining)
+}
@@ -0,0 +1,597 @@
+package lxDb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GeoJSON types
+const (
+	GeoPoint             = "Point"
+	GeoLineString        = "LineString"
+	GeoPolygon           = "Polygon"
+	GeoMultiPolygon      = "MultiPolygon"
+	GeoFeature           = "Feature"
+	GeoFeatureCollection = "FeatureCollection"
+)
+
+// DefaultDistanceField, field of distance in results of FindNear
+const DefaultDistanceField = "distance"
+
+// Geometry, GeoJSON geometry, Point, LineString, Polygon or MultiPolygon.
+// Geometries are validated by marshal and unmarshal.
+type Geometry interface {
+	GeoType() string
+	Validate() error
+}
+
+// Position, longitude and latitude
+type Position [2]float64
+
+// Point, GeoJSON point
+// Example:
+// type Store struct {
+// Location lxDb.Point `bson:"location"`
+// }
+// store.Location = lxDb.NewPoint(13.405, 52.52)
+type Point struct {
+	Coordinates Position
+}
+
+// LineString, GeoJSON line string with at least two positions
+type LineString struct {
+	Coordinates []Position
+}
+
+// Polygon, GeoJSON polygon, first ring is the exterior, other rings are holes,
+// rings are closed with at least four positions
+type Polygon struct {
+	Coordinates [][]Position
+}
+
+// MultiPolygon, GeoJSON multi polygon
+type MultiPolygon struct {
+	Coordinates [][][]Position
+}
+
+// Feature, GeoJSON feature with geometry and properties
+type Feature struct {
+	ID         interface{}
+	Geometry   Geometry
+	Properties map[string]interface{}
+}
+
+// FeatureCollection, GeoJSON feature collection
+type FeatureCollection struct {
+	Features []Feature
+}
+
+// NewPoint, return point of longitude and latitude
+func NewPoint(lng, lat float64) Point {
+	return Point{Coordinates: Position{lng, lat}}
+}
+
+// Lng, return longitude
+func (p Point) Lng() float64 { return p.Coordinates[0] }
+
+// Lat, return latitude
+func (p Point) Lat() float64 { return p.Coordinates[1] }
+
+// GeoType, implements Geometry
+func (p Point) GeoType() string { return GeoPoint }
+
+// GeoType, implements Geometry
+func (l LineString) GeoType() string { return GeoLineString }
+
+// GeoType, implements Geometry
+func (p Polygon) GeoType() string { return GeoPolygon }
+
+// GeoType, implements Geometry
+func (m MultiPolygon) GeoType() string { return GeoMultiPolygon }
+
+// Validate, check range of longitude and latitude
+func (pos Position) Validate() error {
+	lng, lat := pos[0], pos[1]
+	if math.IsNaN(lng) || math.IsNaN(lat) || lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+		return fmt.Errorf("position [%v, %v] out of range, %w", lng, lat, ErrGeoJSON)
+	}
+	return nil
+}
+
+// Validate, check position
+func (p Point) Validate() error {
+	return p.Coordinates.Validate()
+}
+
+// Validate, check positions, at least two
+func (l LineString) Validate() error {
+	if len(l.Coordinates) < 2 {
+		return fmt.Errorf("line string requires at least two positions, %w", ErrGeoJSON)
+	}
+	return validatePositions(l.Coordinates)
+}
+
+// Validate, check rings, at least one, closed with at least four positions
+func (p Polygon) Validate() error {
+	if len(p.Coordinates) == 0 {
+		return fmt.Errorf("polygon requires at least one ring, %w", ErrGeoJSON)
+	}
+	for i, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("polygon ring %d requires at least four positions, %w", i, ErrGeoJSON)
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return fmt.Errorf("polygon ring %d is not closed, %w", i, ErrGeoJSON)
+		}
+		if err := validatePositions(ring); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate, check polygons, at least one
+func (m MultiPolygon) Validate() error {
+	if len(m.Coordinates) == 0 {
+		return fmt.Errorf("multi polygon requires at least one polygon, %w", ErrGeoJSON)
+	}
+	for _, coordinates := range m.Coordinates {
+		if err := (Polygon{Coordinates: coordinates}).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate, check geometry
+func (f Feature) Validate() error {
+	if f.Geometry == nil {
+		return nil
+	}
+	return f.Geometry.Validate()
+}
+
+// Validate, check geometries of features
+func (fc FeatureCollection) Validate() error {
+	for i, f := range fc.Features {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("feature %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validatePositions, check all positions
+func validatePositions(positions []Position) error {
+	for _, pos := range positions {
+		if err := pos.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// geoDoc, document of geometry
+func geoDoc(g Geometry) (bson.D, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	var coordinates interface{}
+	switch v := g.(type) {
+	case Point:
+		coordinates = v.Coordinates
+	case LineString:
+		coordinates = v.Coordinates
+	case Polygon:
+		coordinates = v.Coordinates
+	case MultiPolygon:
+		coordinates = v.Coordinates
+	default:
+		return nil, fmt.Errorf("%T, %w", g, ErrGeoJSON)
+	}
+	return bson.D{{Key: "type", Value: g.GeoType()}, {Key: "coordinates", Value: coordinates}}, nil
+}
+
+// rawGeometry, decoded type and coordinates
+type rawGeometry struct {
+	Type        string          `json:"type" bson:"type"`
+	Coordinates json.RawMessage `json:"coordinates" bson:"-"`
+	BsonCoords  bson.RawValue   `json:"-" bson:"coordinates"`
+}
+
+// decode, decode coordinates of json or bson and validate
+func (raw *rawGeometry) decode(geoType string, coordinates interface{}, g Geometry) error {
+	if raw.Type != geoType {
+		return fmt.Errorf("type %q is not %s, %w", raw.Type, geoType, ErrGeoJSON)
+	}
+	var err error
+	if raw.Coordinates != nil {
+		err = json.Unmarshal(raw.Coordinates, coordinates)
+	} else {
+		err = raw.BsonCoords.Unmarshal(coordinates)
+	}
+	if err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	return g.Validate()
+}
+
+// MarshalBSON, implements bson.Marshaler
+func (p Point) MarshalBSON() ([]byte, error) { return marshalGeoBSON(p) }
+
+// MarshalBSON, implements bson.Marshaler
+func (l LineString) MarshalBSON() ([]byte, error) { return marshalGeoBSON(l) }
+
+// MarshalBSON, implements bson.Marshaler
+func (p Polygon) MarshalBSON() ([]byte, error) { return marshalGeoBSON(p) }
+
+// MarshalBSON, implements bson.Marshaler
+func (m MultiPolygon) MarshalBSON() ([]byte, error) { return marshalGeoBSON(m) }
+
+// MarshalJSON, implements json.Marshaler
+func (p Point) MarshalJSON() ([]byte, error) { return marshalGeoJSON(p) }
+
+// MarshalJSON, implements json.Marshaler
+func (l LineString) MarshalJSON() ([]byte, error) { return marshalGeoJSON(l) }
+
+// MarshalJSON, implements json.Marshaler
+func (p Polygon) MarshalJSON() ([]byte, error) { return marshalGeoJSON(p) }
+
+// MarshalJSON, implements json.Marshaler
+func (m MultiPolygon) MarshalJSON() ([]byte, error) { return marshalGeoJSON(m) }
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (p *Point) UnmarshalBSON(data []byte) error {
+	return unmarshalGeoBSON(data, GeoPoint, &p.Coordinates, p)
+}
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (l *LineString) UnmarshalBSON(data []byte) error {
+	return unmarshalGeoBSON(data, GeoLineString, &l.Coordinates, l)
+}
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (p *Polygon) UnmarshalBSON(data []byte) error {
+	return unmarshalGeoBSON(data, GeoPolygon, &p.Coordinates, p)
+}
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (m *MultiPolygon) UnmarshalBSON(data []byte) error {
+	return unmarshalGeoBSON(data, GeoMultiPolygon, &m.Coordinates, m)
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (p *Point) UnmarshalJSON(data []byte) error {
+	return unmarshalGeoJSON(data, GeoPoint, &p.Coordinates, p)
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (l *LineString) UnmarshalJSON(data []byte) error {
+	return unmarshalGeoJSON(data, GeoLineString, &l.Coordinates, l)
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (p *Polygon) UnmarshalJSON(data []byte) error {
+	return unmarshalGeoJSON(data, GeoPolygon, &p.Coordinates, p)
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (m *MultiPolygon) UnmarshalJSON(data []byte) error {
+	return unmarshalGeoJSON(data, GeoMultiPolygon, &m.Coordinates, m)
+}
+
+// marshalGeoBSON, return bson of validated geometry
+func marshalGeoBSON(g Geometry) ([]byte, error) {
+	doc, err := geoDoc(g)
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+// marshalGeoJSON, return json of validated geometry
+func marshalGeoJSON(g Geometry) ([]byte, error) {
+	doc, err := geoDoc(g)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type        string      `json:"type"`
+		Coordinates interface{} `json:"coordinates"`
+	}{doc[0].Value.(string), doc[1].Value})
+}
+
+// unmarshalGeoBSON, decode bson geometry of type
+func unmarshalGeoBSON(data []byte, geoType string, coordinates interface{}, g Geometry) error {
+	var raw rawGeometry
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	return raw.decode(geoType, coordinates, g)
+}
+
+// unmarshalGeoJSON, decode json geometry of type
+func unmarshalGeoJSON(data []byte, geoType string, coordinates interface{}, g Geometry) error {
+	var raw rawGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	if raw.Coordinates == nil {
+		return fmt.Errorf("missing coordinates, %w", ErrGeoJSON)
+	}
+	return raw.decode(geoType, coordinates, g)
+}
+
+// newGeometry, return empty geometry of type
+func newGeometry(geoType string) (Geometry, error) {
+	switch geoType {
+	case GeoPoint:
+		return &Point{}, nil
+	case GeoLineString:
+		return &LineString{}, nil
+	case GeoPolygon:
+		return &Polygon{}, nil
+	case GeoMultiPolygon:
+		return &MultiPolygon{}, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry %q, %w", geoType, ErrGeoJSON)
+}
+
+// derefGeometry, return geometry value of pointer
+func derefGeometry(g Geometry) Geometry {
+	switch v := g.(type) {
+	case *Point:
+		return *v
+	case *LineString:
+		return *v
+	case *Polygon:
+		return *v
+	case *MultiPolygon:
+		return *v
+	}
+	return g
+}
+
+// featureJSON, json of feature
+type featureJSON struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// MarshalJSON, implements json.Marshaler, geometry nil is null
+func (f Feature) MarshalJSON() ([]byte, error) {
+	geometry := json.RawMessage("null")
+	if f.Geometry != nil {
+		data, err := marshalGeoJSON(f.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		geometry = data
+	}
+	return json.Marshal(featureJSON{Type: GeoFeature, ID: f.ID, Geometry: geometry, Properties: f.Properties})
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (f *Feature) UnmarshalJSON(data []byte) error {
+	var raw featureJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	if raw.Type != GeoFeature {
+		return fmt.Errorf("type %q is not %s, %w", raw.Type, GeoFeature, ErrGeoJSON)
+	}
+
+	*f = Feature{ID: raw.ID, Properties: raw.Properties}
+	if len(raw.Geometry) == 0 || string(raw.Geometry) == "null" {
+		return nil
+	}
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw.Geometry, &typed); err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	g, err := newGeometry(typed.Type)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw.Geometry, g); err != nil {
+		return err
+	}
+	f.Geometry = derefGeometry(g)
+	return nil
+}
+
+// featureBSON, bson of feature
+type featureBSON struct {
+	Type       string                 `bson:"type"`
+	ID         interface{}            `bson:"id,omitempty"`
+	Geometry   bson.RawValue          `bson:"geometry"`
+	Properties map[string]interface{} `bson:"properties"`
+}
+
+// MarshalBSON, implements bson.Marshaler
+func (f Feature) MarshalBSON() ([]byte, error) {
+	doc := bson.D{{Key: "type", Value: GeoFeature}}
+	if f.ID != nil {
+		doc = append(doc, bson.E{Key: "id", Value: f.ID})
+	}
+	if f.Geometry != nil {
+		geometry, err := geoDoc(f.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		doc = append(doc, bson.E{Key: "geometry", Value: geometry})
+	} else {
+		doc = append(doc, bson.E{Key: "geometry", Value: nil})
+	}
+	doc = append(doc, bson.E{Key: "properties", Value: f.Properties})
+	return bson.Marshal(doc)
+}
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (f *Feature) UnmarshalBSON(data []byte) error {
+	var raw featureBSON
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%v, %w", err, ErrGeoJSON)
+	}
+	if raw.Type != GeoFeature {
+		return fmt.Errorf("type %q is not %s, %w", raw.Type, GeoFeature, ErrGeoJSON)
+	}
+
+	*f = Feature{ID: raw.ID, Properties: raw.Properties}
+	doc, ok := raw.Geometry.DocumentOK()
+	if !ok {
+		return nil
+	}
+	geoType, _ := doc.Lookup("type").StringValueOK()
+	g, err := newGeometry(geoType)
+	if err != nil {
+		return err
+	}
+	if err := bson.Unmarshal(doc, g); err != nil {
+		return err
+	}
+	f.Geometry = derefGeometry(g)
+	return nil
+}
+
+// MarshalJSON, implements json.Marshaler
+func (fc FeatureCollection) MarshalJSON() ([]byte, error) {
+	features := fc.Features
+	if features == nil {
+		features = []Feature{}
+	}
+	return json.Marshal(struct {
+		Type     string    `json:"type"`
+		Features []Feature `json:"features"`
+	}{GeoFeatureCollection, features})
+}
+
+// UnmarshalJSON, implements json.Unmarshaler
+func (fc *FeatureCollection) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type     string    `json:"type"`
+		Features []Feature `json:"features"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Type != GeoFeatureCollection {
+		return fmt.Errorf("type %q is not %s, %w", raw.Type, GeoFeatureCollection, ErrGeoJSON)
+	}
+	fc.Features = raw.Features
+	return nil
+}
+
+// MarshalBSON, implements bson.Marshaler
+func (fc FeatureCollection) MarshalBSON() ([]byte, error) {
+	features := make(bson.A, len(fc.Features))
+	for i, f := range fc.Features {
+		features[i] = f
+	}
+	return bson.Marshal(bson.D{{Key: "type", Value: GeoFeatureCollection}, {Key: "features", Value: features}})
+}
+
+// UnmarshalBSON, implements bson.Unmarshaler
+func (fc *FeatureCollection) UnmarshalBSON(data []byte) error {
+	var raw struct {
+		Type     string    `bson:"type"`
+		Features []Feature `bson:"features"`
+	}
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Type != GeoFeatureCollection {
+		return fmt.Errorf("type %q is not %s, %w", raw.Type, GeoFeatureCollection, ErrGeoJSON)
+	}
+	fc.Features = raw.Features
+	return nil
+}
+
+// GeoIndex, return 2dsphere index model of fields for CreateIndexes
+// Example:
+// _, err := repo.CreateIndexes(lxDb.GeoIndex("location"))
+func GeoIndex(fields ...string) mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: "2dsphere"})
+	}
+	return mongo.IndexModel{Keys: keys}
+}
+
+// GeoNearOptions, $geoNear query of FindNear and Pipeline.GeoNear
+type GeoNearOptions struct {
+	// Near, point of query
+	Near Point
+	// Key, 2dsphere indexed field, required with more than one geo index
+	Key string
+	// DistanceField, field of distance in meters in results, default DefaultDistanceField
+	DistanceField string
+	// MinDistance and MaxDistance in meters, 0 is not set
+	MinDistance float64
+	MaxDistance float64
+	// Filter, additional query of documents
+	Filter interface{}
+	// DistanceMultiplier, e.g. 0.001 for kilometers, 0 is not set
+	DistanceMultiplier float64
+	// Limit, max number of results, 0 is not set
+	Limit int64
+}
+
+// stage, return $geoNear stage
+func (o *GeoNearOptions) stage() (bson.D, error) {
+	if err := o.Near.Validate(); err != nil {
+		return nil, err
+	}
+	if o.MinDistance < 0 || o.MaxDistance < 0 || (o.MaxDistance > 0 && o.MinDistance > o.MaxDistance) {
+		return nil, fmt.Errorf("invalid distance, %w", ErrGeoJSON)
+	}
+
+	distanceField := o.DistanceField
+	if distanceField == "" {
+		distanceField = DefaultDistanceField
+	}
+	near, _ := geoDoc(o.Near)
+	stage := bson.D{
+		{Key: "near", Value: near},
+		{Key: "distanceField", Value: distanceField},
+		{Key: "spherical", Value: true},
+	}
+	if o.Key != "" {
+		stage = append(stage, bson.E{Key: "key", Value: o.Key})
+	}
+	if o.MinDistance > 0 {
+		stage = append(stage, bson.E{Key: "minDistance", Value: o.MinDistance})
+	}
+	if o.MaxDistance > 0 {
+		stage = append(stage, bson.E{Key: "maxDistance", Value: o.MaxDistance})
+	}
+	if o.Filter != nil {
+		stage = append(stage, bson.E{Key: "query", Value: o.Filter})
+	}
+	if o.DistanceMultiplier > 0 {
+		stage = append(stage, bson.E{Key: "distanceMultiplier", Value: o.DistanceMultiplier})
+	}
+	return stage, nil
+}
+
+// FindNear, find documents near point sorted by distance, the distance is set in DistanceField of the results,
+// IGeoRepo
+// Example:
+// var stores []StoreWithDistance
+// err := repo.(lxDb.IGeoRepo).FindNear(&lxDb.GeoNearOptions{Near: lxDb.NewPoint(13.405, 52.52), MaxDistance: 5000, Limit: 10}, &stores)
+func (repo *mongoBaseRepo) FindNear(near *GeoNearOptions, result interface{}, args ...interface{}) error {
+	pipeline := NewPipeline().GeoNear(near)
+	if near != nil && near.Limit > 0 {
+		pipeline.Limit(near.Limit)
+	}
+
+	return repo.Aggregate(pipeline, result, args...)
+}
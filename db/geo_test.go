@@ -0,0 +1,122 @@
+package lxDb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var testPolygon = lxDb.Polygon{Coordinates: [][]lxDb.Position{{{13.3, 52.4}, {13.5, 52.4}, {13.5, 52.6}, {13.3, 52.4}}}}
+
+func TestGeometry_Marshal(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("bson", func(t *testing.T) {
+		type store struct {
+			Location lxDb.Point   `bson:"location"`
+			Area     lxDb.Polygon `bson:"area"`
+		}
+		data, err := bson.Marshal(store{Location: lxDb.NewPoint(13.405, 52.52), Area: testPolygon})
+		its.NoError(err)
+
+		var doc bson.M
+		its.NoError(bson.Unmarshal(data, &doc))
+		its.Equal(bson.M{"type": "Point", "coordinates": bson.A{13.405, 52.52}}, doc["location"])
+
+		var result store
+		its.NoError(bson.Unmarshal(data, &result))
+		its.Equal(52.52, result.Location.Lat())
+		its.Equal(testPolygon, result.Area)
+
+		// Integer coordinates
+		data, err = bson.Marshal(bson.M{"location": bson.M{"type": "Point", "coordinates": bson.A{int32(13), int32(52)}}})
+		its.NoError(err)
+		its.NoError(bson.Unmarshal(data, &result))
+		its.Equal(lxDb.NewPoint(13, 52), result.Location)
+	})
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(lxDb.LineString{Coordinates: []lxDb.Position{{1, 2}, {3, 4}}})
+		its.NoError(err)
+		its.JSONEq(`{"type": "LineString", "coordinates": [[1, 2], [3, 4]]}`, string(data))
+
+		var line lxDb.LineString
+		its.NoError(json.Unmarshal(data, &line))
+		its.Equal(lxDb.Position{3, 4}, line.Coordinates[1])
+
+		var point lxDb.Point
+		its.True(errors.Is(json.Unmarshal(data, &point), lxDb.ErrGeoJSON))
+	})
+	t.Run("feature collection", func(t *testing.T) {
+		fc := lxDb.FeatureCollection{Features: []lxDb.Feature{
+			{ID: "berlin", Geometry: lxDb.NewPoint(13.405, 52.52), Properties: map[string]interface{}{"name": "Berlin"}},
+			{Geometry: lxDb.MultiPolygon{Coordinates: [][][]lxDb.Position{testPolygon.Coordinates}}},
+		}}
+
+		data, err := json.Marshal(fc)
+		its.NoError(err)
+		var fromJSON lxDb.FeatureCollection
+		its.NoError(json.Unmarshal(data, &fromJSON))
+		its.Equal(fc.Features[0].Geometry, fromJSON.Features[0].Geometry)
+		its.Equal(fc.Features[1].Geometry, fromJSON.Features[1].Geometry)
+		its.Equal("Berlin", fromJSON.Features[0].Properties["name"])
+
+		data, err = bson.Marshal(fc)
+		its.NoError(err)
+		var fromBSON lxDb.FeatureCollection
+		its.NoError(bson.Unmarshal(data, &fromBSON))
+		its.Equal(fc.Features[1].Geometry, fromBSON.Features[1].Geometry)
+		its.Equal("berlin", fromBSON.Features[0].ID)
+	})
+}
+
+func TestGeometry_Validate(t *testing.T) {
+	its := assert.New(t)
+
+	its.NoError(testPolygon.Validate())
+	for _, g := range []lxDb.Geometry{
+		lxDb.NewPoint(181, 0),
+		lxDb.NewPoint(0, -91),
+		lxDb.LineString{Coordinates: []lxDb.Position{{1, 2}}},
+		lxDb.Polygon{Coordinates: [][]lxDb.Position{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}},
+		lxDb.Polygon{},
+		lxDb.MultiPolygon{},
+	} {
+		its.True(errors.Is(g.Validate(), lxDb.ErrGeoJSON), g)
+	}
+
+	// Invalid geometries can't be marshaled
+	_, err := bson.Marshal(bson.M{"location": lxDb.NewPoint(200, 0)})
+	its.True(errors.Is(err, lxDb.ErrGeoJSON))
+}
+
+func TestPipeline_GeoNear(t *testing.T) {
+	its := assert.New(t)
+
+	pipeline, err := lxDb.NewPipeline().GeoNear(&lxDb.GeoNearOptions{
+		Near:        lxDb.NewPoint(13.405, 52.52),
+		Key:         "location",
+		MaxDistance: 5000,
+		Filter:      lxDb.Where("is_active").Eq(true),
+	}).Build()
+	its.NoError(err)
+	its.Equal(mongo.Pipeline{{{Key: "$geoNear", Value: bson.D{
+		{Key: "near", Value: bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: lxDb.Position{13.405, 52.52}}}},
+		{Key: "distanceField", Value: "distance"},
+		{Key: "spherical", Value: true},
+		{Key: "key", Value: "location"},
+		{Key: "maxDistance", Value: 5000.0},
+		{Key: "query", Value: lxDb.Where("is_active").Eq(true)},
+	}}}}, pipeline)
+
+	_, err = lxDb.NewPipeline().Match(bson.D{}).GeoNear(&lxDb.GeoNearOptions{}).Build()
+	its.True(errors.Is(err, lxDb.ErrPipeline))
+	_, err = lxDb.NewPipeline().GeoNear(&lxDb.GeoNearOptions{Near: lxDb.NewPoint(0, 100)}).Build()
+	its.True(errors.Is(err, lxDb.ErrPipeline))
+
+	its.Equal(bson.D{{Key: "location", Value: "2dsphere"}}, lxDb.GeoIndex("location").Keys)
+}
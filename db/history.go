@@ -0,0 +1,411 @@
+package lxDb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// HistorySuffix, suffix of default history collection
+	HistorySuffix = "_history"
+
+	// History change types
+	HistoryUpdate  = "update"
+	HistoryReplace = "replace"
+	HistoryDelete  = "delete"
+	HistoryRevert  = "revert"
+)
+
+// HistoryOptions, versioned history for NewMongoBaseRepo.
+// Updates, replaces and deletes with *AuditAuth copy the previous document
+// into the history collection as new revision.
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, &lxDb.HistoryOptions{MaxRevisions: 50})
+type HistoryOptions struct {
+	// Collection, name of history collection, default <collection>_history
+	Collection string
+	// MaxRevisions, max revisions per document, 0 keeps all
+	MaxRevisions int64
+	// MaxAge, max age of revisions, 0 keeps all.
+	// Old revisions are removed by a TTL index on timestamp, the server deletes them in the background
+	MaxAge time.Duration
+}
+
+// applyRepo, implements RepoOption
+func (o *HistoryOptions) applyRepo(repo *mongoBaseRepo) {
+	if o != nil {
+		repo.history = newHistory(repo.collection, *o)
+	}
+}
+
+// Revision, previous version of a document
+type Revision struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	DocID     interface{}        `bson:"doc_id"`
+	Revision  int64              `bson:"revision"`
+	Type      string             `bson:"type"`
+	User      interface{}        `bson:"user"`
+	Timestamp time.Time          `bson:"timestamp"`
+	Document  bson.M             `bson:"document,omitempty"`
+}
+
+// RevisionChange, changed field between two revisions, fields are dotted paths
+type RevisionChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// historyType, internal arg for the change type of a write
+type historyType string
+
+// history, history collection of repo
+type history struct {
+	opts       HistoryOptions
+	collection *mongo.Collection
+	indexOnce  sync.Once
+	indexErr   error
+}
+
+// newHistory, return history for collection
+func newHistory(collection *mongo.Collection, opts HistoryOptions) *history {
+	if opts.Collection == "" {
+		opts.Collection = collection.Name() + HistorySuffix
+	}
+	return &history{
+		opts:       opts,
+		collection: collection.Database().Collection(opts.Collection),
+	}
+}
+
+// historyActive, return true when history is configured and user is known
+func (repo *mongoBaseRepo) historyActive(authUser interface{}) bool {
+	return repo.history != nil && authUser != nil
+}
+
+// historyBefore, return documents before write, for single writes the first document,
+// nil when history is not active
+func (repo *mongoBaseRepo) historyBefore(authUser, filter interface{}, many bool, sort interface{}, timeout time.Duration) ([]bson.M, error) {
+	if !repo.historyActive(authUser) {
+		return nil, nil
+	}
+	if many {
+		var docs []bson.M
+		err := repo.Find(filter, &docs, timeout)
+		return docs, err
+	}
+
+	opts := options.FindOne()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	var doc bson.M
+	if err := repo.FindOne(filter, &doc, opts, timeout); err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []bson.M{doc}, nil
+}
+
+// recordHistory, store changed documents of previous as revisions
+func (repo *mongoBaseRepo) recordHistory(previous []bson.M, changeType string, authUser interface{}, timeout time.Duration) error {
+	if len(previous) == 0 {
+		return nil
+	}
+	h := repo.history
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	h.indexOnce.Do(func() {
+		h.indexErr = h.ensureIndexes(ctx)
+	})
+	if h.indexErr != nil {
+		return fmt.Errorf("history: %w", h.indexErr)
+	}
+
+	// Current documents, unchanged documents have no new revision
+	ids := make(bson.A, 0, len(previous))
+	for _, doc := range previous {
+		ids = append(ids, doc["_id"])
+	}
+	var current []bson.M
+	if err := repo.Find(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, &current, timeout); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	currentByID := make(map[string]bson.M, len(current))
+	for _, doc := range current {
+		currentByID[refKey(doc["_id"])] = doc
+	}
+
+	now := time.Now()
+	for _, doc := range previous {
+		if cur, ok := currentByID[refKey(doc["_id"])]; ok && cmp.Equal(cur, doc) {
+			continue
+		}
+
+		// Next revision, retry on concurrent insert of same revision
+		var err error
+		for try := 0; try < 3; try++ {
+			var rev int64
+			if rev, err = h.lastRevision(ctx, doc["_id"]); err != nil {
+				break
+			}
+			_, err = h.collection.InsertOne(ctx, Revision{
+				DocID:     doc["_id"],
+				Revision:  rev + 1,
+				Type:      changeType,
+				User:      authUser,
+				Timestamp: now,
+				Document:  doc,
+			})
+			if !isDuplicateKey(err) {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+
+		if err := h.cleanup(ctx, doc["_id"]); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensureIndexes, create index of revisions and TTL index of MaxAge,
+// the TTL of an existing index is changed to MaxAge
+func (h *history) ensureIndexes(ctx context.Context) error {
+	models := []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "doc_id", Value: 1}, {Key: "revision", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	}}
+	ttl := bson.D{{Key: "timestamp", Value: 1}}
+	seconds := int32(h.opts.MaxAge / time.Second)
+	if h.opts.MaxAge > 0 {
+		if seconds < 1 {
+			seconds = 1
+		}
+		models = append(models, mongo.IndexModel{Keys: ttl, Options: options.Index().SetExpireAfterSeconds(seconds)})
+	}
+
+	_, err := h.collection.Indexes().CreateMany(ctx, models)
+	if h.opts.MaxAge <= 0 || !isIndexConflict(err) {
+		return err
+	}
+
+	// MaxAge changed
+	if err := h.collection.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: h.collection.Name()},
+		{Key: "index", Value: bson.D{{Key: "keyPattern", Value: ttl}, {Key: "expireAfterSeconds", Value: seconds}}},
+	}).Err(); err != nil {
+		return err
+	}
+	_, err = h.collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// isIndexConflict, return true for index with same keys and other options
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86)
+}
+
+// lastRevision, return last revision of document, 0 without revisions
+func (h *history) lastRevision(ctx context.Context, docID interface{}) (int64, error) {
+	var last Revision
+	err := h.collection.FindOne(ctx, bson.D{{Key: "doc_id", Value: docID}},
+		options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}}).SetProjection(bson.D{{Key: "revision", Value: 1}})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Revision, err
+}
+
+// cleanup, delete revisions of document over MaxRevisions, MaxAge is done by TTL index
+func (h *history) cleanup(ctx context.Context, docID interface{}) error {
+	if h.opts.MaxRevisions > 0 {
+		var oldest Revision
+		err := h.collection.FindOne(ctx, bson.D{{Key: "doc_id", Value: docID}},
+			options.FindOne().
+				SetSort(bson.D{{Key: "revision", Value: -1}}).
+				SetSkip(h.opts.MaxRevisions-1).
+				SetProjection(bson.D{{Key: "revision", Value: 1}})).Decode(&oldest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if err == nil {
+			if _, err := h.collection.DeleteMany(ctx, bson.D{
+				{Key: "doc_id", Value: docID},
+				{Key: "revision", Value: bson.D{{Key: "$lt", Value: oldest.Revision}}},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isDuplicateKey, return true for duplicate key error
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 11000
+}
+
+// historyTimeout, return timeout of args
+func historyTimeout(args []interface{}) time.Duration {
+	for _, arg := range args {
+		if timeout, ok := arg.(time.Duration); ok {
+			return timeout
+		}
+	}
+	return DefaultTimeout
+}
+
+// Revisions, return revisions of document without documents, newest first,
+// IHistoryRepo
+func (repo *mongoBaseRepo) Revisions(id interface{}, args ...interface{}) ([]Revision, error) {
+	if repo.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout(args))
+	defer cancel()
+
+	cur, err := repo.history.collection.Find(ctx, bson.D{{Key: "doc_id", Value: id}},
+		options.Find().SetSort(bson.D{{Key: "revision", Value: -1}}).SetProjection(bson.D{{Key: "document", Value: 0}}))
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]Revision, 0)
+	err = cur.All(ctx, &revisions)
+	return revisions, err
+}
+
+// GetRevision, return revision with document,
+// IHistoryRepo
+func (repo *mongoBaseRepo) GetRevision(id interface{}, revision int64, args ...interface{}) (*Revision, error) {
+	if repo.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout(args))
+	defer cancel()
+
+	var rev Revision
+	if err := repo.history.collection.FindOne(ctx, bson.D{{Key: "doc_id", Value: id}, {Key: "revision", Value: revision}}).Decode(&rev); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("revision %d, %w", revision, ErrNotFound)
+		}
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// DiffRevisions, return changed fields from revision to revision,
+// revision 0 is the current document
+// Example:
+// changes, err := repo.(lxDb.IHistoryRepo).DiffRevisions(id, 3, 0)
+func (repo *mongoBaseRepo) DiffRevisions(id interface{}, from, to int64, args ...interface{}) ([]RevisionChange, error) {
+	fromDoc, err := repo.revisionDocument(id, from, args)
+	if err != nil {
+		return nil, err
+	}
+	toDoc, err := repo.revisionDocument(id, to, args)
+	if err != nil {
+		return nil, err
+	}
+
+	fromFields := make(map[string]interface{})
+	toFields := make(map[string]interface{})
+	flattenDoc("", fromDoc, fromFields)
+	flattenDoc("", toDoc, toFields)
+
+	keys := make(map[string]bool)
+	for key := range fromFields {
+		keys[key] = true
+	}
+	for key := range toFields {
+		keys[key] = true
+	}
+	fields := make([]string, 0, len(keys))
+	for key := range keys {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+
+	changes := make([]RevisionChange, 0)
+	for _, field := range fields {
+		oldValue, newValue := fromFields[field], toFields[field]
+		if !cmp.Equal(oldValue, newValue) {
+			changes = append(changes, RevisionChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	return changes, nil
+}
+
+// RevertRevision, replace document with revision, the replaced document is recorded as HistoryRevert revision,
+// a deleted document is inserted again
+// Example:
+// err := repo.(lxDb.IHistoryRepo).RevertRevision(id, 3, lxDb.SetAuditAuth(user))
+func (repo *mongoBaseRepo) RevertRevision(id interface{}, revision int64, args ...interface{}) error {
+	rev, err := repo.GetRevision(id, revision, args...)
+	if err != nil {
+		return err
+	}
+
+	replaceArgs := append([]interface{}{options.Replace().SetUpsert(true), historyType(HistoryRevert)}, args...)
+	return repo.ReplaceOne(bson.D{{Key: "_id", Value: id}}, rev.Document, replaceArgs...)
+}
+
+// revisionDocument, return document of revision, 0 is the current document
+func (repo *mongoBaseRepo) revisionDocument(id interface{}, revision int64, args []interface{}) (bson.M, error) {
+	if revision == 0 {
+		var doc bson.M
+		err := repo.FindOne(bson.D{{Key: "_id", Value: id}}, &doc, historyTimeout(args))
+		return doc, err
+	}
+
+	rev, err := repo.GetRevision(id, revision, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rev.Document, nil
+}
+
+// flattenDoc, set fields of document with dotted paths, arrays are compared as values
+func flattenDoc(prefix string, doc bson.M, fields map[string]interface{}) {
+	for key, value := range doc {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if sub, ok := value.(bson.M); ok && len(sub) > 0 {
+			flattenDoc(path, sub, fields)
+			continue
+		}
+		fields[path] = value
+	}
+}
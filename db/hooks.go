@@ -0,0 +1,222 @@
+package lxDb
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Hook events
+const (
+	HookInsert = "insert"
+	HookUpdate = "update"
+	HookDelete = "delete"
+	// HookAll, hook runs for all events
+	HookAll = "*"
+)
+
+// HookImage, document before and after a write,
+// Before is nil for inserted documents
+type HookImage struct {
+	Before bson.M
+	After  bson.M
+}
+
+// HookContext, operation passed through the hook chain.
+// Before hooks can change Filter, Doc, Docs and Update,
+// after hooks receive Result, IDs and, when audit is active, Images.
+type HookContext struct {
+	// Event, HookInsert, HookUpdate or HookDelete
+	Event string
+	// Operation, name of repo method, e.g. "UpdateOne"
+	Operation  string
+	Collection string
+	// User, user of *AuditAuth, nil without auth
+	User   interface{}
+	Filter interface{}
+	// Doc, document of InsertOne and replacement of ReplaceOne and FindOneAndReplace
+	Doc    interface{}
+	Docs   []interface{}
+	Update interface{}
+
+	// Result, result of operation, e.g. inserted id or *UpdateManyResult
+	Result interface{}
+	// IDs, ids of affected documents, with and without audit
+	IDs []interface{}
+	// Images, before and after images computed by the audit path
+	Images []HookImage
+}
+
+// BeforeHook, runs before operation, an error aborts the operation
+type BeforeHook func(hc *HookContext) error
+
+// AfterHook, runs after successful operation
+type AfterHook func(hc *HookContext)
+
+// SkipHooks, arg to skip the hooks of a call
+// Example:
+// err := repo.UpdateOne(filter, update, lxDb.SkipHooks{})
+type SkipHooks struct{}
+
+type beforeHook struct {
+	event string
+	fn    BeforeHook
+}
+
+type afterHook struct {
+	event string
+	fn    AfterHook
+}
+
+// Hooks, chain of before and after hooks, set as option of NewMongoBaseRepo.
+// Hooks run in order of registration, before hooks stop at the first error.
+// Example:
+// hooks := lxDb.NewHooks().
+// Before(lxDb.HookInsert, setCreatedAt).
+// After(lxDb.HookAll, publishEvent)
+// repo := lxDb.NewMongoBaseRepo(collection, hooks)
+type Hooks struct {
+	mux    sync.RWMutex
+	before []beforeHook
+	after  []afterHook
+}
+
+// applyRepo, implements RepoOption
+func (h *Hooks) applyRepo(repo *mongoBaseRepo) {
+	if h != nil {
+		repo.hooks = h
+	}
+}
+
+// NewHooks, return empty hook chain
+func NewHooks() *Hooks {
+	return new(Hooks)
+}
+
+// Before, add hook for event before operation
+func (h *Hooks) Before(event string, fn BeforeHook) *Hooks {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.before = append(h.before, beforeHook{event: event, fn: fn})
+	return h
+}
+
+// After, add hook for event after operation
+func (h *Hooks) After(event string, fn AfterHook) *Hooks {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.after = append(h.after, afterHook{event: event, fn: fn})
+	return h
+}
+
+// runBefore, run before hooks of event, return first error
+func (h *Hooks) runBefore(hc *HookContext) error {
+	h.mux.RLock()
+	hooks := h.before
+	h.mux.RUnlock()
+
+	for _, hook := range hooks {
+		if hook.event != HookAll && hook.event != hc.Event {
+			continue
+		}
+		if err := hook.fn(hc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAfter, run after hooks of event
+func (h *Hooks) runAfter(hc *HookContext) {
+	h.mux.RLock()
+	hooks := h.after
+	h.mux.RUnlock()
+
+	for _, hook := range hooks {
+		if hook.event == HookAll || hook.event == hc.Event {
+			hook.fn(hc)
+		}
+	}
+}
+
+// hookContext, return context for operation, nil without hooks or with SkipHooks
+func (repo *mongoBaseRepo) hookContext(event, operation string, authUser interface{}, skip bool) *HookContext {
+	if repo.hooks == nil || skip {
+		return nil
+	}
+	return &HookContext{
+		Event:      event,
+		Operation:  operation,
+		Collection: repo.collection.Name(),
+		User:       authUser,
+	}
+}
+
+// runAfterHooks, set result, ids and images and run after hooks, nil context is ignored
+func (repo *mongoBaseRepo) runAfterHooks(hc *HookContext, result interface{}, ids []interface{}, images ...HookImage) {
+	if hc == nil {
+		return
+	}
+	hc.Result = result
+	hc.IDs = ids
+	hc.Images = images
+	repo.hooks.runAfter(hc)
+}
+
+// hookFilter, return ids of documents of filter for after hooks of writes without audit
+// and filter limited to these ids, so the ids are the written documents.
+// Unchanged filter without hooks or found documents
+func (repo *mongoBaseRepo) hookFilter(hc *HookContext, filter interface{}, many bool, collation *options.Collation, timeout time.Duration) (interface{}, []interface{}, error) {
+	if hc == nil {
+		return filter, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	findOpts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	findOpts.Collation = collation
+	if !many {
+		findOpts.SetLimit(1)
+	}
+	cur, err := repo.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, nil, err
+	}
+	if len(docs) == 0 {
+		return filter, nil, nil
+	}
+
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc["_id"])
+	}
+	if !many {
+		return bson.D{{Key: "_id", Value: ids[0]}}, ids, nil
+	}
+	return bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}}}, ids, nil
+}
+
+// docID, return _id of document or nil
+func docID(doc interface{}) interface{} {
+	return documentID(reflect.ValueOf(doc))
+}
+
+// idList, return ids without nil values
+func idList(ids ...interface{}) []interface{} {
+	var list []interface{}
+	for _, id := range ids {
+		if id != nil {
+			list = append(list, id)
+		}
+	}
+	return list
+}
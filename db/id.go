@@ -0,0 +1,567 @@
+package lxDb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// IdGenerator, generates missing _id values of inserts and upserts,
+// set as option of NewMongoBaseRepo
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, lxDb.WithIdGenerator(lxDb.UUIDGenerator()))
+type IdGenerator interface {
+	NewID() interface{}
+}
+
+// IdGeneratorFunc, function as IdGenerator
+type IdGeneratorFunc func() interface{}
+
+// NewID, implements IdGenerator
+func (fn IdGeneratorFunc) NewID() interface{} {
+	return fn()
+}
+
+// ObjectIDGenerator, return generator of primitive.ObjectID
+func ObjectIDGenerator() IdGenerator {
+	return IdGeneratorFunc(func() interface{} { return primitive.NewObjectID() })
+}
+
+// UUIDGenerator, return generator of random UUID (version 4)
+func UUIDGenerator() IdGenerator {
+	return IdGeneratorFunc(func() interface{} { return NewUUID() })
+}
+
+// ULIDGenerator, return generator of monotonic ULID
+func ULIDGenerator() IdGenerator {
+	return IdGeneratorFunc(func() interface{} { return NewULID() })
+}
+
+// UUID, stored as binary subtype 4, encoded as string in json
+type UUID [16]byte
+
+// NewUUID, return random UUID version 4
+func NewUUID() UUID {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+// ParseUUID, parse UUID with or without hyphens
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return u, fmt.Errorf("%q, %w", s, ErrInvalidUUID)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+// String, return UUID in canonical form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// IsZero, return true for zero UUID
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+// MarshalBSONValue, implements bson.ValueMarshaler
+func (u UUID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.Binary, bsoncore.AppendBinary(nil, bsontype.BinaryUUID, u[:]), nil
+}
+
+// UnmarshalBSONValue, implements bson.ValueUnmarshaler,
+// accepts binary subtype 4 and 3 and strings
+func (u *UUID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.Binary:
+		subtype, b, _, ok := bsoncore.ReadBinary(data)
+		if !ok || len(b) != 16 || (subtype != bsontype.BinaryUUID && subtype != bsontype.BinaryUUIDOld) {
+			return ErrInvalidUUID
+		}
+		copy(u[:], b)
+		return nil
+	case bsontype.String:
+		s, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return ErrInvalidUUID
+		}
+		parsed, err := ParseUUID(s)
+		*u = parsed
+		return err
+	}
+	return fmt.Errorf("bson type %s, %w", t, ErrInvalidUUID)
+}
+
+// MarshalText, implements encoding.TextMarshaler
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText, implements encoding.TextUnmarshaler
+func (u *UUID) UnmarshalText(b []byte) error {
+	parsed, err := ParseUUID(string(b))
+	*u = parsed
+	return err
+}
+
+// crockford, base32 alphabet of ULID
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID, 48 bit milliseconds and 80 bit random, stored as 26 characters string,
+// sorting by string is sorting by time
+type ULID [16]byte
+
+var ulidState struct {
+	sync.Mutex
+	last ULID
+}
+
+// NewULID, return ULID of now, within the same millisecond
+// the random part is incremented for monotonic order
+func NewULID() ULID {
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	ulidState.Lock()
+	defer ulidState.Unlock()
+
+	var u ULID
+	u[0], u[1], u[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	u[3], u[4], u[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+
+	if string(u[:6]) != string(ulidState.last[:6]) {
+		if _, err := rand.Read(u[6:]); err != nil {
+			panic(err)
+		}
+	} else {
+		// Increment random part of last id
+		copy(u[6:], ulidState.last[6:])
+		for i := 15; i >= 6; i-- {
+			u[i]++
+			if u[i] != 0 {
+				break
+			}
+		}
+	}
+
+	ulidState.last = u
+	return u
+}
+
+// ParseULID, parse 26 characters ULID, case insensitive
+func ParseULID(s string) (ULID, error) {
+	var u ULID
+	if len(s) != 26 || s[0] > '7' {
+		return u, fmt.Errorf("%q, %w", s, ErrInvalidULID)
+	}
+
+	// 26 * 5 bit = 130 bit, the first 2 bits are always zero
+	var carry uint
+	var bits uint
+	pos := 15
+	for i := len(s) - 1; i >= 0; i-- {
+		v := strings.IndexByte(crockford, upper(s[i]))
+		if v < 0 {
+			return ULID{}, fmt.Errorf("%q, %w", s, ErrInvalidULID)
+		}
+		carry |= uint(v) << bits
+		bits += 5
+		for bits >= 8 && pos >= 0 {
+			u[pos] = byte(carry)
+			carry >>= 8
+			bits -= 8
+			pos--
+		}
+	}
+	if pos >= 0 {
+		u[pos] = byte(carry)
+	}
+	return u, nil
+}
+
+// upper, return upper case of ascii letter
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// String, return ULID as 26 characters Crockford base32
+func (u ULID) String() string {
+	out := make([]byte, 26)
+	var carry uint
+	var bits uint
+	pos := 25
+	for i := 15; i >= 0; i-- {
+		carry |= uint(u[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[pos] = crockford[carry&31]
+			carry >>= 5
+			bits -= 5
+			pos--
+		}
+	}
+	out[0] = crockford[carry&31]
+	return string(out)
+}
+
+// Time, return timestamp of ULID
+func (u ULID) Time() time.Time {
+	ms := int64(u[0])<<40 | int64(u[1])<<32 | int64(u[2])<<24 | int64(u[3])<<16 | int64(u[4])<<8 | int64(u[5])
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+// IsZero, return true for zero ULID
+func (u ULID) IsZero() bool {
+	return u == ULID{}
+}
+
+// MarshalBSONValue, implements bson.ValueMarshaler
+func (u ULID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.String, bsoncore.AppendString(nil, u.String()), nil
+}
+
+// UnmarshalBSONValue, implements bson.ValueUnmarshaler,
+// accepts strings and binary with 16 bytes
+func (u *ULID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.String:
+		s, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return ErrInvalidULID
+		}
+		parsed, err := ParseULID(s)
+		*u = parsed
+		return err
+	case bsontype.Binary:
+		_, b, _, ok := bsoncore.ReadBinary(data)
+		if !ok || len(b) != 16 {
+			return ErrInvalidULID
+		}
+		copy(u[:], b)
+		return nil
+	}
+	return fmt.Errorf("bson type %s, %w", t, ErrInvalidULID)
+}
+
+// MarshalText, implements encoding.TextMarshaler
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText, implements encoding.TextUnmarshaler
+func (u *ULID) UnmarshalText(b []byte) error {
+	parsed, err := ParseULID(string(b))
+	*u = parsed
+	return err
+}
+
+// SnowflakeEpoch, epoch of snowflake ids
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// snowflake, 41 bit milliseconds since SnowflakeEpoch, 10 bit node and 12 bit sequence
+type snowflake struct {
+	mux  sync.Mutex
+	node int64
+	ms   int64
+	seq  int64
+}
+
+// SnowflakeGenerator, return generator of int64 snowflake ids, node between 0 and 1023
+func SnowflakeGenerator(node int64) (IdGenerator, error) {
+	if node < 0 || node > 1023 {
+		return nil, fmt.Errorf("%d, %w", node, ErrSnowflakeNode)
+	}
+	return &snowflake{node: node}, nil
+}
+
+// NewID, implements IdGenerator
+func (s *snowflake) NewID() interface{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ms := time.Since(SnowflakeEpoch).Milliseconds()
+	if ms < s.ms {
+		// Clock moved backwards, continue with last millisecond
+		ms = s.ms
+	}
+	if ms == s.ms {
+		s.seq = (s.seq + 1) & 4095
+		if s.seq == 0 {
+			// Sequence exhausted, wait for next millisecond
+			for ms <= s.ms {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Since(SnowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.ms = ms
+
+	return ms<<22 | s.node<<12 | s.seq
+}
+
+// ensureID, return copy of doc with generated _id when doc has no or an empty _id,
+// doc of the caller is not changed
+func (repo *mongoBaseRepo) ensureID(doc interface{}) (interface{}, error) {
+	if repo.idGenerator == nil {
+		return doc, nil
+	}
+
+	switch d := doc.(type) {
+	case bson.M:
+		if !isEmptyID(d["_id"]) {
+			return d, nil
+		}
+		out := make(bson.M, len(d)+1)
+		for key, val := range d {
+			out[key] = val
+		}
+		out["_id"] = repo.idGenerator.NewID()
+		return out, nil
+	case bson.D:
+		return repo.ensureIDDoc(d), nil
+	case *bson.D:
+		out := repo.ensureIDDoc(*d)
+		return &out, nil
+	}
+
+	// Pointer to struct with _id field
+	v := reflect.ValueOf(doc)
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct {
+		if field, ok := idField(v.Elem()); ok {
+			if !field.CanInterface() || !isEmptyID(field.Interface()) {
+				return doc, nil
+			}
+			id := reflect.ValueOf(repo.idGenerator.NewID())
+			if field.Kind() == reflect.Ptr && id.Type().AssignableTo(field.Type().Elem()) {
+				// Pointer _id field, e.g. *lxDb.UUID
+				ptr := reflect.New(field.Type().Elem())
+				ptr.Elem().Set(id)
+				id = ptr
+			}
+			if !id.Type().AssignableTo(field.Type()) {
+				return nil, fmt.Errorf("%s to %s, %w", id.Type(), field.Type(), ErrIdType)
+			}
+			out := reflect.New(v.Elem().Type())
+			out.Elem().Set(v.Elem())
+			field, _ = idField(out.Elem())
+			field.Set(id)
+			return out.Interface(), nil
+		}
+	}
+
+	// Other documents are converted
+	d, err := ToBsonDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ensureIDDoc(*d), nil
+}
+
+// insertedID, return _id of doc with type of generator, the driver returns
+// ids like UUID as primitive.Binary, id is returned without generator or _id
+func (repo *mongoBaseRepo) insertedID(doc, id interface{}) interface{} {
+	if repo.idGenerator == nil {
+		return id
+	}
+
+	var docID interface{}
+	switch d := doc.(type) {
+	case bson.M:
+		docID = d["_id"]
+	case bson.D:
+		docID = d.Map()["_id"]
+	case *bson.D:
+		docID = d.Map()["_id"]
+	default:
+		v := reflect.ValueOf(doc)
+		if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct {
+			if field, ok := idField(v.Elem()); ok && field.CanInterface() {
+				if field.Kind() == reflect.Ptr && !field.IsNil() {
+					field = field.Elem()
+				}
+				docID = field.Interface()
+			}
+		}
+	}
+
+	if isEmptyID(docID) {
+		return id
+	}
+	return docID
+}
+
+// ensureIDDoc, return doc with _id as first element
+func (repo *mongoBaseRepo) ensureIDDoc(doc bson.D) bson.D {
+	for _, e := range doc {
+		if e.Key == "_id" && !isEmptyID(e.Value) {
+			return doc
+		}
+	}
+
+	out := make(bson.D, 0, len(doc)+1)
+	out = append(out, bson.E{Key: "_id", Value: repo.idGenerator.NewID()})
+	for _, e := range doc {
+		if e.Key != "_id" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// upsertUpdate, add generated _id with $setOnInsert to update document,
+// unchanged when filter has _id or update is a pipeline
+func (repo *mongoBaseRepo) upsertUpdate(filter, update interface{}) interface{} {
+	if repo.idGenerator == nil || hasID(filter) {
+		return update
+	}
+
+	var doc bson.D
+	switch u := update.(type) {
+	case bson.D:
+		doc = append(bson.D{}, u...)
+	case bson.M:
+		for _, key := range sortedMapKeys(u) {
+			doc = append(doc, bson.E{Key: key, Value: u[key]})
+		}
+	default:
+		d, err := ToBsonDoc(update)
+		if err != nil {
+			return update
+		}
+		doc = *d
+	}
+
+	for i, e := range doc {
+		if e.Key != "$setOnInsert" {
+			continue
+		}
+		setOnInsert, err := ToBsonDoc(e.Value)
+		if err != nil || hasID(*setOnInsert) {
+			return update
+		}
+		doc[i].Value = append(bson.D{{Key: "_id", Value: repo.idGenerator.NewID()}}, *setOnInsert...)
+		return doc
+	}
+
+	return append(doc, bson.E{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: repo.idGenerator.NewID()}}})
+}
+
+// upsertReplacement, return replacement with _id of existing document or generated _id,
+// a replacement can't change the _id of an existing document
+func (repo *mongoBaseRepo) upsertReplacement(filter, replacement interface{}, sortOrder interface{}) (interface{}, error) {
+	if repo.idGenerator == nil || hasID(filter) {
+		return replacement, nil
+	}
+
+	doc, err := ToBsonDoc(replacement)
+	if err != nil {
+		return nil, err
+	}
+	if hasID(*doc) {
+		return replacement, nil
+	}
+
+	// Keep id of existing document
+	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	if sortOrder != nil {
+		opts.SetSort(sortOrder)
+	}
+	var existing bson.M
+	if err := repo.FindOne(filter, &existing, opts); err == nil {
+		return append(bson.D{{Key: "_id", Value: existing["_id"]}}, *doc...), nil
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+
+	return repo.ensureIDDoc(*doc), nil
+}
+
+// idField, return _id field of struct
+func idField(v reflect.Value) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]; name == "_id" {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// hasID, return true when document has a not empty _id
+func hasID(doc interface{}) bool {
+	switch d := doc.(type) {
+	case nil:
+		return false
+	case bson.M:
+		return !isEmptyID(d["_id"])
+	case bson.D:
+		for _, e := range d {
+			if e.Key == "_id" {
+				return !isEmptyID(e.Value)
+			}
+		}
+		return false
+	}
+
+	d, err := ToBsonDoc(doc)
+	if err != nil {
+		return false
+	}
+	return hasID(*d)
+}
+
+// isEmptyID, return true for nil, empty string, nil pointers and zero values
+// with IsZero method like ObjectID, UUID and ULID, other values like 0 are valid ids
+func isEmptyID(id interface{}) bool {
+	if id == nil {
+		return true
+	}
+	if v := reflect.ValueOf(id); v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return true
+		}
+		return isEmptyID(v.Elem().Interface())
+	}
+
+	switch val := id.(type) {
+	case string:
+		return val == ""
+	case interface{ IsZero() bool }:
+		return val.IsZero()
+	}
+	return false
+}
+
+// sortedMapKeys, return sorted keys of bson.M
+func sortedMapKeys(m bson.M) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
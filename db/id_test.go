@@ -0,0 +1,154 @@
+package lxDb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUUID(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("version", func(t *testing.T) {
+		u := lxDb.NewUUID()
+		its.False(u.IsZero())
+		its.Equal(byte(0x40), u[6]&0xf0)
+		its.Equal(byte(0x80), u[8]&0xc0)
+		its.NotEqual(u, lxDb.NewUUID())
+	})
+	t.Run("parse", func(t *testing.T) {
+		u, err := lxDb.ParseUUID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		its.NoError(err)
+		its.Equal("6ba7b810-9dad-11d1-80b4-00c04fd430c8", u.String())
+
+		u2, err := lxDb.ParseUUID("6ba7b8109dad11d180b400c04fd430c8")
+		its.NoError(err)
+		its.Equal(u, u2)
+
+		_, err = lxDb.ParseUUID("6ba7b810")
+		its.True(errors.Is(err, lxDb.ErrInvalidUUID))
+	})
+	t.Run("bson", func(t *testing.T) {
+		u := lxDb.NewUUID()
+		data, err := bson.Marshal(bson.M{"_id": u})
+		its.NoError(err)
+
+		var raw bson.M
+		its.NoError(bson.Unmarshal(data, &raw))
+		bin, ok := raw["_id"].(primitive.Binary)
+		its.True(ok)
+		its.Equal(byte(4), bin.Subtype)
+		its.Equal(u[:], bin.Data)
+
+		var doc struct {
+			ID lxDb.UUID `bson:"_id"`
+		}
+		its.NoError(bson.Unmarshal(data, &doc))
+		its.Equal(u, doc.ID)
+
+		// From string
+		data, err = bson.Marshal(bson.M{"_id": u.String()})
+		its.NoError(err)
+		its.NoError(bson.Unmarshal(data, &doc))
+		its.Equal(u, doc.ID)
+	})
+	t.Run("json", func(t *testing.T) {
+		u := lxDb.NewUUID()
+		data, err := json.Marshal(u)
+		its.NoError(err)
+		its.Equal(`"`+u.String()+`"`, string(data))
+
+		var u2 lxDb.UUID
+		its.NoError(json.Unmarshal(data, &u2))
+		its.Equal(u, u2)
+	})
+}
+
+func TestULID(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("monotonic", func(t *testing.T) {
+		last := lxDb.NewULID()
+		for i := 0; i < 1000; i++ {
+			u := lxDb.NewULID()
+			its.True(u.String() > last.String())
+			last = u
+		}
+		its.WithinDuration(time.Now(), last.Time(), time.Second)
+	})
+	t.Run("parse", func(t *testing.T) {
+		u := lxDb.NewULID()
+		s := u.String()
+		its.Len(s, 26)
+
+		u2, err := lxDb.ParseULID(s)
+		its.NoError(err)
+		its.Equal(u, u2)
+
+		_, err = lxDb.ParseULID("01ARZ3NDEKTSV4RRFFQ69G5FA")
+		its.True(errors.Is(err, lxDb.ErrInvalidULID))
+		_, err = lxDb.ParseULID("01ARZ3NDEKTSV4RRFFQ69G5FAU")
+		its.True(errors.Is(err, lxDb.ErrInvalidULID))
+		_, err = lxDb.ParseULID("81ARZ3NDEKTSV4RRFFQ69G5FAV")
+		its.True(errors.Is(err, lxDb.ErrInvalidULID))
+
+		u3, err := lxDb.ParseULID("01arz3ndektsv4rrffq69g5fav")
+		its.NoError(err)
+		its.Equal("01ARZ3NDEKTSV4RRFFQ69G5FAV", u3.String())
+		its.Equal(int64(1469922850259), u3.Time().UnixNano()/int64(time.Millisecond))
+	})
+	t.Run("bson", func(t *testing.T) {
+		u := lxDb.NewULID()
+		data, err := bson.Marshal(bson.M{"_id": u})
+		its.NoError(err)
+
+		var raw bson.M
+		its.NoError(bson.Unmarshal(data, &raw))
+		its.Equal(u.String(), raw["_id"])
+
+		var doc struct {
+			ID lxDb.ULID `bson:"_id"`
+		}
+		its.NoError(bson.Unmarshal(data, &doc))
+		its.Equal(u, doc.ID)
+	})
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	its := assert.New(t)
+
+	_, err := lxDb.SnowflakeGenerator(1024)
+	its.True(errors.Is(err, lxDb.ErrSnowflakeNode))
+
+	gen, err := lxDb.SnowflakeGenerator(7)
+	its.NoError(err)
+
+	seen := make(map[int64]bool)
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id := gen.NewID().(int64)
+		its.False(seen[id])
+		its.True(id > last)
+		its.Equal(int64(7), (id>>12)&1023)
+		seen[id] = true
+		last = id
+	}
+}
+
+func TestIdGenerator(t *testing.T) {
+	its := assert.New(t)
+
+	_, ok := lxDb.ObjectIDGenerator().NewID().(primitive.ObjectID)
+	its.True(ok)
+	_, ok = lxDb.UUIDGenerator().NewID().(lxDb.UUID)
+	its.True(ok)
+	_, ok = lxDb.ULIDGenerator().NewID().(lxDb.ULID)
+	its.True(ok)
+	its.Equal("id", lxDb.IdGeneratorFunc(func() interface{} { return "id" }).NewID())
+}
@@ -7,6 +7,7 @@ package lxDbMocks
 import (
 	gomock "github.com/golang/mock/gomock"
 	lxDb "github.com/litixsoft/lxgo/db"
+	lxHelper "github.com/litixsoft/lxgo/helper"
 	reflect "reflect"
 )
 
@@ -246,6 +247,25 @@ func (mr *MockIBaseRepoMockRecorder) UpdateOne(filter, update interface{}, args
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOne", reflect.TypeOf((*MockIBaseRepo)(nil).UpdateOne), varargs...)
 }
 
+// ReplaceOne mocks base method
+func (m *MockIBaseRepo) ReplaceOne(filter, replacement interface{}, args ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{filter, replacement}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ReplaceOne", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceOne indicates an expected call of ReplaceOne
+func (mr *MockIBaseRepoMockRecorder) ReplaceOne(filter, replacement interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{filter, replacement}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceOne", reflect.TypeOf((*MockIBaseRepo)(nil).ReplaceOne), varargs...)
+}
+
 // UpdateMany mocks base method
 func (m *MockIBaseRepo) UpdateMany(filter, update interface{}, args ...interface{}) (*lxDb.UpdateManyResult, error) {
 	m.ctrl.T.Helper()
@@ -378,6 +398,46 @@ func (mr *MockIBaseRepoMockRecorder) Aggregate(pipeline, result interface{}, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Aggregate", reflect.TypeOf((*MockIBaseRepo)(nil).Aggregate), varargs...)
 }
 
+// Distinct mocks base method
+func (m *MockIBaseRepo) Distinct(fieldName string, filter interface{}, args ...interface{}) ([]interface{}, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{fieldName, filter}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Distinct", varargs...)
+	ret0, _ := ret[0].([]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Distinct indicates an expected call of Distinct
+func (mr *MockIBaseRepoMockRecorder) Distinct(fieldName, filter interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{fieldName, filter}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Distinct", reflect.TypeOf((*MockIBaseRepo)(nil).Distinct), varargs...)
+}
+
+// FindWithCount mocks base method
+func (m *MockIBaseRepo) FindWithCount(filter, result interface{}, findOptions *lxHelper.FindOptions, args ...interface{}) (*lxDb.FindWithCountResult, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{filter, result, findOptions}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "FindWithCount", varargs...)
+	ret0, _ := ret[0].(*lxDb.FindWithCountResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindWithCount indicates an expected call of FindWithCount
+func (mr *MockIBaseRepoMockRecorder) FindWithCount(filter, result, findOptions interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{filter, result, findOptions}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindWithCount", reflect.TypeOf((*MockIBaseRepo)(nil).FindWithCount), varargs...)
+}
+
 // MockIBaseRepoAudit is a mock of IBaseRepoAudit interface
 type MockIBaseRepoAudit struct {
 	ctrl     *gomock.Controller
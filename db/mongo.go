@@ -3,12 +3,14 @@ package lxDb
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/go-cmp/cmp"
+	lxHelper "github.com/litixsoft/lxgo/helper"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -17,51 +19,85 @@ const (
 	Insert         = "insert"
 	Update         = "update"
 	Delete         = "delete"
+	Purge          = "purge"
+	Archive        = "archive"
+	Read           = "read"
 )
 
 type mongoBaseRepo struct {
-	collection *mongo.Collection
-	audit      IBaseRepoAudit
-	locale     *string
+	collection  *mongo.Collection
+	audit       IBaseRepoAudit
+	locale      *string
+	readAudit   *readAuditor
+	idGenerator IdGenerator
+	hooks       *Hooks
+	validation  *SchemaValidation
+	history     *history
+	relations   *Relations
+	txOnce      sync.Once
+	txSupported bool
 }
 
-// NewMongoBaseRepo, return base repo instance
-func NewMongoBaseRepo(collection *mongo.Collection, baseRepoAudit ...IBaseRepoAudit) IBaseRepo {
-	// Default audit is nil
-	var audit IBaseRepoAudit
+// RepoOption, option of NewMongoBaseRepo, implemented by *ReadAuditOptions, *Hooks,
+// *SchemaValidation, *HistoryOptions, *Relations and the options of WithAudit and WithIdGenerator
+type RepoOption interface {
+	applyRepo(repo *mongoBaseRepo)
+}
 
-	// Optional audit in args
-	if len(baseRepoAudit) > 0 {
-		audit = baseRepoAudit[0]
-	}
+// repoOptionFunc, function as RepoOption
+type repoOptionFunc func(repo *mongoBaseRepo)
 
-	return &mongoBaseRepo{
-		collection: collection,
-		audit:      audit,
-		locale:     nil,
-	}
+// applyRepo, implements RepoOption
+func (fn repoOptionFunc) applyRepo(repo *mongoBaseRepo) {
+	fn(repo)
 }
 
-// GetMongoDbClient, return new mongo driver client
-func GetMongoDbClient(uri string) (client *mongo.Client, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// WithAudit, return option which sends audit entries of repo to audit
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, lxDb.WithAudit(audit))
+func WithAudit(audit IBaseRepoAudit) RepoOption {
+	return repoOptionFunc(func(repo *mongoBaseRepo) { repo.audit = audit })
+}
 
-	client, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
-	if err != nil {
-		return
+// WithIdGenerator, return option which generates missing _id values with gen
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, lxDb.WithIdGenerator(lxDb.UUIDGenerator()))
+func WithIdGenerator(gen IdGenerator) RepoOption {
+	return repoOptionFunc(func(repo *mongoBaseRepo) { repo.idGenerator = gen })
+}
+
+// NewMongoBaseRepo, return base repo instance with options,
+// opts are RepoOption, IBaseRepoAudit or IdGenerator values, other types are ignored
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, audit, &lxDb.HistoryOptions{MaxRevisions: 50})
+func NewMongoBaseRepo(collection *mongo.Collection, opts ...interface{}) IBaseRepo {
+	repo := &mongoBaseRepo{
+		collection: collection,
+		locale:     nil,
 	}
 
-	// Check connection
-	err = client.Ping(ctx, readpref.Primary())
-	if err != nil {
-		return client, err
+	for _, opt := range opts {
+		switch val := opt.(type) {
+		case RepoOption:
+			val.applyRepo(repo)
+		case IBaseRepoAudit:
+			repo.audit = val
+		case IdGenerator:
+			repo.idGenerator = val
+		}
 	}
 
-	return client, nil
+	return repo
+}
+
+// GetMongoDbClient, return new mongo driver client
+// For pool sizes, tls, compression, concerns and monitoring see NewMongoClient
+func GetMongoDbClient(uri string) (client *mongo.Client, err error) {
+	return NewMongoClient(&MongoClientConfig{URI: uri})
 }
 
-// CreateIndexes, creates multiple indexes in the collection.
+// CreateIndexes, creates multiple indexes in the collection,
+// indexes are []mongo.IndexModel or a single mongo.IndexModel like GeoIndex.
 // The names of the created indexes are returned.
 func (repo *mongoBaseRepo) CreateIndexes(indexes interface{}, args ...interface{}) ([]string, error) {
 	timeout := DefaultTimeout
@@ -76,9 +112,14 @@ func (repo *mongoBaseRepo) CreateIndexes(indexes interface{}, args ...interface{
 		}
 	}
 
-	// Convert indexModels
-	indexModels, ok := indexes.([]mongo.IndexModel)
-	if !ok {
+	// Convert indexModels, single model e.g. of GeoIndex
+	var indexModels []mongo.IndexModel
+	switch val := indexes.(type) {
+	case []mongo.IndexModel:
+		indexModels = val
+	case mongo.IndexModel:
+		indexModels = []mongo.IndexModel{val}
+	default:
 		return []string{}, ErrIndexConvert
 	}
 
@@ -94,6 +135,7 @@ func (repo *mongoBaseRepo) InsertOne(doc interface{}, args ...interface{}) (inte
 	timeout := DefaultTimeout
 	opts := &options.InsertOneOptions{}
 	var authUser interface{}
+	var skipHooks, skipValidation bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -103,6 +145,33 @@ func (repo *mongoBaseRepo) InsertOne(doc interface{}, args ...interface{}) (inte
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
+		}
+	}
+
+	// Before hooks
+	hc := repo.hookContext(HookInsert, "InsertOne", authUser, skipHooks)
+	if hc != nil {
+		hc.Doc = doc
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return nil, err
+		}
+		doc = hc.Doc
+	}
+
+	// Generate missing _id
+	doc, err := repo.ensureID(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Schema validation
+	if repo.validationActive(skipValidation) {
+		if err := repo.validateDoc(doc, 0); err != nil {
+			return nil, err
 		}
 	}
 
@@ -113,6 +182,7 @@ func (repo *mongoBaseRepo) InsertOne(doc interface{}, args ...interface{}) (inte
 	if err != nil {
 		return nil, err
 	}
+	res.InsertedID = repo.insertedID(doc, res.InsertedID)
 
 	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
 
@@ -136,6 +206,7 @@ func (repo *mongoBaseRepo) InsertOne(doc interface{}, args ...interface{}) (inte
 		})
 	}
 
+	repo.runAfterHooks(hc, res.InsertedID, idList(res.InsertedID))
 	return res.InsertedID, nil
 }
 
@@ -144,6 +215,7 @@ func (repo *mongoBaseRepo) InsertMany(docs []interface{}, args ...interface{}) (
 	timeout := DefaultTimeout
 	opts := &options.InsertManyOptions{}
 	var authUser interface{}
+	var skipHooks, skipValidation bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -153,12 +225,47 @@ func (repo *mongoBaseRepo) InsertMany(docs []interface{}, args ...interface{}) (
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
 		}
 	}
 
 	// Return UpdateManyResult
 	insertManyResult := new(InsertManyResult)
 
+	// Before hooks
+	hc := repo.hookContext(HookInsert, "InsertMany", authUser, skipHooks)
+	if hc != nil {
+		hc.Docs = docs
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return insertManyResult, err
+		}
+		docs = hc.Docs
+	}
+
+	// Generate missing _ids, without changing the given slice and documents
+	if repo.idGenerator != nil {
+		withIDs := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			var err error
+			if withIDs[i], err = repo.ensureID(doc); err != nil {
+				return insertManyResult, err
+			}
+		}
+		docs = withIDs
+	}
+
+	// Schema validation
+	if repo.validationActive(skipValidation) {
+		for i, doc := range docs {
+			if err := repo.validateDoc(doc, i); err != nil {
+				return insertManyResult, err
+			}
+		}
+	}
+
 	// Audit with insert, when audit is active than insert one and audit
 	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
 		// InsertOne func for audit insert many
@@ -180,6 +287,7 @@ func (repo *mongoBaseRepo) InsertMany(docs []interface{}, args ...interface{}) (
 				insertManyResult.FailedCount++
 			} else {
 				// Add inserted id
+				res.InsertedID = repo.insertedID(doc, res.InsertedID)
 				insertManyResult.InsertedIDs = append(insertManyResult.InsertedIDs, res.InsertedID)
 
 				// Convert for audit
@@ -210,6 +318,7 @@ func (repo *mongoBaseRepo) InsertMany(docs []interface{}, args ...interface{}) (
 		// Send to audit
 		repo.audit.Send(auditEntries)
 
+		repo.runAfterHooks(hc, insertManyResult, insertManyResult.InsertedIDs)
 		return insertManyResult, nil
 	}
 
@@ -224,8 +333,14 @@ func (repo *mongoBaseRepo) InsertMany(docs []interface{}, args ...interface{}) (
 	// Convert
 	if res != nil {
 		insertManyResult.InsertedIDs = res.InsertedIDs
+		for i := range insertManyResult.InsertedIDs {
+			if i < len(docs) {
+				insertManyResult.InsertedIDs[i] = repo.insertedID(docs[i], insertManyResult.InsertedIDs[i])
+			}
+		}
 	}
 
+	repo.runAfterHooks(hc, insertManyResult, insertManyResult.InsertedIDs)
 	return insertManyResult, nil
 }
 
@@ -235,6 +350,7 @@ func (repo *mongoBaseRepo) CountDocuments(filter interface{}, args ...interface{
 	// Default values
 	timeout := DefaultTimeout
 	opts := &options.CountOptions{}
+	var authUser interface{}
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -242,13 +358,20 @@ func (repo *mongoBaseRepo) CountDocuments(filter interface{}, args ...interface{
 			timeout = val
 		case *options.CountOptions:
 			opts = val
+		case *AuditAuth:
+			authUser = val.User
 		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	return repo.collection.CountDocuments(ctx, filter, opts)
+	count, err := repo.collection.CountDocuments(ctx, filter, opts)
+	if err == nil && repo.readAuditActive(authUser) {
+		repo.auditRead(authUser, "count", filter, nil, count)
+	}
+
+	return count, err
 }
 
 // EstimatedDocumentCount gets an estimate of the count of documents in a collection using collection metadata.
@@ -277,6 +400,8 @@ func (repo *mongoBaseRepo) Find(filter interface{}, result interface{}, args ...
 	// Default values
 	timeout := DefaultTimeout
 	opts := &options.FindOptions{}
+	var authUser interface{}
+	var populates []*PopulateOptions
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -284,6 +409,10 @@ func (repo *mongoBaseRepo) Find(filter interface{}, result interface{}, args ...
 			timeout = val
 		case *options.FindOptions:
 			opts = val
+		case *AuditAuth:
+			authUser = val.User
+		case *PopulateOptions:
+			populates = append(populates, val)
 		}
 	}
 
@@ -301,7 +430,27 @@ func (repo *mongoBaseRepo) Find(filter interface{}, result interface{}, args ...
 		return err
 	}
 
-	return cur.All(ctx, result)
+	if len(populates) > 0 {
+		// Populate references before decode
+		var docs []bson.M
+		if err := cur.All(ctx, &docs); err != nil {
+			return err
+		}
+		if err := repo.populate(ctx, docs, populates); err != nil {
+			return err
+		}
+		if err := decodeDocs(docs, result); err != nil {
+			return err
+		}
+	} else if err := cur.All(ctx, result); err != nil {
+		return err
+	}
+
+	if repo.readAuditActive(authUser) {
+		repo.auditRead(authUser, "find", filter, result, 0)
+	}
+
+	return nil
 }
 
 // Find, find all matched by filter
@@ -309,6 +458,8 @@ func (repo *mongoBaseRepo) FindOne(filter interface{}, result interface{}, args
 	// Default values
 	timeout := DefaultTimeout
 	opts := &options.FindOneOptions{}
+	var authUser interface{}
+	var populates []*PopulateOptions
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -316,6 +467,10 @@ func (repo *mongoBaseRepo) FindOne(filter interface{}, result interface{}, args
 			timeout = val
 		case *options.FindOneOptions:
 			opts = val
+		case *AuditAuth:
+			authUser = val.User
+		case *PopulateOptions:
+			populates = append(populates, val)
 		}
 	}
 
@@ -329,14 +484,38 @@ func (repo *mongoBaseRepo) FindOne(filter interface{}, result interface{}, args
 	defer cancel()
 
 	// Find and convert no documents error
-	err := repo.collection.FindOne(ctx, filter, opts).Decode(result)
-	if err != nil {
+	sr := repo.collection.FindOne(ctx, filter, opts)
+	if err := sr.Err(); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return ErrNotFound
 		}
 		return err
 	}
 
+	if len(populates) > 0 {
+		// Populate references before decode
+		var doc bson.M
+		if err := sr.Decode(&doc); err != nil {
+			return err
+		}
+		if err := repo.populate(ctx, []bson.M{doc}, populates); err != nil {
+			return err
+		}
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := bson.Unmarshal(data, result); err != nil {
+			return err
+		}
+	} else if err := sr.Decode(result); err != nil {
+		return err
+	}
+
+	if repo.readAuditActive(authUser) {
+		repo.auditRead(authUser, "findOne", filter, result, 0)
+	}
+
 	return nil
 }
 
@@ -346,6 +525,7 @@ func (repo *mongoBaseRepo) FindOneAndDelete(filter interface{}, result interface
 	timeout := DefaultTimeout
 	opts := &options.FindOneAndDeleteOptions{}
 	var authUser interface{}
+	var skipHooks bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -355,6 +535,8 @@ func (repo *mongoBaseRepo) FindOneAndDelete(filter interface{}, result interface
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
 		}
 	}
 
@@ -364,6 +546,22 @@ func (repo *mongoBaseRepo) FindOneAndDelete(filter interface{}, result interface
 		})
 	}
 
+	// Before hooks
+	hc := repo.hookContext(HookDelete, "FindOneAndDelete", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, opts.Sort, timeout)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -391,6 +589,10 @@ func (repo *mongoBaseRepo) FindOneAndDelete(filter interface{}, result interface
 		})
 	}
 
+	if err := repo.recordHistory(previous, HistoryDelete, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, result, idList(docID(result)))
 	return nil
 }
 
@@ -400,6 +602,7 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 	timeout := DefaultTimeout
 	opts := options.FindOneAndReplace()
 	var authUser interface{}
+	var skipHooks, skipValidation bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -409,6 +612,10 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
 		}
 	}
 
@@ -418,6 +625,39 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 		})
 	}
 
+	// Before hooks
+	hc := repo.hookContext(HookUpdate, "FindOneAndReplace", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		hc.Doc = replacement
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+		replacement = hc.Doc
+	}
+
+	// Keep or generate _id for upsert
+	if isUpsert(opts.Upsert) {
+		var err error
+		if replacement, err = repo.upsertReplacement(filter, replacement, opts.Sort); err != nil {
+			return err
+		}
+	}
+
+	// Schema validation
+	if repo.validationActive(skipValidation) {
+		if err := repo.validateDoc(replacement, 0); err != nil {
+			return err
+		}
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, opts.Sort, timeout)
+	if err != nil {
+		return err
+	}
+
 	// Audit only with options.After
 	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
 		// Check and set options
@@ -429,7 +669,8 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 		// Check option and replace with audit
 		switch *opts.ReturnDocument {
 		case options.After:
-			// Save doc before replace for compare
+			// Save doc before replace for compare,
+			// with upsert a not found doc will be inserted
 			var beforeReplace bson.M
 			// Set FindOne options
 			findOneOpts := options.FindOne()
@@ -438,7 +679,9 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 				findOneOpts.SetSort(opts.Sort)
 			}
 			if err := repo.FindOne(filter, &beforeReplace, findOneOpts); err != nil {
-				return err
+				if !errors.Is(err, ErrNotFound) || !isUpsert(opts.Upsert) {
+					return err
+				}
 			}
 
 			// FindOne and update
@@ -455,16 +698,30 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 				return err
 			}
 			// Compare and audit
-			if !cmp.Equal(beforeReplace, afterReplace) {
-				// Send to audit
-				repo.audit.Send(bson.M{
-					"collection": repo.collection.Name(),
-					"action":     Update,
-					"user":       authUser,
-					"data":       afterReplace,
-				})
+			repo.auditUpsert(authUser, beforeReplace, afterReplace)
+			if err := repo.recordHistory(previous, HistoryReplace, authUser, timeout); err != nil {
+				return err
 			}
+			repo.runAfterHooks(hc, result, idList(afterReplace["_id"]), HookImage{Before: beforeReplace, After: afterReplace})
 		case options.Before:
+			// With upsert and not existing doc, replace returns no document,
+			// the inserted doc is returned with options.After for audit
+			if isUpsert(opts.Upsert) {
+				inserted, err := repo.upsertIfNotExists(filter, opts.Sort, func(ctx context.Context, after *bson.M) error {
+					afterOpts := *opts
+					afterOpts.SetReturnDocument(options.After)
+					return repo.collection.FindOneAndReplace(ctx, filter, replacement, &afterOpts).Decode(after)
+				}, timeout)
+				if err != nil {
+					return err
+				}
+				if inserted != nil {
+					repo.auditUpsert(authUser, nil, inserted)
+					repo.runAfterHooks(hc, nil, idList(inserted["_id"]), HookImage{After: inserted})
+					return ErrNotFound
+				}
+			}
+
 			// FindOne and replace
 			ctx, cancel := context.WithTimeout(context.Background(), timeout)
 			defer cancel()
@@ -498,6 +755,10 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 					"data":       afterReplace,
 				})
 			}
+			if err := repo.recordHistory(previous, HistoryReplace, authUser, timeout); err != nil {
+				return err
+			}
+			repo.runAfterHooks(hc, result, idList(afterReplace["_id"]), HookImage{Before: beforeReplace, After: afterReplace})
 		}
 
 		return nil
@@ -514,6 +775,10 @@ func (repo *mongoBaseRepo) FindOneAndReplace(filter, replacement, result interfa
 		return err
 	}
 
+	if err := repo.recordHistory(previous, HistoryReplace, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, result, idList(docID(result)))
 	return nil
 }
 
@@ -523,6 +788,7 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 	timeout := DefaultTimeout
 	opts := &options.FindOneAndUpdateOptions{}
 	var authUser interface{}
+	var skipHooks, skipValidation bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -532,6 +798,10 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
 		}
 	}
 
@@ -541,8 +811,92 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 		})
 	}
 
+	// Before hooks
+	hc := repo.hookContext(HookUpdate, "FindOneAndUpdate", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		hc.Update = update
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+		update = hc.Update
+	}
+
+	// Generate _id for upsert
+	if isUpsert(opts.Upsert) {
+		update = repo.upsertUpdate(filter, update)
+	}
+
+	// Schema validation of updated documents, with transactions the update is validated on write
+	validateTx := false
+	updateOpts := options.Update()
+	if repo.validationActive(skipValidation) {
+		updateOpts.ArrayFilters = opts.ArrayFilters
+		updateOpts.BypassDocumentValidation = opts.BypassDocumentValidation
+		updateOpts.Collation = opts.Collation
+		updateOpts.Hint = opts.Hint
+		updateOpts.Upsert = opts.Upsert
+		if validateTx = repo.transactionsSupported(timeout); !validateTx {
+			if err := repo.validateUpdate(filter, update, updateOpts, opts.Sort, false, timeout); err != nil {
+				return err
+			}
+		}
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, opts.Sort, timeout)
+	if err != nil {
+		return err
+	}
+
+	auditActive := authUser != nil && repo.audit != nil && repo.audit.IsActive()
+
+	// Update and validation in transaction
+	if validateTx {
+		res, err := repo.updateValidated(filter, update, updateOpts, opts.Sort, false, true, timeout)
+		if err != nil {
+			return err
+		}
+		if len(res.IDs) == 0 {
+			return ErrNotFound
+		}
+		beforeUpdate, afterUpdate := res.image(0)
+
+		// Audit returns document after update by default
+		returnAfter := opts.ReturnDocument != nil && *opts.ReturnDocument == options.After
+		if auditActive && opts.ReturnDocument == nil {
+			returnAfter = true
+		}
+		doc := beforeUpdate
+		if returnAfter {
+			doc = afterUpdate
+		}
+
+		if auditActive {
+			repo.auditUpsert(authUser, beforeUpdate, afterUpdate)
+		}
+		if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+			return err
+		}
+		if doc == nil {
+			repo.runAfterHooks(hc, nil, res.IDs, HookImage{Before: beforeUpdate, After: afterUpdate})
+			return ErrNotFound
+		}
+
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := bson.Unmarshal(raw, result); err != nil {
+			return err
+		}
+		repo.runAfterHooks(hc, result, res.IDs, HookImage{Before: beforeUpdate, After: afterUpdate})
+		return nil
+	}
+
 	// Audit only with options.After
-	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
+	if auditActive {
 		// Check and set options
 		if opts.ReturnDocument == nil || *opts.ReturnDocument != options.After && *opts.ReturnDocument != options.Before {
 			// Set default to after
@@ -561,7 +915,9 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 				findOneOpts.SetSort(opts.Sort)
 			}
 			if err := repo.FindOne(filter, &beforeUpdate, findOneOpts); err != nil {
-				return err
+				if !errors.Is(err, ErrNotFound) || !isUpsert(opts.Upsert) {
+					return err
+				}
 			}
 
 			// FindOne and update
@@ -578,16 +934,30 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 				return err
 			}
 			// Compare and audit
-			if !cmp.Equal(beforeUpdate, afterUpdate) {
-				// Send to audit
-				repo.audit.Send(bson.M{
-					"collection": repo.collection.Name(),
-					"action":     Update,
-					"user":       authUser,
-					"data":       afterUpdate,
-				})
+			repo.auditUpsert(authUser, beforeUpdate, afterUpdate)
+			if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+				return err
 			}
+			repo.runAfterHooks(hc, result, idList(afterUpdate["_id"]), HookImage{Before: beforeUpdate, After: afterUpdate})
 		case options.Before:
+			// With upsert and not existing doc, update returns no document,
+			// the inserted doc is returned with options.After for audit
+			if isUpsert(opts.Upsert) {
+				inserted, err := repo.upsertIfNotExists(filter, opts.Sort, func(ctx context.Context, after *bson.M) error {
+					afterOpts := *opts
+					afterOpts.SetReturnDocument(options.After)
+					return repo.collection.FindOneAndUpdate(ctx, filter, update, &afterOpts).Decode(after)
+				}, timeout)
+				if err != nil {
+					return err
+				}
+				if inserted != nil {
+					repo.auditUpsert(authUser, nil, inserted)
+					repo.runAfterHooks(hc, nil, idList(inserted["_id"]), HookImage{After: inserted})
+					return ErrNotFound
+				}
+			}
+
 			// FindOne and update
 			ctx, cancel := context.WithTimeout(context.Background(), timeout)
 			defer cancel()
@@ -621,6 +991,10 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 					"data":       afterUpdate,
 				})
 			}
+			if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+				return err
+			}
+			repo.runAfterHooks(hc, result, idList(afterUpdate["_id"]), HookImage{Before: beforeUpdate, After: afterUpdate})
 		}
 
 		return nil
@@ -637,6 +1011,10 @@ func (repo *mongoBaseRepo) FindOneAndUpdate(filter, update, result interface{},
 		return err
 	}
 
+	if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, result, idList(docID(result)))
 	return nil
 }
 
@@ -645,6 +1023,7 @@ func (repo *mongoBaseRepo) UpdateOne(filter interface{}, update interface{}, arg
 	timeout := DefaultTimeout
 	opts := &options.UpdateOptions{}
 	var authUser interface{}
+	var skipHooks, skipValidation bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -654,6 +1033,10 @@ func (repo *mongoBaseRepo) UpdateOne(filter interface{}, update interface{}, arg
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
 		}
 	}
 
@@ -663,71 +1046,153 @@ func (repo *mongoBaseRepo) UpdateOne(filter interface{}, update interface{}, arg
 		})
 	}
 
-	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
-		// When audit then save doc before update for compare
+	// Before hooks
+	hc := repo.hookContext(HookUpdate, "UpdateOne", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		hc.Update = update
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+		update = hc.Update
+	}
+
+	// Generate _id for upsert
+	if isUpsert(opts.Upsert) {
+		update = repo.upsertUpdate(filter, update)
+	}
+
+	// Schema validation of updated documents, with transactions the update is validated on write
+	validateTx := false
+	if repo.validationActive(skipValidation) {
+		if validateTx = repo.transactionsSupported(timeout); !validateTx {
+			if err := repo.validateUpdate(filter, update, opts, nil, false, timeout); err != nil {
+				return err
+			}
+		}
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	auditActive := authUser != nil && repo.audit != nil && repo.audit.IsActive()
+
+	// Update and validation in transaction
+	if validateTx {
+		res, err := repo.updateValidated(filter, update, opts, nil, false, auditActive || hc != nil, timeout)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 && res.UpsertedID == nil {
+			return ErrNotFound
+		}
+
+		beforeUpdate, afterUpdate := res.image(0)
+		if auditActive {
+			repo.auditUpsert(authUser, beforeUpdate, afterUpdate)
+		}
+		if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+			return err
+		}
+		repo.runAfterHooks(hc, nil, res.IDs, HookImage{Before: beforeUpdate, After: afterUpdate})
+		return nil
+	}
+
+	if auditActive {
+		// When audit then save doc before update for compare,
+		// with upsert a not found doc will be inserted
 		var beforeUpdate bson.M
 		if err := repo.FindOne(filter, &beforeUpdate); err != nil {
-			return err
+			if !errors.Is(err, ErrNotFound) || !isUpsert(opts.Upsert) {
+				return err
+			}
+		}
+
+		// Update the found doc by id
+		subFilter := filter
+		if beforeUpdate != nil {
+			subFilter = bson.D{{Key: "_id", Value: beforeUpdate["_id"]}}
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
 		// Find and update doc save doc after updated
-		foaOpts := &options.FindOneAndUpdateOptions{}
-		foaOpts.SetReturnDocument(options.After)
+		foaOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		foaOpts.ArrayFilters = opts.ArrayFilters
+		foaOpts.BypassDocumentValidation = opts.BypassDocumentValidation
+		foaOpts.Collation = opts.Collation
+		foaOpts.Upsert = opts.Upsert
 		var afterUpdate bson.M
-		if err := repo.collection.FindOneAndUpdate(ctx, filter, update, foaOpts).Decode(&afterUpdate); err != nil {
+		if err := repo.collection.FindOneAndUpdate(ctx, subFilter, update, foaOpts).Decode(&afterUpdate); err != nil {
 			if errors.Is(err, mongo.ErrNoDocuments) {
 				return ErrNotFound
 			}
 			return err
 		}
 
-		// Audit only is updated
-		if !cmp.Equal(beforeUpdate, afterUpdate) {
-			// Send to audit
-			repo.audit.Send(bson.M{
-				"collection": repo.collection.Name(),
-				"action":     Update,
-				"user":       authUser,
-				"data":       afterUpdate,
-			})
+		// Audit only is inserted or updated
+		repo.auditUpsert(authUser, beforeUpdate, afterUpdate)
+		if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+			return err
 		}
+		repo.runAfterHooks(hc, nil, idList(afterUpdate["_id"]), HookImage{Before: beforeUpdate, After: afterUpdate})
 		return nil
 	}
 
-	// Without audit can simple update
+	// Without audit can simple update, with hooks the found doc is updated by id
+	filter, ids, err := repo.hookFilter(hc, filter, false, opts.Collation, timeout)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	// Simple update doc
 	res, err := repo.collection.UpdateOne(ctx, filter, update, opts)
 
-	if res != nil && res.MatchedCount == 0 {
+	if res != nil && res.MatchedCount == 0 && res.UpsertedCount == 0 {
 		//return NewNotFoundError()
 		return ErrNotFound
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, nil, append(ids, idList(res.UpsertedID)...))
+	return nil
 }
 
-// UpdateMany updates multiple documents in the collection.
-func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, args ...interface{}) (*UpdateManyResult, error) {
-	// Default values
+// ReplaceOne replaces a single document in the collection.
+func (repo *mongoBaseRepo) ReplaceOne(filter interface{}, replacement interface{}, args ...interface{}) error {
 	timeout := DefaultTimeout
-	opts := &options.UpdateOptions{}
+	opts := &options.ReplaceOptions{}
 	var authUser interface{}
+	var skipHooks, skipValidation bool
+	changeType := HistoryReplace
 
-	// Check args
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
 		case time.Duration:
 			timeout = val
-		case *options.UpdateOptions:
+		case *options.ReplaceOptions:
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
+		case historyType:
+			changeType = string(val)
 		}
 	}
 
@@ -737,14 +1202,220 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 		})
 	}
 
-	// Return UpdateManyResult
-	updateManyResult := new(UpdateManyResult)
+	// Before hooks
+	hc := repo.hookContext(HookUpdate, "ReplaceOne", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		hc.Doc = replacement
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+		replacement = hc.Doc
+	}
 
-	// Audit
-	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
-		// UpdateOne func for audit update many
-		updOneFn := func(subFilter bson.D, afterUpdate *bson.M) error {
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// Keep or generate _id for upsert
+	if isUpsert(opts.Upsert) {
+		var err error
+		if replacement, err = repo.upsertReplacement(filter, replacement, nil); err != nil {
+			return err
+		}
+	}
+
+	// Schema validation
+	if repo.validationActive(skipValidation) {
+		if err := repo.validateDoc(replacement, 0); err != nil {
+			return err
+		}
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
+		// When audit then save doc before replace for compare,
+		// with upsert a not found doc will be inserted
+		var beforeReplace bson.M
+		if err := repo.FindOne(filter, &beforeReplace); err != nil {
+			if !errors.Is(err, ErrNotFound) || !isUpsert(opts.Upsert) {
+				return err
+			}
+		}
+
+		// Replace the found doc by id
+		subFilter := filter
+		if beforeReplace != nil {
+			subFilter = bson.D{{Key: "_id", Value: beforeReplace["_id"]}}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		// Find and replace doc save doc after replaced
+		foarOpts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		foarOpts.BypassDocumentValidation = opts.BypassDocumentValidation
+		foarOpts.Collation = opts.Collation
+		foarOpts.Upsert = opts.Upsert
+		var afterReplace bson.M
+		if err := repo.collection.FindOneAndReplace(ctx, subFilter, replacement, foarOpts).Decode(&afterReplace); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		// Audit only is inserted or replaced
+		repo.auditUpsert(authUser, beforeReplace, afterReplace)
+		if err := repo.recordHistory(previous, changeType, authUser, timeout); err != nil {
+			return err
+		}
+		repo.runAfterHooks(hc, nil, idList(afterReplace["_id"]), HookImage{Before: beforeReplace, After: afterReplace})
+		return nil
+	}
+
+	// Without audit can simple replace, with hooks the found doc is replaced by id
+	filter, ids, err := repo.hookFilter(hc, filter, false, opts.Collation, timeout)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := repo.collection.ReplaceOne(ctx, filter, replacement, opts)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+		return ErrNotFound
+	}
+
+	if err := repo.recordHistory(previous, changeType, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, nil, append(ids, idList(res.UpsertedID)...))
+	return nil
+}
+
+// UpdateMany updates multiple documents in the collection.
+func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, args ...interface{}) (*UpdateManyResult, error) {
+	// Default values
+	timeout := DefaultTimeout
+	opts := &options.UpdateOptions{}
+	var authUser interface{}
+	var skipHooks, skipValidation bool
+
+	// Check args
+	for i := 0; i < len(args); i++ {
+		switch val := args[i].(type) {
+		case time.Duration:
+			timeout = val
+		case *options.UpdateOptions:
+			opts = val
+		case *AuditAuth:
+			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
+		case SkipValidation:
+			skipValidation = true
+		}
+	}
+
+	if repo.locale != nil && opts.Collation == nil {
+		opts.SetCollation(&options.Collation{
+			Locale: *repo.locale,
+		})
+	}
+
+	// Before hooks
+	hc := repo.hookContext(HookUpdate, "UpdateMany", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		hc.Update = update
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return nil, err
+		}
+		filter = hc.Filter
+		update = hc.Update
+	}
+
+	// Generate _id for upsert
+	if isUpsert(opts.Upsert) {
+		update = repo.upsertUpdate(filter, update)
+	}
+
+	// Schema validation of updated documents, with transactions the update is validated on write
+	validateTx := false
+	if repo.validationActive(skipValidation) {
+		if validateTx = repo.transactionsSupported(timeout); !validateTx {
+			if err := repo.validateUpdate(filter, update, opts, nil, true, timeout); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Return UpdateManyResult
+	updateManyResult := new(UpdateManyResult)
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, true, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	auditActive := authUser != nil && repo.audit != nil && repo.audit.IsActive()
+
+	// Update and validation in transaction
+	if validateTx {
+		res, err := repo.updateValidated(filter, update, opts, nil, true, auditActive || hc != nil, timeout)
+		if err != nil {
+			return updateManyResult, err
+		}
+		updateManyResult.MatchedCount = res.MatchedCount
+		updateManyResult.ModifiedCount = res.ModifiedCount
+		updateManyResult.UpsertedID = res.UpsertedID
+		if res.UpsertedID != nil {
+			updateManyResult.UpsertedCount = 1
+		}
+
+		var auditEntries []bson.M
+		var images []HookImage
+		for i := range res.IDs {
+			beforeUpdate, afterUpdate := res.image(i)
+			images = append(images, HookImage{Before: beforeUpdate, After: afterUpdate})
+			if !auditActive || cmp.Equal(beforeUpdate, afterUpdate) {
+				continue
+			}
+			action := Update
+			if beforeUpdate == nil {
+				action = Insert
+			}
+			auditEntries = append(auditEntries, bson.M{
+				"collection": repo.collection.Name(),
+				"action":     action,
+				"user":       authUser,
+				"data":       afterUpdate})
+		}
+		if len(auditEntries) > 0 {
+			repo.audit.Send(auditEntries)
+		}
+
+		if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+			return updateManyResult, err
+		}
+		repo.runAfterHooks(hc, updateManyResult, res.IDs, images...)
+		return updateManyResult, nil
+	}
+
+	// Audit
+	if auditActive {
+		// UpdateOne func for audit update many
+		updOneFn := func(subFilter bson.D, afterUpdate *bson.M) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
 			defer cancel()
 			return repo.collection.FindOneAndUpdate(ctx, subFilter, update,
 				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(afterUpdate)
@@ -756,8 +1427,41 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 			return nil, err
 		}
 
-		// Array for audits
+		// Nothing found, upsert inserts one doc
+		if len(allDocs) == 0 && isUpsert(opts.Upsert) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			res, err := repo.collection.UpdateMany(ctx, filter, update, opts)
+			if err != nil {
+				return updateManyResult, err
+			}
+			updateManyResult.MatchedCount = res.MatchedCount
+			updateManyResult.ModifiedCount = res.ModifiedCount
+			updateManyResult.UpsertedCount = res.UpsertedCount
+			updateManyResult.UpsertedID = res.UpsertedID
+
+			var images []HookImage
+			if res.UpsertedID != nil {
+				var afterUpsert bson.M
+				if err := repo.FindOne(bson.D{{Key: "_id", Value: res.UpsertedID}}, &afterUpsert); err != nil {
+					return updateManyResult, err
+				}
+				repo.auditUpsert(authUser, nil, afterUpsert)
+				images = append(images, HookImage{After: afterUpsert})
+			}
+
+			if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+				return updateManyResult, err
+			}
+			repo.runAfterHooks(hc, updateManyResult, idList(res.UpsertedID), images...)
+			return updateManyResult, nil
+		}
+
+		// Array for audits, ids and images for hooks
 		var auditEntries []bson.M
+		var ids []interface{}
+		var images []HookImage
 
 		// Update docs and log entries
 		for _, val := range allDocs {
@@ -770,6 +1474,8 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 				updateManyResult.FailedIDs = append(updateManyResult.FailedIDs, subFilter.Map()["_id"])
 			} else {
 				updateManyResult.MatchedCount++
+				ids = append(ids, val["_id"])
+				images = append(images, HookImage{Before: val, After: afterUpdate})
 				// Is Modified use DeepEqual
 				if !cmp.Equal(val, afterUpdate) {
 					updateManyResult.ModifiedCount++
@@ -783,6 +1489,11 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 			}
 		}
 
+		if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+			return updateManyResult, err
+		}
+		repo.runAfterHooks(hc, updateManyResult, ids, images...)
+
 		// Check audit entries
 		if auditEntries == nil || len(auditEntries) == 0 {
 			return updateManyResult, nil
@@ -794,6 +1505,12 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 		return updateManyResult, nil
 	}
 
+	// With hooks only the found docs are updated
+	filter, ids, err := repo.hookFilter(hc, filter, true, opts.Collation, timeout)
+	if err != nil {
+		return updateManyResult, err
+	}
+
 	// Context for update
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -808,8 +1525,15 @@ func (repo *mongoBaseRepo) UpdateMany(filter interface{}, update interface{}, ar
 		updateManyResult.UpsertedCount = res.UpsertedCount
 		updateManyResult.UpsertedID = res.UpsertedID
 	}
+	if err != nil {
+		return updateManyResult, err
+	}
 
-	return updateManyResult, err
+	if err := repo.recordHistory(previous, HistoryUpdate, authUser, timeout); err != nil {
+		return updateManyResult, err
+	}
+	repo.runAfterHooks(hc, updateManyResult, append(ids, idList(updateManyResult.UpsertedID)...))
+	return updateManyResult, nil
 }
 
 // DeleteOne deletes a single document from the collection.
@@ -818,6 +1542,7 @@ func (repo *mongoBaseRepo) DeleteOne(filter interface{}, args ...interface{}) er
 	timeout := DefaultTimeout
 	opts := &options.FindOneAndDeleteOptions{}
 	var authUser interface{}
+	var skipHooks bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -827,6 +1552,8 @@ func (repo *mongoBaseRepo) DeleteOne(filter interface{}, args ...interface{}) er
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
 		}
 	}
 
@@ -836,19 +1563,45 @@ func (repo *mongoBaseRepo) DeleteOne(filter interface{}, args ...interface{}) er
 		})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// Before hooks
+	hc := repo.hookContext(HookDelete, "DeleteOne", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return err
+		}
+		filter = hc.Filter
+	}
+
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, false, nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	// Relations, on-delete rules of child collections
+	cascade, err := repo.startCascade(filter, false, opts.Sort, authUser, timeout)
+	if err != nil {
+		return err
+	}
+	defer cascade.end()
+
+	ctx, cancel := context.WithTimeout(cascade.context(), timeout)
 	defer cancel()
 
-	// Find document before delete for audit
+	// Find document before delete for audit, _id can be of any type
 	var beforeDelete struct {
-		ID primitive.ObjectID `bson:"_id"`
+		ID interface{} `bson:"_id"`
 	}
-	if err := repo.collection.FindOneAndDelete(ctx, filter, opts).Decode(&beforeDelete); err != nil {
+	if err := repo.collection.FindOneAndDelete(ctx, cascade.filter(filter), opts).Decode(&beforeDelete); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return ErrNotFound
 		}
 		return err
 	}
+	if err := cascade.commit(); err != nil {
+		return err
+	}
 
 	// Audit
 	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
@@ -861,6 +1614,10 @@ func (repo *mongoBaseRepo) DeleteOne(filter interface{}, args ...interface{}) er
 		})
 	}
 
+	if err := repo.recordHistory(previous, HistoryDelete, authUser, timeout); err != nil {
+		return err
+	}
+	repo.runAfterHooks(hc, nil, idList(beforeDelete.ID))
 	return nil
 }
 
@@ -870,6 +1627,7 @@ func (repo *mongoBaseRepo) DeleteMany(filter interface{}, args ...interface{}) (
 	timeout := DefaultTimeout
 	opts := &options.DeleteOptions{}
 	var authUser interface{}
+	var skipHooks bool
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -879,6 +1637,8 @@ func (repo *mongoBaseRepo) DeleteMany(filter interface{}, args ...interface{}) (
 			opts = val
 		case *AuditAuth:
 			authUser = val.User
+		case SkipHooks:
+			skipHooks = true
 		}
 	}
 
@@ -888,9 +1648,32 @@ func (repo *mongoBaseRepo) DeleteMany(filter interface{}, args ...interface{}) (
 		})
 	}
 
+	// Before hooks
+	hc := repo.hookContext(HookDelete, "DeleteMany", authUser, skipHooks)
+	if hc != nil {
+		hc.Filter = filter
+		if err := repo.hooks.runBefore(hc); err != nil {
+			return nil, err
+		}
+		filter = hc.Filter
+	}
+
 	// Return UpdateManyResult
 	deleteManyResult := new(DeleteManyResult)
 
+	// History, documents before write
+	previous, err := repo.historyBefore(authUser, filter, true, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relations, on-delete rules of child collections
+	cascade, err := repo.startCascade(filter, true, nil, authUser, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cascade.end()
+
 	// Audit
 	if authUser != nil && repo.audit != nil && repo.audit.IsActive() {
 		// Find all (only id field) with filter for audit
@@ -900,15 +1683,18 @@ func (repo *mongoBaseRepo) DeleteMany(filter interface{}, args ...interface{}) (
 		}
 
 		// DeleteMany
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ctx, cancel := context.WithTimeout(cascade.context(), timeout)
 		defer cancel()
-		res, err := repo.collection.DeleteMany(ctx, filter, opts)
+		res, err := repo.collection.DeleteMany(ctx, cascade.filter(filter), opts)
 		if err != nil {
 			return deleteManyResult, err
 		}
 		if res != nil {
 			deleteManyResult.DeletedCount = res.DeletedCount
 		}
+		if err := cascade.commit(); err != nil {
+			return deleteManyResult, err
+		}
 
 		// Start audit async
 		if allDocs != nil && len(allDocs) > 0 {
@@ -928,18 +1714,42 @@ func (repo *mongoBaseRepo) DeleteMany(filter interface{}, args ...interface{}) (
 			repo.audit.Send(auditEntries)
 		}
 
+		ids := make([]interface{}, 0, len(allDocs))
+		for _, doc := range allDocs {
+			ids = append(ids, doc["_id"])
+		}
+		if err := repo.recordHistory(previous, HistoryDelete, authUser, timeout); err != nil {
+			return deleteManyResult, err
+		}
+		repo.runAfterHooks(hc, deleteManyResult, ids)
 		return deleteManyResult, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// With hooks only the found docs are deleted
+	filter, ids, err := repo.hookFilter(hc, filter, true, opts.Collation, timeout)
+	if err != nil {
+		return deleteManyResult, err
+	}
+
+	ctx, cancel := context.WithTimeout(cascade.context(), timeout)
 	defer cancel()
 
-	res, err := repo.collection.DeleteMany(ctx, filter, opts)
+	res, err := repo.collection.DeleteMany(ctx, cascade.filter(filter), opts)
 	if res != nil {
 		deleteManyResult.DeletedCount = res.DeletedCount
 	}
+	if err != nil {
+		return deleteManyResult, err
+	}
+	if err := cascade.commit(); err != nil {
+		return deleteManyResult, err
+	}
 
-	return deleteManyResult, err
+	if err := repo.recordHistory(previous, HistoryDelete, authUser, timeout); err != nil {
+		return deleteManyResult, err
+	}
+	repo.runAfterHooks(hc, deleteManyResult, ids)
+	return deleteManyResult, nil
 }
 
 // GetCollection get instance of repo collection.
@@ -966,11 +1776,13 @@ func (repo *mongoBaseRepo) SetLocale(code string) {
 	}
 }
 
-// Aggregate, performs a aggregation with binding to result
+// Aggregate, performs a aggregation with binding to result,
+// pipeline can be a mongo.Pipeline, bson.A or *Pipeline
 func (repo *mongoBaseRepo) Aggregate(pipeline interface{}, result interface{}, args ...interface{}) error {
 	// Default values
 	timeout := DefaultTimeout
 	opts := &options.AggregateOptions{}
+	var authUser interface{}
 
 	for i := 0; i < len(args); i++ {
 		switch val := args[i].(type) {
@@ -978,6 +1790,8 @@ func (repo *mongoBaseRepo) Aggregate(pipeline interface{}, result interface{}, a
 			timeout = val
 		case *options.AggregateOptions:
 			opts = val
+		case *AuditAuth:
+			authUser = val.User
 		}
 	}
 
@@ -987,6 +1801,15 @@ func (repo *mongoBaseRepo) Aggregate(pipeline interface{}, result interface{}, a
 		})
 	}
 
+	// Build pipeline from builder
+	if p, ok := pipeline.(*Pipeline); ok {
+		built, err := p.Build()
+		if err != nil {
+			return err
+		}
+		pipeline = built
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -1004,5 +1827,244 @@ func (repo *mongoBaseRepo) Aggregate(pipeline interface{}, result interface{}, a
 		}
 	}
 
+	if repo.readAuditActive(authUser) {
+		repo.auditRead(authUser, "aggregate", pipeline, result, 0)
+	}
+
 	return nil
 }
+
+// Distinct, find the distinct values for a specified field across the collection.
+func (repo *mongoBaseRepo) Distinct(fieldName string, filter interface{}, args ...interface{}) ([]interface{}, error) {
+	// Default values
+	timeout := DefaultTimeout
+	opts := &options.DistinctOptions{}
+
+	for i := 0; i < len(args); i++ {
+		switch val := args[i].(type) {
+		case time.Duration:
+			timeout = val
+		case *options.DistinctOptions:
+			opts = val
+		}
+	}
+
+	if repo.locale != nil && opts.Collation == nil {
+		opts.SetCollation(&options.Collation{
+			Locale: *repo.locale,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return repo.collection.Distinct(ctx, fieldName, filter, opts)
+}
+
+// FindWithCount, find a page matched by filter and count all matched documents in one aggregation with $facet.
+// Projection, sort, skip, limit and locale are taken from findOptions, sort keys are applied in alphabetical order.
+// With *FacetOptions the counts of values per field are returned in FindWithCountResult.Facets.
+// The page and the facets must fit in one document (16MB).
+// Example:
+// var users []User
+// res, err := repo.FindWithCount(bson.M{"is_active": true}, &users, &lxHelper.FindOptions{Skip: 20, Limit: 10})
+// res, err := repo.FindWithCount(bson.M{}, &users, rbq.FindOptions, &lxDb.FacetOptions{Fields: []string{"gender"}})
+func (repo *mongoBaseRepo) FindWithCount(filter interface{}, result interface{}, findOptions *lxHelper.FindOptions, args ...interface{}) (*FindWithCountResult, error) {
+	// Default values
+	timeout := DefaultTimeout
+	opts := &options.AggregateOptions{}
+	var facetOpts *FacetOptions
+	var authUser interface{}
+
+	for i := 0; i < len(args); i++ {
+		switch val := args[i].(type) {
+		case time.Duration:
+			timeout = val
+		case *options.AggregateOptions:
+			opts = val
+		case *FacetOptions:
+			facetOpts = val
+		case *AuditAuth:
+			authUser = val.User
+		}
+	}
+
+	if findOptions == nil {
+		findOptions = &lxHelper.FindOptions{}
+	}
+	if findOptions.Locale != nil && opts.Collation == nil {
+		opts.SetCollation(&options.Collation{
+			Locale: *findOptions.Locale,
+		})
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	// Data facet
+	data := NewPipeline()
+	if len(findOptions.Sort) > 0 {
+		keys := make([]string, 0, len(findOptions.Sort))
+		for key := range findOptions.Sort {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fields := make([]bson.E, 0, len(keys))
+		for _, key := range keys {
+			fields = append(fields, bson.E{Key: key, Value: findOptions.Sort[key]})
+		}
+		data.Sort(fields...)
+	}
+	if findOptions.Skip > 0 {
+		data.Skip(findOptions.Skip)
+	}
+	if findOptions.Limit > 0 {
+		data.Limit(findOptions.Limit)
+	}
+	if len(findOptions.Fields) > 0 {
+		projection := bson.M{}
+		for key, value := range findOptions.Fields {
+			projection[key] = value
+		}
+		data.Project(projection)
+	}
+	// $facet requires at least one stage
+	if data.Len() == 0 {
+		data.Skip(0)
+	}
+
+	facets := map[string]*Pipeline{
+		"data":  data,
+		"total": NewPipeline().Count("count"),
+	}
+
+	// Field facets, named by index, field paths are not allowed as facet names
+	if facetOpts != nil {
+		for i, field := range facetOpts.Fields {
+			fieldFacet := NewPipeline().
+				Unwind(field).
+				Stage(bson.D{{Key: "$sortByCount", Value: FieldRef(field)}})
+			if facetOpts.Limit > 0 {
+				fieldFacet.Limit(facetOpts.Limit)
+			}
+			facets[fmt.Sprintf("facet%d", i)] = fieldFacet
+		}
+	}
+
+	pipeline := NewPipeline().Match(filter).Facet(facets)
+
+	var docs []bson.Raw
+	if err := repo.Aggregate(pipeline, &docs, timeout, opts); err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 {
+		return nil, ErrNotFound
+	}
+
+	res := &FindWithCountResult{
+		Skip:  findOptions.Skip,
+		Limit: findOptions.Limit,
+		Page:  1,
+	}
+
+	// Total
+	if total, err := docs[0].LookupErr("total", "0", "count"); err == nil {
+		if n, ok := total.Int32OK(); ok {
+			res.Total = int64(n)
+		} else if n, ok := total.Int64OK(); ok {
+			res.Total = n
+		}
+	}
+
+	// Data
+	dataVal := docs[0].Lookup("data")
+	if values, err := dataVal.Array().Values(); err == nil {
+		res.Count = int64(len(values))
+	}
+	if result != nil {
+		if err := dataVal.Unmarshal(result); err != nil {
+			return nil, err
+		}
+		if repo.readAuditActive(authUser) {
+			repo.auditRead(authUser, "findWithCount", filter, result, 0)
+		}
+	}
+
+	// Pages
+	if res.Limit > 0 {
+		res.Page = res.Skip/res.Limit + 1
+		res.Pages = (res.Total + res.Limit - 1) / res.Limit
+	} else if res.Total > 0 {
+		res.Pages = 1
+	}
+	res.HasNext = res.Skip+res.Count < res.Total
+
+	// Facets
+	if facetOpts != nil {
+		res.Facets = make(map[string][]FacetCount, len(facetOpts.Fields))
+		for i, field := range facetOpts.Fields {
+			var counts []FacetCount
+			if err := docs[0].Lookup(fmt.Sprintf("facet%d", i)).Unmarshal(&counts); err != nil {
+				return nil, err
+			}
+			res.Facets[field] = counts
+		}
+	}
+
+	return res, nil
+}
+
+// upsertIfNotExists, runs upsertFn with the after document when no document matches filter,
+// returns the inserted document or nil when a document exists
+func (repo *mongoBaseRepo) upsertIfNotExists(filter, sort interface{}, upsertFn func(ctx context.Context, after *bson.M) error, timeout time.Duration) (bson.M, error) {
+	findOneOpts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	if sort != nil {
+		findOneOpts.SetSort(sort)
+	}
+
+	var existing bson.M
+	err := repo.FindOne(filter, &existing, findOneOpts)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var inserted bson.M
+	if err := upsertFn(ctx, &inserted); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return inserted, nil
+}
+
+// auditUpsert, sends insert when before is nil, otherwise update when doc is changed
+func (repo *mongoBaseRepo) auditUpsert(authUser interface{}, before, after bson.M) {
+	action := Update
+	if before == nil {
+		action = Insert
+	} else if cmp.Equal(before, after) {
+		return
+	}
+
+	// Send to audit
+	repo.audit.Send(bson.M{
+		"collection": repo.collection.Name(),
+		"action":     action,
+		"user":       authUser,
+		"data":       after,
+	})
+}
+
+// isUpsert, check upsert option
+func isUpsert(upsert *bool) bool {
+	return upsert != nil && *upsert
+}
@@ -9,6 +9,7 @@ import (
 	lxDb "github.com/litixsoft/lxgo/db"
 	lxDbMocks "github.com/litixsoft/lxgo/db/mocks"
 	lxHelper "github.com/litixsoft/lxgo/helper"
+	lxSchema "github.com/litixsoft/lxgo/schema"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -30,6 +31,16 @@ type TestUser struct {
 	IsActive bool               `json:"is_active,omitempty" bson:"is_active"`
 }
 
+type TestUUIDUser struct {
+	Id   lxDb.UUID `json:"id" bson:"_id"`
+	Name string    `json:"name" bson:"name"`
+}
+
+type TestULIDUser struct {
+	Id   *lxDb.ULID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name string     `json:"name" bson:"name"`
+}
+
 type TestUserLocale struct {
 	Index    int    `json:"index" bson:"index"`
 	Name     string `json:"name" bson:"name"`
@@ -1935,6 +1946,29 @@ func TestMongoDbBaseRepo_DeleteMany(t *testing.T) {
 	})
 }
 
+func TestNewMongoBaseRepo(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+	// Not connected client
+	client, err := mongo.NewClient()
+	its.NoError(err)
+	collection := client.Database(TestDbName).Collection(TestCollection)
+
+	// Audit as positional arg, as before options
+	base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit)
+	its.Equal(collection, base.GetCollection())
+
+	// Audit and id generator mixed with options
+	base = lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, lxDb.UUIDGenerator(), &lxDb.ReadAuditOptions{}, nil)
+	its.Equal(collection, base.GetCollection())
+	base = lxDb.NewMongoBaseRepo(collection, lxDb.WithAudit(mockIBaseRepoAudit), lxDb.WithIdGenerator(lxDb.ULIDGenerator()))
+	its.Equal(TestDbName+"/"+TestCollection, base.GetRepoName())
+}
+
 func TestMongoBaseRepo_GetCollection(t *testing.T) {
 	its := assert.New(t)
 
@@ -2104,6 +2138,1148 @@ func TestMongoDbBaseRepo_LocaleTest(t *testing.T) {
 	})
 }
 
+func TestMongoBaseRepo_ReplaceOne(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+
+	t.Run("without_audit", func(t *testing.T) {
+		t.Run("replace", func(t *testing.T) {
+			// Test the base repo
+			base := lxDb.NewMongoBaseRepo(collection)
+
+			// testUsers Sorted by name
+			testUsers := setupData(db)
+
+			// Replace testUser
+			testUser := testUsers[5]
+			testUser.Name = "Is Replaced"
+
+			filter := bson.D{{Key: "_id", Value: testUser.Id}}
+			its.NoError(base.ReplaceOne(filter, testUser, time.Second*10, options.Replace()))
+
+			// Check with Find
+			var check TestUser
+			its.NoError(base.FindOne(filter, &check))
+			its.Equal(testUser, check)
+		})
+		t.Run("upsert", func(t *testing.T) {
+			// Test the base repo
+			base := lxDb.NewMongoBaseRepo(collection)
+			setupData(db)
+
+			testUser := TestUser{Id: primitive.NewObjectID(), Name: "Is Upserted", Email: "upsert@test.de"}
+			filter := bson.D{{Key: "_id", Value: testUser.Id}}
+			its.NoError(base.ReplaceOne(filter, testUser, options.Replace().SetUpsert(true)))
+
+			// Check with Find
+			var check TestUser
+			its.NoError(base.FindOne(filter, &check))
+			its.Equal(testUser, check)
+		})
+		t.Run("error_not_found", func(t *testing.T) {
+			// Test the base repo
+			base := lxDb.NewMongoBaseRepo(collection)
+
+			filter := bson.D{{Key: "_id", Value: primitive.NewObjectID()}}
+			err := base.ReplaceOne(filter, TestUser{Name: "Not Exists"})
+			its.True(errors.Is(err, lxDb.ErrNotFound))
+		})
+	})
+	t.Run("with_audit", func(t *testing.T) {
+		t.Run("replace", func(t *testing.T) {
+			// testUsers Sorted by name
+			testUsers := setupData(db)
+			auditUser := getTestAuditUser()
+			testUser := testUsers[6]
+			testUser.Name = "Is Replaced"
+
+			// Test the base repo with mock
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+			// Test the base repo
+			base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit)
+
+			// Check mock params
+			doAction := func(elem interface{}) {
+				val := elem.(bson.M)
+				its.Equal(TestCollection, val["collection"])
+				its.Equal(lxDb.Update, val["action"])
+				its.Equal(auditUser, val["user"])
+
+				chkUser, err := lxDb.ToBsonMap(testUser)
+				its.NoError(err)
+				its.Equal(chkUser, val["data"])
+			}
+
+			// Configure mock
+			mockIBaseRepoAudit.EXPECT().IsActive().Return(true).Times(1)
+			mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Return().Do(doAction).Times(1)
+
+			filter := bson.D{{Key: "_id", Value: testUser.Id}}
+			its.NoError(base.ReplaceOne(filter, testUser, lxDb.SetAuditAuth(auditUser)))
+		})
+		t.Run("upsert", func(t *testing.T) {
+			setupData(db)
+			auditUser := getTestAuditUser()
+			testUser := TestUser{Id: primitive.NewObjectID(), Name: "Is Upserted", Email: "upsert@test.de"}
+
+			// Test the base repo with mock
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+			// Test the base repo
+			base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit)
+
+			// Check mock params
+			doAction := func(elem interface{}) {
+				val := elem.(bson.M)
+				its.Equal(lxDb.Insert, val["action"])
+				its.Equal(testUser.Id, val["data"].(bson.M)["_id"])
+			}
+
+			// Configure mock
+			mockIBaseRepoAudit.EXPECT().IsActive().Return(true).Times(1)
+			mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Return().Do(doAction).Times(1)
+
+			filter := bson.D{{Key: "_id", Value: testUser.Id}}
+			its.NoError(base.ReplaceOne(filter, testUser, options.Replace().SetUpsert(true), lxDb.SetAuditAuth(auditUser)))
+		})
+	})
+}
+
+func TestMongoBaseRepo_Upsert_Audit(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+	auditUser := getTestAuditUser()
+
+	// Expect one insert entry with email
+	expectInsert := func(t *testing.T, email string) (lxDb.IBaseRepo, *gomock.Controller) {
+		mockCtrl := gomock.NewController(t)
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+		doAction := func(elem interface{}) {
+			val := elem.(bson.M)
+			its.Equal(TestCollection, val["collection"])
+			its.Equal(lxDb.Insert, val["action"])
+			its.Equal(auditUser, val["user"])
+			its.Equal(email, val["data"].(bson.M)["email"])
+		}
+
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true).Times(1)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Return().Do(doAction).Times(1)
+
+		return lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit), mockCtrl
+	}
+
+	t.Run("update_one", func(t *testing.T) {
+		setupData(db)
+		base, mockCtrl := expectInsert(t, "upsert_one@test.de")
+		defer mockCtrl.Finish()
+
+		filter := bson.D{{Key: "email", Value: "upsert_one@test.de"}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Upsert One"}}}}
+		its.NoError(base.UpdateOne(filter, update, options.Update().SetUpsert(true), lxDb.SetAuditAuth(auditUser)))
+
+		count, err := base.CountDocuments(filter)
+		its.NoError(err)
+		its.Equal(int64(1), count)
+	})
+	t.Run("update_many", func(t *testing.T) {
+		setupData(db)
+		base, mockCtrl := expectInsert(t, "upsert_many@test.de")
+		defer mockCtrl.Finish()
+
+		filter := bson.D{{Key: "email", Value: "upsert_many@test.de"}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Upsert Many"}}}}
+		res, err := base.UpdateMany(filter, update, options.Update().SetUpsert(true), lxDb.SetAuditAuth(auditUser))
+		its.NoError(err)
+		its.Equal(int64(1), res.UpsertedCount)
+		its.NotNil(res.UpsertedID)
+	})
+	t.Run("find_one_and_update_after", func(t *testing.T) {
+		setupData(db)
+		base, mockCtrl := expectInsert(t, "upsert_after@test.de")
+		defer mockCtrl.Finish()
+
+		filter := bson.D{{Key: "email", Value: "upsert_after@test.de"}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Upsert After"}}}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+		var result TestUser
+		its.NoError(base.FindOneAndUpdate(filter, update, &result, opts, lxDb.SetAuditAuth(auditUser)))
+		its.Equal("Upsert After", result.Name)
+	})
+	t.Run("find_one_and_update_before", func(t *testing.T) {
+		setupData(db)
+		base, mockCtrl := expectInsert(t, "upsert_before@test.de")
+		defer mockCtrl.Finish()
+
+		filter := bson.D{{Key: "email", Value: "upsert_before@test.de"}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Upsert Before"}}}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+		// No document before upsert
+		var result TestUser
+		err := base.FindOneAndUpdate(filter, update, &result, opts, lxDb.SetAuditAuth(auditUser))
+		its.True(errors.Is(err, lxDb.ErrNotFound))
+
+		count, err := base.CountDocuments(filter)
+		its.NoError(err)
+		its.Equal(int64(1), count)
+	})
+	t.Run("find_one_and_replace", func(t *testing.T) {
+		setupData(db)
+		base, mockCtrl := expectInsert(t, "upsert_replace@test.de")
+		defer mockCtrl.Finish()
+
+		filter := bson.D{{Key: "email", Value: "upsert_replace@test.de"}}
+		replacement := TestUser{Name: "Upsert Replace", Email: "upsert_replace@test.de"}
+		opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
+
+		var result TestUser
+		its.NoError(base.FindOneAndReplace(filter, replacement, &result, opts, lxDb.SetAuditAuth(auditUser)))
+		its.Equal("Upsert Replace", result.Name)
+	})
+}
+
+func TestMongoBaseRepo_Distinct(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	base := lxDb.NewMongoBaseRepo(db.Collection(TestCollection))
+	setupData(db)
+
+	t.Run("all", func(t *testing.T) {
+		values, err := base.Distinct("gender", bson.D{}, time.Second*10)
+		its.NoError(err)
+		its.ElementsMatch([]interface{}{"Female", "Male"}, values)
+	})
+	t.Run("with_filter_and_options", func(t *testing.T) {
+		filter := bson.D{{Key: "gender", Value: "Male"}}
+		values, err := base.Distinct("gender", filter, options.Distinct())
+		its.NoError(err)
+		its.Equal([]interface{}{"Male"}, values)
+	})
+}
+
+func TestMongoBaseRepo_FindWithCount(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	base := lxDb.NewMongoBaseRepo(db.Collection(TestCollection))
+	testUsers := setupData(db)
+
+	t.Run("page", func(t *testing.T) {
+		var result []TestUser
+		opts := &lxHelper.FindOptions{
+			Sort:   map[string]int{"email": 1},
+			Skip:   10,
+			Limit:  10,
+			Fields: map[string]int{"email": 1},
+		}
+		res, err := base.FindWithCount(bson.D{}, &result, opts, time.Second*10)
+		its.NoError(err)
+
+		sort.Slice(testUsers, func(i, j int) bool { return testUsers[i].Email < testUsers[j].Email })
+		its.Len(result, 10)
+		its.Equal(testUsers[10].Email, result[0].Email)
+		its.Empty(result[0].Name)
+		its.Equal(&lxDb.FindWithCountResult{
+			Total:   25,
+			Count:   10,
+			Skip:    10,
+			Limit:   10,
+			Page:    2,
+			Pages:   3,
+			HasNext: true,
+		}, res)
+	})
+	t.Run("filter and facets", func(t *testing.T) {
+		var result []TestUser
+		res, err := base.FindWithCount(bson.D{{Key: "is_active", Value: true}}, &result, nil, &lxDb.FacetOptions{Fields: []string{"gender", "is_active"}})
+		its.NoError(err)
+		its.Len(result, 12)
+		its.Equal(int64(12), res.Total)
+		its.Equal(int64(1), res.Pages)
+		its.False(res.HasNext)
+		its.Equal([]lxDb.FacetCount{{Value: true, Count: 12}}, res.Facets["is_active"])
+
+		var sum int64
+		for _, fc := range res.Facets["gender"] {
+			sum += fc.Count
+		}
+		its.Equal(int64(12), sum)
+	})
+	t.Run("empty", func(t *testing.T) {
+		var result []TestUser
+		res, err := base.FindWithCount(bson.D{{Key: "name", Value: "unknown"}}, &result, &lxHelper.FindOptions{Limit: 5})
+		its.NoError(err)
+		its.Len(result, 0)
+		its.Equal(int64(0), res.Total)
+		its.Equal(int64(0), res.Pages)
+		its.False(res.HasNext)
+	})
+}
+
+func TestMongoBaseRepo_IdGenerator(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	t.Run("insert_one", func(t *testing.T) {
+		setupData(db)
+		base := lxDb.NewMongoBaseRepo(collection, lxDb.WithIdGenerator(lxDb.UUIDGenerator()))
+
+		doc := bson.M{"name": "Uuid"}
+		id, err := base.InsertOne(doc)
+		its.NoError(err)
+		uuid, ok := id.(lxDb.UUID)
+		its.True(ok)
+
+		// Document of caller is not changed
+		its.Equal(bson.M{"name": "Uuid"}, doc)
+
+		var result bson.M
+		its.NoError(base.FindOne(bson.D{{Key: "_id", Value: uuid}}, &result))
+		its.Equal("Uuid", result["name"])
+
+		// Existing id is kept
+		id, err = base.InsertOne(bson.M{"_id": "fixed", "name": "Fixed"})
+		its.NoError(err)
+		its.Equal("fixed", id)
+
+		// Zero value other than empty string and ObjectID is an id
+		id, err = base.InsertOne(bson.D{{Key: "_id", Value: int32(0)}, {Key: "name", Value: "Zero"}})
+		its.NoError(err)
+		its.Equal(int32(0), id)
+
+		// Struct with other id type
+		_, err = base.InsertOne(&TestUser{Name: "Struct"})
+		its.True(errors.Is(err, lxDb.ErrIdType))
+	})
+	t.Run("struct_uuid", func(t *testing.T) {
+		setupData(db)
+		base := lxDb.NewMongoBaseRepo(collection, lxDb.UUIDGenerator())
+
+		// Zero UUID is filled on each insert
+		doc := &TestUUIDUser{Name: "Uuid"}
+		id1, err := base.InsertOne(doc)
+		its.NoError(err)
+		id2, err := base.InsertOne(doc)
+		its.NoError(err)
+		its.False(id1.(lxDb.UUID).IsZero())
+		its.NotEqual(id1, id2)
+		its.True(doc.Id.IsZero())
+
+		var result TestUUIDUser
+		its.NoError(base.FindOne(bson.D{{Key: "_id", Value: id2}}, &result))
+		its.Equal(id2, result.Id)
+	})
+	t.Run("struct_ulid", func(t *testing.T) {
+		setupData(db)
+		base := lxDb.NewMongoBaseRepo(collection, lxDb.ULIDGenerator())
+
+		// Zero ULID and nil pointer are filled
+		id1, err := base.InsertOne(&TestULIDUser{Id: &lxDb.ULID{}, Name: "Ulid"})
+		its.NoError(err)
+		id2, err := base.InsertOne(&TestULIDUser{Name: "Ulid"})
+		its.NoError(err)
+		its.False(id1.(lxDb.ULID).IsZero())
+		its.False(id2.(lxDb.ULID).IsZero())
+		its.NotEqual(id1, id2)
+
+		n, err := base.CountDocuments(bson.D{{Key: "name", Value: "Ulid"}})
+		its.NoError(err)
+		its.Equal(int64(2), n)
+	})
+	t.Run("insert_many_audit", func(t *testing.T) {
+		setupData(db)
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, lxDb.WithIdGenerator(lxDb.ULIDGenerator()))
+
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) {
+			entries := elem.([]bson.M)
+			its.Len(entries, 2)
+			_, ok := entries[0]["data"].(bson.M)["_id"].(lxDb.ULID)
+			its.True(ok)
+		})
+
+		res, err := base.InsertMany([]interface{}{bson.M{"name": "A"}, bson.D{{Key: "name", Value: "B"}}}, lxDb.SetAuditAuth(getTestAuditUser()))
+		its.NoError(err)
+		its.Len(res.InsertedIDs, 2)
+	})
+	t.Run("upsert_and_delete", func(t *testing.T) {
+		setupData(db)
+		gen, err := lxDb.SnowflakeGenerator(1)
+		its.NoError(err)
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, lxDb.WithIdGenerator(gen))
+
+		filter := bson.D{{Key: "email", Value: "upsert@example.com"}}
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Upsert"}}}}, options.Update().SetUpsert(true)))
+
+		var result bson.M
+		its.NoError(base.FindOne(filter, &result))
+		id, ok := result["_id"].(int64)
+		its.True(ok)
+
+		// Replace keeps id
+		its.NoError(base.ReplaceOne(filter, bson.M{"email": "upsert@example.com", "name": "Replaced"}, options.Replace().SetUpsert(true)))
+		its.NoError(base.FindOne(filter, &result))
+		its.Equal(id, result["_id"])
+		its.Equal("Replaced", result["name"])
+
+		// Delete with audit of int64 id
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true)
+		mockIBaseRepoAudit.EXPECT().Send(bson.M{
+			"collection": TestCollection,
+			"action":     lxDb.Delete,
+			"user":       getTestAuditUser(),
+			"data":       bson.M{"_id": id},
+		})
+		its.NoError(base.DeleteOne(filter, lxDb.SetAuditAuth(getTestAuditUser())))
+	})
+}
+
+func TestMongoBaseRepo_Hooks(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+	auditUser := getTestAuditUser()
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	t.Run("order_and_mutate", func(t *testing.T) {
+		setupData(db)
+
+		var calls []string
+		hooks := lxDb.NewHooks().
+			Before(lxDb.HookAll, func(hc *lxDb.HookContext) error {
+				calls = append(calls, "all:"+hc.Operation)
+				return nil
+			}).
+			Before(lxDb.HookInsert, func(hc *lxDb.HookContext) error {
+				calls = append(calls, "insert")
+				doc := hc.Doc.(bson.M)
+				doc["created_by"] = hc.User
+				return nil
+			}).
+			After(lxDb.HookInsert, func(hc *lxDb.HookContext) {
+				calls = append(calls, "after")
+				its.Len(hc.IDs, 1)
+				its.Equal(hc.IDs[0], hc.Result)
+			})
+		base := lxDb.NewMongoBaseRepo(collection, hooks)
+
+		id, err := base.InsertOne(bson.M{"name": "Hook"}, lxDb.SetAuditAuth(auditUser))
+		its.NoError(err)
+		its.Equal([]string{"all:InsertOne", "insert", "after"}, calls)
+
+		var result bson.M
+		its.NoError(base.FindOne(bson.D{{Key: "_id", Value: id}}, &result))
+		its.Equal(auditUser, result["created_by"])
+
+		// Skip hooks
+		calls = nil
+		_, err = base.InsertOne(bson.M{"name": "NoHook"}, lxDb.SkipHooks{})
+		its.NoError(err)
+		its.Nil(calls)
+	})
+	t.Run("abort", func(t *testing.T) {
+		testUsers := setupData(db)
+		errDenied := errors.New("denied")
+		hooks := lxDb.NewHooks().Before(lxDb.HookDelete, func(hc *lxDb.HookContext) error {
+			return errDenied
+		})
+		base := lxDb.NewMongoBaseRepo(collection, hooks)
+
+		err := base.DeleteOne(bson.D{{Key: "_id", Value: testUsers[0].Id}})
+		its.True(errors.Is(err, errDenied))
+
+		count, err := base.CountDocuments(bson.D{})
+		its.NoError(err)
+		its.Equal(int64(len(testUsers)), count)
+	})
+	t.Run("images_with_audit", func(t *testing.T) {
+		testUsers := setupData(db)
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true).AnyTimes()
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).AnyTimes()
+
+		var after *lxDb.HookContext
+		hooks := lxDb.NewHooks().After(lxDb.HookUpdate, func(hc *lxDb.HookContext) {
+			after = hc
+		})
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, hooks)
+
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Updated"}}}}
+		its.NoError(base.UpdateOne(filter, update, lxDb.SetAuditAuth(auditUser)))
+
+		its.NotNil(after)
+		its.Equal("UpdateOne", after.Operation)
+		its.Equal(auditUser, after.User)
+		its.Equal([]interface{}{testUsers[0].Id}, after.IDs)
+		its.Len(after.Images, 1)
+		its.Equal(testUsers[0].Name, after.Images[0].Before["name"])
+		its.Equal("Updated", after.Images[0].After["name"])
+
+		// Without audit no images
+		after = nil
+		its.NoError(base.UpdateOne(filter, update))
+		its.NotNil(after)
+		its.Empty(after.Images)
+	})
+	t.Run("ids_without_audit", func(t *testing.T) {
+		testUsers := setupData(db)
+
+		var after *lxDb.HookContext
+		hooks := lxDb.NewHooks().After(lxDb.HookAll, func(hc *lxDb.HookContext) {
+			after = hc
+		})
+		base := lxDb.NewMongoBaseRepo(collection, hooks)
+
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Updated"}}}}))
+		its.Equal([]interface{}{testUsers[0].Id}, after.IDs)
+
+		its.NoError(base.ReplaceOne(filter, bson.M{"name": "Replaced", "email": "replaced@example.com"}))
+		its.Equal([]interface{}{testUsers[0].Id}, after.IDs)
+
+		var males []interface{}
+		for _, u := range testUsers {
+			if u.Gender == "Male" {
+				males = append(males, u.Id)
+			}
+		}
+		res, err := base.UpdateMany(bson.D{{Key: "gender", Value: "Male"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "is_active", Value: true}}}})
+		its.NoError(err)
+		its.Equal(int64(len(males)), res.MatchedCount)
+		its.ElementsMatch(males, after.IDs)
+
+		delRes, err := base.DeleteMany(bson.D{{Key: "gender", Value: "Male"}})
+		its.NoError(err)
+		its.Equal(int64(len(males)), delRes.DeletedCount)
+		its.ElementsMatch(males, after.IDs)
+	})
+}
+
+func TestMongoBaseRepo_SchemaValidation(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+
+	lxSchema.InitJsonSchemaLoader()
+	its.NoError(lxSchema.Loader.SetSchemaRootDirectory("testdata"))
+	base := lxDb.NewMongoBaseRepo(collection, &lxDb.SchemaValidation{Loader: lxSchema.Loader, Schema: "user.schema.json"})
+
+	t.Run("insert", func(t *testing.T) {
+		setupData(db)
+
+		_, err := base.InsertOne(&TestUser{Name: "Valid", Email: "valid@example.com", Gender: "Male"})
+		its.NoError(err)
+
+		_, err = base.InsertOne(bson.M{"name": "Invalid", "gender": "Unknown"})
+		its.True(errors.Is(err, lxDb.ErrValidation))
+		var valErr *lxDb.ValidationError
+		its.True(errors.As(err, &valErr))
+		its.Len(valErr.Result.Errors, 2)
+
+		_, err = base.InsertMany([]interface{}{
+			bson.M{"name": "A", "email": "a@example.com"},
+			bson.M{"name": "", "email": "b@example.com"},
+		})
+		its.True(errors.As(err, &valErr))
+		its.Equal(1, valErr.Index)
+
+		// Skip validation
+		_, err = base.InsertOne(bson.M{"name": "Skipped"}, lxDb.SkipValidation{})
+		its.NoError(err)
+	})
+	t.Run("replace", func(t *testing.T) {
+		testUsers := setupData(db)
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+
+		err := base.ReplaceOne(filter, bson.M{"name": "Replaced"})
+		its.True(errors.Is(err, lxDb.ErrValidation))
+
+		its.NoError(base.ReplaceOne(filter, bson.M{"name": "Replaced", "email": "replaced@example.com"}))
+	})
+	t.Run("update", func(t *testing.T) {
+		testUsers := setupData(db)
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+
+		// Simulated $set and $unset
+		err := base.UpdateOne(filter, bson.D{{Key: "$unset", Value: bson.D{{Key: "email", Value: ""}}}})
+		its.True(errors.Is(err, lxDb.ErrValidation))
+		err = base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "unknown", Value: 1}}}})
+		its.True(errors.Is(err, lxDb.ErrValidation))
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Updated"}}}}))
+
+		_, err = base.UpdateMany(bson.D{{Key: "gender", Value: "Male"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "gender", Value: "Other"}}}})
+		its.True(errors.Is(err, lxDb.ErrValidation))
+
+		// Upsert with filter fields
+		err = base.UpdateOne(bson.D{{Key: "email", Value: "new@example.com"}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "is_active", Value: true}}}}, options.Update().SetUpsert(true))
+		its.True(errors.Is(err, lxDb.ErrValidation))
+
+		// Other operators are validated in a transaction, not supported by standalone servers
+		err = base.UpdateOne(filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "logins", Value: 1}}}})
+		its.True(err == nil || errors.Is(err, lxDb.ErrUpdateValidation), err)
+	})
+	t.Run("update_array", func(t *testing.T) {
+		testUsers := setupData(db)
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "tags", Value: bson.A{"a", "b"}}}}}))
+
+		// Array index is not simulated as document field
+		err := base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "tags.1", Value: "c"}}}})
+		its.True(err == nil || errors.Is(err, lxDb.ErrUpdateValidation), err)
+
+		// Array filters of caller are used
+		err = base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "tags.$[t]", Value: "x"}}}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: bson.A{bson.D{{Key: "t", Value: "a"}}}}))
+		its.True(err == nil || errors.Is(err, lxDb.ErrUpdateValidation), err)
+		err = base.FindOneAndUpdate(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "tags.$[t]", Value: 1}}}}, &bson.M{},
+			options.FindOneAndUpdate().SetArrayFilters(options.ArrayFilters{Filters: bson.A{bson.D{{Key: "t", Value: "b"}}}}))
+		its.True(errors.Is(err, lxDb.ErrValidation) || errors.Is(err, lxDb.ErrUpdateValidation), err)
+	})
+	t.Run("update_once", func(t *testing.T) {
+		testUsers := setupData(db)
+		filter := bson.D{{Key: "_id", Value: testUsers[0].Id}}
+
+		// Valid update is written once
+		err := base.UpdateOne(filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "logins", Value: 1}}}})
+		if errors.Is(err, lxDb.ErrUpdateValidation) {
+			t.Skip("transactions not supported by server")
+		}
+		its.NoError(err)
+		var result bson.M
+		its.NoError(base.FindOne(filter, &result))
+		its.EqualValues(1, result["logins"])
+
+		// Invalid update is not committed
+		err = base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "tags.0", Value: 1}}}, {Key: "$inc", Value: bson.D{{Key: "logins", Value: 1}}}},
+			options.Update().SetUpsert(false))
+		its.True(errors.Is(err, lxDb.ErrValidation), err)
+		its.NoError(base.FindOne(filter, &result))
+		its.EqualValues(1, result["logins"])
+
+		// Many with documents before and after update in result
+		res, err := base.UpdateMany(bson.D{{Key: "gender", Value: "Male"}}, bson.D{{Key: "$inc", Value: bson.D{{Key: "logins", Value: 2}}}})
+		its.NoError(err)
+		its.True(res.MatchedCount > 0)
+		its.Equal(res.MatchedCount, res.ModifiedCount)
+
+		var updated bson.M
+		its.NoError(base.FindOneAndUpdate(filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "logins", Value: 1}}}}, &updated,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)))
+		its.NoError(base.FindOne(filter, &result))
+		its.Equal(result["logins"], updated["logins"])
+	})
+}
+
+func TestMongoBaseRepo_Populate(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	testUsers := setupData(db)
+
+	orders := db.Collection("orders")
+	_, err = orders.DeleteMany(context.Background(), bson.D{})
+	its.NoError(err)
+	_, err = orders.InsertMany(context.Background(), []interface{}{
+		bson.M{"no": 1, "customerId": testUsers[0].Id, "watcherIds": bson.A{testUsers[2].Id, primitive.NewObjectID(), testUsers[1].Id},
+			"items": bson.A{bson.M{"sellerId": testUsers[3].Id}, bson.M{"sellerId": testUsers[4].Id}}},
+		bson.M{"no": 2, "customerId": primitive.NewObjectID()},
+	})
+	its.NoError(err)
+
+	base := lxDb.NewMongoBaseRepo(orders)
+	projection := bson.D{{Key: "name", Value: 1}}
+
+	t.Run("find", func(t *testing.T) {
+		var result []bson.M
+		its.NoError(base.Find(bson.D{}, &result,
+			options.Find().SetSort(bson.D{{Key: "no", Value: 1}}),
+			lxDb.Populate("customerId", TestCollection, "customer", projection),
+			lxDb.Populate("watcherIds", TestCollection, "watchers", projection),
+			lxDb.Populate("items.sellerId", TestCollection, "items.seller", projection)))
+		its.Len(result, 2)
+
+		// Single reference with projection
+		its.Equal(bson.M{"_id": testUsers[0].Id, "name": testUsers[0].Name}, result[0]["customer"])
+		its.Nil(result[1]["customer"])
+
+		// Array in order, missing reference left out
+		watchers := result[0]["watchers"].(bson.A)
+		its.Len(watchers, 2)
+		its.Equal(testUsers[2].Id, watchers[0].(bson.M)["_id"])
+		its.Equal(testUsers[1].Id, watchers[1].(bson.M)["_id"])
+
+		// Nested in array elements
+		items := result[0]["items"].(bson.A)
+		its.Equal(testUsers[4].Name, items[1].(bson.M)["seller"].(bson.M)["name"])
+	})
+	t.Run("exclusion", func(t *testing.T) {
+		// Excluded foreign field is used for matching only
+		var result bson.M
+		its.NoError(base.FindOne(bson.D{{Key: "no", Value: 1}}, &result,
+			lxDb.Populate("customerId", TestCollection, "customer", bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: 1}})))
+		its.Equal(bson.M{"name": testUsers[0].Name}, result["customer"])
+
+		result = nil
+		its.NoError(base.FindOne(bson.D{{Key: "no", Value: 1}}, &result,
+			lxDb.Populate("customerId", TestCollection, "customer", bson.D{{Key: "_id", Value: 0}})))
+		customer := result["customer"].(bson.M)
+		its.Equal(testUsers[0].Email, customer["email"])
+		its.NotContains(customer, "_id")
+	})
+	t.Run("find_one_struct", func(t *testing.T) {
+		var result struct {
+			No       int      `bson:"no"`
+			Customer TestUser `bson:"customer"`
+		}
+		its.NoError(base.FindOne(bson.D{{Key: "no", Value: 1}}, &result, lxDb.Populate("customerId", TestCollection, "customer", nil)))
+		its.Equal(1, result.No)
+		its.Equal(testUsers[0].Email, result.Customer.Email)
+	})
+}
+
+func TestMongoBaseRepo_History(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	testUsers := setupData(db)
+	collection := db.Collection(TestCollection)
+
+	_, err = db.Collection(TestCollection+lxDb.HistorySuffix).DeleteMany(context.Background(), bson.D{})
+	its.NoError(err)
+
+	base := lxDb.NewMongoBaseRepo(collection, &lxDb.HistoryOptions{MaxRevisions: 3})
+	history := base.(lxDb.IHistoryRepo)
+	auditAuth := lxDb.SetAuditAuth(getTestAuditUser())
+	id := testUsers[0].Id
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	t.Run("record", func(t *testing.T) {
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Revision 1"}}}}, auditAuth))
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Revision 2"}}}}, auditAuth))
+
+		// Unchanged document and write without user are not recorded
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Revision 2"}}}}, auditAuth))
+		its.NoError(base.UpdateOne(filter, bson.D{{Key: "$set", Value: bson.D{{Key: "gender", Value: "Diverse"}}}}))
+
+		revisions, err := history.Revisions(id)
+		its.NoError(err)
+		its.Len(revisions, 2)
+		its.Equal(int64(2), revisions[0].Revision)
+		its.Equal(lxDb.HistoryUpdate, revisions[0].Type)
+		its.Nil(revisions[0].Document)
+
+		rev, err := history.GetRevision(id, 1)
+		its.NoError(err)
+		its.Equal(testUsers[0].Name, rev.Document["name"])
+
+		_, err = history.GetRevision(id, 10)
+		its.True(errors.Is(err, lxDb.ErrNotFound))
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		changes, err := history.DiffRevisions(id, 1, 0)
+		its.NoError(err)
+		its.Equal([]lxDb.RevisionChange{
+			{Field: "gender", Old: testUsers[0].Gender, New: "Diverse"},
+			{Field: "name", Old: testUsers[0].Name, New: "Revision 2"},
+		}, changes)
+	})
+
+	t.Run("revert", func(t *testing.T) {
+		its.NoError(base.DeleteOne(filter, auditAuth))
+		its.NoError(history.RevertRevision(id, 1, auditAuth))
+
+		var result TestUser
+		its.NoError(base.FindOne(filter, &result))
+		its.Equal(testUsers[0].Name, result.Name)
+
+		// Retention keeps MaxRevisions, revert of deleted document has no revision
+		revisions, err := history.Revisions(id)
+		its.NoError(err)
+		its.Len(revisions, 3)
+		its.Equal(int64(3), revisions[0].Revision)
+		its.Equal(lxDb.HistoryDelete, revisions[0].Type)
+		its.Equal(int64(1), revisions[2].Revision)
+
+		its.NoError(history.RevertRevision(id, 2, auditAuth))
+		revisions, err = history.Revisions(id)
+		its.NoError(err)
+		its.Len(revisions, 3)
+		its.Equal(lxDb.HistoryRevert, revisions[0].Type)
+		its.Equal(int64(2), revisions[2].Revision)
+	})
+
+	t.Run("max_age_index", func(t *testing.T) {
+		ttl := func() interface{} {
+			cur, err := db.Collection(TestCollection + lxDb.HistorySuffix).Indexes().List(context.Background())
+			its.NoError(err)
+			var indexes []bson.M
+			its.NoError(cur.All(context.Background(), &indexes))
+			for _, idx := range indexes {
+				if idx["name"] == "timestamp_1" {
+					return idx["expireAfterSeconds"]
+				}
+			}
+			return nil
+		}
+		update := bson.D{{Key: "$inc", Value: bson.D{{Key: "logins", Value: 1}}}}
+
+		// Old revisions expire by TTL index
+		its.NoError(lxDb.NewMongoBaseRepo(collection, &lxDb.HistoryOptions{MaxAge: time.Hour}).UpdateOne(filter, update, auditAuth))
+		its.EqualValues(3600, ttl())
+
+		// Changed MaxAge
+		its.NoError(lxDb.NewMongoBaseRepo(collection, &lxDb.HistoryOptions{MaxAge: time.Hour * 2}).UpdateOne(filter, update, auditAuth))
+		its.EqualValues(7200, ttl())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := lxDb.NewMongoBaseRepo(collection).(lxDb.IHistoryRepo).Revisions(id)
+		its.True(errors.Is(err, lxDb.ErrHistoryDisabled))
+	})
+}
+
+func TestMongoBaseRepo_FindNear(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	collection := client.Database(TestDbName).Collection("stores")
+	its.NoError(collection.Drop(context.Background()))
+
+	type store struct {
+		Name     string     `bson:"name"`
+		Location lxDb.Point `bson:"location"`
+		Distance float64    `bson:"distance,omitempty"`
+	}
+
+	base := lxDb.NewMongoBaseRepo(collection)
+	names, err := base.CreateIndexes(lxDb.GeoIndex("location"))
+	its.NoError(err)
+	its.Equal([]string{"location_2dsphere"}, names)
+
+	_, err = base.InsertMany([]interface{}{
+		store{Name: "Alexanderplatz", Location: lxDb.NewPoint(13.4132, 52.5219)},
+		store{Name: "Potsdamer Platz", Location: lxDb.NewPoint(13.3761, 52.5096)},
+		store{Name: "Hamburg", Location: lxDb.NewPoint(9.9937, 53.5511)},
+	})
+	its.NoError(err)
+
+	t.Run("near", func(t *testing.T) {
+		var result []store
+		its.NoError(base.(lxDb.IGeoRepo).FindNear(&lxDb.GeoNearOptions{Near: lxDb.NewPoint(13.405, 52.52), MaxDistance: 10000}, &result))
+		its.Len(result, 2)
+		its.Equal("Alexanderplatz", result[0].Name)
+		its.True(result[0].Distance > 0 && result[0].Distance < result[1].Distance)
+	})
+	t.Run("within", func(t *testing.T) {
+		var result []store
+		its.NoError(base.Find(lxDb.Where("location").GeoWithin(lxDb.Polygon{Coordinates: [][]lxDb.Position{
+			{{13.0, 52.3}, {13.8, 52.3}, {13.8, 52.7}, {13.0, 52.7}, {13.0, 52.3}},
+		}}), &result))
+		its.Len(result, 2)
+
+		its.NoError(base.Find(lxDb.Where("location").GeoWithinCenterSphere(lxDb.NewPoint(10, 53.55).Coordinates, 5.0/6378.1), &result))
+		its.Len(result, 1)
+		its.Equal("Hamburg", result[0].Name)
+	})
+}
+
+func TestMongoBaseRepo_Relations(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+	db := client.Database(TestDbName)
+
+	relations := lxDb.NewRelations()
+	its.NoError(relations.Add(lxDb.Relation{Parent: "customers", Child: "addresses", Field: "customerId", OnDelete: lxDb.OnDeleteCascade}))
+	its.NoError(relations.Add(lxDb.Relation{Parent: "addresses", Child: "deliveries", Field: "addressId", OnDelete: lxDb.OnDeleteCascade}))
+	its.NoError(relations.Add(lxDb.Relation{Parent: "customers", Child: "contacts", Field: "customerId", OnDelete: lxDb.OnDeleteSetNull}))
+	its.NoError(relations.Add(lxDb.Relation{Parent: "customers", Child: "invoices", Field: "customerId", OnDelete: lxDb.OnDeleteRestrict}))
+
+	setup := func() (primitive.ObjectID, primitive.ObjectID) {
+		for _, name := range []string{"customers", "addresses", "deliveries", "contacts", "invoices"} {
+			_, err := db.Collection(name).DeleteMany(context.Background(), bson.D{})
+			its.NoError(err)
+		}
+		free, blocked := primitive.NewObjectID(), primitive.NewObjectID()
+		addressID := primitive.NewObjectID()
+		_, err := db.Collection("customers").InsertMany(context.Background(), []interface{}{bson.M{"_id": free}, bson.M{"_id": blocked}})
+		its.NoError(err)
+		_, err = db.Collection("addresses").InsertOne(context.Background(), bson.M{"_id": addressID, "customerId": free})
+		its.NoError(err)
+		_, err = db.Collection("deliveries").InsertOne(context.Background(), bson.M{"addressId": addressID})
+		its.NoError(err)
+		_, err = db.Collection("contacts").InsertOne(context.Background(), bson.M{"customerId": free})
+		its.NoError(err)
+		_, err = db.Collection("invoices").InsertOne(context.Background(), bson.M{"customerId": blocked})
+		its.NoError(err)
+		return free, blocked
+	}
+	count := func(name string, filter interface{}) int64 {
+		n, err := db.Collection(name).CountDocuments(context.Background(), filter)
+		its.NoError(err)
+		return n
+	}
+
+	base := lxDb.NewMongoBaseRepo(db.Collection("customers"), relations, mockIBaseRepoAudit)
+
+	t.Run("dry run", func(t *testing.T) {
+		free, _ := setup()
+		plan, err := base.(lxDb.IRelationRepo).PlanDelete(bson.D{})
+		its.NoError(err)
+		its.Len(plan.IDs, 2)
+		its.Len(plan.Changes, 3)
+		its.Len(plan.Restricted, 1)
+		its.Equal("invoices", plan.Restricted[0].Collection)
+
+		// Nothing changed
+		its.Equal(int64(1), count("addresses", bson.D{{Key: "customerId", Value: free}}))
+	})
+	t.Run("restrict", func(t *testing.T) {
+		_, blocked := setup()
+		err := base.DeleteOne(bson.D{{Key: "_id", Value: blocked}})
+		var restrictErr *lxDb.RestrictError
+		its.True(errors.As(err, &restrictErr))
+		its.True(errors.Is(err, lxDb.ErrRestrict))
+		its.Equal("invoices", restrictErr.References[0].Collection)
+		its.Equal(int64(1), count("customers", bson.D{{Key: "_id", Value: blocked}}))
+
+		// Nothing is deleted when one parent is restricted
+		_, err = base.DeleteMany(bson.D{})
+		its.True(errors.Is(err, lxDb.ErrRestrict))
+		its.Equal(int64(2), count("customers", bson.D{}))
+		its.Equal(int64(1), count("deliveries", bson.D{}))
+	})
+	t.Run("cascade", func(t *testing.T) {
+		free, _ := setup()
+
+		// Parent, address, delivery and contact are audited
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true).Times(2)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(entries interface{}) {
+			its.Len(entries, 3)
+		}).Times(1)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Times(1)
+
+		its.NoError(base.DeleteOne(bson.D{{Key: "_id", Value: free}}, lxDb.SetAuditAuth(getTestAuditUser())))
+		its.Equal(int64(0), count("customers", bson.D{{Key: "_id", Value: free}}))
+		its.Equal(int64(0), count("addresses", bson.D{}))
+		its.Equal(int64(0), count("deliveries", bson.D{}))
+		its.Equal(int64(1), count("contacts", bson.D{{Key: "customerId", Value: nil}}))
+	})
+	t.Run("deleted parent only", func(t *testing.T) {
+		free, _ := setup()
+		second := primitive.NewObjectID()
+		_, err := db.Collection("customers").InsertOne(context.Background(), bson.M{"_id": second})
+		its.NoError(err)
+		_, err = db.Collection("addresses").InsertOne(context.Background(), bson.M{"customerId": second})
+		its.NoError(err)
+
+		// Children of the planned and deleted parent are changed
+		filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{free, second}}}}}
+		its.NoError(base.DeleteOne(filter, options.FindOneAndDelete().SetSort(bson.D{{Key: "_id", Value: -1}})))
+		its.Equal(int64(0), count("customers", bson.D{{Key: "_id", Value: second}}))
+		its.Equal(int64(0), count("addresses", bson.D{{Key: "customerId", Value: second}}))
+		its.Equal(int64(1), count("addresses", bson.D{{Key: "customerId", Value: free}}))
+
+		// Without deleted parent nothing is changed
+		its.True(errors.Is(base.DeleteOne(bson.D{{Key: "_id", Value: second}}), lxDb.ErrNotFound))
+		res, err := base.DeleteMany(bson.D{{Key: "_id", Value: primitive.NewObjectID()}})
+		its.NoError(err)
+		its.Equal(int64(0), res.DeletedCount)
+		its.Equal(int64(1), count("contacts", bson.D{{Key: "customerId", Value: free}}))
+	})
+	t.Run("set null in arrays", func(t *testing.T) {
+		free, blocked := setup()
+		for _, name := range []string{"teams", "tickets"} {
+			_, err := db.Collection(name).DeleteMany(context.Background(), bson.D{})
+			its.NoError(err)
+		}
+		_, err := db.Collection("teams").InsertOne(context.Background(), bson.M{"memberIds": bson.A{free, blocked}})
+		its.NoError(err)
+		_, err = db.Collection("tickets").InsertMany(context.Background(), []interface{}{
+			bson.M{"no": 1, "watchers": bson.A{bson.M{"customerId": free}, bson.M{"customerId": blocked}}},
+			bson.M{"no": 2, "watchers": bson.M{"customerId": free}},
+		})
+		its.NoError(err)
+
+		arrays := lxDb.NewRelations()
+		its.NoError(arrays.Add(lxDb.Relation{Parent: "customers", Child: "teams", Field: "memberIds", OnDelete: lxDb.OnDeleteSetNull}))
+		its.NoError(arrays.Add(lxDb.Relation{Parent: "customers", Child: "tickets", Field: "watchers.customerId", OnDelete: lxDb.OnDeleteSetNull}))
+		its.NoError(lxDb.NewMongoBaseRepo(db.Collection("customers"), arrays).DeleteOne(bson.D{{Key: "_id", Value: free}}))
+
+		// Deleted reference is pulled from array
+		var team bson.M
+		its.NoError(db.Collection("teams").FindOne(context.Background(), bson.D{}).Decode(&team))
+		its.Equal(bson.A{blocked}, team["memberIds"])
+
+		// Set to null in matching elements and in embedded document
+		var ticket bson.M
+		its.NoError(db.Collection("tickets").FindOne(context.Background(), bson.D{{Key: "no", Value: 1}}).Decode(&ticket))
+		its.Equal(bson.A{bson.M{"customerId": nil}, bson.M{"customerId": blocked}}, ticket["watchers"])
+		its.NoError(db.Collection("tickets").FindOne(context.Background(), bson.D{{Key: "no", Value: 2}}).Decode(&ticket))
+		its.Equal(bson.M{"customerId": nil}, ticket["watchers"])
+	})
+}
+
+func TestMongoBaseRepo_ReadAudit(t *testing.T) {
+	its := assert.New(t)
+
+	client, err := lxDb.GetMongoDbClient(dbHost)
+	its.NoError(err)
+
+	db := client.Database(TestDbName)
+	collection := db.Collection(TestCollection)
+	testUsers := setupData(db)
+	auditUser := getTestAuditUser()
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	t.Run("find", func(t *testing.T) {
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, &lxDb.ReadAuditOptions{MaxIDs: 3})
+
+		filter := bson.D{{Key: "gender", Value: "Male"}}
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) {
+			entry := elem.(bson.M)
+			data := entry["data"].(bson.M)
+			its.Equal(TestCollection, entry["collection"])
+			its.Equal(lxDb.Read, entry["action"])
+			its.Equal(auditUser, entry["user"])
+			its.Equal("find", data["operation"])
+			its.Equal(filter, data["filter"])
+			its.Equal(int64(12), data["count"])
+			its.Len(data["ids"], 3)
+		})
+
+		var result []TestUser
+		its.NoError(base.Find(filter, &result, lxDb.SetAuditAuth(auditUser)))
+		its.Len(result, 12)
+
+		// Without auth no audit
+		its.NoError(base.Find(filter, &result))
+	})
+	t.Run("find_one_hashed", func(t *testing.T) {
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, &lxDb.ReadAuditOptions{HashValues: true, HashSalt: "salt"})
+
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) {
+			data := elem.(bson.M)["data"].(bson.M)
+			filter := data["filter"].(bson.D)
+			its.Equal("email", filter[0].Key)
+			its.Len(filter[0].Value, 64)
+			its.NotEqual(testUsers[0].Email, filter[0].Value)
+			its.Equal([]interface{}{testUsers[0].Id}, data["ids"])
+			its.Equal(int64(1), data["count"])
+		})
+
+		var result TestUser
+		its.NoError(base.FindOne(bson.D{{Key: "email", Value: testUsers[0].Email}}, &result, lxDb.SetAuditAuth(auditUser)))
+	})
+	t.Run("count_and_aggregate_window", func(t *testing.T) {
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, &lxDb.ReadAuditOptions{AggregateWindow: time.Millisecond * 100})
+
+		sent := make(chan interface{}, 1)
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true).Times(3)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) { sent <- elem })
+
+		filter := bson.D{{Key: "is_active", Value: true}}
+		for i := 0; i < 2; i++ {
+			count, err := base.CountDocuments(filter, lxDb.SetAuditAuth(auditUser))
+			its.NoError(err)
+			its.Equal(int64(12), count)
+		}
+		var result []bson.M
+		its.NoError(base.Aggregate(lxDb.NewPipeline().Match(filter), &result, lxDb.SetAuditAuth(auditUser)))
+
+		entries := (<-sent).([]bson.M)
+		its.Len(entries, 2)
+		its.Equal("count", entries[0]["data"].(bson.M)["operation"])
+		its.Equal(int64(2), entries[0]["data"].(bson.M)["calls"])
+		its.Equal(int64(24), entries[0]["data"].(bson.M)["count"])
+		its.Equal("aggregate", entries[1]["data"].(bson.M)["operation"])
+		its.Len(entries[1]["data"].(bson.M)["ids"], 12)
+	})
+	t.Run("flush", func(t *testing.T) {
+		mockIBaseRepoAudit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		base := lxDb.NewMongoBaseRepo(collection, mockIBaseRepoAudit, &lxDb.ReadAuditOptions{AggregateWindow: time.Hour})
+
+		mockIBaseRepoAudit.EXPECT().IsActive().Return(true)
+		mockIBaseRepoAudit.EXPECT().Send(gomock.Any()).Do(func(elem interface{}) {
+			its.Len(elem, 1)
+		})
+
+		_, err := base.CountDocuments(bson.D{{Key: "is_active", Value: true}}, lxDb.SetAuditAuth(auditUser))
+		its.NoError(err)
+
+		// Pending entries are sent without waiting for the window
+		base.(lxDb.IReadAuditRepo).FlushReadAudit()
+		base.(lxDb.IReadAuditRepo).FlushReadAudit()
+	})
+}
+
 /////////////////////////////////////////////////////////////////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,471 @@
+package lxDb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Pipeline, fluent builder for aggregation pipelines
+// Example:
+// pipeline := lxDb.NewPipeline().Match(bson.M{"status": "open"}).
+// Group("$customerId", lxDb.Sum("total", "$amount"), lxDb.Count("orders")).
+// Sort(bson.E{Key: "total", Value: -1}).Limit(10)
+// err := repo.Aggregate(pipeline, &result)
+type Pipeline struct {
+	stages []bson.D
+	errs   []string
+}
+
+// NewPipeline, return empty pipeline
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// add, append stage
+func (p *Pipeline) add(name string, value interface{}) *Pipeline {
+	p.stages = append(p.stages, bson.D{{Key: name, Value: value}})
+	return p
+}
+
+// fail, save validation error of stage
+func (p *Pipeline) fail(format string, a ...interface{}) *Pipeline {
+	p.errs = append(p.errs, fmt.Sprintf("stage %d: ", len(p.stages))+fmt.Sprintf(format, a...))
+	return p
+}
+
+// Stage, append a raw stage
+func (p *Pipeline) Stage(stage bson.D) *Pipeline {
+	if len(stage) != 1 || !strings.HasPrefix(stage[0].Key, "$") {
+		return p.fail("raw stage must have exactly one operator")
+	}
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// Match, $match stage with filter
+func (p *Pipeline) Match(filter interface{}) *Pipeline {
+	return p.add("$match", filter)
+}
+
+// GeoNear, $geoNear stage, must be the first stage, see FindNear
+func (p *Pipeline) GeoNear(near *GeoNearOptions) *Pipeline {
+	if near == nil {
+		return p.fail("$geoNear requires near")
+	}
+	stage, err := near.stage()
+	if err != nil {
+		return p.fail("$geoNear: %v", err)
+	}
+	return p.add("$geoNear", stage)
+}
+
+// Project, $project stage
+func (p *Pipeline) Project(projection interface{}) *Pipeline {
+	return p.add("$project", projection)
+}
+
+// Group, $group stage with _id expression and accumulators
+func (p *Pipeline) Group(id interface{}, accumulators ...bson.E) *Pipeline {
+	group := bson.D{{Key: "_id", Value: id}}
+	for _, acc := range accumulators {
+		if acc.Key == "_id" {
+			return p.fail("$group accumulator can't be named _id")
+		}
+		group = append(group, acc)
+	}
+	return p.add("$group", group)
+}
+
+// Lookup, $lookup stage with equality match
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	if from == "" || as == "" {
+		return p.fail("$lookup requires from and as")
+	}
+	return p.add("$lookup", bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	})
+}
+
+// LookupPipeline, $lookup stage with let variables and sub pipeline
+func (p *Pipeline) LookupPipeline(from string, let bson.D, pipeline *Pipeline, as string) *Pipeline {
+	if from == "" || as == "" {
+		return p.fail("$lookup requires from and as")
+	}
+	sub, err := pipeline.Build()
+	if err != nil {
+		return p.fail("$lookup pipeline: %v", err)
+	}
+	if name := pipeline.firstOf("$out", "$merge"); name != "" {
+		return p.fail("$lookup pipeline can't contain %s", name)
+	}
+
+	lookup := bson.D{{Key: "from", Value: from}}
+	if len(let) > 0 {
+		lookup = append(lookup, bson.E{Key: "let", Value: let})
+	}
+	lookup = append(lookup,
+		bson.E{Key: "pipeline", Value: sub},
+		bson.E{Key: "as", Value: as})
+
+	return p.add("$lookup", lookup)
+}
+
+// Unwind, $unwind stage, path with or without $ prefix
+func (p *Pipeline) Unwind(path string, preserveNullAndEmptyArrays ...bool) *Pipeline {
+	if path == "" {
+		return p.fail("$unwind requires path")
+	}
+	path = FieldRef(path)
+
+	if len(preserveNullAndEmptyArrays) > 0 && preserveNullAndEmptyArrays[0] {
+		return p.add("$unwind", bson.D{
+			{Key: "path", Value: path},
+			{Key: "preserveNullAndEmptyArrays", Value: true},
+		})
+	}
+	return p.add("$unwind", path)
+}
+
+// Facet, $facet stage, facets are sorted by name
+func (p *Pipeline) Facet(facets map[string]*Pipeline) *Pipeline {
+	if len(facets) == 0 {
+		return p.fail("$facet requires at least one facet")
+	}
+
+	names := make([]string, 0, len(facets))
+	for name := range facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	facet := bson.D{}
+	for _, name := range names {
+		sub, err := facets[name].Build()
+		if err != nil {
+			return p.fail("$facet %s: %v", name, err)
+		}
+		if op := facets[name].firstOf("$facet", "$out", "$merge", "$geoNear", "$collStats", "$indexStats"); op != "" {
+			return p.fail("$facet %s can't contain %s", name, op)
+		}
+		facet = append(facet, bson.E{Key: name, Value: sub})
+	}
+
+	return p.add("$facet", facet)
+}
+
+// Bucket, $bucket stage, boundaries must be sorted ascending
+func (p *Pipeline) Bucket(groupBy interface{}, boundaries []interface{}, defaultBucket interface{}, output ...bson.E) *Pipeline {
+	if len(boundaries) < 2 {
+		return p.fail("$bucket requires at least two boundaries")
+	}
+
+	bucket := bson.D{
+		{Key: "groupBy", Value: groupBy},
+		{Key: "boundaries", Value: boundaries},
+	}
+	if defaultBucket != nil {
+		bucket = append(bucket, bson.E{Key: "default", Value: defaultBucket})
+	}
+	if len(output) > 0 {
+		bucket = append(bucket, bson.E{Key: "output", Value: bson.D(output)})
+	}
+
+	return p.add("$bucket", bucket)
+}
+
+// Sort, $sort stage
+func (p *Pipeline) Sort(fields ...bson.E) *Pipeline {
+	if len(fields) == 0 {
+		return p.fail("$sort requires at least one field")
+	}
+	return p.add("$sort", bson.D(fields))
+}
+
+// Limit, $limit stage
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	if n <= 0 {
+		return p.fail("$limit must be positive")
+	}
+	return p.add("$limit", n)
+}
+
+// Skip, $skip stage
+func (p *Pipeline) Skip(n int64) *Pipeline {
+	if n < 0 {
+		return p.fail("$skip can't be negative")
+	}
+	return p.add("$skip", n)
+}
+
+// AddFields, $addFields stage
+func (p *Pipeline) AddFields(fields interface{}) *Pipeline {
+	return p.add("$addFields", fields)
+}
+
+// ReplaceRoot, $replaceRoot stage
+func (p *Pipeline) ReplaceRoot(newRoot interface{}) *Pipeline {
+	return p.add("$replaceRoot", bson.D{{Key: "newRoot", Value: newRoot}})
+}
+
+// Count, $count stage
+func (p *Pipeline) Count(field string) *Pipeline {
+	if field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return p.fail("$count invalid field name %q", field)
+	}
+	return p.add("$count", field)
+}
+
+// Merge, $merge stage into collection of the same database,
+// optional fields like on, whenMatched, whenNotMatched
+func (p *Pipeline) Merge(into string, fields ...bson.E) *Pipeline {
+	if into == "" {
+		return p.fail("$merge requires into")
+	}
+	merge := bson.D{{Key: "into", Value: into}}
+	merge = append(merge, fields...)
+	return p.add("$merge", merge)
+}
+
+// Out, $out stage
+func (p *Pipeline) Out(collection string) *Pipeline {
+	if collection == "" {
+		return p.fail("$out requires collection")
+	}
+	return p.add("$out", collection)
+}
+
+// firstOf, return first operator of stages in ops
+func (p *Pipeline) firstOf(ops ...string) string {
+	for _, stage := range p.stages {
+		for _, op := range ops {
+			if stage[0].Key == op {
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+// validate, check stage order
+func (p *Pipeline) validate() []string {
+	errs := append([]string{}, p.errs...)
+	last := len(p.stages) - 1
+	for i, stage := range p.stages {
+		switch stage[0].Key {
+		case "$out", "$merge":
+			if i != last {
+				errs = append(errs, fmt.Sprintf("stage %d: %s must be the last stage", i, stage[0].Key))
+			}
+		case "$geoNear":
+			if i != 0 {
+				errs = append(errs, fmt.Sprintf("stage %d: $geoNear must be the first stage", i))
+			}
+		}
+	}
+	return errs
+}
+
+// Len, return number of stages
+func (p *Pipeline) Len() int {
+	return len(p.stages)
+}
+
+// Build, validate and return pipeline
+func (p *Pipeline) Build() (mongo.Pipeline, error) {
+	if errs := p.validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("%s, %w", strings.Join(errs, "; "), ErrPipeline)
+	}
+
+	pipeline := make(mongo.Pipeline, len(p.stages))
+	copy(pipeline, p.stages)
+	return pipeline, nil
+}
+
+// MustBuild, return pipeline, panics by validation errors
+func (p *Pipeline) MustBuild() mongo.Pipeline {
+	pipeline, err := p.Build()
+	if err != nil {
+		panic(err)
+	}
+	return pipeline
+}
+
+// String, return pipeline as indented relaxed extended json for debugging and golden tests
+func (p *Pipeline) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, stage := range p.stages {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		raw, err := bson.MarshalExtJSON(stage, false, false)
+		if err != nil {
+			buf.WriteString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+			continue
+		}
+		buf.Write(raw)
+	}
+	buf.WriteString("]")
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return buf.String()
+	}
+	return out.String()
+}
+
+// Accumulators for Group and Bucket
+
+// Sum, $sum accumulator
+func Sum(field string, expr interface{}) bson.E {
+	return accumulator(field, "$sum", expr)
+}
+
+// Count, $sum: 1 accumulator
+func Count(field string) bson.E {
+	return accumulator(field, "$sum", 1)
+}
+
+// Avg, $avg accumulator
+func Avg(field string, expr interface{}) bson.E {
+	return accumulator(field, "$avg", expr)
+}
+
+// Min, $min accumulator
+func Min(field string, expr interface{}) bson.E {
+	return accumulator(field, "$min", expr)
+}
+
+// Max, $max accumulator
+func Max(field string, expr interface{}) bson.E {
+	return accumulator(field, "$max", expr)
+}
+
+// First, $first accumulator
+func First(field string, expr interface{}) bson.E {
+	return accumulator(field, "$first", expr)
+}
+
+// Last, $last accumulator
+func Last(field string, expr interface{}) bson.E {
+	return accumulator(field, "$last", expr)
+}
+
+// Push, $push accumulator
+func Push(field string, expr interface{}) bson.E {
+	return accumulator(field, "$push", expr)
+}
+
+// AddToSet, $addToSet accumulator
+func AddToSet(field string, expr interface{}) bson.E {
+	return accumulator(field, "$addToSet", expr)
+}
+
+// accumulator, return field with accumulator
+func accumulator(field, op string, expr interface{}) bson.E {
+	return bson.E{Key: field, Value: bson.D{{Key: op, Value: expr}}}
+}
+
+// Expressions
+
+// FieldRef, return field path with $ prefix
+func FieldRef(path string) string {
+	if strings.HasPrefix(path, "$") {
+		return path
+	}
+	return "$" + path
+}
+
+// Expr, return expression {op: args}, a single arg is not wrapped in an array
+func Expr(op string, args ...interface{}) bson.D {
+	if len(args) == 1 {
+		return bson.D{{Key: op, Value: args[0]}}
+	}
+	return bson.D{{Key: op, Value: bson.A(args)}}
+}
+
+// ExprEq, $eq expression
+func ExprEq(a, b interface{}) bson.D { return Expr("$eq", a, b) }
+
+// ExprNe, $ne expression
+func ExprNe(a, b interface{}) bson.D { return Expr("$ne", a, b) }
+
+// ExprGt, $gt expression
+func ExprGt(a, b interface{}) bson.D { return Expr("$gt", a, b) }
+
+// ExprGte, $gte expression
+func ExprGte(a, b interface{}) bson.D { return Expr("$gte", a, b) }
+
+// ExprLt, $lt expression
+func ExprLt(a, b interface{}) bson.D { return Expr("$lt", a, b) }
+
+// ExprLte, $lte expression
+func ExprLte(a, b interface{}) bson.D { return Expr("$lte", a, b) }
+
+// ExprAnd, $and expression
+func ExprAnd(exprs ...interface{}) bson.D { return bson.D{{Key: "$and", Value: bson.A(exprs)}} }
+
+// ExprOr, $or expression
+func ExprOr(exprs ...interface{}) bson.D { return bson.D{{Key: "$or", Value: bson.A(exprs)}} }
+
+// ExprIn, $in expression
+func ExprIn(value, array interface{}) bson.D { return Expr("$in", value, array) }
+
+// ExprAdd, $add expression
+func ExprAdd(values ...interface{}) bson.D { return bson.D{{Key: "$add", Value: bson.A(values)}} }
+
+// ExprSubtract, $subtract expression
+func ExprSubtract(a, b interface{}) bson.D { return Expr("$subtract", a, b) }
+
+// ExprMultiply, $multiply expression
+func ExprMultiply(values ...interface{}) bson.D {
+	return bson.D{{Key: "$multiply", Value: bson.A(values)}}
+}
+
+// ExprDivide, $divide expression
+func ExprDivide(a, b interface{}) bson.D { return Expr("$divide", a, b) }
+
+// ExprConcat, $concat expression
+func ExprConcat(values ...interface{}) bson.D {
+	return bson.D{{Key: "$concat", Value: bson.A(values)}}
+}
+
+// ExprSize, $size expression
+func ExprSize(array interface{}) bson.D { return Expr("$size", array) }
+
+// ExprIfNull, $ifNull expression
+func ExprIfNull(value, replacement interface{}) bson.D { return Expr("$ifNull", value, replacement) }
+
+// ExprCond, $cond expression
+func ExprCond(cond, then, otherwise interface{}) bson.D {
+	return bson.D{{Key: "$cond", Value: bson.D{
+		{Key: "if", Value: cond},
+		{Key: "then", Value: then},
+		{Key: "else", Value: otherwise},
+	}}}
+}
+
+// ExprDateToString, $dateToString expression
+func ExprDateToString(format string, date interface{}) bson.D {
+	return bson.D{{Key: "$dateToString", Value: bson.D{
+		{Key: "format", Value: format},
+		{Key: "date", Value: date},
+	}}}
+}
+
+// ExprArrayElemAt, $arrayElemAt expression
+func ExprArrayElemAt(array interface{}, index int) bson.D { return Expr("$arrayElemAt", array, index) }
+
+// ExprMergeObjects, $mergeObjects expression
+func ExprMergeObjects(docs ...interface{}) bson.D {
+	return bson.D{{Key: "$mergeObjects", Value: bson.A(docs)}}
+}
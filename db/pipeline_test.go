@@ -0,0 +1,138 @@
+package lxDb_test
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+const PipelineGolden = "testdata/pipeline.golden"
+
+func TestPipeline_Build(t *testing.T) {
+	its := assert.New(t)
+
+	t.Run("stages", func(t *testing.T) {
+		pipeline, err := lxDb.NewPipeline().
+			Match(bson.D{{Key: "is_active", Value: true}}).
+			Project(bson.D{{Key: "name", Value: 1}, {Key: "gender", Value: 1}}).
+			Group("$gender", lxDb.Count("count"), lxDb.Push("names", "$name")).
+			Sort(bson.E{Key: "count", Value: -1}).
+			Skip(5).
+			Limit(10).
+			Build()
+
+		its.NoError(err)
+		its.Equal(mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "is_active", Value: true}}}},
+			{{Key: "$project", Value: bson.D{{Key: "name", Value: 1}, {Key: "gender", Value: 1}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$gender"},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "names", Value: bson.D{{Key: "$push", Value: "$name"}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}}}},
+			{{Key: "$skip", Value: int64(5)}},
+			{{Key: "$limit", Value: int64(10)}},
+		}, pipeline)
+	})
+	t.Run("unwind", func(t *testing.T) {
+		pipeline, err := lxDb.NewPipeline().
+			Unwind("tags").
+			Unwind("$items", true).
+			Build()
+
+		its.NoError(err)
+		its.Equal(mongo.Pipeline{
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$unwind", Value: bson.D{
+				{Key: "path", Value: "$items"},
+				{Key: "preserveNullAndEmptyArrays", Value: true},
+			}}},
+		}, pipeline)
+	})
+	t.Run("validation errors", func(t *testing.T) {
+		tests := map[string]*lxDb.Pipeline{
+			"out not last":      lxDb.NewPipeline().Out("archive").Match(bson.D{}),
+			"merge not last":    lxDb.NewPipeline().Merge("archive").Limit(1),
+			"limit":             lxDb.NewPipeline().Limit(0),
+			"skip":              lxDb.NewPipeline().Skip(-1),
+			"count":             lxDb.NewPipeline().Count("$total"),
+			"group _id":         lxDb.NewPipeline().Group(nil, lxDb.Sum("_id", 1)),
+			"bucket":            lxDb.NewPipeline().Bucket("$age", []interface{}{1}, nil),
+			"facet empty":       lxDb.NewPipeline().Facet(nil),
+			"facet nested":      lxDb.NewPipeline().Facet(map[string]*lxDb.Pipeline{"a": lxDb.NewPipeline().Out("x")}),
+			"lookup pipeline":   lxDb.NewPipeline().LookupPipeline("orders", nil, lxDb.NewPipeline().Merge("x"), "orders"),
+			"lookup":            lxDb.NewPipeline().Lookup("", "a", "b", "c"),
+			"raw stage":         lxDb.NewPipeline().Stage(bson.D{{Key: "match", Value: 1}}),
+			"geoNear not first": lxDb.NewPipeline().Limit(1).Stage(bson.D{{Key: "$geoNear", Value: bson.D{}}}),
+		}
+		for name, p := range tests {
+			_, err := p.Build()
+			its.True(errors.Is(err, lxDb.ErrPipeline), name)
+		}
+
+		its.Panics(func() { lxDb.NewPipeline().Limit(-1).MustBuild() })
+	})
+}
+
+func TestPipeline_String(t *testing.T) {
+	its := assert.New(t)
+
+	orders := lxDb.NewPipeline().
+		Match(bson.D{{Key: "$expr", Value: lxDb.ExprEq("$customerId", "$$id")}}).
+		Project(bson.D{{Key: "total", Value: 1}})
+
+	pipeline := lxDb.NewPipeline().
+		Match(bson.D{{Key: "is_active", Value: true}}).
+		LookupPipeline("orders", bson.D{{Key: "id", Value: "$_id"}}, orders, "orders").
+		Lookup("addresses", "_id", "userId", "addresses").
+		AddFields(bson.D{
+			{Key: "orderTotal", Value: bson.D{{Key: "$sum", Value: "$orders.total"}}},
+			{Key: "label", Value: lxDb.ExprCond(lxDb.ExprGte("$orderTotal", 1000), "vip", "default")},
+			{Key: "fullName", Value: lxDb.ExprConcat("$firstName", " ", "$lastName")},
+		}).
+		Facet(map[string]*lxDb.Pipeline{
+			"data":  lxDb.NewPipeline().Skip(0).Limit(20),
+			"count": lxDb.NewPipeline().Count("total"),
+		}).
+		ReplaceRoot(lxDb.ExprMergeObjects(bson.D{{Key: "total", Value: 0}}, "$$ROOT")).
+		Merge("report", bson.E{Key: "whenMatched", Value: "replace"})
+
+	_, err := pipeline.Build()
+	its.NoError(err)
+
+	buckets := lxDb.NewPipeline().
+		Bucket("$age", []interface{}{0, 18, 65}, "other", lxDb.Count("count"), lxDb.Avg("avgTotal", "$total"))
+
+	out := pipeline.String() + "\n" + buckets.String() + "\n"
+
+	if *updateGolden {
+		its.NoError(ioutil.WriteFile(PipelineGolden, []byte(out), 0644))
+	}
+
+	golden, err := ioutil.ReadFile(PipelineGolden)
+	its.NoError(err)
+	its.Equal(string(golden), out)
+}
+
+func TestExpr(t *testing.T) {
+	its := assert.New(t)
+
+	its.Equal("$name", lxDb.FieldRef("name"))
+	its.Equal("$name", lxDb.FieldRef("$name"))
+	its.Equal(bson.D{{Key: "$size", Value: "$tags"}}, lxDb.ExprSize("$tags"))
+	its.Equal(bson.D{{Key: "$add", Value: bson.A{"$a", "$b", 1}}}, lxDb.ExprAdd("$a", "$b", 1))
+	its.Equal(bson.D{{Key: "$ifNull", Value: bson.A{"$a", ""}}}, lxDb.ExprIfNull("$a", ""))
+	its.Equal(bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$gt", Value: bson.A{"$a", 1}}},
+		bson.D{{Key: "$lt", Value: bson.A{"$a", 5}}},
+	}}}, lxDb.ExprAnd(lxDb.ExprGt("$a", 1), lxDb.ExprLt("$a", 5)))
+}
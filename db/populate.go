@@ -0,0 +1,249 @@
+package lxDb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultPopulateBatchSize, max number of ids in one $in query of populate
+const DefaultPopulateBatchSize = 1000
+
+// PopulateOptions, reference resolved by Find and FindOne, see Populate
+type PopulateOptions struct {
+	// LocalField, path of reference, single value or array, can be nested
+	LocalField string
+	// From, collection of referenced documents in the same database
+	From string
+	// As, path of populated document or array
+	As string
+	// Projection of referenced documents, optional, an excluded ForeignField is only used for matching
+	Projection interface{}
+	// ForeignField, field of referenced documents, default _id
+	ForeignField string
+	// BatchSize, max ids per query, default DefaultPopulateBatchSize
+	BatchSize int
+}
+
+// Populate, return option for Find and FindOne, which resolves references in LocalField
+// with documents of collection From and sets them in As.
+// Array references are populated as array in order of the references, missing references are left out,
+// a missing single reference is set to nil.
+// When LocalField and As have the same parent in an array, the references are populated in each element.
+// Example:
+// err := repo.Find(filter, &orders, lxDb.Populate("customerId", "customers", "customer", bson.D{{"name", 1}}))
+// err := repo.Find(filter, &orders, lxDb.Populate("items.productId", "products", "items.product", nil))
+func Populate(localField, from, as string, projection interface{}) *PopulateOptions {
+	return &PopulateOptions{
+		LocalField: localField,
+		From:       from,
+		As:         as,
+		Projection: projection,
+	}
+}
+
+// SetForeignField, set field of referenced documents
+func (p *PopulateOptions) SetForeignField(field string) *PopulateOptions {
+	p.ForeignField = field
+	return p
+}
+
+// populate, resolve references of all populate options in docs
+func (repo *mongoBaseRepo) populate(ctx context.Context, docs []bson.M, populates []*PopulateOptions) error {
+	for _, p := range populates {
+		foreignField := p.ForeignField
+		if foreignField == "" {
+			foreignField = "_id"
+		}
+		batchSize := p.BatchSize
+		if batchSize <= 0 {
+			batchSize = DefaultPopulateBatchSize
+		}
+		localParts := strings.Split(p.LocalField, ".")
+
+		// Unique references of all docs
+		var ids []interface{}
+		seen := make(map[string]bool)
+		for _, doc := range docs {
+			values, _ := pathValues(doc, localParts)
+			for _, id := range values {
+				if key := refKey(id); id != nil && !seen[key] {
+					seen[key] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+
+		// Referenced documents by batches of $in
+		refs := make(map[string]bson.M)
+		opts := options.Find()
+		excluded := false
+		if p.Projection != nil {
+			var projection interface{}
+			projection, excluded = withField(p.Projection, foreignField)
+			opts.SetProjection(projection)
+		}
+		if repo.locale != nil {
+			opts.SetCollation(&options.Collation{Locale: *repo.locale})
+		}
+		from := repo.collection.Database().Collection(p.From)
+		for start := 0; start < len(ids); start += batchSize {
+			end := start + batchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+
+			cur, err := from.Find(ctx, bson.D{{Key: foreignField, Value: bson.D{{Key: "$in", Value: ids[start:end]}}}}, opts)
+			if err != nil {
+				return err
+			}
+			var found []bson.M
+			if err := cur.All(ctx, &found); err != nil {
+				return err
+			}
+			for _, ref := range found {
+				refs[refKey(ref[foreignField])] = ref
+				// Excluded by projection, only used for matching
+				if excluded {
+					delete(ref, foreignField)
+				}
+			}
+		}
+
+		asParts := strings.Split(p.As, ".")
+		for _, doc := range docs {
+			setPopulated(doc, localParts, asParts, refs)
+		}
+	}
+	return nil
+}
+
+// setPopulated, set referenced documents of local path in as path
+func setPopulated(doc bson.M, local, as []string, refs map[string]bson.M) {
+	// Same parent, populate in sub documents
+	if len(local) > 1 && len(as) > 1 && local[0] == as[0] {
+		switch v := doc[local[0]].(type) {
+		case bson.M:
+			setPopulated(v, local[1:], as[1:], refs)
+		case bson.A:
+			for _, elem := range v {
+				if sub, ok := elem.(bson.M); ok {
+					setPopulated(sub, local[1:], as[1:], refs)
+				}
+			}
+		}
+		return
+	}
+
+	values, many := pathValues(doc, local)
+	if many {
+		populated := bson.A{}
+		for _, id := range values {
+			if ref, ok := refs[refKey(id)]; ok {
+				populated = append(populated, ref)
+			}
+		}
+		setPath(doc, strings.Join(as, "."), populated)
+		return
+	}
+
+	var populated interface{}
+	if len(values) == 1 {
+		if ref, ok := refs[refKey(values[0])]; ok {
+			populated = ref
+		}
+	}
+	setPath(doc, strings.Join(as, "."), populated)
+}
+
+// pathValues, return values of dotted path, true when the path contains arrays
+func pathValues(value interface{}, parts []string) ([]interface{}, bool) {
+	if len(parts) == 0 {
+		switch v := value.(type) {
+		case nil:
+			return nil, false
+		case bson.A:
+			return v, true
+		}
+		return []interface{}{value}, false
+	}
+
+	switch v := value.(type) {
+	case bson.M:
+		return pathValues(v[parts[0]], parts[1:])
+	case bson.A:
+		var values []interface{}
+		for _, elem := range v {
+			elemValues, _ := pathValues(elem, parts)
+			values = append(values, elemValues...)
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// refKey, return comparable key of reference
+func refKey(id interface{}) string {
+	return fmt.Sprintf("%T:%v", id, id)
+}
+
+// withField, return projection which returns field, an exclusion of field is removed
+// and an inclusion projection gets the field, returns true when field was excluded
+func withField(projection interface{}, field string) (interface{}, bool) {
+	doc, err := ToBsonDoc(projection)
+	if err != nil {
+		return projection, false
+	}
+
+	out := make(bson.D, 0, len(*doc)+1)
+	inclusion, excluded := false, false
+	for _, e := range *doc {
+		if e.Key == field {
+			if !isExclusion(e.Value) {
+				return projection, false
+			}
+			excluded = true
+			continue
+		}
+		if e.Key != "_id" && !isExclusion(e.Value) {
+			inclusion = true
+		}
+		out = append(out, e)
+	}
+	if inclusion {
+		out = append(out, bson.E{Key: field, Value: 1})
+	}
+	return out, excluded
+}
+
+// isExclusion, return true for 0 and false
+func isExclusion(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	case int32:
+		return v == 0
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+// decodeDocs, decode documents into result slice
+func decodeDocs(docs []bson.M, result interface{}) error {
+	if docs == nil {
+		docs = []bson.M{}
+	}
+	data, err := bson.Marshal(bson.M{"docs": docs})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(data).Lookup("docs").Unmarshal(result)
+}
@@ -0,0 +1,278 @@
+package lxDb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	// DefaultReadAuditMaxIDs, default max number of ids in a read audit entry
+	DefaultReadAuditMaxIDs = 100
+)
+
+// ReadAuditOptions, opt-in read auditing for NewMongoBaseRepo.
+// Find, FindOne, Aggregate and CountDocuments with *AuditAuth send read entries
+// with filter, returned ids and count.
+// Example:
+// repo := lxDb.NewMongoBaseRepo(collection, audit, &lxDb.ReadAuditOptions{HashValues: true, SampleRate: 0.1})
+type ReadAuditOptions struct {
+	// HashValues, replace filter values by sha256 hashes, keys and operators are kept
+	HashValues bool
+	// HashSalt, salt for hashed values
+	HashSalt string
+	// MaxIDs, max number of ids per entry, default DefaultReadAuditMaxIDs
+	MaxIDs int
+	// SampleRate, part of reads between 0 and 1 to audit, 0 audits all
+	SampleRate float64
+	// AggregateWindow, combine reads of same user, operation and filter within window to one entry,
+	// pending entries are sent by FlushReadAudit of IReadAuditRepo
+	AggregateWindow time.Duration
+}
+
+// applyRepo, implements RepoOption
+func (o *ReadAuditOptions) applyRepo(repo *mongoBaseRepo) {
+	if o != nil {
+		repo.readAudit = newReadAuditor(*o)
+	}
+}
+
+// readAuditor, sends read audit entries
+type readAuditor struct {
+	opts    ReadAuditOptions
+	mux     sync.Mutex
+	pending map[string]bson.M
+	order   []string
+	timer   *time.Timer
+}
+
+// newReadAuditor, return auditor with defaults
+func newReadAuditor(opts ReadAuditOptions) *readAuditor {
+	if opts.MaxIDs <= 0 {
+		opts.MaxIDs = DefaultReadAuditMaxIDs
+	}
+	return &readAuditor{opts: opts, pending: make(map[string]bson.M)}
+}
+
+// readAuditActive, return true when read audit is configured and active
+func (repo *mongoBaseRepo) readAuditActive(authUser interface{}) bool {
+	return authUser != nil && repo.readAudit != nil && repo.audit != nil && repo.audit.IsActive()
+}
+
+// auditRead, send or aggregate read entry, ids are extracted from result
+func (repo *mongoBaseRepo) auditRead(authUser interface{}, operation string, filter interface{}, result interface{}, count int64) {
+	ra := repo.readAudit
+	if ra.opts.SampleRate > 0 && ra.opts.SampleRate < 1 && rand.Float64() >= ra.opts.SampleRate {
+		return
+	}
+
+	ids, n := resultIDs(result, ra.opts.MaxIDs)
+	if result != nil {
+		count = n
+	}
+
+	if ra.opts.HashValues {
+		filter = hashValues(filter, ra.opts.HashSalt)
+	}
+
+	data := bson.M{
+		"operation": operation,
+		"filter":    filter,
+		"ids":       ids,
+		"count":     count,
+	}
+	entry := bson.M{
+		"collection": repo.collection.Name(),
+		"action":     Read,
+		"user":       authUser,
+		"data":       data,
+	}
+
+	if ra.opts.AggregateWindow <= 0 {
+		repo.audit.Send(entry)
+		return
+	}
+
+	ra.aggregate(repo.audit, entry)
+}
+
+// FlushReadAudit, send read entries pending in AggregateWindow,
+// IReadAuditRepo
+func (repo *mongoBaseRepo) FlushReadAudit() {
+	if repo.readAudit != nil && repo.audit != nil {
+		repo.readAudit.flush(repo.audit)
+	}
+}
+
+// aggregate, combine entry with pending entry and send all pending entries after window
+func (ra *readAuditor) aggregate(audit IBaseRepoAudit, entry bson.M) {
+	data := entry["data"].(bson.M)
+	key := fmt.Sprintf("%v|%v|%v", entry["user"], data["operation"], data["filter"])
+
+	ra.mux.Lock()
+	defer ra.mux.Unlock()
+
+	if pending, ok := ra.pending[key]; ok {
+		pendingData := pending["data"].(bson.M)
+		pendingData["calls"] = pendingData["calls"].(int64) + 1
+		pendingData["count"] = pendingData["count"].(int64) + data["count"].(int64)
+		pendingData["ids"] = mergeIDs(pendingData["ids"].([]interface{}), data["ids"].([]interface{}), ra.opts.MaxIDs)
+		pendingData["to"] = time.Now()
+		return
+	}
+
+	now := time.Now()
+	data["calls"] = int64(1)
+	data["from"] = now
+	data["to"] = now
+	ra.pending[key] = entry
+	ra.order = append(ra.order, key)
+
+	if ra.timer == nil {
+		ra.timer = time.AfterFunc(ra.opts.AggregateWindow, func() { ra.flush(audit) })
+	}
+}
+
+// flush, send pending entries
+func (ra *readAuditor) flush(audit IBaseRepoAudit) {
+	ra.mux.Lock()
+	entries := make([]bson.M, 0, len(ra.order))
+	for _, key := range ra.order {
+		entries = append(entries, ra.pending[key])
+	}
+	ra.pending = make(map[string]bson.M)
+	ra.order = nil
+	if ra.timer != nil {
+		ra.timer.Stop()
+		ra.timer = nil
+	}
+	ra.mux.Unlock()
+
+	if len(entries) > 0 {
+		audit.Send(entries)
+	}
+}
+
+// resultIDs, return max ids and count of result, result is a pointer to slice or document
+func resultIDs(result interface{}, max int) ([]interface{}, int64) {
+	ids := []interface{}{}
+	if result == nil {
+		return ids, 0
+	}
+
+	v := reflect.ValueOf(result)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		if id := documentID(v); id != nil {
+			ids = append(ids, id)
+		}
+		return ids, 1
+	}
+
+	for i := 0; i < v.Len() && len(ids) < max; i++ {
+		if id := documentID(v.Index(i)); id != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, int64(v.Len())
+}
+
+// documentID, return _id of document or nil
+func documentID(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+
+	switch doc := v.Interface().(type) {
+	case bson.M:
+		return doc["_id"]
+	case bson.D:
+		return doc.Map()["_id"]
+	}
+
+	raw, err := bson.Marshal(v.Interface())
+	if err != nil {
+		return nil
+	}
+	id, err := bson.Raw(raw).LookupErr("_id")
+	if err != nil {
+		return nil
+	}
+
+	var val interface{}
+	if err := id.Unmarshal(&val); err != nil {
+		return nil
+	}
+	return val
+}
+
+// mergeIDs, append unique ids up to max
+func mergeIDs(ids, add []interface{}, max int) []interface{} {
+	for _, id := range add {
+		if len(ids) >= max {
+			break
+		}
+		found := false
+		for _, existing := range ids {
+			if reflect.DeepEqual(existing, id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// hashValues, replace all values of filter or pipeline by salted sha256 hashes,
+// keys and operators are kept
+func hashValues(filter interface{}, salt string) interface{} {
+	if filter == nil {
+		return nil
+	}
+
+	// Marshal documents and arrays with wrapper
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: filter}})
+	if err != nil {
+		return nil
+	}
+	return hashRawValue(bson.Raw(raw).Lookup("v"), salt)
+}
+
+// hashRawValue, hash raw value recursive
+func hashRawValue(v bson.RawValue, salt string) interface{} {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, _ := v.Document().Elements()
+		doc := make(bson.D, 0, len(elems))
+		for _, elem := range elems {
+			doc = append(doc, bson.E{Key: elem.Key(), Value: hashRawValue(elem.Value(), salt)})
+		}
+		return doc
+	case bsontype.Array:
+		values, _ := v.Array().Values()
+		arr := make(bson.A, 0, len(values))
+		for _, val := range values {
+			arr = append(arr, hashRawValue(val, salt))
+		}
+		return arr
+	}
+
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte{byte(v.Type)})
+	h.Write(v.Value)
+	return hex.EncodeToString(h.Sum(nil))
+}
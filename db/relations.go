@@ -0,0 +1,536 @@
+package lxDb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// On-delete behaviours of relations
+const (
+	// OnDeleteCascade, delete child documents, recursively
+	OnDeleteCascade = "cascade"
+	// OnDeleteSetNull, set reference field of child documents to null,
+	// deleted references are pulled from arrays and set to null in elements of arrays
+	OnDeleteSetNull = "setNull"
+	// OnDeleteRestrict, return *RestrictError while child documents exist
+	OnDeleteRestrict = "restrict"
+)
+
+// Relation, foreign-key-like link from child collection to parent collection
+type Relation struct {
+	// Parent, name of parent collection
+	Parent string
+	// ParentField, referenced field of parent documents, default _id
+	ParentField string
+	// Child, name of child collection in the same database
+	Child string
+	// Field, reference field of child documents, can be nested or an array
+	Field string
+	// OnDelete, OnDeleteCascade, OnDeleteSetNull or OnDeleteRestrict
+	OnDelete string
+}
+
+// Relations, registry of relations, set as option of NewMongoBaseRepo.
+// DeleteOne and DeleteMany of a parent repo enforce the on-delete rules recursively
+// in a transaction, when the server supports transactions. The plan and the restrict check
+// run in the transaction, children inserted concurrently by other clients are not blocked.
+// Without transactions, e.g. on standalone servers, the changes are not atomic,
+// the parent documents are deleted first and the changes run for the deleted parents afterwards.
+// Cascaded changes are audited with the audit of the parent repo,
+// hooks and history of child repos are not run.
+// Example:
+// relations := lxDb.NewRelations()
+// err := relations.Add(lxDb.Relation{Parent: "customers", Child: "addresses", Field: "customerId", OnDelete: lxDb.OnDeleteCascade})
+// customers := lxDb.NewMongoBaseRepo(db.Collection("customers"), relations, audit)
+type Relations struct {
+	mux      sync.RWMutex
+	byParent map[string][]Relation
+}
+
+// applyRepo, implements RepoOption
+func (r *Relations) applyRepo(repo *mongoBaseRepo) {
+	if r != nil {
+		repo.relations = r
+	}
+}
+
+// Reference, child documents of a relation
+type Reference struct {
+	Collection string
+	Field      string
+	IDs        []interface{}
+}
+
+// CascadeChange, change of child documents by delete of parent
+type CascadeChange struct {
+	Reference
+	// Action, OnDeleteCascade or OnDeleteSetNull
+	Action string
+	// Values, referenced values of the deleted parents
+	Values []interface{}
+}
+
+// CascadePlan, documents affected by a delete, see PlanDelete
+type CascadePlan struct {
+	Collection string
+	IDs        []interface{}
+	Changes    []CascadeChange
+	// Restricted, references blocking the delete
+	Restricted []Reference
+}
+
+// RestrictError, delete is blocked by child documents of restrict relations
+// Example:
+// var restrictErr *lxDb.RestrictError
+// if errors.As(err, &restrictErr) {
+// return c.JSON(http.StatusConflict, restrictErr.References)
+// }
+type RestrictError struct {
+	References []Reference
+}
+
+// Error, implements error
+func (e *RestrictError) Error() string {
+	refs := make([]string, 0, len(e.References))
+	for _, ref := range e.References {
+		refs = append(refs, fmt.Sprintf("%s.%s (%d)", ref.Collection, ref.Field, len(ref.IDs)))
+	}
+	return fmt.Sprintf("%s: %s", ErrRestrict.Error(), strings.Join(refs, ", "))
+}
+
+// Unwrap, return ErrRestrict for errors.Is
+func (e *RestrictError) Unwrap() error {
+	return ErrRestrict
+}
+
+// NewRelations, return empty registry
+func NewRelations() *Relations {
+	return &Relations{byParent: make(map[string][]Relation)}
+}
+
+// Add, add relation, returns ErrRelation for invalid relations
+func (r *Relations) Add(rel Relation) error {
+	if rel.Parent == "" || rel.Child == "" || rel.Field == "" {
+		return fmt.Errorf("parent, child and field required, %w", ErrRelation)
+	}
+	switch rel.OnDelete {
+	case OnDeleteCascade, OnDeleteSetNull, OnDeleteRestrict:
+	default:
+		return fmt.Errorf("on delete %q, %w", rel.OnDelete, ErrRelation)
+	}
+	if rel.ParentField == "" {
+		rel.ParentField = "_id"
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.byParent[rel.Parent] = append(r.byParent[rel.Parent], rel)
+	return nil
+}
+
+// Of, return relations of parent collection
+func (r *Relations) Of(parent string) []Relation {
+	if r == nil {
+		return nil
+	}
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return append([]Relation{}, r.byParent[parent]...)
+}
+
+// String, return plan as lines for logs and dry runs
+func (p *CascadePlan) String() string {
+	lines := []string{fmt.Sprintf("delete %s (%d)", p.Collection, len(p.IDs))}
+	for _, c := range p.Changes {
+		lines = append(lines, fmt.Sprintf("%s %s.%s (%d)", c.Action, c.Collection, c.Field, len(c.IDs)))
+	}
+	for _, ref := range p.Restricted {
+		lines = append(lines, fmt.Sprintf("%s %s.%s (%d)", OnDeleteRestrict, ref.Collection, ref.Field, len(ref.IDs)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PlanDelete, return documents affected by DeleteMany with filter without changes (dry run),
+// IRelationRepo
+// Example:
+// plan, err := repo.(lxDb.IRelationRepo).PlanDelete(bson.D{{"_id", customerID}})
+// fmt.Println(plan)
+func (repo *mongoBaseRepo) PlanDelete(filter interface{}, args ...interface{}) (*CascadePlan, error) {
+	timeout := DefaultTimeout
+	for _, arg := range args {
+		if val, ok := arg.(time.Duration); ok {
+			timeout = val
+		}
+	}
+
+	var parents []bson.M
+	if err := repo.Find(filter, &parents, timeout); err != nil {
+		return nil, err
+	}
+	return repo.planCascade(context.Background(), parents, timeout)
+}
+
+// planCascade, return plan of relations for parent documents, ctx is the context of the transaction
+func (repo *mongoBaseRepo) planCascade(parentCtx context.Context, parents []bson.M, timeout time.Duration) (*CascadePlan, error) {
+	plan := &CascadePlan{Collection: repo.collection.Name(), IDs: make([]interface{}, 0, len(parents))}
+	for _, doc := range parents {
+		plan.IDs = append(plan.IDs, doc["_id"])
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, timeout)
+	defer cancel()
+
+	visited := make(map[string]bool)
+	for _, id := range plan.IDs {
+		visited[plan.Collection+":"+refKey(id)] = true
+	}
+	if err := repo.planRelations(ctx, plan, plan.Collection, parents, visited); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planRelations, add changes of relations of parent collection to plan, recursive for cascades
+func (repo *mongoBaseRepo) planRelations(ctx context.Context, plan *CascadePlan, parent string, parents []bson.M, visited map[string]bool) error {
+	for _, rel := range repo.relations.Of(parent) {
+		values := make(bson.A, 0, len(parents))
+		for _, doc := range parents {
+			if value, ok := doc[rel.ParentField]; ok && value != nil {
+				values = append(values, value)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		// Child documents with fields of their own relations
+		projection := bson.D{{Key: "_id", Value: 1}}
+		for _, childRel := range repo.relations.Of(rel.Child) {
+			if childRel.ParentField != "_id" {
+				projection = append(projection, bson.E{Key: childRel.ParentField, Value: 1})
+			}
+		}
+		collection := repo.collection.Database().Collection(rel.Child)
+		cur, err := collection.Find(ctx, bson.D{{Key: rel.Field, Value: bson.D{{Key: "$in", Value: values}}}},
+			options.Find().SetProjection(projection))
+		if err != nil {
+			return err
+		}
+		var children []bson.M
+		if err := cur.All(ctx, &children); err != nil {
+			return err
+		}
+
+		// Documents deleted by the plan are left out, e.g. of cycles
+		var unvisited []bson.M
+		for _, doc := range children {
+			key := rel.Child + ":" + refKey(doc["_id"])
+			if visited[key] {
+				continue
+			}
+			if rel.OnDelete == OnDeleteCascade {
+				visited[key] = true
+			}
+			unvisited = append(unvisited, doc)
+		}
+		if len(unvisited) == 0 {
+			continue
+		}
+
+		ref := Reference{Collection: rel.Child, Field: rel.Field, IDs: make([]interface{}, 0, len(unvisited))}
+		for _, doc := range unvisited {
+			ref.IDs = append(ref.IDs, doc["_id"])
+		}
+
+		switch rel.OnDelete {
+		case OnDeleteRestrict:
+			plan.Restricted = append(plan.Restricted, ref)
+		case OnDeleteSetNull:
+			plan.Changes = append(plan.Changes, CascadeChange{Reference: ref, Action: OnDeleteSetNull, Values: values})
+		case OnDeleteCascade:
+			plan.Changes = append(plan.Changes, CascadeChange{Reference: ref, Action: OnDeleteCascade, Values: values})
+			if err := repo.planRelations(ctx, plan, rel.Child, unvisited, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cascadeTx, changes of relations of a delete, in a transaction when supported,
+// without transaction the changes are applied after the delete of the parent documents
+type cascadeTx struct {
+	repo      *mongoBaseRepo
+	plan      *CascadePlan
+	parents   []bson.M
+	parentIDs bson.A
+	authUser  interface{}
+	session   mongo.Session
+	ctx       context.Context
+	timeout   time.Duration
+	done      bool
+}
+
+// startCascade, plan changes of relations of the delete of parent documents,
+// in a transaction the changes run before the delete, otherwise with commit after the delete.
+// Returns nil without relations, *RestrictError when restricted
+func (repo *mongoBaseRepo) startCascade(filter interface{}, many bool, sort interface{}, authUser interface{}, timeout time.Duration) (*cascadeTx, error) {
+	if len(repo.relations.Of(repo.collection.Name())) == 0 {
+		return nil, nil
+	}
+
+	// Parent documents of delete
+	var parents []bson.M
+	if many {
+		if err := repo.Find(filter, &parents, timeout); err != nil {
+			return nil, err
+		}
+	} else {
+		opts := options.FindOne()
+		if sort != nil {
+			opts.SetSort(sort)
+		}
+		var doc bson.M
+		if err := repo.FindOne(filter, &doc, opts, timeout); err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if doc != nil {
+			parents = append(parents, doc)
+		}
+	}
+
+	c := &cascadeTx{repo: repo, authUser: authUser, ctx: context.Background(), timeout: timeout, parents: parents, parentIDs: bson.A{}}
+	for _, doc := range parents {
+		c.parentIDs = append(c.parentIDs, doc["_id"])
+	}
+	if repo.transactionsSupported(timeout) {
+		var err error
+		if c.session, err = repo.collection.Database().Client().StartSession(); err != nil {
+			return nil, err
+		}
+		if err := c.session.StartTransaction(); err != nil {
+			c.session.EndSession(context.Background())
+			return nil, err
+		}
+		c.ctx = mongo.NewSessionContext(context.Background(), c.session)
+	}
+
+	// Plan and restrict check in the transaction
+	plan, err := repo.planCascade(c.ctx, parents, timeout)
+	if err != nil {
+		c.end()
+		return nil, err
+	}
+	if len(plan.Restricted) > 0 {
+		c.end()
+		return nil, &RestrictError{References: plan.Restricted}
+	}
+	c.plan = plan
+
+	if c.session == nil {
+		return c, nil
+	}
+	if err := c.apply(timeout); err != nil {
+		c.end()
+		return nil, err
+	}
+	return c, nil
+}
+
+// apply, run changes of plan
+func (c *cascadeTx) apply(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+
+	db := c.repo.collection.Database()
+	for _, change := range c.plan.Changes {
+		filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: change.IDs}}}}
+		var err error
+		switch change.Action {
+		case OnDeleteCascade:
+			_, err = db.Collection(change.Collection).DeleteMany(ctx, filter)
+		case OnDeleteSetNull:
+			for _, u := range setNullUpdates(change.Field, change.Values) {
+				opts := options.Update()
+				if u.arrayFilters != nil {
+					opts.SetArrayFilters(options.ArrayFilters{Filters: u.arrayFilters})
+				}
+				if _, err = db.Collection(change.Collection).UpdateMany(ctx, append(filter, u.filter...), u.update, opts); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", change.Action, change.Collection, err)
+		}
+	}
+	return nil
+}
+
+// applyDeleted, run changes of the parent documents which are deleted, used without transaction
+func (c *cascadeTx) applyDeleted() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	// Parent documents which still exist, e.g. changed before the delete, are not cascaded
+	cur, err := c.repo.collection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: c.parentIDs}}}},
+		options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var existing []bson.M
+	if err := cur.All(ctx, &existing); err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		exists := map[string]bool{}
+		for _, doc := range existing {
+			exists[refKey(doc["_id"])] = true
+		}
+		var deleted []bson.M
+		for _, doc := range c.parents {
+			if !exists[refKey(doc["_id"])] {
+				deleted = append(deleted, doc)
+			}
+		}
+		plan, err := c.repo.planCascade(ctx, deleted, c.timeout)
+		if err != nil {
+			return err
+		}
+		c.plan = plan
+	}
+	return c.apply(c.timeout)
+}
+
+// setNullUpdate, update of OnDeleteSetNull for one kind of reference field
+type setNullUpdate struct {
+	filter       bson.D
+	update       bson.D
+	arrayFilters []interface{}
+}
+
+// setNullUpdates, return updates which remove the deleted values from field:
+// values are set to null in fields and pulled from arrays,
+// for paths into arrays of documents the value is set to null in the matching elements
+func setNullUpdates(field string, values []interface{}) []setNullUpdate {
+	in := bson.A(values)
+	updates := []setNullUpdate{
+		{
+			filter: bson.D{{Key: field, Value: bson.D{{Key: "$in", Value: in}, {Key: "$not", Value: bson.D{{Key: "$type", Value: "array"}}}}}},
+			update: bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: nil}}}},
+		},
+		{
+			filter: bson.D{{Key: field, Value: bson.D{{Key: "$in", Value: in}, {Key: "$type", Value: "array"}}}},
+			update: bson.D{{Key: "$pull", Value: bson.D{{Key: field, Value: bson.D{{Key: "$in", Value: in}}}}}},
+		},
+	}
+
+	i := strings.Index(field, ".")
+	if i < 0 {
+		return updates
+	}
+
+	// Plain paths only into documents, elements of arrays with arrayFilters
+	prefix, rest := field[:i], field[i+1:]
+	notArray := bson.E{Key: prefix, Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$type", Value: "array"}}}}}
+	for j := range updates {
+		updates[j].filter = append(bson.D{notArray}, updates[j].filter...)
+	}
+	return append(updates, setNullUpdate{
+		filter:       bson.D{{Key: prefix, Value: bson.D{{Key: "$type", Value: "array"}}}, {Key: field, Value: bson.D{{Key: "$in", Value: in}}}},
+		update:       bson.D{{Key: "$set", Value: bson.D{{Key: prefix + ".$[elem]." + rest, Value: nil}}}},
+		arrayFilters: []interface{}{bson.D{{Key: "elem." + rest, Value: bson.D{{Key: "$in", Value: in}}}}},
+	})
+}
+
+// context, return context of transaction for the delete of parent documents
+func (c *cascadeTx) context() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// filter, return filter of delete limited to the planned parent documents
+func (c *cascadeTx) filter(filter interface{}) interface{} {
+	if c == nil {
+		return filter
+	}
+	return bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: c.parentIDs}}}}}}}
+}
+
+// commit, commit transaction or apply changes after the delete without transaction, and audit changes
+func (c *cascadeTx) commit() error {
+	if c == nil {
+		return nil
+	}
+	if c.session != nil {
+		if err := c.session.CommitTransaction(context.Background()); err != nil {
+			c.end()
+			return err
+		}
+	} else if err := c.applyDeleted(); err != nil {
+		return err
+	}
+	c.done = true
+	c.end()
+
+	repo := c.repo
+	if c.authUser == nil || repo.audit == nil || !repo.audit.IsActive() {
+		return nil
+	}
+	var entries []bson.M
+	for _, change := range c.plan.Changes {
+		for _, id := range change.IDs {
+			entry := bson.M{"collection": change.Collection, "user": c.authUser}
+			if change.Action == OnDeleteCascade {
+				entry["action"] = Delete
+				entry["data"] = bson.M{"_id": id}
+			} else {
+				entry["action"] = Update
+				entry["data"] = bson.M{"_id": id, change.Field: nil}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) > 0 {
+		repo.audit.Send(entries)
+	}
+	return nil
+}
+
+// end, abort transaction when not committed and end session
+func (c *cascadeTx) end() {
+	if c == nil || c.session == nil {
+		return
+	}
+	if !c.done {
+		_ = c.session.AbortTransaction(context.Background())
+	}
+	c.session.EndSession(context.Background())
+	c.session = nil
+}
+
+// transactionsSupported, return true for replica sets and sharded clusters, cached per repo
+func (repo *mongoBaseRepo) transactionsSupported(timeout time.Duration) bool {
+	repo.txOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var res bson.M
+		if err := repo.collection.Database().Client().Database("admin").
+			RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&res); err != nil {
+			return
+		}
+		_, replicaSet := res["setName"]
+		repo.txSupported = replicaSet || res["msg"] == "isdbgrid"
+	})
+	return repo.txSupported
+}
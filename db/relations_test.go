@@ -0,0 +1,40 @@
+package lxDb_test
+
+import (
+	"errors"
+	"testing"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelations_Add(t *testing.T) {
+	its := assert.New(t)
+
+	relations := lxDb.NewRelations()
+	its.NoError(relations.Add(lxDb.Relation{Parent: "customers", Child: "addresses", Field: "customerId", OnDelete: lxDb.OnDeleteCascade}))
+	its.True(errors.Is(relations.Add(lxDb.Relation{Parent: "customers", Child: "files", Field: "customerId", OnDelete: "ignore"}), lxDb.ErrRelation))
+	its.True(errors.Is(relations.Add(lxDb.Relation{Parent: "customers", Child: "files", OnDelete: lxDb.OnDeleteCascade}), lxDb.ErrRelation))
+
+	rels := relations.Of("customers")
+	its.Len(rels, 1)
+	its.Equal("_id", rels[0].ParentField)
+	its.Empty(relations.Of("addresses"))
+}
+
+func TestRestrictError(t *testing.T) {
+	its := assert.New(t)
+
+	var err error = &lxDb.RestrictError{References: []lxDb.Reference{
+		{Collection: "invoices", Field: "customerId", IDs: []interface{}{1, 2}},
+	}}
+	its.True(errors.Is(err, lxDb.ErrRestrict))
+	its.Equal("delete restricted by references: invoices.customerId (2)", err.Error())
+
+	plan := &lxDb.CascadePlan{
+		Collection: "customers",
+		IDs:        []interface{}{1},
+		Changes:    []lxDb.CascadeChange{{Reference: lxDb.Reference{Collection: "addresses", Field: "customerId", IDs: []interface{}{3}}, Action: lxDb.OnDeleteCascade}},
+	}
+	its.Equal("delete customers (1)\ncascade addresses.customerId (1)", plan.String())
+}
@@ -0,0 +1,189 @@
+// Package lxDbRetention deletes, archives or anonymizes documents
+// after a maximum age, as configured by retention policies.
+package lxDbRetention
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// Policy actions
+	ActionDelete    = "delete"
+	ActionArchive   = "archive"
+	ActionAnonymize = "anonymize"
+
+	// Suffix of default archive collection
+	ArchiveSuffix = "_archive"
+
+	// Default batch size of runner
+	DefaultBatchSize = 500
+)
+
+// Errors
+var (
+	ErrPolicy        = errors.New("invalid retention policy")
+	ErrArchiveTarget = errors.New("archive target requires a mongo collection")
+)
+
+// DefaultSystemUser, user of audit entries when RunnerOptions.SystemUser is nil
+var DefaultSystemUser = bson.M{"name": "system", "id": "retention"}
+
+// Policy, retention policy for one collection.
+// Documents with AgeField older than MaxAgeMonths plus MaxAge
+// and matched by Filter are processed by Action.
+type Policy struct {
+	// Name for reports and audit
+	Name string
+	// Repo of the collection
+	Repo lxDb.IBaseRepo
+	// AgeField, date field of document age
+	AgeField string
+	// MaxAgeMonths and MaxAge, cutoff is now - MaxAgeMonths - MaxAge
+	MaxAgeMonths int
+	MaxAge       time.Duration
+	// Filter, additional filter, optional
+	Filter interface{}
+	// Action ActionDelete, ActionArchive or ActionAnonymize
+	Action string
+
+	// ArchiveRepo, target of ActionArchive, optional.
+	// Default is the collection <collection>_archive in ArchiveDatabase
+	// or in the database of Repo.
+	ArchiveRepo       lxDb.IBaseRepo
+	ArchiveDatabase   string
+	ArchiveCollection string
+
+	// Anonymize, fields with replacement values for ActionAnonymize,
+	// fields with nil value are removed
+	Anonymize map[string]interface{}
+}
+
+// Validate, check policy configuration
+func (p *Policy) Validate() error {
+	switch {
+	case p.Repo == nil:
+		return fmt.Errorf("%s: repo is required, %w", p.Name, ErrPolicy)
+	case p.AgeField == "":
+		return fmt.Errorf("%s: age field is required, %w", p.Name, ErrPolicy)
+	case p.MaxAgeMonths < 0 || p.MaxAge < 0 || (p.MaxAgeMonths == 0 && p.MaxAge == 0):
+		return fmt.Errorf("%s: max age must be positive, %w", p.Name, ErrPolicy)
+	}
+
+	switch p.Action {
+	case ActionDelete, ActionArchive:
+	case ActionAnonymize:
+		if len(p.Anonymize) == 0 {
+			return fmt.Errorf("%s: anonymize requires fields, %w", p.Name, ErrPolicy)
+		}
+		if _, ok := p.Anonymize["_id"]; ok {
+			return fmt.Errorf("%s: _id can't be anonymized, %w", p.Name, ErrPolicy)
+		}
+	default:
+		return fmt.Errorf("%s: unknown action %q, %w", p.Name, p.Action, ErrPolicy)
+	}
+	return nil
+}
+
+// Cutoff, return cutoff date of policy
+func (p *Policy) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, -p.MaxAgeMonths, 0).Add(-p.MaxAge)
+}
+
+// filter, return filter of expired documents, excluding ids
+func (p *Policy) filter(cutoff time.Time, exclude []interface{}) bson.D {
+	and := bson.A{bson.D{{Key: p.AgeField, Value: bson.D{{Key: "$lt", Value: cutoff}}}}}
+	if p.Filter != nil {
+		and = append(and, p.Filter)
+	}
+
+	// Skip already anonymized documents
+	if p.Action == ActionAnonymize {
+		or := bson.A{}
+		for _, field := range sortedKeys(p.Anonymize) {
+			if value := p.Anonymize[field]; value == nil {
+				or = append(or, bson.D{{Key: field, Value: bson.D{{Key: "$exists", Value: true}}}})
+			} else {
+				or = append(or, bson.D{{Key: field, Value: bson.D{{Key: "$ne", Value: value}}}})
+			}
+		}
+		and = append(and, bson.D{{Key: "$or", Value: or}})
+	}
+
+	if len(exclude) > 0 {
+		and = append(and, bson.D{{Key: "_id", Value: bson.D{{Key: "$nin", Value: exclude}}}})
+	}
+
+	if len(and) == 1 {
+		return and[0].(bson.D)
+	}
+	return bson.D{{Key: "$and", Value: and}}
+}
+
+// update, return update of ActionAnonymize
+func (p *Policy) update() bson.D {
+	set, unset := bson.D{}, bson.D{}
+	for _, field := range sortedKeys(p.Anonymize) {
+		if value := p.Anonymize[field]; value == nil {
+			unset = append(unset, bson.E{Key: field, Value: ""})
+		} else {
+			set = append(set, bson.E{Key: field, Value: value})
+		}
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// archiveRepo, return archive target repo
+func (p *Policy) archiveRepo() (lxDb.IBaseRepo, string, error) {
+	if p.ArchiveRepo != nil {
+		return p.ArchiveRepo, p.ArchiveRepo.GetRepoName(), nil
+	}
+
+	collection, ok := p.Repo.GetCollection().(*mongo.Collection)
+	if !ok || collection == nil {
+		return nil, "", ErrArchiveTarget
+	}
+
+	db := collection.Database()
+	if p.ArchiveDatabase != "" {
+		db = collection.Database().Client().Database(p.ArchiveDatabase)
+	}
+	name := p.ArchiveCollection
+	if name == "" {
+		name = collection.Name() + ArchiveSuffix
+	}
+
+	repo := lxDb.NewMongoBaseRepo(db.Collection(name))
+	return repo, repo.GetRepoName(), nil
+}
+
+// collectionName, return collection of repo name "db/collection"
+func collectionName(repo lxDb.IBaseRepo) string {
+	name := repo.GetRepoName()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// sortedKeys, return sorted keys of map
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,220 @@
+package lxDbRetention_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxDbMocks "github.com/litixsoft/lxgo/db/mocks"
+	lxDbRetention "github.com/litixsoft/lxgo/db/retention"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var testNow = time.Date(2020, 9, 21, 12, 0, 0, 0, time.UTC)
+
+// findIDs, mock Find result with ids
+func findIDs(ids ...interface{}) func(filter interface{}, result interface{}, args ...interface{}) error {
+	return func(filter interface{}, result interface{}, args ...interface{}) error {
+		docs := make([]bson.M, len(ids))
+		for i, id := range ids {
+			docs[i] = bson.M{"_id": id, "name": "doc"}
+		}
+		*result.(*[]bson.M) = docs
+		return nil
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+	valid := lxDbRetention.Policy{Name: "logs", Repo: repo, AgeField: "createdAt", MaxAgeMonths: 6, Action: lxDbRetention.ActionDelete}
+	its.NoError(valid.Validate())
+	its.Equal(time.Date(2020, 3, 21, 12, 0, 0, 0, time.UTC), valid.Cutoff(testNow))
+
+	invalid := []func(p *lxDbRetention.Policy){
+		func(p *lxDbRetention.Policy) { p.Repo = nil },
+		func(p *lxDbRetention.Policy) { p.AgeField = "" },
+		func(p *lxDbRetention.Policy) { p.MaxAgeMonths = 0 },
+		func(p *lxDbRetention.Policy) { p.MaxAge = -time.Hour },
+		func(p *lxDbRetention.Policy) { p.Action = "move" },
+		func(p *lxDbRetention.Policy) { p.Action = lxDbRetention.ActionAnonymize },
+		func(p *lxDbRetention.Policy) {
+			p.Action = lxDbRetention.ActionAnonymize
+			p.Anonymize = map[string]interface{}{"_id": nil}
+		},
+	}
+	for i, fn := range invalid {
+		p := valid
+		fn(&p)
+		its.True(errors.Is(p.Validate(), lxDbRetention.ErrPolicy), i)
+	}
+
+	_, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{{Name: "x"}}, lxDbRetention.RunnerOptions{})
+	its.True(errors.Is(err, lxDbRetention.ErrPolicy))
+}
+
+func TestRunner_Run(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	cutoff := time.Date(2020, 8, 21, 12, 0, 0, 0, time.UTC)
+	ageFilter := bson.D{{Key: "createdAt", Value: bson.D{{Key: "$lt", Value: cutoff}}}}
+	now := func() time.Time { return testNow }
+
+	t.Run("delete in batches", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		audit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		filter := bson.D{{Key: "$and", Value: bson.A{ageFilter, bson.M{"type": "log"}}}}
+		findOpts := options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetLimit(2).
+			SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+		repo.EXPECT().CountDocuments(filter, gomock.Any()).Return(int64(3), nil)
+		gomock.InOrder(
+			repo.EXPECT().Find(filter, gomock.Any(), findOpts, gomock.Any()).DoAndReturn(findIDs(1, 2)),
+			repo.EXPECT().
+				DeleteMany(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: []interface{}{1, 2}}}}}, gomock.Any()).
+				Return(&lxDb.DeleteManyResult{DeletedCount: 2}, nil),
+			repo.EXPECT().Find(filter, gomock.Any(), findOpts, gomock.Any()).DoAndReturn(findIDs(3)),
+			repo.EXPECT().DeleteMany(gomock.Any(), gomock.Any()).Return(&lxDb.DeleteManyResult{DeletedCount: 1}, nil),
+		)
+		repo.EXPECT().GetRepoName().Return("test/logs").AnyTimes()
+		audit.EXPECT().IsActive().Return(true).Times(2)
+		audit.EXPECT().Send([]bson.M{
+			{"collection": "logs", "action": lxDb.Purge, "user": lxDbRetention.DefaultSystemUser, "data": bson.M{"_id": 1, "policy": "logs"}},
+			{"collection": "logs", "action": lxDb.Purge, "user": lxDbRetention.DefaultSystemUser, "data": bson.M{"_id": 2, "policy": "logs"}},
+		})
+		audit.EXPECT().Send(gomock.Len(1))
+
+		runner, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{{
+			Name:     "logs",
+			Repo:     repo,
+			AgeField: "createdAt",
+			MaxAge:   31 * 24 * time.Hour,
+			Filter:   bson.M{"type": "log"},
+			Action:   lxDbRetention.ActionDelete,
+		}}, lxDbRetention.RunnerOptions{BatchSize: 2, Audit: audit, Now: now})
+		its.NoError(err)
+
+		reports, err := runner.Run(context.Background(), false)
+		its.NoError(err)
+		its.Len(reports, 1)
+		its.Equal(int64(3), reports[0].Matched)
+		its.Equal(int64(3), reports[0].Processed)
+		its.Equal(int64(2), reports[0].Batches)
+		its.Equal(cutoff, reports[0].Cutoff)
+	})
+	t.Run("archive", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		archive := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		user := bson.M{"name": "cron"}
+
+		repo.EXPECT().CountDocuments(ageFilter, gomock.Any()).Return(int64(2), nil)
+		repo.EXPECT().Find(ageFilter, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(findIDs(1, 2))
+		archive.EXPECT().GetRepoName().Return("archive/orders_archive")
+		archive.EXPECT().
+			ReplaceOne(bson.D{{Key: "_id", Value: 1}}, bson.M{"_id": 1, "name": "doc"}, options.Replace().SetUpsert(true), gomock.Any()).
+			Return(nil)
+		archive.EXPECT().
+			ReplaceOne(bson.D{{Key: "_id", Value: 2}}, gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(errors.New("duplicate"))
+		repo.EXPECT().
+			DeleteMany(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: []interface{}{1}}}}}, gomock.Any()).
+			Return(&lxDb.DeleteManyResult{DeletedCount: 1}, nil)
+		repo.EXPECT().GetRepoName().Return("test/orders")
+
+		audit := lxDbMocks.NewMockIBaseRepoAudit(mockCtrl)
+		audit.EXPECT().IsActive().Return(true)
+		audit.EXPECT().Send([]bson.M{{
+			"collection": "orders",
+			"action":     lxDb.Archive,
+			"user":       user,
+			"data":       bson.M{"_id": 1, "policy": "orders", "target": "archive/orders_archive"},
+		}})
+
+		runner, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{{
+			Name:         "orders",
+			Repo:         repo,
+			AgeField:     "createdAt",
+			MaxAgeMonths: 1,
+			Action:       lxDbRetention.ActionArchive,
+			ArchiveRepo:  archive,
+		}}, lxDbRetention.RunnerOptions{Audit: audit, SystemUser: user, Now: now})
+		its.NoError(err)
+
+		reports, err := runner.Run(context.Background(), false)
+		its.NoError(err)
+		its.Equal(int64(1), reports[0].Processed)
+		its.Equal(int64(1), reports[0].Failed)
+		its.Equal("archive/orders_archive", reports[0].Target)
+	})
+	t.Run("anonymize", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		filter := bson.D{{Key: "$and", Value: bson.A{ageFilter, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "email", Value: bson.D{{Key: "$exists", Value: true}}}},
+			bson.D{{Key: "name", Value: bson.D{{Key: "$ne", Value: "anonymous"}}}},
+		}}}}}}
+
+		repo.EXPECT().CountDocuments(filter, gomock.Any()).Return(int64(1), nil)
+		repo.EXPECT().Find(filter, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(findIDs(1))
+		repo.EXPECT().
+			UpdateMany(gomock.Any(), bson.D{
+				{Key: "$set", Value: bson.D{{Key: "name", Value: "anonymous"}}},
+				{Key: "$unset", Value: bson.D{{Key: "email", Value: ""}}},
+			}, gomock.Any()).
+			Return(&lxDb.UpdateManyResult{ModifiedCount: 1}, nil)
+
+		runner, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{{
+			Name:         "users",
+			Repo:         repo,
+			AgeField:     "createdAt",
+			MaxAgeMonths: 1,
+			Action:       lxDbRetention.ActionAnonymize,
+			Anonymize:    map[string]interface{}{"name": "anonymous", "email": nil},
+		}}, lxDbRetention.RunnerOptions{Now: now})
+		its.NoError(err)
+
+		reports, err := runner.Run(context.Background(), false)
+		its.NoError(err)
+		its.Equal(int64(1), reports[0].Processed)
+	})
+	t.Run("dry run and errors", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		failing := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		archived := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+		repo.EXPECT().CountDocuments(gomock.Any(), gomock.Any()).Return(int64(42), nil)
+		failing.EXPECT().CountDocuments(gomock.Any(), gomock.Any()).Return(int64(0), lxDb.ErrNotFound)
+		archived.EXPECT().GetCollection().Return(nil)
+
+		policy := func(name string, repo lxDb.IBaseRepo, action string) *lxDbRetention.Policy {
+			return &lxDbRetention.Policy{Name: name, Repo: repo, AgeField: "createdAt", MaxAgeMonths: 1, Action: action}
+		}
+		runner, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{
+			policy("failing", failing, lxDbRetention.ActionDelete),
+			policy("archive", archived, lxDbRetention.ActionArchive),
+			policy("logs", repo, lxDbRetention.ActionDelete),
+		}, lxDbRetention.RunnerOptions{Now: now})
+		its.NoError(err)
+
+		reports, err := runner.Run(context.Background(), true)
+		its.True(errors.Is(err, lxDb.ErrNotFound))
+		its.Len(reports, 3)
+		its.True(errors.Is(reports[1].Err, lxDbRetention.ErrArchiveTarget))
+		its.True(reports[2].DryRun)
+		its.Equal(int64(42), reports[2].Matched)
+		its.Equal(int64(0), reports[2].Processed)
+	})
+}
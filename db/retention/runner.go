@@ -0,0 +1,249 @@
+package lxDbRetention
+
+import (
+	"context"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RunnerOptions, options for Runner
+type RunnerOptions struct {
+	// BatchSize, documents per batch
+	BatchSize int64
+	// BatchInterval, min duration between batches for rate limiting
+	BatchInterval time.Duration
+	// Timeout of each db operation
+	Timeout time.Duration
+	// Audit for purge and archive entries, optional
+	Audit lxDb.IBaseRepoAudit
+	// SystemUser of audit entries, default DefaultSystemUser
+	SystemUser interface{}
+	// Now, default time.Now
+	Now func() time.Time
+}
+
+// Report, result of a policy run
+type Report struct {
+	Policy    string
+	Action    string
+	Target    string
+	Cutoff    time.Time
+	DryRun    bool
+	Matched   int64
+	Processed int64
+	Failed    int64
+	Batches   int64
+	Duration  time.Duration
+	Err       error
+}
+
+// Runner, executes retention policies
+type Runner struct {
+	policies []*Policy
+	opts     RunnerOptions
+}
+
+// NewRunner, return runner for valid policies
+// Example:
+// runner, err := lxDbRetention.NewRunner([]*lxDbRetention.Policy{{
+// Name: "logs", Repo: logRepo, AgeField: "createdAt", MaxAgeMonths: 6, Action: lxDbRetention.ActionDelete,
+// }}, lxDbRetention.RunnerOptions{Audit: audit, BatchInterval: time.Second})
+// reports, err := runner.Run(ctx, false)
+func NewRunner(policies []*Policy, opts RunnerOptions) (*Runner, error) {
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = lxDb.DefaultTimeout
+	}
+	if opts.SystemUser == nil {
+		opts.SystemUser = DefaultSystemUser
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Runner{policies: policies, opts: opts}, nil
+}
+
+// Run, execute all policies, with dryRun only the matched documents are counted.
+// A failed policy does not stop the other policies, the first error is returned.
+func (r *Runner) Run(ctx context.Context, dryRun bool) ([]*Report, error) {
+	var firstErr error
+	reports := make([]*Report, 0, len(r.policies))
+
+	for _, p := range r.policies {
+		report := r.runPolicy(ctx, p, dryRun)
+		reports = append(reports, report)
+		if report.Err != nil && firstErr == nil {
+			firstErr = report.Err
+		}
+		if ctx.Err() != nil {
+			return reports, ctx.Err()
+		}
+	}
+
+	return reports, firstErr
+}
+
+// runPolicy, execute policy in batches
+func (r *Runner) runPolicy(ctx context.Context, p *Policy, dryRun bool) *Report {
+	start := r.opts.Now()
+	report := &Report{
+		Policy: p.Name,
+		Action: p.Action,
+		Cutoff: p.Cutoff(start),
+		DryRun: dryRun,
+	}
+	defer func() { report.Duration = r.opts.Now().Sub(start) }()
+
+	var archive lxDb.IBaseRepo
+	if p.Action == ActionArchive {
+		if archive, report.Target, report.Err = p.archiveRepo(); report.Err != nil {
+			return report
+		}
+	}
+
+	report.Matched, report.Err = p.Repo.CountDocuments(p.filter(report.Cutoff, nil), r.opts.Timeout)
+	if report.Err != nil || dryRun {
+		return report
+	}
+
+	// Documents that failed are excluded from next batches
+	var failed []interface{}
+	var last time.Time
+
+	for {
+		if report.Err = ctx.Err(); report.Err != nil {
+			return report
+		}
+		if !last.IsZero() && r.opts.BatchInterval > 0 {
+			if wait := r.opts.BatchInterval - time.Since(last); wait > 0 {
+				select {
+				case <-ctx.Done():
+					report.Err = ctx.Err()
+					return report
+				case <-time.After(wait):
+				}
+			}
+		}
+		last = time.Now()
+
+		findOpts := options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetLimit(r.opts.BatchSize)
+		if p.Action != ActionArchive {
+			findOpts.SetProjection(bson.D{{Key: "_id", Value: 1}})
+		}
+
+		var docs []bson.M
+		if report.Err = p.Repo.Find(p.filter(report.Cutoff, failed), &docs, findOpts, r.opts.Timeout); report.Err != nil {
+			return report
+		}
+		if len(docs) == 0 {
+			return report
+		}
+		report.Batches++
+
+		var done []interface{}
+		switch p.Action {
+		case ActionArchive:
+			done, failed = r.archive(p, archive, docs, failed)
+		default:
+			for _, doc := range docs {
+				done = append(done, doc["_id"])
+			}
+		}
+
+		processed, err := r.apply(p, done)
+		report.Processed += processed
+		report.Failed = int64(len(failed))
+		if err != nil {
+			report.Err = err
+			return report
+		}
+
+		r.sendAudit(p, report.Target, done)
+
+		if int64(len(docs)) < r.opts.BatchSize {
+			return report
+		}
+	}
+}
+
+// archive, upsert documents in archive, return archived and failed ids
+func (r *Runner) archive(p *Policy, archive lxDb.IBaseRepo, docs []bson.M, failed []interface{}) ([]interface{}, []interface{}) {
+	var done []interface{}
+	for _, doc := range docs {
+		// Upsert, so a repeated run after an error does not duplicate documents
+		err := archive.ReplaceOne(bson.D{{Key: "_id", Value: doc["_id"]}}, doc, options.Replace().SetUpsert(true), r.opts.Timeout)
+		if err != nil {
+			failed = append(failed, doc["_id"])
+			continue
+		}
+		done = append(done, doc["_id"])
+	}
+	return done, failed
+}
+
+// apply, delete or anonymize documents by ids
+func (r *Runner) apply(p *Policy, ids []interface{}) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}
+
+	if p.Action == ActionAnonymize {
+		res, err := p.Repo.UpdateMany(filter, p.update(), r.opts.Timeout)
+		if err != nil {
+			return 0, err
+		}
+		return res.ModifiedCount, nil
+	}
+
+	res, err := p.Repo.DeleteMany(filter, r.opts.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// sendAudit, send purge or archive entries with id only, data of purged documents must not be kept
+func (r *Runner) sendAudit(p *Policy, target string, ids []interface{}) {
+	if len(ids) == 0 || r.opts.Audit == nil || !r.opts.Audit.IsActive() {
+		return
+	}
+
+	action := lxDb.Purge
+	if p.Action == ActionArchive {
+		action = lxDb.Archive
+	}
+
+	entries := make([]bson.M, 0, len(ids))
+	for _, id := range ids {
+		data := bson.M{"_id": id, "policy": p.Name}
+		switch p.Action {
+		case ActionArchive:
+			data["target"] = target
+		case ActionAnonymize:
+			data["fields"] = sortedKeys(p.Anonymize)
+		}
+
+		entries = append(entries, bson.M{
+			"collection": collectionName(p.Repo),
+			"action":     action,
+			"user":       r.opts.SystemUser,
+			"data":       data})
+	}
+
+	r.opts.Audit.Send(entries)
+}
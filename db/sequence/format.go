@@ -0,0 +1,95 @@
+// Package lxDbSequence generates strictly increasing business numbers,
+// e.g. invoice numbers per tenant and year, backed by a counters collection.
+package lxDbSequence
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Reset periods
+	ResetNever   = ""
+	ResetYearly  = "yearly"
+	ResetMonthly = "monthly"
+
+	// DefaultTemplate, number without prefix and padding
+	DefaultTemplate = "{SEQ}"
+)
+
+// Errors
+var (
+	ErrTemplate    = errors.New("invalid sequence template")
+	ErrReset       = errors.New("invalid sequence reset")
+	ErrName        = errors.New("invalid sequence name")
+	ErrCount       = errors.New("invalid reservation count")
+	ErrTransaction = errors.New("gapless sequence requires a transaction")
+)
+
+// placeholder of template, {YYYY}, {YY}, {MM}, {DD}, {SEQ} or {SEQ:n}
+var placeholder = regexp.MustCompile(`\{([A-Z]+)(?::(\d+))?\}`)
+
+// ValidateTemplate, check placeholders of template, {SEQ} is required
+func ValidateTemplate(template string) error {
+	hasSeq := false
+	for _, m := range placeholder.FindAllStringSubmatch(template, -1) {
+		switch m[1] {
+		case "YYYY", "YY", "MM", "DD":
+			if m[2] != "" {
+				return fmt.Errorf("%s, %w", m[0], ErrTemplate)
+			}
+		case "SEQ":
+			hasSeq = true
+		default:
+			return fmt.Errorf("%s, %w", m[0], ErrTemplate)
+		}
+	}
+	if !hasSeq {
+		return fmt.Errorf("%q without {SEQ}, %w", template, ErrTemplate)
+	}
+	return nil
+}
+
+// Format, return number formatted by template with date parts of t,
+// {SEQ:n} pads the value with zeros to n digits
+// Example:
+// lxDbSequence.Format("RE-{YYYY}-{SEQ:6}", 123, time.Now()) // RE-2026-000123
+func Format(template string, value int64, t time.Time) string {
+	return placeholder.ReplaceAllStringFunc(template, func(s string) string {
+		m := placeholder.FindStringSubmatch(s)
+		switch m[1] {
+		case "YYYY":
+			return fmt.Sprintf("%04d", t.Year())
+		case "YY":
+			return fmt.Sprintf("%02d", t.Year()%100)
+		case "MM":
+			return fmt.Sprintf("%02d", int(t.Month()))
+		case "DD":
+			return fmt.Sprintf("%02d", t.Day())
+		case "SEQ":
+			digits := strconv.FormatInt(value, 10)
+			if width, _ := strconv.Atoi(m[2]); width > len(digits) {
+				digits = strings.Repeat("0", width-len(digits)) + digits
+			}
+			return digits
+		}
+		return s
+	})
+}
+
+// Period, return period key of reset at t, empty for ResetNever
+func Period(reset string, t time.Time) (string, error) {
+	switch reset {
+	case ResetNever:
+		return "", nil
+	case ResetYearly:
+		return t.Format("2006"), nil
+	case ResetMonthly:
+		return t.Format("2006-01"), nil
+	}
+	return "", fmt.Errorf("%q, %w", reset, ErrReset)
+}
@@ -0,0 +1,230 @@
+package lxDbSequence
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultCollection, default name of counters collection
+const DefaultCollection = "counters"
+
+// Options, options of Generator
+type Options struct {
+	// Template of numbers, default DefaultTemplate
+	// Example: "RE-{YYYY}-{SEQ:6}"
+	Template string
+	// Reset, ResetNever, ResetYearly or ResetMonthly
+	Reset string
+	// Start, first value of each period, default 1
+	Start int64
+	// Gapless, numbers only with NextTx in the caller's transaction,
+	// numbers of aborted transactions are reused
+	Gapless bool
+	// Location of date parts and periods, default UTC
+	Location *time.Location
+	// Timeout of db operations
+	Timeout time.Duration
+	// Now, current time, for tests
+	Now func() time.Time
+}
+
+// Number, allocated number of a sequence
+type Number struct {
+	Name   string
+	Period string
+	Value  int64
+	// Formatted, number formatted by template
+	Formatted string
+}
+
+// String, return formatted number
+func (n Number) String() string {
+	return n.Formatted
+}
+
+// counter, document of counters collection, _id is name and period
+type counter struct {
+	ID        string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	Period    string    `bson:"period"`
+	Value     int64     `bson:"value"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Generator, allocates numbers with atomic $inc and upsert
+type Generator struct {
+	repo lxDb.IBaseRepo
+	opts Options
+}
+
+// NewGenerator, return generator for counters repo, e.g. of collection DefaultCollection
+// Example:
+// repo := lxDb.NewMongoBaseRepo(db.Collection(lxDbSequence.DefaultCollection))
+// invoices, err := lxDbSequence.NewGenerator(repo, lxDbSequence.Options{Template: "RE-{YYYY}-{SEQ:6}", Reset: lxDbSequence.ResetYearly})
+// number, err := invoices.Next("invoice:" + tenantID)
+func NewGenerator(repo lxDb.IBaseRepo, opts Options) (*Generator, error) {
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	if err := ValidateTemplate(opts.Template); err != nil {
+		return nil, err
+	}
+	if _, err := Period(opts.Reset, time.Now()); err != nil {
+		return nil, err
+	}
+	if opts.Start == 0 {
+		opts.Start = 1
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = lxDb.DefaultTimeout
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Generator{repo: repo, opts: opts}, nil
+}
+
+// Next, allocate next number of sequence name,
+// in gapless mode ErrTransaction is returned, use NextTx
+func (g *Generator) Next(name string) (Number, error) {
+	if g.opts.Gapless {
+		return Number{}, ErrTransaction
+	}
+	numbers, err := g.allocate(nil, name, 1)
+	if err != nil {
+		return Number{}, err
+	}
+	return numbers[0], nil
+}
+
+// NextTx, allocate next number in the caller's transaction,
+// when the transaction aborts the number is not used
+// Example:
+// err := client.UseSession(ctx, func(sc mongo.SessionContext) error {
+// _, err := session.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+// number, err := invoices.NextTx(sc, "invoice")
+// ...
+func (g *Generator) NextTx(sc mongo.SessionContext, name string) (Number, error) {
+	if sc == nil {
+		return Number{}, ErrTransaction
+	}
+	numbers, err := g.allocate(sc, name, 1)
+	if err != nil {
+		return Number{}, err
+	}
+	return numbers[0], nil
+}
+
+// Reserve, allocate a block of count numbers with one update, e.g. for batch imports
+func (g *Generator) Reserve(name string, count int) ([]Number, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("%d, %w", count, ErrCount)
+	}
+	if g.opts.Gapless {
+		return nil, ErrTransaction
+	}
+	return g.allocate(nil, name, int64(count))
+}
+
+// Current, return last allocated number of current period, Value is Start - 1 without numbers
+func (g *Generator) Current(name string) (Number, error) {
+	now := g.opts.Now().In(g.opts.Location)
+	period, _ := Period(g.opts.Reset, now)
+
+	var c counter
+	err := g.repo.FindOne(bson.D{{Key: "_id", Value: counterID(name, period)}}, &c, g.opts.Timeout)
+	if err != nil && !errors.Is(err, lxDb.ErrNotFound) {
+		return Number{}, err
+	}
+
+	value := g.opts.Start - 1 + c.Value
+	return Number{Name: name, Period: period, Value: value, Formatted: Format(g.opts.Template, value, now)}, nil
+}
+
+// allocate, increment counter by count and return allocated numbers,
+// with sc in the session of the transaction
+func (g *Generator) allocate(sc mongo.SessionContext, name string, count int64) ([]Number, error) {
+	if name == "" {
+		return nil, ErrName
+	}
+
+	now := g.opts.Now().In(g.opts.Location)
+	period, _ := Period(g.opts.Reset, now)
+	filter := bson.D{{Key: "_id", Value: counterID(name, period)}}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "value", Value: count}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "name", Value: name}, {Key: "period", Value: period}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var c counter
+	var err error
+	// Concurrent upserts of a new counter can fail with duplicate key, the retry increments
+	for try := 0; try < 2; try++ {
+		if sc != nil {
+			err = g.updateTx(sc, filter, update, opts, &c)
+		} else {
+			err = g.repo.FindOneAndUpdate(filter, update, &c, opts, g.opts.Timeout)
+		}
+		if !isDuplicateKey(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]Number, 0, count)
+	for value := c.Value - count + 1; value <= c.Value; value++ {
+		v := g.opts.Start - 1 + value
+		numbers = append(numbers, Number{Name: name, Period: period, Value: v, Formatted: Format(g.opts.Template, v, now)})
+	}
+	return numbers, nil
+}
+
+// updateTx, increment with collection of repo in session
+func (g *Generator) updateTx(sc mongo.SessionContext, filter, update interface{}, opts *options.FindOneAndUpdateOptions, c *counter) error {
+	collection, ok := g.repo.GetCollection().(*mongo.Collection)
+	if !ok || collection == nil {
+		return ErrTransaction
+	}
+	return collection.FindOneAndUpdate(sc, filter, update, opts).Decode(c)
+}
+
+// counterID, return _id of counter
+func counterID(name, period string) string {
+	if period == "" {
+		return name
+	}
+	return name + "|" + period
+}
+
+// isDuplicateKey, return true for duplicate key error
+func isDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 11000 {
+		return true
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
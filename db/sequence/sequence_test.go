@@ -0,0 +1,135 @@
+package lxDbSequence_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	lxDbMocks "github.com/litixsoft/lxgo/db/mocks"
+	lxDbSequence "github.com/litixsoft/lxgo/db/sequence"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var testNow = time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC)
+
+// counterValue, mock FindOneAndUpdate result
+func counterValue(value int64) func(filter, update, result interface{}, args ...interface{}) error {
+	return func(filter, update, result interface{}, args ...interface{}) error {
+		data, _ := bson.Marshal(bson.M{"value": value})
+		return bson.Unmarshal(data, result)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	its := assert.New(t)
+
+	its.Equal("RE-2026-000123", lxDbSequence.Format("RE-{YYYY}-{SEQ:6}", 123, testNow))
+	its.Equal("26/03/07-42", lxDbSequence.Format("{YY}/{MM}/{DD}-{SEQ}", 42, testNow))
+	its.Equal("1234567", lxDbSequence.Format("{SEQ:3}", 1234567, testNow))
+
+	its.NoError(lxDbSequence.ValidateTemplate("K{SEQ:8}"))
+	its.True(errors.Is(lxDbSequence.ValidateTemplate("RE-{YYYY}"), lxDbSequence.ErrTemplate))
+	its.True(errors.Is(lxDbSequence.ValidateTemplate("{SEQ}-{HH}"), lxDbSequence.ErrTemplate))
+	its.True(errors.Is(lxDbSequence.ValidateTemplate("{YYYY:2}{SEQ}"), lxDbSequence.ErrTemplate))
+}
+
+func TestPeriod(t *testing.T) {
+	its := assert.New(t)
+
+	for reset, expected := range map[string]string{
+		lxDbSequence.ResetNever:   "",
+		lxDbSequence.ResetYearly:  "2026",
+		lxDbSequence.ResetMonthly: "2026-03",
+	} {
+		period, err := lxDbSequence.Period(reset, testNow)
+		its.NoError(err)
+		its.Equal(expected, period)
+	}
+
+	_, err := lxDbSequence.Period("daily", testNow)
+	its.True(errors.Is(err, lxDbSequence.ErrReset))
+}
+
+func TestGenerator_Next(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+	gen, err := lxDbSequence.NewGenerator(repo, lxDbSequence.Options{
+		Template: "RE-{YYYY}-{SEQ:6}",
+		Reset:    lxDbSequence.ResetYearly,
+		Now:      func() time.Time { return testNow },
+	})
+	its.NoError(err)
+
+	repo.EXPECT().FindOneAndUpdate(bson.D{{Key: "_id", Value: "invoice:t1|2026"}}, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(filter, update, result interface{}, args ...interface{}) error {
+			its.Equal(bson.D{{Key: "value", Value: int64(1)}}, update.(bson.D)[0].Value)
+			return counterValue(123)(filter, update, result)
+		})
+
+	number, err := gen.Next("invoice:t1")
+	its.NoError(err)
+	its.Equal(int64(123), number.Value)
+	its.Equal("2026", number.Period)
+	its.Equal("RE-2026-000123", number.String())
+
+	_, err = gen.Next("")
+	its.True(errors.Is(err, lxDbSequence.ErrName))
+}
+
+func TestGenerator_Reserve(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+	gen, err := lxDbSequence.NewGenerator(repo, lxDbSequence.Options{
+		Template: "K{SEQ}",
+		Start:    1000,
+		Now:      func() time.Time { return testNow },
+	})
+	its.NoError(err)
+
+	// Duplicate key of concurrent upsert is retried
+	repo.EXPECT().FindOneAndUpdate(bson.D{{Key: "_id", Value: "customer"}}, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(mongo.CommandError{Code: 11000, Message: "E11000 duplicate key"})
+	repo.EXPECT().FindOneAndUpdate(bson.D{{Key: "_id", Value: "customer"}}, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(counterValue(3))
+
+	numbers, err := gen.Reserve("customer", 3)
+	its.NoError(err)
+	its.Len(numbers, 3)
+	its.Equal("K1000", numbers[0].String())
+	its.Equal("K1002", numbers[2].String())
+
+	_, err = gen.Reserve("customer", 0)
+	its.True(errors.Is(err, lxDbSequence.ErrCount))
+}
+
+func TestGenerator_Gapless(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+	gen, err := lxDbSequence.NewGenerator(repo, lxDbSequence.Options{Gapless: true})
+	its.NoError(err)
+
+	_, err = gen.Next("invoice")
+	its.True(errors.Is(err, lxDbSequence.ErrTransaction))
+	_, err = gen.Reserve("invoice", 10)
+	its.True(errors.Is(err, lxDbSequence.ErrTransaction))
+	_, err = gen.NextTx(nil, "invoice")
+	its.True(errors.Is(err, lxDbSequence.ErrTransaction))
+
+	_, err = lxDbSequence.NewGenerator(repo, lxDbSequence.Options{Reset: "weekly"})
+	its.True(errors.Is(err, lxDbSequence.ErrReset))
+}
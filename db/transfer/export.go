@@ -0,0 +1,231 @@
+package lxDbTransfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxHelper "github.com/litixsoft/lxgo/helper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportOptions, options for Export
+type ExportOptions struct {
+	// Format FormatNDJSON (default) or FormatCSV
+	Format string
+	// Canonical extended json, default is relaxed
+	Canonical bool
+	// Columns for csv
+	Columns []Column
+	// FindOptions for projection, sort, skip and limit
+	FindOptions *options.FindOptions
+	// BatchSize of cursor or pages
+	BatchSize int32
+	// Timeout for the whole export, 0 is without timeout
+	Timeout time.Duration
+	// Progress is called after each batch
+	Progress func(p Progress)
+}
+
+// ExportByQuery, export result of RequestByQuery
+func ExportByQuery(repo lxDb.IBaseRepo, w io.Writer, rbq *lxHelper.RequestByQuery, opts ExportOptions) (*Progress, error) {
+	opts.FindOptions = rbq.FindOptions.ToMongoFindOptions()
+	var filter interface{} = bson.M(rbq.Query)
+	if rbq.Query == nil {
+		filter = bson.M{}
+	}
+
+	return Export(repo, w, filter, opts)
+}
+
+// Export, stream all documents matched by filter to w.
+// When the repo has a mongo collection a cursor is used,
+// otherwise the documents are read in pages with repo.Find.
+// Example:
+// f, _ := os.Create("users.ndjson")
+// progress, err := lxDbTransfer.Export(repo, f, bson.M{"is_active": true}, lxDbTransfer.ExportOptions{})
+func Export(repo lxDb.IBaseRepo, w io.Writer, filter interface{}, opts ExportOptions) (*Progress, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FindOptions == nil {
+		opts.FindOptions = options.Find()
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	bw := bufio.NewWriter(w)
+	writeFn, flushFn, err := newWriter(bw, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := new(Progress)
+	batchFn := func(docs []bson.Raw) error {
+		for _, doc := range docs {
+			progress.Read++
+			if err := writeFn(doc); err != nil {
+				return err
+			}
+			progress.Written++
+		}
+		if err := flushFn(); err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(*progress)
+		}
+		return nil
+	}
+
+	if collection, ok := repo.GetCollection().(*mongo.Collection); ok && collection != nil {
+		err = exportCursor(ctx, collection, filter, opts, batchFn)
+	} else {
+		err = exportPages(repo, filter, opts, batchFn)
+	}
+	if err != nil {
+		return progress, err
+	}
+
+	return progress, bw.Flush()
+}
+
+// exportCursor, read documents with cursor
+func exportCursor(ctx context.Context, collection *mongo.Collection, filter interface{}, opts ExportOptions, batchFn func([]bson.Raw) error) error {
+	findOpts := *opts.FindOptions
+	findOpts.SetBatchSize(opts.BatchSize)
+
+	cur, err := collection.Find(ctx, filter, &findOpts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cur.Close(context.Background()) }()
+
+	batch := make([]bson.Raw, 0, opts.BatchSize)
+	for cur.Next(ctx) {
+		// Current is only valid until next call
+		doc := make(bson.Raw, len(cur.Current))
+		copy(doc, cur.Current)
+		batch = append(batch, doc)
+
+		if len(batch) == cap(batch) {
+			if err := batchFn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return batchFn(batch)
+	}
+	return nil
+}
+
+// exportPages, read documents in pages with repo.Find,
+// without sort the pages are sorted by _id
+func exportPages(repo lxDb.IBaseRepo, filter interface{}, opts ExportOptions, batchFn func([]bson.Raw) error) error {
+	skip := int64(0)
+	if opts.FindOptions.Skip != nil {
+		skip = *opts.FindOptions.Skip
+	}
+	limit := int64(-1)
+	if opts.FindOptions.Limit != nil && *opts.FindOptions.Limit > 0 {
+		limit = *opts.FindOptions.Limit
+	}
+
+	for limit != 0 {
+		pageSize := int64(opts.BatchSize)
+		if limit > 0 && limit < pageSize {
+			pageSize = limit
+		}
+
+		findOpts := *opts.FindOptions
+		if findOpts.Sort == nil {
+			findOpts.SetSort(bson.D{{Key: "_id", Value: 1}})
+		}
+		findOpts.SetSkip(skip).SetLimit(pageSize)
+
+		var page []bson.Raw
+		if err := repo.Find(filter, &page, &findOpts); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		if err := batchFn(page); err != nil {
+			return err
+		}
+
+		skip += int64(len(page))
+		if limit > 0 {
+			limit -= int64(len(page))
+		}
+		if int64(len(page)) < pageSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// newWriter, return write and flush functions for format
+func newWriter(w io.Writer, opts ExportOptions) (func(bson.Raw) error, func() error, error) {
+	switch opts.Format {
+	case "", FormatNDJSON:
+		writeFn := func(doc bson.Raw) error {
+			line, err := bson.MarshalExtJSON(doc, opts.Canonical, false)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			_, err = w.Write([]byte{'\n'})
+			return err
+		}
+		return writeFn, func() error { return nil }, nil
+
+	case FormatCSV:
+		if len(opts.Columns) == 0 {
+			return nil, nil, ErrColumns
+		}
+
+		cw := csv.NewWriter(w)
+		header := make([]string, len(opts.Columns))
+		for i, col := range opts.Columns {
+			header[i] = col.Header
+		}
+		if err := cw.Write(header); err != nil {
+			return nil, nil, err
+		}
+
+		record := make([]string, len(opts.Columns))
+		writeFn := func(doc bson.Raw) error {
+			for i, col := range opts.Columns {
+				record[i] = formatValue(lookupPath(doc, col.Path))
+			}
+			return cw.Write(record)
+		}
+		flushFn := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return writeFn, flushFn, nil
+	}
+
+	return nil, nil, fmt.Errorf("%q, %w", opts.Format, ErrFormat)
+}
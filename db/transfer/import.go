@@ -0,0 +1,298 @@
+package lxDbTransfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxSchema "github.com/litixsoft/lxgo/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Max length of a ndjson line
+	MaxLineSize = 16 * 1024 * 1024
+	// Max number of row errors in ImportResult
+	MaxRowErrors = 100
+)
+
+// ImportOptions, options for Import
+type ImportOptions struct {
+	// Format FormatNDJSON (default) or FormatCSV
+	Format string
+	// Columns for csv, the first row is the header and will be skipped
+	Columns []Column
+	// BatchSize of inserts
+	BatchSize int
+	// UpsertKeys, replace documents with same keys instead of insert
+	UpsertKeys []string
+	// DryRun, only read and validate
+	DryRun bool
+	// SchemaLoader and Schema for validation of each document
+	SchemaLoader lxSchema.IJSONSchema
+	Schema       string
+	// Timeout for each write operation
+	Timeout time.Duration
+	// AuditAuth for audit of writes
+	AuditAuth *lxDb.AuditAuth
+	// Progress is called after each batch
+	Progress func(p Progress)
+}
+
+// RowError, error of a single row, row starts with 1
+type RowError struct {
+	Row        int64
+	Err        error
+	Validation *lxSchema.JSONValidationResult
+}
+
+// Error, implements error
+func (e *RowError) Error() string {
+	if e.Validation != nil {
+		return fmt.Sprintf("row %d: %v", e.Row, e.Validation.Errors)
+	}
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+// ImportResult, result of Import,
+// Errors contains at most MaxRowErrors entries
+type ImportResult struct {
+	Progress
+	Errors []*RowError
+}
+
+// addError, count and add row error
+func (r *ImportResult) addError(rowErr *RowError) {
+	if rowErr.Validation != nil {
+		r.Invalid++
+	} else {
+		r.Failed++
+	}
+	if len(r.Errors) < MaxRowErrors {
+		r.Errors = append(r.Errors, rowErr)
+	}
+}
+
+// Import, read documents from r and write them in batches to repo.
+// Rows with errors are counted and skipped, read errors abort the import.
+// Example:
+// f, _ := os.Open("users.ndjson")
+// res, err := lxDbTransfer.Import(repo, f, lxDbTransfer.ImportOptions{UpsertKeys: []string{"email"}})
+func Import(repo lxDb.IBaseRepo, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	readFn, err := newReader(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []interface{}
+	if opts.Timeout > 0 {
+		args = append(args, opts.Timeout)
+	}
+	if opts.AuditAuth != nil {
+		args = append(args, opts.AuditAuth)
+	}
+
+	result := new(ImportResult)
+	batch := make([]interface{}, 0, opts.BatchSize)
+	rows := make([]int64, 0, opts.BatchSize)
+
+	flushFn := func() error {
+		if len(batch) > 0 && !opts.DryRun {
+			if err := writeBatch(repo, batch, rows, opts.UpsertKeys, args, result); err != nil {
+				return err
+			}
+		}
+		batch, rows = batch[:0], rows[:0]
+		if opts.Progress != nil {
+			opts.Progress(result.Progress)
+		}
+		return nil
+	}
+
+	for {
+		doc, row, err := readFn()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErr, ok := err.(*RowError)
+			if !ok {
+				return result, err
+			}
+			result.Read++
+			result.addError(rowErr)
+			continue
+		}
+		result.Read++
+
+		if rowErr := validate(doc, row, opts); rowErr != nil {
+			result.addError(rowErr)
+			continue
+		}
+
+		batch = append(batch, doc)
+		rows = append(rows, row)
+		if len(batch) == opts.BatchSize {
+			if err := flushFn(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, flushFn()
+}
+
+// writeBatch, insert or upsert batch
+func writeBatch(repo lxDb.IBaseRepo, batch []interface{}, rows []int64, upsertKeys []string, args []interface{}, result *ImportResult) error {
+	if len(upsertKeys) == 0 {
+		res, err := repo.InsertMany(batch, args...)
+		if err != nil {
+			return err
+		}
+		result.Written += int64(len(res.InsertedIDs))
+		result.Failed += res.FailedCount
+		return nil
+	}
+
+	replaceArgs := append([]interface{}{options.Replace().SetUpsert(true)}, args...)
+	for i, doc := range batch {
+		filter, err := upsertFilter(doc.(bson.D), upsertKeys)
+		if err != nil {
+			result.addError(&RowError{Row: rows[i], Err: err})
+			continue
+		}
+		if err := repo.ReplaceOne(filter, doc, replaceArgs...); err != nil {
+			result.addError(&RowError{Row: rows[i], Err: err})
+			continue
+		}
+		result.Upserted++
+	}
+	return nil
+}
+
+// upsertFilter, build filter from upsert keys
+func upsertFilter(doc bson.D, keys []string) (bson.D, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{}
+	for _, key := range keys {
+		var v interface{}
+		rv := lookupPath(raw, key)
+		if rv.Type == bsontype.Null || rv.Unmarshal(&v) != nil {
+			return nil, fmt.Errorf("%q, %w", key, ErrUpsertKey)
+		}
+		filter = append(filter, bson.E{Key: key, Value: v})
+	}
+	return filter, nil
+}
+
+// validate, validate document against schema
+func validate(doc bson.D, row int64, opts ImportOptions) *RowError {
+	if opts.SchemaLoader == nil || opts.Schema == "" {
+		return nil
+	}
+
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return &RowError{Row: row, Err: err}
+	}
+
+	res, err := opts.SchemaLoader.ValidateBindRaw(opts.Schema, &data, nil)
+	if err != nil {
+		return &RowError{Row: row, Err: err}
+	}
+	if res != nil {
+		return &RowError{Row: row, Validation: res}
+	}
+	return nil
+}
+
+// newReader, return read function for format,
+// the function returns the document with its row, io.EOF at the end and *RowError for invalid rows
+func newReader(r io.Reader, opts ImportOptions) (func() (bson.D, int64, error), error) {
+	switch opts.Format {
+	case "", FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
+		row := int64(0)
+
+		return func() (bson.D, int64, error) {
+			for scanner.Scan() {
+				row++
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+
+				var doc bson.D
+				if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+					return nil, row, &RowError{Row: row, Err: err}
+				}
+				return doc, row, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, row, err
+			}
+			return nil, row, io.EOF
+		}, nil
+
+	case FormatCSV:
+		if len(opts.Columns) == 0 {
+			return nil, ErrColumns
+		}
+
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(opts.Columns)
+		cr.ReuseRecord = true
+
+		// Skip header
+		if _, err := cr.Read(); err != nil {
+			if err == io.EOF {
+				return func() (bson.D, int64, error) { return nil, 0, io.EOF }, nil
+			}
+			return nil, err
+		}
+		row := int64(0)
+
+		return func() (bson.D, int64, error) {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return nil, row, io.EOF
+			}
+			row++
+			if err != nil {
+				if _, ok := err.(*csv.ParseError); ok {
+					return nil, row, &RowError{Row: row, Err: err}
+				}
+				return nil, row, err
+			}
+
+			doc := bson.D{}
+			for i, col := range opts.Columns {
+				v, err := convertValue(record[i], col.Type)
+				if err != nil {
+					return nil, row, &RowError{Row: row, Err: fmt.Errorf("column %q, %w", col.Header, err)}
+				}
+				if v != nil {
+					doc = setPath(doc, col.Path, v)
+				}
+			}
+			return doc, row, nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%q, %w", opts.Format, ErrFormat)
+}
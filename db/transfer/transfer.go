@@ -0,0 +1,199 @@
+// Package lxDbTransfer exports and imports collections of an lxDb.IBaseRepo
+// as NDJSON (canonical or relaxed Extended JSON) or CSV. Both directions
+// stream documents in batches, so memory stays flat for large collections.
+package lxDbTransfer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Formats
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+
+	// Column types for csv import
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDate     = "date"
+	TypeObjectID = "objectId"
+
+	// Default batch size for export and import
+	DefaultBatchSize = 500
+)
+
+// Errors
+var (
+	ErrFormat     = errors.New("unsupported format")
+	ErrColumns    = errors.New("csv requires columns")
+	ErrColumnType = errors.New("unsupported column type")
+	ErrUpsertKey  = errors.New("missing upsert key")
+)
+
+// Column, mapping of a csv column to a document path,
+// nested paths are separated by dots, array elements by index (items.0.name)
+type Column struct {
+	Header string
+	Path   string
+	Type   string
+}
+
+// ParseColumns, parse column definitions "header:path:type,header:path",
+// without path the header is used as path
+// Example:
+// cols, err := lxDbTransfer.ParseColumns("Name:name,City:address.city,Created:createdAt:date")
+func ParseColumns(def string) ([]Column, error) {
+	var cols []Column
+	for _, part := range strings.Split(def, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		col := Column{Header: fields[0], Path: fields[0], Type: TypeString}
+		if len(fields) > 1 && fields[1] != "" {
+			col.Path = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			col.Type = fields[2]
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("column %q, %w", part, ErrColumns)
+		}
+		if _, err := convertValue("", col.Type); err != nil {
+			return nil, fmt.Errorf("column %q, %w", part, err)
+		}
+
+		cols = append(cols, col)
+	}
+
+	if len(cols) == 0 {
+		return nil, ErrColumns
+	}
+	return cols, nil
+}
+
+// Progress, counters of a running export or import
+type Progress struct {
+	Read     int64
+	Written  int64
+	Upserted int64
+	Invalid  int64
+	Failed   int64
+}
+
+// formatValue, format raw value for csv
+func formatValue(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.Null, bsontype.Undefined:
+		return ""
+	case bsontype.String:
+		return v.StringValue()
+	case bsontype.ObjectID:
+		return v.ObjectID().Hex()
+	case bsontype.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bsontype.Boolean:
+		return strconv.FormatBool(v.Boolean())
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(v.Int32()), 10)
+	case bsontype.Int64:
+		return strconv.FormatInt(v.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(v.Double(), 'f', -1, 64)
+	case bsontype.Decimal128:
+		return v.Decimal128().String()
+	case bsontype.EmbeddedDocument:
+		raw, err := bson.MarshalExtJSON(v.Document(), false, false)
+		if err == nil {
+			return string(raw)
+		}
+	case bsontype.Array:
+		var a bson.A
+		if err := v.Unmarshal(&a); err == nil {
+			raw, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: a}}, false, false)
+			if err == nil {
+				// strip wrapper {"v":...}
+				return strings.TrimSuffix(strings.TrimPrefix(string(raw), `{"v":`), "}")
+			}
+		}
+	}
+	return v.String()
+}
+
+// convertValue, convert csv value to type, empty values are nil
+func convertValue(s, typ string) (interface{}, error) {
+	switch typ {
+	case "", TypeString:
+		if s == "" {
+			return nil, nil
+		}
+		return s, nil
+	case TypeInt, TypeFloat, TypeBool, TypeDate, TypeObjectID:
+	default:
+		return nil, fmt.Errorf("%q, %w", typ, ErrColumnType)
+	}
+
+	if s == "" {
+		return nil, nil
+	}
+
+	switch typ {
+	case TypeInt:
+		return strconv.ParseInt(s, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(s, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	case TypeDate:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		return primitive.NewDateTimeFromTime(t), nil
+	default:
+		return primitive.ObjectIDFromHex(s)
+	}
+}
+
+// lookupPath, return value of dotted path in raw document
+func lookupPath(doc bson.Raw, path string) bson.RawValue {
+	v, err := doc.LookupErr(strings.Split(path, ".")...)
+	if err != nil {
+		return bson.RawValue{Type: bsontype.Null}
+	}
+	return v
+}
+
+// setPath, set value of dotted path in document, creates nested documents
+func setPath(doc bson.D, path string, value interface{}) bson.D {
+	parts := strings.SplitN(path, ".", 2)
+	for i, e := range doc {
+		if e.Key != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			doc[i].Value = value
+			return doc
+		}
+		sub, _ := e.Value.(bson.D)
+		doc[i].Value = setPath(sub, parts[1], value)
+		return doc
+	}
+
+	if len(parts) == 1 {
+		return append(doc, bson.E{Key: parts[0], Value: value})
+	}
+	return append(doc, bson.E{Key: parts[0], Value: setPath(bson.D{}, parts[1], value)})
+}
@@ -0,0 +1,306 @@
+package lxDbTransfer_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	lxDb "github.com/litixsoft/lxgo/db"
+	lxDbMocks "github.com/litixsoft/lxgo/db/mocks"
+	lxDbTransfer "github.com/litixsoft/lxgo/db/transfer"
+	lxHelper "github.com/litixsoft/lxgo/helper"
+	lxSchema "github.com/litixsoft/lxgo/schema"
+	lxSchemaMocks "github.com/litixsoft/lxgo/schema/mocks"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	testID   = primitive.NewObjectID()
+	testDate = time.Date(2020, 9, 21, 12, 0, 0, 0, time.UTC)
+)
+
+// testDocs, raw test documents
+func testDocs(t *testing.T, n int) []bson.Raw {
+	docs := make([]bson.Raw, n)
+	for i := range docs {
+		raw, err := bson.Marshal(bson.D{
+			{Key: "_id", Value: testID},
+			{Key: "name", Value: "User, " + string(rune('A'+i))},
+			{Key: "age", Value: int32(20 + i)},
+			{Key: "address", Value: bson.D{{Key: "city", Value: "Berlin"}}},
+			{Key: "createdAt", Value: primitive.NewDateTimeFromTime(testDate)},
+		})
+		assert.NoError(t, err)
+		docs[i] = raw
+	}
+	return docs
+}
+
+// expectPages, mock repo.Find with pages of docs
+func expectPages(repo *lxDbMocks.MockIBaseRepo, docs []bson.Raw, pageSize int) {
+	repo.EXPECT().GetCollection().Return(nil)
+	repo.EXPECT().
+		Find(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(filter interface{}, result interface{}, args ...interface{}) error {
+			opts := args[0].(*options.FindOptions)
+			skip := int(*opts.Skip)
+			end := skip + int(*opts.Limit)
+			if end > len(docs) {
+				end = len(docs)
+			}
+			if skip < end {
+				*result.(*[]bson.Raw) = docs[skip:end]
+			}
+			return nil
+		}).
+		Times(len(docs)/pageSize + 1)
+}
+
+func TestParseColumns(t *testing.T) {
+	its := assert.New(t)
+
+	cols, err := lxDbTransfer.ParseColumns("Name:name, City:address.city ,Created:createdAt:date,age::int")
+	its.NoError(err)
+	its.Equal([]lxDbTransfer.Column{
+		{Header: "Name", Path: "name", Type: lxDbTransfer.TypeString},
+		{Header: "City", Path: "address.city", Type: lxDbTransfer.TypeString},
+		{Header: "Created", Path: "createdAt", Type: lxDbTransfer.TypeDate},
+		{Header: "age", Path: "age", Type: lxDbTransfer.TypeInt},
+	}, cols)
+
+	_, err = lxDbTransfer.ParseColumns("a:b:money")
+	its.True(errors.Is(err, lxDbTransfer.ErrColumnType))
+	_, err = lxDbTransfer.ParseColumns(" , ")
+	its.True(errors.Is(err, lxDbTransfer.ErrColumns))
+}
+
+func TestExport(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	t.Run("ndjson", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		docs := testDocs(t, 5)
+		expectPages(repo, docs, 2)
+
+		var calls int
+		buf := new(bytes.Buffer)
+		progress, err := lxDbTransfer.Export(repo, buf, bson.M{}, lxDbTransfer.ExportOptions{
+			BatchSize: 2,
+			Progress:  func(p lxDbTransfer.Progress) { calls++ },
+		})
+		its.NoError(err)
+		its.Equal(int64(5), progress.Written)
+		its.Equal(3, calls)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		its.Len(lines, 5)
+		its.Equal(`{"_id":{"$oid":"`+testID.Hex()+`"},"name":"User, A","age":20,"address":{"city":"Berlin"},"createdAt":{"$date":"2020-09-21T12:00:00Z"}}`, lines[0])
+	})
+	t.Run("canonical with limit", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		docs := testDocs(t, 5)
+		repo.EXPECT().GetCollection().Return(nil)
+		repo.EXPECT().
+			Find(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(filter interface{}, result interface{}, args ...interface{}) error {
+				opts := args[0].(*options.FindOptions)
+				its.Equal(int64(1), *opts.Skip)
+				its.Equal(int64(2), *opts.Limit)
+				its.Equal(bson.D{{Key: "_id", Value: 1}}, opts.Sort)
+				*result.(*[]bson.Raw) = docs[1:3]
+				return nil
+			})
+
+		buf := new(bytes.Buffer)
+		progress, err := lxDbTransfer.Export(repo, buf, bson.M{}, lxDbTransfer.ExportOptions{
+			Canonical:   true,
+			FindOptions: options.Find().SetSkip(1).SetLimit(2),
+		})
+		its.NoError(err)
+		its.Equal(int64(2), progress.Written)
+		its.Contains(buf.String(), `"age":{"$numberInt":"21"}`)
+	})
+	t.Run("csv", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		docs := testDocs(t, 2)
+		expectPages(repo, docs, lxDbTransfer.DefaultBatchSize)
+
+		cols, err := lxDbTransfer.ParseColumns("Id:_id,Name:name,Age:age:int,City:address.city,Created:createdAt:date,Missing:a.b")
+		its.NoError(err)
+
+		buf := new(bytes.Buffer)
+		_, err = lxDbTransfer.Export(repo, buf, bson.M{}, lxDbTransfer.ExportOptions{Format: lxDbTransfer.FormatCSV, Columns: cols})
+		its.NoError(err)
+		its.Equal("Id,Name,Age,City,Created,Missing\n"+
+			testID.Hex()+",\"User, A\",20,Berlin,2020-09-21T12:00:00Z,\n"+
+			testID.Hex()+",\"User, B\",21,Berlin,2020-09-21T12:00:00Z,\n", buf.String())
+	})
+	t.Run("by query", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		repo.EXPECT().GetCollection().Return(nil)
+		repo.EXPECT().
+			Find(bson.M{"name": "Bob"}, gomock.Any(), gomock.Any()).
+			DoAndReturn(func(filter interface{}, result interface{}, args ...interface{}) error {
+				opts := args[0].(*options.FindOptions)
+				its.Equal(map[string]int{"name": 1}, opts.Sort)
+				return nil
+			})
+
+		rbq, err := lxHelper.NewRequestByQuery(`{"query":{"name":"Bob"},"opts":{"sort":{"name":1}}}`)
+		its.NoError(err)
+
+		progress, err := lxDbTransfer.ExportByQuery(repo, new(bytes.Buffer), rbq, lxDbTransfer.ExportOptions{})
+		its.NoError(err)
+		its.Equal(int64(0), progress.Written)
+	})
+	t.Run("errors", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+		_, err := lxDbTransfer.Export(repo, new(bytes.Buffer), bson.M{}, lxDbTransfer.ExportOptions{Format: "xml"})
+		its.True(errors.Is(err, lxDbTransfer.ErrFormat))
+		_, err = lxDbTransfer.Export(repo, new(bytes.Buffer), bson.M{}, lxDbTransfer.ExportOptions{Format: lxDbTransfer.FormatCSV})
+		its.True(errors.Is(err, lxDbTransfer.ErrColumns))
+
+		repo.EXPECT().GetCollection().Return(nil)
+		repo.EXPECT().Find(gomock.Any(), gomock.Any(), gomock.Any()).Return(lxDb.ErrNotFound)
+		_, err = lxDbTransfer.Export(repo, new(bytes.Buffer), bson.M{}, lxDbTransfer.ExportOptions{})
+		its.True(errors.Is(err, lxDb.ErrNotFound))
+	})
+}
+
+func TestImport(t *testing.T) {
+	its := assert.New(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ndjson := `{"_id":{"$oid":"` + testID.Hex() + `"},"name":"Alice","email":"alice@example.com"}
+
+{"name":"Bob","email":"bob@example.com","createdAt":{"$date":"2020-09-21T12:00:00Z"}}
+{"name": broken}
+{"name":"Carol"}
+`
+
+	t.Run("ndjson batches", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		auth := &lxDb.AuditAuth{User: bson.M{"name": "admin"}}
+
+		gomock.InOrder(
+			repo.EXPECT().
+				InsertMany(gomock.Any(), auth).
+				DoAndReturn(func(docs []interface{}, args ...interface{}) (*lxDb.InsertManyResult, error) {
+					its.Len(docs, 2)
+					its.Equal(testID, docs[0].(bson.D).Map()["_id"])
+					its.Equal(primitive.NewDateTimeFromTime(testDate), docs[1].(bson.D).Map()["createdAt"])
+					return &lxDb.InsertManyResult{InsertedIDs: []interface{}{testID, primitive.NewObjectID()}}, nil
+				}),
+			repo.EXPECT().
+				InsertMany(gomock.Len(1), auth).
+				Return(&lxDb.InsertManyResult{FailedCount: 1}, nil),
+		)
+
+		var progress []lxDbTransfer.Progress
+		res, err := lxDbTransfer.Import(repo, strings.NewReader(ndjson), lxDbTransfer.ImportOptions{
+			BatchSize: 2,
+			AuditAuth: auth,
+			Progress:  func(p lxDbTransfer.Progress) { progress = append(progress, p) },
+		})
+		its.NoError(err)
+		its.Equal(int64(4), res.Read)
+		its.Equal(int64(2), res.Written)
+		its.Equal(int64(2), res.Failed)
+		its.Len(res.Errors, 1)
+		its.Equal(int64(4), res.Errors[0].Row)
+		its.Len(progress, 2)
+	})
+	t.Run("upsert keys", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		repo.EXPECT().
+			ReplaceOne(bson.D{{Key: "email", Value: "alice@example.com"}}, gomock.Any(), options.Replace().SetUpsert(true)).
+			Return(nil)
+		repo.EXPECT().
+			ReplaceOne(bson.D{{Key: "email", Value: "bob@example.com"}}, gomock.Any(), options.Replace().SetUpsert(true)).
+			Return(lxDb.ErrNotFound)
+
+		res, err := lxDbTransfer.Import(repo, strings.NewReader(ndjson), lxDbTransfer.ImportOptions{UpsertKeys: []string{"email"}})
+		its.NoError(err)
+		its.Equal(int64(1), res.Upserted)
+		its.Equal(int64(3), res.Failed)
+		its.Len(res.Errors, 3)
+		its.True(errors.Is(res.Errors[2].Err, lxDbTransfer.ErrUpsertKey))
+		its.Equal(int64(5), res.Errors[2].Row)
+	})
+	t.Run("dry run with schema", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		loader := lxSchemaMocks.NewMockIJSONSchema(mockCtrl)
+		invalid := &lxSchema.JSONValidationResult{Errors: []lxSchema.JSONValidationErrors{{Field: "email", Message: "required"}}}
+
+		loader.EXPECT().ValidateBindRaw("user.json", gomock.Any(), nil).Return(nil, nil).Times(2)
+		loader.EXPECT().ValidateBindRaw("user.json", gomock.Any(), nil).Return(invalid, nil)
+
+		res, err := lxDbTransfer.Import(repo, strings.NewReader(ndjson), lxDbTransfer.ImportOptions{
+			DryRun:       true,
+			SchemaLoader: loader,
+			Schema:       "user.json",
+		})
+		its.NoError(err)
+		its.Equal(int64(4), res.Read)
+		its.Equal(int64(0), res.Written)
+		its.Equal(int64(1), res.Invalid)
+		its.Equal(int64(1), res.Failed)
+		its.Equal(invalid, res.Errors[1].Validation)
+	})
+	t.Run("csv", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+		cols, err := lxDbTransfer.ParseColumns("Name:name,Age:age:int,City:address.city,Active:active:bool,Created:createdAt:date")
+		its.NoError(err)
+
+		repo.EXPECT().
+			InsertMany(gomock.Any()).
+			DoAndReturn(func(docs []interface{}, args ...interface{}) (*lxDb.InsertManyResult, error) {
+				its.Equal([]interface{}{
+					bson.D{
+						{Key: "name", Value: "Alice, A"},
+						{Key: "age", Value: int64(30)},
+						{Key: "address", Value: bson.D{{Key: "city", Value: "Berlin"}}},
+						{Key: "active", Value: true},
+						{Key: "createdAt", Value: primitive.NewDateTimeFromTime(testDate)},
+					},
+					bson.D{{Key: "name", Value: "Bob"}},
+				}, docs)
+				return &lxDb.InsertManyResult{InsertedIDs: []interface{}{1, 2}}, nil
+			})
+
+		data := "Name,Age,City,Active,Created\n" +
+			"\"Alice, A\",30,Berlin,true,2020-09-21T12:00:00Z\n" +
+			"Carol,x,,,\n" +
+			"Bob,,,,\n"
+		res, err := lxDbTransfer.Import(repo, strings.NewReader(data), lxDbTransfer.ImportOptions{Format: lxDbTransfer.FormatCSV, Columns: cols})
+		its.NoError(err)
+		its.Equal(int64(3), res.Read)
+		its.Equal(int64(2), res.Written)
+		its.Equal(int64(1), res.Failed)
+		its.Equal(int64(2), res.Errors[0].Row)
+	})
+	t.Run("errors", func(t *testing.T) {
+		repo := lxDbMocks.NewMockIBaseRepo(mockCtrl)
+
+		_, err := lxDbTransfer.Import(repo, strings.NewReader(""), lxDbTransfer.ImportOptions{Format: "xml"})
+		its.True(errors.Is(err, lxDbTransfer.ErrFormat))
+		_, err = lxDbTransfer.Import(repo, strings.NewReader(""), lxDbTransfer.ImportOptions{Format: lxDbTransfer.FormatCSV})
+		its.True(errors.Is(err, lxDbTransfer.ErrColumns))
+
+		repo.EXPECT().InsertMany(gomock.Any()).Return(nil, lxDb.ErrNotFound)
+		_, err = lxDbTransfer.Import(repo, strings.NewReader(ndjson), lxDbTransfer.ImportOptions{})
+		its.True(errors.Is(err, lxDb.ErrNotFound))
+	})
+}
@@ -0,0 +1,247 @@
+package lxDb
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// UpdateBuilder, fluent builder for update documents, can be passed as update to all IBaseRepo methods,
+// invalid updates return ErrUpdate by Build and on marshal.
+// Operators are in order of first use, fields of an operator in order of use.
+// Example:
+// update := lxDb.Set("status", "closed").Inc("version", 1).Push("log", entry)
+// err := repo.UpdateOne(filter, update)
+type UpdateBuilder struct {
+	ops    bson.D
+	fields *Fields
+	errs   []string
+}
+
+// NewUpdate, return empty update
+func NewUpdate() *UpdateBuilder {
+	return &UpdateBuilder{}
+}
+
+// Set, return update with $set of field, see NewUpdate for other operators
+func Set(field string, value interface{}) *UpdateBuilder {
+	return NewUpdate().Set(field, value)
+}
+
+// Unset, return update with $unset of fields
+func Unset(fields ...string) *UpdateBuilder {
+	return NewUpdate().Unset(fields...)
+}
+
+// Inc, return update with $inc of field
+func Inc(field string, value interface{}) *UpdateBuilder {
+	return NewUpdate().Inc(field, value)
+}
+
+// add, set field of operator, replaces value of same field in operator
+func (u *UpdateBuilder) add(op, field string, value interface{}) *UpdateBuilder {
+	if field == "" || strings.HasPrefix(field, "$") {
+		u.errs = append(u.errs, fmt.Sprintf("%s: invalid field name %q", op, field))
+		return u
+	}
+	if u.fields != nil && !u.fields.Has(field) {
+		u.errs = append(u.errs, fmt.Sprintf("%s: unknown field %q", op, field))
+		return u
+	}
+
+	// Paths of other operators conflict on the same field and on parents
+	for _, o := range u.ops {
+		for _, e := range o.Value.(bson.D) {
+			if o.Key != op && pathsConflict(e.Key, field) {
+				u.errs = append(u.errs, fmt.Sprintf("%s: field %q conflicts with %s %q", op, field, o.Key, e.Key))
+				return u
+			}
+		}
+	}
+
+	for i, o := range u.ops {
+		if o.Key != op {
+			continue
+		}
+		fields := o.Value.(bson.D)
+		for j, e := range fields {
+			if e.Key == field {
+				fields[j].Value = value
+				return u
+			}
+			if pathsConflict(e.Key, field) {
+				u.errs = append(u.errs, fmt.Sprintf("%s: field %q conflicts with %q", op, field, e.Key))
+				return u
+			}
+		}
+		u.ops[i].Value = append(fields, bson.E{Key: field, Value: value})
+		return u
+	}
+	u.ops = append(u.ops, bson.E{Key: op, Value: bson.D{{Key: field, Value: value}}})
+	return u
+}
+
+// pathsConflict, return true when paths are equal or one is parent of the other
+func pathsConflict(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// Fields
+
+// Set, $set field
+func (u *UpdateBuilder) Set(field string, value interface{}) *UpdateBuilder {
+	return u.add("$set", field, value)
+}
+
+// SetOnInsert, $setOnInsert field
+func (u *UpdateBuilder) SetOnInsert(field string, value interface{}) *UpdateBuilder {
+	return u.add("$setOnInsert", field, value)
+}
+
+// Unset, $unset fields
+func (u *UpdateBuilder) Unset(fields ...string) *UpdateBuilder {
+	for _, field := range fields {
+		u.add("$unset", field, "")
+	}
+	return u
+}
+
+// Inc, $inc field
+func (u *UpdateBuilder) Inc(field string, value interface{}) *UpdateBuilder {
+	return u.add("$inc", field, value)
+}
+
+// Mul, $mul field
+func (u *UpdateBuilder) Mul(field string, value interface{}) *UpdateBuilder {
+	return u.add("$mul", field, value)
+}
+
+// Min, $min field
+func (u *UpdateBuilder) Min(field string, value interface{}) *UpdateBuilder {
+	return u.add("$min", field, value)
+}
+
+// Max, $max field
+func (u *UpdateBuilder) Max(field string, value interface{}) *UpdateBuilder {
+	return u.add("$max", field, value)
+}
+
+// Rename, $rename field to new name
+func (u *UpdateBuilder) Rename(field, newName string) *UpdateBuilder {
+	if u.fields != nil && !u.fields.Has(newName) {
+		u.errs = append(u.errs, fmt.Sprintf("$rename: unknown field %q", newName))
+		return u
+	}
+	return u.add("$rename", field, newName)
+}
+
+// CurrentDate, $currentDate field as date
+func (u *UpdateBuilder) CurrentDate(field string) *UpdateBuilder {
+	return u.add("$currentDate", field, true)
+}
+
+// CurrentTimestamp, $currentDate field as timestamp
+func (u *UpdateBuilder) CurrentTimestamp(field string) *UpdateBuilder {
+	return u.add("$currentDate", field, bson.D{{Key: "$type", Value: "timestamp"}})
+}
+
+// Array
+
+// Push, $push value
+func (u *UpdateBuilder) Push(field string, value interface{}) *UpdateBuilder {
+	return u.add("$push", field, value)
+}
+
+// PushEach, $push values with $each and optional modifiers $position, $slice and $sort
+// Example:
+// update := lxDb.NewUpdate().PushEach("scores", bson.A{89, 92}, bson.E{Key: "$slice", Value: -5})
+func (u *UpdateBuilder) PushEach(field string, values bson.A, modifiers ...bson.E) *UpdateBuilder {
+	each := bson.D{{Key: "$each", Value: values}}
+	for _, m := range modifiers {
+		switch m.Key {
+		case "$position", "$slice", "$sort":
+			each = append(each, m)
+		default:
+			u.errs = append(u.errs, fmt.Sprintf("$push: invalid modifier %q", m.Key))
+			return u
+		}
+	}
+	return u.add("$push", field, each)
+}
+
+// AddToSet, $addToSet value
+func (u *UpdateBuilder) AddToSet(field string, value interface{}) *UpdateBuilder {
+	return u.add("$addToSet", field, value)
+}
+
+// AddToSetEach, $addToSet values with $each
+func (u *UpdateBuilder) AddToSetEach(field string, values ...interface{}) *UpdateBuilder {
+	return u.add("$addToSet", field, bson.D{{Key: "$each", Value: bson.A(values)}})
+}
+
+// Pop, $pop first or last element
+func (u *UpdateBuilder) Pop(field string, first bool) *UpdateBuilder {
+	if first {
+		return u.add("$pop", field, -1)
+	}
+	return u.add("$pop", field, 1)
+}
+
+// Pull, $pull elements equal to value or matching a *Filter of element fields
+// Example:
+// update := lxDb.NewUpdate().Pull("items", lxDb.Where("qty").Lte(0))
+func (u *UpdateBuilder) Pull(field string, condition interface{}) *UpdateBuilder {
+	if f, ok := condition.(*Filter); ok {
+		u.errs = append(u.errs, f.errs...)
+		condition = f.doc
+	}
+	return u.add("$pull", field, condition)
+}
+
+// PullAll, $pullAll values
+func (u *UpdateBuilder) PullAll(field string, values ...interface{}) *UpdateBuilder {
+	return u.add("$pullAll", field, bson.A(values))
+}
+
+// Build, return update or ErrUpdate with all errors
+func (u *UpdateBuilder) Build() (bson.D, error) {
+	if len(u.errs) > 0 {
+		return nil, fmt.Errorf("%s, %w", strings.Join(u.errs, "; "), ErrUpdate)
+	}
+	if len(u.ops) == 0 {
+		return nil, fmt.Errorf("empty update, %w", ErrUpdate)
+	}
+	return u.ops, nil
+}
+
+// MustBuild, return update, panics by errors
+func (u *UpdateBuilder) MustBuild() bson.D {
+	doc, err := u.Build()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// MarshalBSON, implements bson.Marshaler, the update can be used as update of IBaseRepo methods
+func (u *UpdateBuilder) MarshalBSON() ([]byte, error) {
+	doc, err := u.Build()
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+// String, return update as relaxed extended json for debugging
+func (u *UpdateBuilder) String() string {
+	doc, err := u.Build()
+	if err != nil {
+		return err.Error()
+	}
+	raw, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err.Error()
+	}
+	return string(raw)
+}
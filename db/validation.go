@@ -0,0 +1,458 @@
+package lxDb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	lxSchema "github.com/litixsoft/lxgo/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SchemaValidation, validates documents of writes with a lxSchema schema,
+// set as option of NewMongoBaseRepo.
+// Inserts and replacements are validated before the write. Updates run once in a transaction,
+// the after-images are validated and the transaction is committed only when all are valid.
+// Transactions need a replica set or sharded cluster, on standalone servers updates with only
+// $set, $unset and $setOnInsert of simple paths are simulated on the found documents before the write,
+// other updates, e.g. with array indexes or array filters, return ErrUpdateValidation,
+// use SkipValidation to write them.
+// Example:
+// lxSchema.InitJsonSchemaLoader()
+// repo := lxDb.NewMongoBaseRepo(collection, &lxDb.SchemaValidation{Loader: lxSchema.Loader, Schema: "user.json"})
+type SchemaValidation struct {
+	Loader lxSchema.IJSONSchema
+	Schema string
+	// IncludeID, validate with _id, default _id is removed before validation
+	IncludeID bool
+}
+
+// applyRepo, implements RepoOption
+func (v *SchemaValidation) applyRepo(repo *mongoBaseRepo) {
+	if v != nil {
+		repo.validation = v
+	}
+}
+
+// SkipValidation, arg to skip the schema validation of a call
+// Example:
+// err := repo.ReplaceOne(filter, doc, lxDb.SkipValidation{})
+type SkipValidation struct{}
+
+// ValidationError, document is invalid for the schema of the repo
+// Example:
+// var valErr *lxDb.ValidationError
+// if errors.As(err, &valErr) {
+// return c.JSON(http.StatusUnprocessableEntity, valErr.Result)
+// }
+type ValidationError struct {
+	// Index, index of document in InsertMany, otherwise 0
+	Index  int
+	Result *lxSchema.JSONValidationResult
+}
+
+// Error, implements error
+func (e *ValidationError) Error() string {
+	var fields []string
+	if e.Result != nil {
+		for _, valErr := range e.Result.Errors {
+			fields = append(fields, valErr.Field)
+		}
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation.Error(), strings.Join(fields, ", "))
+}
+
+// Unwrap, return ErrValidation for errors.Is
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// validationActive, return true when schema validation is configured and not skipped
+func (repo *mongoBaseRepo) validationActive(skip bool) bool {
+	return repo.validation != nil && repo.validation.Loader != nil && !skip
+}
+
+// validateDoc, validate document, returns *ValidationError when invalid
+func (repo *mongoBaseRepo) validateDoc(doc interface{}, index int) error {
+	bm, err := ToBsonMap(doc)
+	if err != nil {
+		return err
+	}
+	if !repo.validation.IncludeID {
+		delete(bm, "_id")
+	}
+
+	data, err := json.Marshal(jsonValue(bm))
+	if err != nil {
+		return err
+	}
+
+	res, err := repo.validation.Loader.ValidateBindRaw(repo.validation.Schema, &data, nil)
+	if err != nil {
+		return err
+	}
+	if res != nil && len(res.Errors) > 0 {
+		return &ValidationError{Index: index, Result: res}
+	}
+	return nil
+}
+
+// validateUpdate, validate documents after update by simulation, used without transactions,
+// opts are the update options of the caller, sort selects the document of single updates.
+// Updates which can't be simulated return ErrUpdateValidation.
+func (repo *mongoBaseRepo) validateUpdate(filter, update interface{}, opts *options.UpdateOptions, sort interface{}, many bool, timeout time.Duration) error {
+	set, unset, setOnInsert, ok := simpleUpdate(update)
+	if !ok {
+		return fmt.Errorf("transactions not supported by server, %w", ErrUpdateValidation)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	findOpts := options.Find()
+	findOpts.Collation = opts.Collation
+	findOpts.Hint = opts.Hint
+	if !many {
+		findOpts.SetLimit(1)
+		findOpts.Sort = sort
+	}
+	cur, err := repo.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	// Found documents are validated one by one
+	found := false
+	for cur.Next(ctx) {
+		found = true
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		if err := repo.validateSimulated(doc, set, unset); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	// Upsert inserts document of filter and update
+	if !found && isUpsert(opts.Upsert) {
+		doc := filterDoc(filter)
+		for _, field := range setOnInsert {
+			setPath(doc, field.Key, field.Value)
+		}
+		return repo.validateSimulated(doc, set, unset)
+	}
+	return nil
+}
+
+// validateSimulated, apply $set and $unset to doc and validate
+func (repo *mongoBaseRepo) validateSimulated(doc bson.M, set, unset bson.D) error {
+	for _, field := range set {
+		setPath(doc, field.Key, field.Value)
+	}
+	for _, field := range unset {
+		unsetPath(doc, field.Key)
+	}
+	return repo.validateDoc(doc, 0)
+}
+
+// validatedUpdate, result of updateValidated, Before and After are set with images
+type validatedUpdate struct {
+	IDs           []interface{}
+	Before        []bson.M
+	After         []bson.M
+	MatchedCount  int64
+	ModifiedCount int64
+	UpsertedID    interface{}
+}
+
+// image, return documents before and after update of index, before is nil for upserted documents
+func (res *validatedUpdate) image(i int) (before, after bson.M) {
+	if i < len(res.Before) {
+		before = res.Before[i]
+	}
+	if i < len(res.After) {
+		after = res.After[i]
+	}
+	return before, after
+}
+
+// updateValidated, run update with options of caller once in a transaction,
+// validate after-images and commit only when all documents are valid.
+// With images the documents before and after the update are returned, otherwise only ids.
+func (repo *mongoBaseRepo) updateValidated(filter, update interface{}, opts *options.UpdateOptions, sort interface{}, many, images bool, timeout time.Duration) (*validatedUpdate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session, err := repo.collection.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	res := &validatedUpdate{}
+	err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		if err := session.StartTransaction(); err != nil {
+			return err
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				_ = session.AbortTransaction(context.Background())
+			}
+		}()
+
+		// Documents before update
+		findOpts := options.Find()
+		if !images {
+			findOpts.SetProjection(bson.D{{Key: "_id", Value: 1}})
+		}
+		findOpts.Collation = opts.Collation
+		findOpts.Hint = opts.Hint
+		if !many {
+			findOpts.SetLimit(1)
+			findOpts.Sort = sort
+		}
+		cur, err := repo.collection.Find(sc, filter, findOpts)
+		if err != nil {
+			return fmt.Errorf("%v, %w", err, ErrUpdateValidation)
+		}
+		for cur.Next(sc) {
+			var doc bson.M
+			if err := cur.Decode(&doc); err != nil {
+				_ = cur.Close(sc)
+				return err
+			}
+			res.IDs = append(res.IDs, doc["_id"])
+			if images {
+				res.Before = append(res.Before, doc)
+			}
+		}
+		if err := cur.Err(); err != nil {
+			_ = cur.Close(sc)
+			return err
+		}
+		_ = cur.Close(sc)
+
+		// Found documents are updated by _id, the hint is only used for the filter
+		updateOpts := options.Update().SetUpsert(isUpsert(opts.Upsert))
+		updateOpts.ArrayFilters = opts.ArrayFilters
+		updateOpts.Collation = opts.Collation
+		updateOpts.BypassDocumentValidation = opts.BypassDocumentValidation
+		var updRes *mongo.UpdateResult
+		if len(res.IDs) == 0 {
+			updateOpts.Hint = opts.Hint
+			updRes, err = repo.collection.UpdateOne(sc, filter, update, updateOpts)
+		} else if many {
+			updRes, err = repo.collection.UpdateMany(sc, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: res.IDs}}}}, update, updateOpts)
+		} else {
+			updRes, err = repo.collection.UpdateOne(sc, bson.D{{Key: "_id", Value: res.IDs[0]}}, update, updateOpts)
+		}
+		if err != nil {
+			return err
+		}
+		res.MatchedCount = updRes.MatchedCount
+		res.ModifiedCount = updRes.ModifiedCount
+		res.UpsertedID = updRes.UpsertedID
+		if res.UpsertedID != nil {
+			res.IDs = append(res.IDs, res.UpsertedID)
+		}
+		if len(res.IDs) == 0 {
+			return nil
+		}
+
+		// After-images, validated one by one
+		cur, err = repo.collection.Find(sc, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: res.IDs}}}})
+		if err != nil {
+			return err
+		}
+		defer cur.Close(sc)
+		after := map[string]bson.M{}
+		for cur.Next(sc) {
+			var doc bson.M
+			if err := cur.Decode(&doc); err != nil {
+				return err
+			}
+			if err := repo.validateDoc(doc, 0); err != nil {
+				return err
+			}
+			if images {
+				after[refKey(doc["_id"])] = doc
+			}
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		if images {
+			for _, id := range res.IDs {
+				res.After = append(res.After, after[refKey(id)])
+			}
+		}
+
+		if err := session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// simpleUpdate, return fields of $set, $unset and $setOnInsert,
+// false when update has other operators or paths with positional operators or array indexes
+func simpleUpdate(update interface{}) (set, unset, setOnInsert bson.D, ok bool) {
+	doc, err := ToBsonDoc(update)
+	if err != nil {
+		return nil, nil, nil, false
+	}
+
+	for _, e := range *doc {
+		fields, err := ToBsonDoc(e.Value)
+		if err != nil {
+			return nil, nil, nil, false
+		}
+		for _, field := range *fields {
+			if strings.Contains(field.Key, "$") || hasIndexPart(field.Key) {
+				return nil, nil, nil, false
+			}
+		}
+
+		switch e.Key {
+		case "$set":
+			set = append(set, *fields...)
+		case "$unset":
+			unset = append(unset, *fields...)
+		case "$setOnInsert":
+			setOnInsert = append(setOnInsert, *fields...)
+		default:
+			return nil, nil, nil, false
+		}
+	}
+	return set, unset, setOnInsert, true
+}
+
+// hasIndexPart, return true when a part of dotted path is a numeric array index
+func hasIndexPart(path string) bool {
+	for _, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDoc, return document of equality fields in filter, as inserted by upsert
+func filterDoc(filter interface{}) bson.M {
+	doc := bson.M{}
+	fields, err := ToBsonDoc(filter)
+	if err != nil {
+		return doc
+	}
+	for _, field := range *fields {
+		if strings.HasPrefix(field.Key, "$") || isOperatorDoc(field.Value) {
+			continue
+		}
+		setPath(doc, field.Key, field.Value)
+	}
+	return doc
+}
+
+// isOperatorDoc, return true when value is a document of query operators
+func isOperatorDoc(value interface{}) bool {
+	switch value.(type) {
+	case bson.M, bson.D, map[string]interface{}:
+	default:
+		return false
+	}
+	doc, err := ToBsonDoc(value)
+	if err != nil || len(*doc) == 0 {
+		return false
+	}
+	return strings.HasPrefix((*doc)[0].Key, "$")
+}
+
+// setPath, set value of dotted path, creates missing documents
+func setPath(doc bson.M, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			if d, isD := doc[part].(bson.D); isD {
+				next = d.Map()
+			} else {
+				next = bson.M{}
+			}
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = value
+}
+
+// unsetPath, remove dotted path
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+// jsonValue, convert bson value to plain json, as used by request schemas:
+// ids, decimals and binaries are strings, dates RFC 3339 strings
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = jsonValue(item)
+		}
+		return out
+	case bson.D:
+		return jsonValue(v.Map())
+	case bson.A:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = jsonValue(item)
+		}
+		return out
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC().Format(time.RFC3339)
+	case primitive.Decimal128:
+		return v.String()
+	case primitive.Binary:
+		if v.Subtype == 4 && len(v.Data) == 16 {
+			var u UUID
+			copy(u[:], v.Data)
+			return u.String()
+		}
+		return base64.StdEncoding.EncodeToString(v.Data)
+	case primitive.Regex:
+		return v.Pattern
+	case primitive.Null, primitive.Undefined:
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,485 @@
+// Package lxDbValidator converts lxSchema JSON schemas (draft-7) to mongo $jsonSchema
+// validators and syncs them to collections with collMod.
+package lxDbValidator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Errors
+var (
+	ErrSchema      = errors.New("invalid schema")
+	ErrRef         = errors.New("unresolvable $ref")
+	ErrCyclicRef   = errors.New("cyclic $ref")
+	ErrCollection  = errors.New("collection not found")
+	ErrValidLevel  = errors.New("invalid validation level")
+	ErrValidAction = errors.New("invalid validation action")
+)
+
+// ObjectIDFormats, formats of strings converted to bsonType objectId
+var ObjectIDFormats = []string{"objectid", "objectId", "object-id"}
+
+// ObjectIDPatterns, patterns of strings converted to bsonType objectId
+var ObjectIDPatterns = []string{
+	"^[0-9a-fA-F]{24}$",
+	"^[a-fA-F0-9]{24}$",
+	"^[0-9a-f]{24}$",
+	"^[a-f0-9]{24}$",
+}
+
+// keywords supported by $jsonSchema, values are copied
+var passKeywords = map[string]bool{
+	"title":         true,
+	"description":   true,
+	"enum":          true,
+	"multipleOf":    true,
+	"maxLength":     true,
+	"minLength":     true,
+	"pattern":       true,
+	"maxItems":      true,
+	"minItems":      true,
+	"uniqueItems":   true,
+	"maxProperties": true,
+	"minProperties": true,
+	"required":      true,
+}
+
+// keywords without effect on validation, removed without report
+var metaKeywords = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"definitions": true,
+	"default":     true,
+	"examples":    true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+// Conversion, result of Convert
+type Conversion struct {
+	// Schema, $jsonSchema document
+	Schema bson.M
+	// Unsupported, keywords which are removed, as "path: keyword"
+	Unsupported []string
+}
+
+// converter, state of one conversion
+type converter struct {
+	factory     gojsonschema.JSONLoaderFactory
+	docs        map[string]interface{}
+	resolving   map[string]bool
+	unsupported []string
+}
+
+// Convert, return $jsonSchema of a draft-7 schema loaded by lxSchema.
+// $refs are resolved, format date-time is converted to bsonType date
+// and object id strings to bsonType objectId. With additionalProperties false
+// on root, _id is added to properties.
+// Example:
+// loader, err := lxSchema.Loader.LoadSchema("user.json")
+// conv, err := lxDbValidator.Convert(loader)
+func Convert(loader gojsonschema.JSONLoader) (*Conversion, error) {
+	doc, err := loader.LoadJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	base := ""
+	if ref, err := loader.JsonReference(); err == nil {
+		u := ref.GetUrl()
+		u.Fragment = ""
+		base = u.String()
+	}
+
+	c := &converter{
+		factory:   loader.LoaderFactory(),
+		docs:      map[string]interface{}{base: doc},
+		resolving: make(map[string]bool),
+	}
+
+	schema, err := c.convert(doc, base, doc, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Without _id in properties no document is valid
+	if schema["additionalProperties"] == false {
+		props, _ := schema["properties"].(bson.M)
+		if props == nil {
+			props = bson.M{}
+			schema["properties"] = props
+		}
+		if _, ok := props["_id"]; !ok {
+			props["_id"] = bson.M{}
+		}
+	}
+
+	sort.Strings(c.unsupported)
+	return &Conversion{Schema: schema, Unsupported: c.unsupported}, nil
+}
+
+// convert, convert schema node at path, base and root are used for $ref
+func (c *converter) convert(node interface{}, base string, root interface{}, path string) (bson.M, error) {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s, %w", pathName(path), ErrSchema)
+	}
+
+	// Resolve $ref, other keywords beside $ref are ignored as in draft-7
+	if ref, ok := schema["$ref"].(string); ok {
+		target, targetBase, targetRoot, err := c.resolve(ref, base, root)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s, %w", pathName(path), ref, err)
+		}
+
+		// Same schema object is the same target
+		key := fmt.Sprintf("%p", target)
+		if c.resolving[key] {
+			return nil, fmt.Errorf("%s %s, %w", pathName(path), ref, ErrCyclicRef)
+		}
+		c.resolving[key] = true
+		defer delete(c.resolving, key)
+
+		return c.convert(target, targetBase, targetRoot, path)
+	}
+
+	out := bson.M{}
+	for _, key := range sortedKeys(schema) {
+		value := schema[key]
+		keyPath := path + "/" + key
+
+		switch {
+		case passKeywords[key]:
+			out[key] = convertValue(value)
+		case metaKeywords[key]:
+		case key == "type":
+			bsonType, err := bsonTypes(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s, %w", pathName(keyPath), err)
+			}
+			out["bsonType"] = bsonType
+		case key == "format":
+			// Handled with type
+		case key == "const":
+			out["enum"] = bson.A{convertValue(value)}
+		case key == "minimum" || key == "maximum":
+			out[key] = convertValue(value)
+		case key == "exclusiveMinimum" || key == "exclusiveMaximum":
+			// Numeric in draft-7, boolean modifier in $jsonSchema
+			if _, ok := value.(bool); ok {
+				out[key] = value
+				continue
+			}
+			limit := "minimum"
+			if key == "exclusiveMaximum" {
+				limit = "maximum"
+			}
+			if _, exists := schema[limit]; exists {
+				c.unsupported = append(c.unsupported, pathName(keyPath)+": "+key+" with "+limit)
+				continue
+			}
+			out[limit] = convertValue(value)
+			out[key] = true
+		case key == "properties" || key == "patternProperties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s, %w", pathName(keyPath), ErrSchema)
+			}
+			converted := bson.M{}
+			for _, name := range sortedKeys(props) {
+				prop, err := c.convert(props[name], base, root, keyPath+"/"+escapePointer(name))
+				if err != nil {
+					return nil, err
+				}
+				converted[name] = prop
+			}
+			out[key] = converted
+		case key == "additionalProperties" || key == "additionalItems" || key == "items" || key == "not":
+			if b, ok := value.(bool); ok && key != "not" && key != "items" {
+				out[key] = b
+				continue
+			}
+			if list, ok := value.([]interface{}); ok && key == "items" {
+				items, err := c.convertList(list, base, root, keyPath)
+				if err != nil {
+					return nil, err
+				}
+				out[key] = items
+				continue
+			}
+			if _, ok := value.(map[string]interface{}); !ok {
+				c.unsupported = append(c.unsupported, pathName(keyPath)+": boolean "+key)
+				continue
+			}
+			sub, err := c.convert(value, base, root, keyPath)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = sub
+		case key == "allOf" || key == "anyOf" || key == "oneOf":
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s, %w", pathName(keyPath), ErrSchema)
+			}
+			subs, err := c.convertList(list, base, root, keyPath)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = subs
+		case key == "dependencies":
+			deps, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s, %w", pathName(keyPath), ErrSchema)
+			}
+			converted := bson.M{}
+			for _, name := range sortedKeys(deps) {
+				if _, ok := deps[name].([]interface{}); ok {
+					converted[name] = convertValue(deps[name])
+					continue
+				}
+				dep, err := c.convert(deps[name], base, root, keyPath+"/"+escapePointer(name))
+				if err != nil {
+					return nil, err
+				}
+				converted[name] = dep
+			}
+			out[key] = converted
+		default:
+			c.unsupported = append(c.unsupported, pathName(path)+": "+key)
+		}
+	}
+
+	c.convertFormat(schema, out, path)
+	return out, nil
+}
+
+// convertList, convert list of schemas
+func (c *converter) convertList(list []interface{}, base string, root interface{}, path string) (bson.A, error) {
+	out := make(bson.A, 0, len(list))
+	for i, item := range list {
+		sub, err := c.convert(item, base, root, path+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub)
+	}
+	return out, nil
+}
+
+// convertFormat, set bsonType date for date-time and objectId for object id strings
+func (c *converter) convertFormat(schema map[string]interface{}, out bson.M, path string) {
+	format, _ := schema["format"].(string)
+	pattern, _ := schema["pattern"].(string)
+
+	switch {
+	case format == "date-time":
+		out["bsonType"] = replaceType(out["bsonType"], "string", "date")
+	case contains(ObjectIDFormats, format) || contains(ObjectIDPatterns, pattern):
+		out["bsonType"] = replaceType(out["bsonType"], "string", "objectId")
+		delete(out, "pattern")
+		delete(out, "minLength")
+		delete(out, "maxLength")
+	case format != "":
+		c.unsupported = append(c.unsupported, pathName(path)+": format "+format)
+	}
+}
+
+// resolve, return schema of $ref, with base and root of its document
+func (c *converter) resolve(ref, base string, root interface{}) (interface{}, string, interface{}, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return nil, "", nil, ErrRef
+	}
+	fragment := refURL.Fragment
+	refURL.Fragment = ""
+
+	// Document of ref
+	targetBase := base
+	targetRoot := root
+	if refURL.String() != "" {
+		baseURL, err := url.Parse(base)
+		if err != nil || !baseURL.IsAbs() {
+			return nil, "", nil, ErrRef
+		}
+		targetBase = baseURL.ResolveReference(refURL).String()
+
+		doc, ok := c.docs[targetBase]
+		if !ok {
+			if c.factory == nil {
+				return nil, "", nil, ErrRef
+			}
+			if doc, err = c.factory.New(targetBase).LoadJSON(); err != nil {
+				return nil, "", nil, fmt.Errorf("%v, %w", err, ErrRef)
+			}
+			c.docs[targetBase] = doc
+		}
+		targetRoot = doc
+	}
+
+	// Json pointer in document
+	target := targetRoot
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch node := target.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, "", nil, ErrRef
+			}
+			target = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, "", nil, ErrRef
+			}
+			target = node[i]
+		default:
+			return nil, "", nil, ErrRef
+		}
+	}
+
+	return target, targetBase, targetRoot, nil
+}
+
+// bsonTypes, return bsonType of json schema type
+func bsonTypes(value interface{}) (interface{}, error) {
+	switch t := value.(type) {
+	case string:
+		types, err := bsonType(t)
+		if err != nil {
+			return nil, err
+		}
+		if len(types) == 1 {
+			return types[0], nil
+		}
+		return types, nil
+	case []interface{}:
+		types := bson.A{}
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, ErrSchema
+			}
+			converted, err := bsonType(name)
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, converted...)
+		}
+		return types, nil
+	}
+	return nil, ErrSchema
+}
+
+// bsonType, return bson types of one json schema type
+func bsonType(name string) (bson.A, error) {
+	switch name {
+	case "string", "object", "array", "null":
+		return bson.A{name}, nil
+	case "boolean":
+		return bson.A{"bool"}, nil
+	case "integer":
+		return bson.A{"int", "long"}, nil
+	case "number":
+		return bson.A{"number"}, nil
+	}
+	return nil, fmt.Errorf("type %q, %w", name, ErrSchema)
+}
+
+// replaceType, replace json type in bsonType
+func replaceType(bsonType interface{}, from, to string) interface{} {
+	switch t := bsonType.(type) {
+	case nil:
+		return to
+	case string:
+		if t == from {
+			return to
+		}
+		return bson.A{t, to}
+	case bson.A:
+		out := bson.A{}
+		replaced := false
+		for _, v := range t {
+			if v == from {
+				v, replaced = to, true
+			}
+			out = append(out, v)
+		}
+		if !replaced {
+			out = append(out, to)
+		}
+		return out
+	}
+	return bsonType
+}
+
+// convertValue, convert json.Number to int64 or float64
+func convertValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		out := make(bson.A, len(v))
+		for i, item := range v {
+			out[i] = convertValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := bson.M{}
+		for key, item := range v {
+			out[key] = convertValue(item)
+		}
+		return out
+	}
+	return value
+}
+
+// sortedKeys, return sorted keys of map
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains, return true when list contains s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePointer, escape json pointer token
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// pathName, return path or root
+func pathName(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,418 @@
+package lxDbValidator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	lxDb "github.com/litixsoft/lxgo/db"
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// Validation levels
+	LevelOff      = "off"
+	LevelStrict   = "strict"
+	LevelModerate = "moderate"
+
+	// Validation actions
+	ActionError = "error"
+	ActionWarn  = "warn"
+
+	// Change operations
+	OpAdd    = "add"
+	OpRemove = "remove"
+	OpChange = "change"
+)
+
+// Options, options of Plan and Sync
+type Options struct {
+	// ValidationLevel, default LevelStrict
+	ValidationLevel string
+	// ValidationAction, default ActionError
+	ValidationAction string
+	// Timeout, default lxDb.DefaultTimeout
+	Timeout time.Duration
+}
+
+// Change, difference between current and new validator
+type Change struct {
+	Path string
+	Op   string
+	Old  interface{}
+	New  interface{}
+}
+
+// String, return change as one diff line
+func (c Change) String() string {
+	switch c.Op {
+	case OpAdd:
+		return fmt.Sprintf("+ %s: %s", c.Path, diffValue(c.New))
+	case OpRemove:
+		return fmt.Sprintf("- %s: %s", c.Path, diffValue(c.Old))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, diffValue(c.Old), diffValue(c.New))
+}
+
+// SyncPlan, changes of the collection validator
+type SyncPlan struct {
+	Collection string
+	// Schema, new $jsonSchema
+	Schema bson.M
+	// Current, current $jsonSchema, nil without validator
+	Current bson.M
+	// Exists, false when the collection will be created
+	Exists           bool
+	ValidationLevel  string
+	ValidationAction string
+	Changes          []Change
+	// Unsupported, keywords removed by Convert
+	Unsupported []string
+}
+
+// HasChanges, return true when validator, level or action changes
+func (p *SyncPlan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// String, return plan as diff
+func (p *SyncPlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "collection %s", p.Collection)
+	if !p.Exists {
+		sb.WriteString(" (create)")
+	}
+	sb.WriteString("\n")
+	if !p.HasChanges() {
+		sb.WriteString("no changes\n")
+	}
+	for _, change := range p.Changes {
+		sb.WriteString(change.String())
+		sb.WriteString("\n")
+	}
+	for _, keyword := range p.Unsupported {
+		fmt.Fprintf(&sb, "! unsupported %s\n", keyword)
+	}
+	return sb.String()
+}
+
+// Plan, convert schema and compare with the validator of collection, nothing is changed
+// Example:
+// plan, err := lxDbValidator.Plan(collection, loader, lxDbValidator.Options{})
+// fmt.Print(plan)
+func Plan(collection *mongo.Collection, loader gojsonschema.JSONLoader, opts Options) (*SyncPlan, error) {
+	if err := opts.defaults(); err != nil {
+		return nil, err
+	}
+
+	conv, err := Convert(loader)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	current, err := currentValidator(ctx, collection)
+	if err != nil && !errors.Is(err, ErrCollection) {
+		return nil, err
+	}
+
+	plan := &SyncPlan{
+		Collection:       collection.Name(),
+		Schema:           conv.Schema,
+		Exists:           err == nil,
+		ValidationLevel:  opts.ValidationLevel,
+		ValidationAction: opts.ValidationAction,
+		Unsupported:      conv.Unsupported,
+	}
+	if current != nil {
+		plan.Current = current.schema
+	}
+
+	plan.Changes = Diff("$jsonSchema", plan.Current, plan.Schema)
+	if current != nil && current.level != opts.ValidationLevel {
+		plan.Changes = append(plan.Changes, Change{Path: "validationLevel", Op: OpChange, Old: current.level, New: opts.ValidationLevel})
+	}
+	if current != nil && current.action != opts.ValidationAction {
+		plan.Changes = append(plan.Changes, Change{Path: "validationAction", Op: OpChange, Old: current.action, New: opts.ValidationAction})
+	}
+
+	return plan, nil
+}
+
+// Apply, set validator of plan with collMod, creates a not existing collection
+func Apply(collection *mongo.Collection, plan *SyncPlan, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = lxDb.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := "collMod"
+	if !plan.Exists {
+		cmd = "create"
+	}
+
+	return collection.Database().RunCommand(ctx, bson.D{
+		{Key: cmd, Value: collection.Name()},
+		{Key: "validator", Value: bson.D{{Key: "$jsonSchema", Value: plan.Schema}}},
+		{Key: "validationLevel", Value: plan.ValidationLevel},
+		{Key: "validationAction", Value: plan.ValidationAction},
+	}).Err()
+}
+
+// Sync, plan and apply when the validator changes
+// Example:
+// plan, err := lxDbValidator.Sync(collection, loader, lxDbValidator.Options{ValidationAction: lxDbValidator.ActionWarn})
+func Sync(collection *mongo.Collection, loader gojsonschema.JSONLoader, opts Options) (*SyncPlan, error) {
+	plan, err := Plan(collection, loader, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.HasChanges() && plan.Exists {
+		return plan, nil
+	}
+	return plan, Apply(collection, plan, opts.Timeout)
+}
+
+// defaults, set and check defaults of options
+func (opts *Options) defaults() error {
+	if opts.ValidationLevel == "" {
+		opts.ValidationLevel = LevelStrict
+	}
+	if opts.ValidationAction == "" {
+		opts.ValidationAction = ActionError
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = lxDb.DefaultTimeout
+	}
+
+	switch opts.ValidationLevel {
+	case LevelOff, LevelStrict, LevelModerate:
+	default:
+		return fmt.Errorf("%q, %w", opts.ValidationLevel, ErrValidLevel)
+	}
+	switch opts.ValidationAction {
+	case ActionError, ActionWarn:
+	default:
+		return fmt.Errorf("%q, %w", opts.ValidationAction, ErrValidAction)
+	}
+	return nil
+}
+
+// validator, current validator of collection
+type validator struct {
+	schema bson.M
+	level  string
+	action string
+}
+
+// currentValidator, return $jsonSchema, level and action of collection
+func currentValidator(ctx context.Context, collection *mongo.Collection) (*validator, error) {
+	cur, err := collection.Database().ListCollections(ctx, bson.D{{Key: "name", Value: collection.Name()}})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []struct {
+		Options struct {
+			Validator        bson.M `bson:"validator"`
+			ValidationLevel  string `bson:"validationLevel"`
+			ValidationAction string `bson:"validationAction"`
+		} `bson:"options"`
+	}
+	if err := cur.All(ctx, &infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("%s, %w", collection.Name(), ErrCollection)
+	}
+
+	// Server defaults
+	v := &validator{level: LevelStrict, action: ActionError}
+	info := infos[0].Options
+	if info.ValidationLevel != "" {
+		v.level = info.ValidationLevel
+	}
+	if info.ValidationAction != "" {
+		v.action = info.ValidationAction
+	}
+	if schema, ok := info.Validator["$jsonSchema"]; ok {
+		if v.schema, err = lxDb.ToBsonMap(schema); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// Diff, return changes from current to target, documents are compared by keys,
+// numbers by value
+// Example:
+// changes := lxDbValidator.Diff("$jsonSchema", plan.Current, plan.Schema)
+func Diff(path string, current, target interface{}) []Change {
+	if isNil(current) && isNil(target) {
+		return nil
+	}
+	if isNil(current) {
+		return []Change{{Path: path, Op: OpAdd, New: target}}
+	}
+	if isNil(target) {
+		return []Change{{Path: path, Op: OpRemove, Old: current}}
+	}
+
+	cm, cok := normalize(current).(map[string]interface{})
+	tm, tok := normalize(target).(map[string]interface{})
+	if cok && tok {
+		currentDoc, targetDoc := document(current), document(target)
+		keys := make(map[string]bool)
+		for key := range cm {
+			keys[key] = true
+		}
+		for key := range tm {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		var changes []Change
+		for _, key := range sorted {
+			changes = append(changes, Diff(path+"."+key, currentDoc[key], targetDoc[key])...)
+		}
+		return changes
+	}
+
+	if !equal(normalize(current), normalize(target)) {
+		return []Change{{Path: path, Op: OpChange, Old: current, New: target}}
+	}
+	return nil
+}
+
+// document, return document as map without normalized values
+func document(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return v
+	case map[string]interface{}:
+		return v
+	case bson.D:
+		return v.Map()
+	}
+	return nil
+}
+
+// isNil, return true for nil and nil documents
+func isNil(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bson.M:
+		return v == nil
+	case bson.D:
+		return v == nil
+	}
+	return false
+}
+
+// normalize, convert documents to maps, arrays to slices and numbers to float64
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return normalizeMap(v)
+	case map[string]interface{}:
+		return normalizeMap(v)
+	case bson.D:
+		return normalizeMap(v.Map())
+	case bson.A:
+		return normalizeSlice(v)
+	case []interface{}:
+		return normalizeSlice(v)
+	case []string:
+		out := make([]interface{}, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case primitive.Decimal128:
+		return v.String()
+	}
+	return value
+}
+
+func normalizeMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		out[key] = normalize(value)
+	}
+	return out
+}
+
+func normalizeSlice(s []interface{}) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, value := range s {
+		out[i] = normalize(value)
+	}
+	return out
+}
+
+// equal, compare normalized values
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			if !equal(value, bv[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		bv, ok := b.(float64)
+		return ok && (av == bv || math.IsNaN(av) && math.IsNaN(bv))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// diffValue, return value as relaxed extended json
+func diffValue(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	data, err := bson.MarshalExtJSON(bson.M{"v": value}, false, false)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	s := string(data)
+	return strings.TrimSuffix(strings.TrimPrefix(s, `{"v":`), "}")
+}
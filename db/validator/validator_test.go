@@ -0,0 +1,109 @@
+package lxDbValidator_test
+
+import (
+	"errors"
+	"testing"
+
+	lxDbValidator "github.com/litixsoft/lxgo/db/validator"
+	lxSchema "github.com/litixsoft/lxgo/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// loadSchema, load fixture with lxSchema
+func loadSchema(t *testing.T, filename string) gojsonschema.JSONLoader {
+	lxSchema.InitJsonSchemaLoader()
+	if err := lxSchema.Loader.SetSchemaRootDirectory("fixtures"); err != nil {
+		t.Fatal(err)
+	}
+	loader, err := lxSchema.Loader.LoadSchema(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loader
+}
+
+func TestConvert(t *testing.T) {
+	its := assert.New(t)
+
+	conv, err := lxDbValidator.Convert(loadSchema(t, "user.json"))
+	its.NoError(err)
+
+	schema := conv.Schema
+	props := schema["properties"].(bson.M)
+	its.Equal("object", schema["bsonType"])
+	its.Equal(false, schema["additionalProperties"])
+	its.Equal(bson.A{"name", "email"}, schema["required"])
+	its.Equal("user", schema["title"])
+	its.NotContains(schema, "$schema")
+	its.NotContains(schema, "definitions")
+
+	// _id is allowed with additionalProperties false
+	its.Equal(bson.M{}, props["_id"])
+
+	its.Equal(bson.M{"bsonType": "string", "minLength": int64(1), "maxLength": int64(100)}, props["name"])
+	its.Equal(bson.M{"bsonType": "string"}, props["email"])
+	its.Equal(bson.M{"bsonType": bson.A{"int", "long"}, "minimum": int64(0), "exclusiveMinimum": true}, props["age"])
+	its.Equal(bson.M{"bsonType": bson.A{"number", "null"}}, props["score"])
+	its.Equal(bson.M{"enum": bson.A{"user"}}, props["role"])
+	its.Equal(bson.M{"bsonType": "date"}, props["created_at"])
+	its.Equal(bson.M{"bsonType": "objectId"}, props["group_id"])
+	its.Equal(bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"street": bson.M{"bsonType": "string"},
+			"city":   bson.M{"bsonType": "string"},
+		},
+		"required": bson.A{"city"},
+	}, props["address"])
+	its.Equal(bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}}, props["tags"])
+
+	its.Equal([]string{
+		"/properties/email: format email",
+		"/properties/tags: contains",
+	}, conv.Unsupported)
+}
+
+func TestConvert_Errors(t *testing.T) {
+	its := assert.New(t)
+
+	_, err := lxDbValidator.Convert(loadSchema(t, "cyclic.json"))
+	its.True(errors.Is(err, lxDbValidator.ErrCyclicRef))
+
+	_, err = lxDbValidator.Convert(gojsonschema.NewStringLoader(`{"properties": {"a": {"$ref": "#/definitions/missing"}}}`))
+	its.True(errors.Is(err, lxDbValidator.ErrRef))
+
+	_, err = lxDbValidator.Convert(gojsonschema.NewStringLoader(`{"type": "date"}`))
+	its.True(errors.Is(err, lxDbValidator.ErrSchema))
+}
+
+func TestDiff(t *testing.T) {
+	its := assert.New(t)
+
+	current := bson.M{
+		"bsonType": "object",
+		"required": bson.A{"name"},
+		"properties": bson.M{
+			"name": bson.M{"bsonType": "string", "maxLength": int32(50)},
+			"old":  bson.M{"bsonType": "string"},
+		},
+	}
+	target := bson.M{
+		"bsonType": "object",
+		"required": bson.A{"name"},
+		"properties": bson.M{
+			"name": bson.M{"bsonType": "string", "maxLength": int64(100)},
+			"age":  bson.M{"bsonType": "int"},
+		},
+	}
+
+	changes := lxDbValidator.Diff("$jsonSchema", current, target)
+	its.Len(changes, 3)
+	its.Equal(`+ $jsonSchema.properties.age: {"bsonType":"int"}`, changes[0].String())
+	its.Equal(`~ $jsonSchema.properties.name.maxLength: 50 -> 100`, changes[1].String())
+	its.Equal(`- $jsonSchema.properties.old: {"bsonType":"string"}`, changes[2].String())
+
+	// Numbers are compared by value
+	its.Empty(lxDbValidator.Diff("", bson.M{"maxLength": int32(5)}, bson.M{"maxLength": int64(5)}))
+}